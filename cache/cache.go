@@ -47,6 +47,8 @@ type Cache struct {
 	confirmChan                   chan *points.Points // for persisted confirmation
 	queryCnt                      uint32
 	overflowCnt                   uint32 // drop packages if cache full
+	overflowPolicy                OverflowPolicy
+	evictedCnt                    uint32 // points evicted if cache full
 	writeStrategy                 WriteStrategy
 	queue                         queue
 	queueBuildCnt                 uint32 // number of times writeout queue was built
@@ -154,6 +156,18 @@ func (c *Cache) Add(p *points.Points) {
 	c.size = atomic.AddUint32(&c.sizeShared, uint32(len(p.Data)))
 }
 
+// receive adds points from receiver if cache is not full or overflow policy frees space
+func (c *Cache) receive(p *points.Points) {
+	if c.maxSize != 0 && c.size >= c.maxSize && c.overflowPolicy == OverflowEvict {
+		c.evict()
+	}
+	if c.maxSize == 0 || c.size < c.maxSize {
+		c.Add(p)
+	} else {
+		atomic.AddUint32(&c.overflowCnt, 1)
+	}
+}
+
 // SetMaxSize of cache
 func (c *Cache) SetMaxSize(maxSize uint32) {
 	c.maxSize = maxSize
@@ -204,7 +218,9 @@ func (c *Cache) Stat(send helper.StatCallback) {
 	send("metrics", float64(atomic.LoadUint32(&c.metricCount)))
 
 	helper.SendAndSubstractUint32("queries", &c.queryCnt, send)
+	send("limit", float64(c.maxSize))
 	helper.SendAndSubstractUint32("overflow", &c.overflowCnt, send)
+	helper.SendAndSubstractUint32("evicted", &c.evictedCnt, send)
 	helper.SendAndSubstractUint32("queueBuildCount", &c.queueBuildCnt, send)
 
 	queueBuildTime := atomic.LoadUint32(&c.queueBuildTime)
@@ -273,11 +289,7 @@ MAIN_LOOP:
 		case sendTo <- values: // to persister
 			values = nil
 		case msg := <-c.inputChan: // from receiver
-			if c.maxSize == 0 || c.size < c.maxSize {
-				c.Add(msg)
-			} else {
-				atomic.AddUint32(&c.overflowCnt, 1)
-			}
+			c.receive(msg)
 		case <-exitChan: // exit
 			break MAIN_LOOP
 		}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// OverflowPolicy defines what cache does with new values when max size is reached
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops new values (cache.overflow metric)
+	OverflowDrop OverflowPolicy = iota
+	// OverflowEvict drops cached points of metrics with most points until cache is filled by 90%, so fresh
+	// values are kept (cache.evicted metric)
+	OverflowEvict
+)
+
+// SetOverflowPolicy sets policy by name: "drop" or "evict"
+func (c *Cache) SetOverflowPolicy(s string) error {
+	switch s {
+	case "", "drop":
+		c.overflowPolicy = OverflowDrop
+	case "evict":
+		c.overflowPolicy = OverflowEvict
+	default:
+		return fmt.Errorf("Unknown overflow policy '%s', should be one of: drop, evict", s)
+	}
+	return nil
+}
+
+// evict removes metrics with most points until size is not more than 90% of max size. Called by worker
+func (c *Cache) evict() {
+	target := c.maxSize - c.maxSize/10
+
+	largest := make(queue, 0, len(c.data))
+	for _, values := range c.data {
+		largest = append(largest, values)
+	}
+	sort.Sort(sort.Reverse(byLength(largest)))
+
+	var metrics, evicted int
+	for _, values := range largest {
+		if c.size <= target {
+			break
+		}
+		c.Remove(values.Metric, len(values.Data))
+		metrics++
+		evicted += len(values.Data)
+	}
+
+	// queue may keep removed values
+	c.queue = c.queue[:0]
+
+	atomic.AddUint32(&c.evictedCnt, uint32(evicted))
+	logrus.Warnf("[cache] Cache is full, %d points of %d metrics evicted", evicted, metrics)
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOverflowPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	assert.Equal(OverflowDrop, c.overflowPolicy)
+	assert.NoError(c.SetOverflowPolicy("evict"))
+	assert.Equal(OverflowEvict, c.overflowPolicy)
+	assert.NoError(c.SetOverflowPolicy("drop"))
+	assert.Equal(OverflowDrop, c.overflowPolicy)
+	assert.Error(c.SetOverflowPolicy("block"))
+}
+
+func TestEvict(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxSize(20)
+
+	big := points.OnePoint("big", 1, 1)
+	for i := 2; i <= 8; i++ {
+		big.Add(1, int64(i))
+	}
+	c.Add(big)
+	for i := 0; i < 12; i++ {
+		c.Add(points.OnePoint(fmt.Sprintf("small%d", i), 1, 1))
+	}
+	c.updateQueue()
+
+	c.evict()
+	assert.Equal(uint32(12), c.Size())
+	_, ok := c.data["big"]
+	assert.False(ok)
+	assert.Empty(c.queue)
+
+	// queue is rebuilt without evicted metric
+	for v := c.Pop(); v != nil; v = c.Pop() {
+		assert.NotEqual("big", v.Metric)
+	}
+	assert.Equal(uint32(0), c.Size())
+
+	stat := make(map[string]float64)
+	c.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(8), stat["evicted"])
+	assert.Equal(float64(20), stat["limit"])
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, policy := range []string{"drop", "evict"} {
+		c := New()
+		c.SetMaxSize(10)
+		c.SetOverflowPolicy(policy)
+
+		for i := 0; i < 15; i++ {
+			c.receive(points.OnePoint(fmt.Sprintf("metric%d", i), 1, 1))
+		}
+		_, last := c.data["metric14"]
+
+		stat := make(map[string]float64)
+		c.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+
+		if policy == "drop" {
+			assert.Equal(uint32(10), c.Size())
+			assert.Equal(float64(5), stat["overflow"])
+			assert.Equal(float64(0), stat["evicted"])
+			assert.False(last)
+		} else {
+			// every eviction frees 10% of max size: one metric
+			assert.Equal(uint32(10), c.Size())
+			assert.Equal(float64(0), stat["overflow"])
+			assert.Equal(float64(5), stat["evicted"])
+			assert.True(last)
+		}
+	}
+}
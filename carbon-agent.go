@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
@@ -11,11 +12,14 @@ import (
 	"os/user"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/lomik/go-carbon/carbon"
 	"github.com/lomik/go-carbon/logging"
+	"github.com/lomik/go-carbon/persister"
 	"github.com/sevlyar/go-daemon"
 )
 
@@ -39,6 +43,78 @@ func httpServe(addr string) (func(), error) {
 	return func() { listener.Close() }, nil
 }
 
+// checkMetricNames prints settings of persister and estimated file size for each metric from stdin (first field
+// of line) in format text, csv or json. Returns false if some metrics are not matched or invalid
+func checkMetricNames(cfg *carbon.Config, format string) bool {
+	if !cfg.Whisper.Enabled {
+		log.Fatal("whisper is disabled")
+	}
+	if format != "text" && format != "csv" && format != "json" {
+		log.Fatalf("unknown format %#v of -check-metrics-format", format)
+	}
+
+	var metrics []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			metrics = append(metrics, fields[0])
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := persister.NewWhisper(cfg.Whisper.DataDir, cfg.Whisper.Schemas, cfg.Whisper.Aggregation, nil, nil)
+
+	res := p.Validate(metrics)
+
+	switch format {
+	case "csv":
+		err = persister.WriteValidationCSV(os.Stdout, res)
+	case "json":
+		err = persister.WriteValidationJSON(os.Stdout, res)
+	default:
+		for _, r := range res {
+			fmt.Println(r.String())
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, r := range res {
+		if r.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// replay writes points from file by persister and prints progress to stderr.
+// Returns false if some lines are malformed or points are outdated
+func replay(app *carbon.App, filename string, rate int) bool {
+	r := os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	start := time.Now()
+	stats, err := app.Replay(r, rate, 10*time.Second, func(s persister.ReplayStats) {
+		fmt.Fprintf(os.Stderr, "%s: lines=%d points=%d skipped=%d malformed=%d outdated=%d\n",
+			time.Since(start).String(), s.Lines, s.Points, s.Skipped, s.Malformed, s.Outdated)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return stats.Malformed == 0 && stats.Outdated == 0
+}
+
 func main() {
 	var err error
 
@@ -47,6 +123,10 @@ func main() {
 	configFile := flag.String("config", "", "Filename of config")
 	printDefaultConfig := flag.Bool("config-print-default", false, "Print default config")
 	checkConfig := flag.Bool("check-config", false, "Check config and exit")
+	checkMetrics := flag.Bool("check-metrics", false, "Print schema and aggregation for metric names from stdin and exit")
+	checkMetricsFormat := flag.String("check-metrics-format", "text", "Output format of -check-metrics: text, csv or json")
+	replayFile := flag.String("replay", "", "Write points from file of \"metric value timestamp\" lines (\"-\" - stdin) by persister and exit")
+	replayRate := flag.Int("replay-rate", 0, "Points per second of -replay, 0 - no limit")
 
 	printVersion := flag.Bool("version", false, "Print version")
 
@@ -83,7 +163,12 @@ func main() {
 		}
 	}
 
-	if err := logging.SetLevel(cfg.Common.LogLevel); err != nil {
+	if err := logging.Setup(cfg.Common.LogFormat, cfg.Common.LogLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	logOutput, err := logging.ParseOutput(cfg.Common.LogOutput)
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -92,11 +177,31 @@ func main() {
 		return
 	}
 
-	if err := logging.PrepareFile(cfg.Common.Logfile, runAsUser); err != nil {
-		logrus.Fatal(err)
+	if *checkMetrics {
+		if !checkMetricNames(cfg, *checkMetricsFormat) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *replayFile != "" {
+		if !replay(app, *replayFile, *replayRate) {
+			os.Exit(1)
+		}
+		return
 	}
 
-	if err := logging.SetFile(cfg.Common.Logfile); err != nil {
+	if !logOutput.IsFile() {
+		if err := logging.SetOutput(cfg.Common.LogOutput); err != nil {
+			logrus.Fatal(err)
+		}
+	} else if err := logging.PrepareFile(cfg.Common.Logfile, runAsUser); err != nil {
+		logrus.Fatal(err)
+	} else if cfg.Common.Logfile != "" && (cfg.Common.LogMaxSize > 0 || cfg.Common.LogMaxAge.Value() > 0) {
+		if err := logging.SetRotateFile(cfg.Common.Logfile, cfg.Common.LogMaxSize*1024*1024, cfg.Common.LogMaxAge.Value(), cfg.Common.LogBackups, cfg.Common.LogCompress); err != nil {
+			logrus.Fatal(err)
+		}
+	} else if err := logging.SetFile(cfg.Common.Logfile); err != nil {
 		logrus.Fatal(err)
 	}
 
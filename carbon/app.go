@@ -2,16 +2,21 @@ package carbon
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/lomik/go-carbon/cache"
 	"github.com/lomik/go-carbon/carbonserver"
 	"github.com/lomik/go-carbon/persister"
+	"github.com/lomik/go-carbon/points"
 	"github.com/lomik/go-carbon/receiver"
 )
 
@@ -27,6 +32,8 @@ type App struct {
 	Persister      *persister.Whisper
 	Carbonserver   *carbonserver.CarbonserverListener
 	Collector      *Collector // (!!!) Should be re-created on every change config/modules
+	Prometheus     net.Listener
+	Introspection  net.Listener
 	exit           chan bool
 }
 
@@ -40,6 +47,32 @@ func New(configFilename string) *App {
 	return app
 }
 
+// internalStorage applies common.metric-retention and common.metric-aggregation to internal metrics
+// (graph-prefix), they are matched before schemas and aggregation sections
+func internalStorage(cfg *Config) error {
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(cfg.Common.GraphPrefix) + "\\.")
+
+	if cfg.Common.MetricRetention != "" {
+		retentions, err := persister.ParseRetentionDefs(cfg.Common.MetricRetention)
+		if err != nil {
+			return fmt.Errorf("common.metric-retention: %s", err.Error())
+		}
+		cfg.Whisper.Schemas = append(persister.WhisperSchemas{{
+			Name:         "carbon-internal",
+			Pattern:      pattern,
+			RetentionStr: cfg.Common.MetricRetention,
+			Retentions:   retentions,
+		}}, cfg.Whisper.Schemas...)
+	}
+
+	if cfg.Common.MetricAggregation != "" {
+		if err := cfg.Whisper.Aggregation.Prepend("carbon-internal", pattern, cfg.Common.MetricAggregation); err != nil {
+			return fmt.Errorf("common.metric-aggregation: %s", err.Error())
+		}
+	}
+	return nil
+}
+
 // configure loads config from config file, schemas.conf, aggregation.conf
 func (app *App) configure() error {
 	var err error
@@ -64,20 +97,138 @@ func (app *App) configure() error {
 		}
 
 		if cfg.Whisper.AggregationFilename != "" {
-			cfg.Whisper.Aggregation, err = persister.ReadWhisperAggregation(cfg.Whisper.AggregationFilename)
+			cfg.Whisper.Aggregation, err = persister.ReadWhisperAggregationWithDefault(cfg.Whisper.AggregationFilename, cfg.Whisper.DefaultXFilesFactor)
 			if err != nil {
 				return err
 			}
 		} else {
 			cfg.Whisper.Aggregation = persister.NewWhisperAggregation()
 		}
+
+		var matchMode persister.MatchMode
+		if matchMode, err = persister.ParseMatchMode(cfg.Whisper.MatchMode); err != nil {
+			return fmt.Errorf("whisper.match-mode: %s", err.Error())
+		}
+		cfg.Whisper.Schemas.SetMatchMode(matchMode)
+		cfg.Whisper.Aggregation.SetMatchMode(matchMode)
+
+		if err = internalStorage(cfg); err != nil {
+			return err
+		}
+
+		if cfg.Whisper.DropFilename != "" {
+			cfg.Whisper.dropList, err = persister.ReadDropList(cfg.Whisper.DropFilename)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.Whisper.Enabled {
+		if cfg.Whisper.dirMode, err = parseFileMode(cfg.Whisper.DirMode); err != nil {
+			return fmt.Errorf("whisper.dir-mode: %s", err.Error())
+		}
+		if cfg.Whisper.fileMode, err = parseFileMode(cfg.Whisper.FileMode); err != nil {
+			return fmt.Errorf("whisper.file-mode: %s", err.Error())
+		}
+		if cfg.Whisper.uid, cfg.Whisper.gid, err = parseOwner(cfg.Whisper.Owner); err != nil {
+			return fmt.Errorf("whisper.owner: %s", err.Error())
+		}
+		if cfg.Whisper.shardFunc, err = persister.NewShardFunc(cfg.Whisper.Sharding, cfg.Whisper.ShardingSegments); err != nil {
+			return fmt.Errorf("whisper.sharding: %s", err.Error())
+		}
+		if cfg.Whisper.Workers < 0 {
+			return fmt.Errorf("whisper.workers: negative value %d", cfg.Whisper.Workers)
+		}
+		if cfg.Whisper.WorkersPerCPU < 0 {
+			return fmt.Errorf("whisper.workers-per-cpu: negative value %g", cfg.Whisper.WorkersPerCPU)
+		}
+		if cfg.Whisper.MaxWorkers < 0 {
+			return fmt.Errorf("whisper.max-workers: negative value %d", cfg.Whisper.MaxWorkers)
+		}
+		if cfg.Whisper.Workers == 0 {
+			cfg.Whisper.Workers = persister.DefaultWorkers(cfg.Whisper.WorkersPerCPU, cfg.Whisper.MaxWorkers)
+		}
+		if cfg.Whisper.NamespaceDepth < 0 {
+			return fmt.Errorf("whisper.namespace-depth: negative value %d", cfg.Whisper.NamespaceDepth)
+		}
+		if cfg.Whisper.NamespaceStats && cfg.Whisper.NamespaceDepth == 0 {
+			return fmt.Errorf("whisper.namespace-stats: whisper.namespace-depth is not set")
+		}
+		if cfg.Whisper.dedupPolicy, err = points.ParseDedupPolicy(cfg.Whisper.Dedup); err != nil {
+			return fmt.Errorf("whisper.dedup: %s", err.Error())
+		}
+		for _, schema := range cfg.Whisper.Schemas {
+			if schema.Pool != "" && cfg.Whisper.Pools[schema.Pool] <= 0 {
+				return fmt.Errorf("%s: pool %#v of [%s] is not defined in whisper.pools", cfg.Whisper.SchemasFilename, schema.Pool, schema.Name)
+			}
+			if schema.Mirror != nil && filepath.Clean(schema.Mirror.Root) == filepath.Clean(cfg.Whisper.DataDir) {
+				return fmt.Errorf("%s: mirror-root of [%s] is whisper.data-dir", cfg.Whisper.SchemasFilename, schema.Name)
+			}
+		}
+		if cfg.Whisper.CreateRetries > 0 && cfg.Whisper.CreateRetryBackoff.Value() <= 0 {
+			return fmt.Errorf("whisper.create-retry-backoff: should be positive")
+		}
+		if cfg.Whisper.CreateThreshold > 1 && cfg.Whisper.CreateWindow.Value() <= 0 {
+			return fmt.Errorf("whisper.create-threshold-window: should be positive")
+		}
+		if cfg.Whisper.BatchDedupSize > 0 && cfg.Whisper.BatchDedupWindow.Value() <= 0 {
+			return fmt.Errorf("whisper.batch-dedup-window: should be positive")
+		}
+		if cfg.Whisper.WAL && cfg.Whisper.WALDir == "" {
+			return fmt.Errorf("whisper.wal-dir: empty path")
+		}
+		if cfg.Whisper.AllowedNames != "" {
+			if cfg.Whisper.allowedNames, err = regexp.Compile(cfg.Whisper.AllowedNames); err != nil {
+				return fmt.Errorf("whisper.allowed-names: %s", err.Error())
+			}
+		}
+		if cfg.Whisper.nameNormalizer, err = persister.NewNameNormalizer(cfg.Whisper.NameNormalize); err != nil {
+			return fmt.Errorf("whisper.name-normalize: %s", err.Error())
+		}
+		if cfg.Whisper.timestampNormalizer, err = persister.NewTimestampNormalizer(cfg.Whisper.TimestampNormalize); err != nil {
+			return fmt.Errorf("whisper.timestamp-normalize: %s", err.Error())
+		}
+		if cfg.Whisper.HashedLayoutDepth < 0 || cfg.Whisper.HashedLayoutDepth > persister.HashedPathEncoderMaxDepth {
+			return fmt.Errorf("whisper.hashed-layout-depth: %d is out of range [0, %d]", cfg.Whisper.HashedLayoutDepth, persister.HashedPathEncoderMaxDepth)
+		}
+		if cfg.Whisper.HashedLayoutDepth > 0 && cfg.Carbonserver.Enabled {
+			return fmt.Errorf("whisper.hashed-layout-depth: not supported by carbonserver")
+		}
+		for path, weight := range cfg.Whisper.DataDirs {
+			if weight <= 0 {
+				return fmt.Errorf("whisper.data-dirs: weight of %#v should be positive", path)
+			}
+		}
+		if len(cfg.Whisper.DataDirs) > 0 && cfg.Carbonserver.Enabled {
+			return fmt.Errorf("whisper.data-dirs: not supported by carbonserver")
+		}
 	}
+
+	if cfg.Whisper.Enabled && !(cfg.Whisper.WriteStrategy == "max" ||
+		cfg.Whisper.WriteStrategy == "sorted" ||
+		cfg.Whisper.WriteStrategy == "noop") {
+		return fmt.Errorf("go-carbon support only \"max\", \"sorted\" or \"noop\" whisper.write-strategy")
+	}
+
+	if cfg.Whisper.Enabled {
+		if _, err := persister.ParseOverflowPolicy(cfg.Whisper.OverflowPolicy); err != nil {
+			return fmt.Errorf("whisper.overflow-policy: %s", err)
+		}
+		if _, err := persister.ParseTimestampAlign(cfg.Whisper.TimestampAlign); err != nil {
+			return fmt.Errorf("whisper.timestamp-align: %s", err)
+		}
+	}
+
 	if !(cfg.Cache.WriteStrategy == "max" ||
 		cfg.Cache.WriteStrategy == "sorted" ||
 		cfg.Cache.WriteStrategy == "noop") {
 		return fmt.Errorf("go-carbon support only \"max\", \"sorted\"  or \"noop\" write-strategy")
 	}
 
+	if err := cache.New().SetOverflowPolicy(cfg.Cache.OverflowPolicy); err != nil {
+		return fmt.Errorf("cache.overflow-policy: %s", err)
+	}
+
 	if cfg.Common.MetricEndpoint == "" {
 		cfg.Common.MetricEndpoint = MetricEndpointLocal
 	}
@@ -94,6 +245,20 @@ func (app *App) configure() error {
 		}
 	}
 
+	if cfg.Common.MetricSink != "" {
+		if _, err := newStatSink(cfg.Common.MetricSink); err != nil {
+			return fmt.Errorf("common.metric-sink: %s", err.Error())
+		}
+	} else if cfg.Common.MetricSinkOnly {
+		return fmt.Errorf("common.metric-sink-only: common.metric-sink is not set")
+	}
+
+	switch cfg.Common.MissingTimestamp {
+	case "", "strict", "lenient":
+	default:
+		return fmt.Errorf("common.missing-timestamp: unknown value %#v, valid values: \"strict\", \"lenient\"", cfg.Common.MissingTimestamp)
+	}
+
 	app.Config = cfg
 
 	return nil
@@ -113,15 +278,40 @@ func (app *App) ReloadConfig() error {
 	defer app.Unlock()
 
 	var err error
+	oldConfig := app.Config
 	if err = app.configure(); err != nil {
 		return err
 	}
 
-	if app.Persister != nil {
-		app.Persister.Stop()
-		app.Persister = nil
+	reload := app.Persister != nil && app.Config.Whisper.Enabled && whisperConfigEqual(oldConfig.Whisper, app.Config.Whisper)
+	if app.Persister != nil && app.Config.Whisper.Enabled && !reload && whisperWorkersChanged(oldConfig.Whisper, app.Config.Whisper) {
+		// only workers count changed (with schemas, aggregation or drop list)
+		if err = app.Persister.Resize(app.Config.Whisper.Workers); err != nil {
+			logrus.Infof("[persister] Restart to change workers count: %s", err.Error())
+		} else {
+			reload = true
+		}
+	}
+
+	if reload {
+		// only schemas, aggregation or drop list changed. Replace it without restart of persister
+		app.Persister.SetStorageConfig(app.Config.Whisper.Schemas, app.Config.Whisper.Aggregation)
+		app.Persister.SetDropList(app.Config.Whisper.dropList)
+		logrus.Info("[persister] Storage schemas, aggregation and drop list reloaded")
+		if app.Config.Whisper.ResolveRootOnReload {
+			if err = app.Persister.ResolveRoot(); err != nil {
+				return fmt.Errorf("whisper.data-dir: %s", err.Error())
+			}
+		}
+	} else {
+		if app.Persister != nil {
+			app.Persister.Stop()
+			app.Persister = nil
+		}
+		if err = app.startPersister(); err != nil {
+			return err
+		}
 	}
-	app.startPersister()
 
 	if app.Collector != nil {
 		app.Collector.Stop()
@@ -164,6 +354,18 @@ func (app *App) stopListeners() {
 		app.Carbonserver = nil
 		logrus.Debug("[carbonserver] finished")
 	}
+
+	if app.Prometheus != nil {
+		app.Prometheus.Close()
+		app.Prometheus = nil
+		logrus.Debug("[prometheus] finished")
+	}
+
+	if app.Introspection != nil {
+		app.Introspection.Close()
+		app.Introspection = nil
+		logrus.Debug("[introspection] finished")
+	}
 }
 
 func (app *App) stopAll() {
@@ -201,23 +403,107 @@ func (app *App) Stop() {
 	app.stopAll()
 }
 
-func (app *App) startPersister() {
+// newPersister creates persister by whisper section of config
+func (app *App) newPersister(in chan *points.Points, confirm chan *points.Points) *persister.Whisper {
+	p := persister.NewWhisper(
+		app.Config.Whisper.DataDir,
+		app.Config.Whisper.Schemas,
+		app.Config.Whisper.Aggregation,
+		in,
+		confirm,
+	)
+	p.SetMaxUpdatesPerSecond(app.Config.Whisper.MaxUpdatesPerSecond)
+	p.SetMaxCreatesPerSecond(app.Config.Whisper.MaxCreatesPerSecond)
+	p.SetMirrorMaxUpdatesPerSecond(app.Config.Whisper.MirrorMaxUpdates)
+	p.SetCreateRetry(app.Config.Whisper.CreateRetries, app.Config.Whisper.CreateRetryBackoff.Value())
+	p.SetCreateThreshold(app.Config.Whisper.CreateThreshold, app.Config.Whisper.CreateWindow.Value())
+	p.SetMaxRetentionAge(app.Config.Whisper.MaxRetentionAge.Value())
+	p.SetSparse(app.Config.Whisper.Sparse)
+	p.SetFsync(app.Config.Whisper.Fsync)
+	p.SetFadviseDontNeed(app.Config.Whisper.FadviseDontNeed)
+	p.SetQuarantineCorrupt(app.Config.Whisper.QuarantineCorrupt)
+	p.SetVerifyOnOpen(app.Config.Whisper.VerifyOnOpen)
+	p.SetFileMode(app.Config.Whisper.dirMode, app.Config.Whisper.fileMode)
+	p.SetOwner(app.Config.Whisper.uid, app.Config.Whisper.gid)
+	p.SetWriteStrategy(app.Config.Whisper.WriteStrategy)
+	p.SetOverflowPolicy(app.Config.Whisper.OverflowPolicy)
+	p.SetTimestampAlign(app.Config.Whisper.TimestampAlign)
+	p.SetStopTimeout(app.Config.Whisper.StopTimeout.Value())
+	p.SetFlushInterval(app.Config.Whisper.FlushInterval.Value())
+	p.SetFlushMaxPoints(app.Config.Whisper.FlushMaxPoints)
+	p.SetMaxPointsPerUpdate(app.Config.Whisper.MaxPointsPerUpdate)
+	p.SetDedupPolicy(app.Config.Whisper.dedupPolicy)
+	p.SetBatchDedup(app.Config.Whisper.BatchDedupSize, app.Config.Whisper.BatchDedupWindow.Value())
+	p.SetNameValidation(app.Config.Whisper.MaxNameLength, app.Config.Whisper.allowedNames)
+	p.SetNameNormalizer(app.Config.Whisper.nameNormalizer)
+	p.SetTimestampNormalizer(app.Config.Whisper.timestampNormalizer)
+	p.SetDropList(app.Config.Whisper.dropList)
+	p.SetHashedLayout(app.Config.Whisper.HashedLayoutDepth)
+	p.SetDataDirs(app.Config.Whisper.DataDirs)
+	p.SetDegradedThreshold(app.Config.Whisper.DegradedWriteErrors)
+	p.SetSlowWriteThreshold(app.Config.Whisper.SlowWriteThreshold.Value())
+	p.SetStoreTimeout(app.Config.Whisper.StoreTimeout.Value())
+	p.SetOneShotTracking(app.Config.Whisper.OneShotWindow.Value(), app.Config.Whisper.OneShotMaxTracked)
+	p.SetMaxFutureDrift(app.Config.Whisper.MaxFutureDrift.Value())
+	p.SetStoreNonFinite(app.Config.Whisper.StoreNonFinite)
+	p.SetArchiveStats(app.Config.Whisper.ArchiveStats)
+	p.SetLogSampling(app.Config.Whisper.LogSamplingWindow.Value(), app.Config.Whisper.LogSamplingRate)
+	p.SetWAL(app.Config.Whisper.WALDir, app.Config.Whisper.WAL)
+	p.SetIndex(app.Config.Whisper.IndexFilename)
+	p.SetAuditLog(
+		app.Config.Whisper.AuditLog,
+		app.Config.Common.LogMaxSize*1024*1024,
+		app.Config.Common.LogMaxAge.Value(),
+		app.Config.Common.LogBackups,
+		app.Config.Common.LogCompress,
+	)
+	p.SetDiskUsageScan(app.Config.Whisper.DiskUsageInterval.Value(), app.Config.Whisper.DiskUsageMaxDepth)
+	p.SetHolePunching(app.Config.Whisper.PunchHolesIdleAge.Value(), app.Config.Whisper.PunchHolesRate)
+	p.SetMaxOpenFiles(app.Config.Whisper.MaxOpenFiles)
+	p.SetSchemaReconcile(app.Config.Whisper.SchemaReconcile, app.Config.Whisper.SchemaReconcileRate)
+	p.SetWorkers(app.Config.Whisper.Workers)
+	p.SetInternalChannelSize(app.Config.Whisper.WorkerChannelSize)
+	p.SetPools(app.Config.Whisper.Pools)
+	p.SetShardFunc(app.Config.Whisper.shardFunc)
+	p.SetNamespaceDepth(app.Config.Whisper.NamespaceDepth)
+	p.SetNamespaceStats(app.Config.Whisper.NamespaceStats)
+	return p
+}
+
+// Replay writes points from lines "metric value timestamp" of r by persister configured as whisper section of
+// config, up to ratePerSec points per second (0 - no limit). WAL, disk usage scan and punch of holes are
+// disabled, persister is stopped after write of all points. progress is called every progressInterval
+func (app *App) Replay(r io.Reader, ratePerSec int, progressInterval time.Duration, progress func(persister.ReplayStats)) (persister.ReplayStats, error) {
+	if !app.Config.Whisper.Enabled {
+		return persister.ReplayStats{}, fmt.Errorf("whisper is disabled")
+	}
+
+	p := app.newPersister(make(chan *points.Points, app.Config.Cache.InputBuffer), nil)
+	p.SetWAL("", false)
+	p.SetDiskUsageScan(0, 0)
+	p.SetHolePunching(0, 0)
+
+	if err := p.Start(); err != nil {
+		return persister.ReplayStats{}, err
+	}
+
+	stats, err := p.Replay(r, ratePerSec, progressInterval, progress)
+	p.Stop()
+	return stats, err
+}
+
+func (app *App) startPersister() error {
 	if app.Config.Whisper.Enabled {
-		p := persister.NewWhisper(
-			app.Config.Whisper.DataDir,
-			app.Config.Whisper.Schemas,
-			app.Config.Whisper.Aggregation,
-			app.Cache.Out(),
-			app.Cache.Confirm(),
-		)
-		p.SetMaxUpdatesPerSecond(app.Config.Whisper.MaxUpdatesPerSecond)
-		p.SetSparse(app.Config.Whisper.Sparse)
-		p.SetWorkers(app.Config.Whisper.Workers)
+		p := app.newPersister(app.Cache.Out(), app.Cache.Confirm())
+		p.SetCacheQuery(app.Cache.Query(), app.Config.Carbonlink.QueryTimeout.Value())
 
-		p.Start()
+		if err := p.Start(); err != nil {
+			return err
+		}
 
 		app.Persister = p
 	}
+	return nil
 }
 
 // Start starts
@@ -237,12 +523,15 @@ func (app *App) Start() (err error) {
 	core.SetMaxSize(conf.Cache.MaxSize)
 	core.SetInputCapacity(conf.Cache.InputBuffer)
 	core.SetWriteStrategy(conf.Cache.WriteStrategy)
+	core.SetOverflowPolicy(conf.Cache.OverflowPolicy)
 	core.Start()
 
 	app.Cache = core
 
 	/* WHISPER start */
-	app.startPersister()
+	if err = app.startPersister(); err != nil {
+		return
+	}
 	/* WHISPER end */
 
 	/* UDP start */
@@ -251,6 +540,7 @@ func (app *App) Start() (err error) {
 			"udp://"+conf.Udp.Listen,
 			receiver.OutChan(core.In()),
 			receiver.UDPLogIncomplete(conf.Udp.LogIncomplete),
+			receiver.MissingTimestamp(conf.Common.MissingTimestamp == "lenient"),
 		)
 
 		if err != nil {
@@ -264,6 +554,8 @@ func (app *App) Start() (err error) {
 		app.TCP, err = receiver.New(
 			"tcp://"+conf.Tcp.Listen,
 			receiver.OutChan(core.In()),
+			receiver.TCPMaxLineSize(conf.Tcp.MaxLineSize),
+			receiver.MissingTimestamp(conf.Common.MissingTimestamp == "lenient"),
 		)
 
 		if err != nil {
@@ -329,6 +621,22 @@ func (app *App) Start() (err error) {
 	}
 	/* CARBONLINK end */
 
+	/* PROMETHEUS start */
+	if conf.Prometheus.Enabled {
+		if err = app.startPrometheus(conf.Prometheus.Listen, conf.Prometheus.HealthPath); err != nil {
+			return
+		}
+	}
+	/* PROMETHEUS end */
+
+	/* INTROSPECTION start */
+	if conf.Introspect.Enabled {
+		if err = app.startIntrospection(conf.Introspect.Listen); err != nil {
+			return
+		}
+	}
+	/* INTROSPECTION end */
+
 	/* RESTORE start */
 	if conf.Dump.Enabled {
 		go app.Restore(core.In(), conf.Dump.Path, conf.Dump.RestorePerSecond)
@@ -1,12 +1,16 @@
 package carbon
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"testing"
 	"time"
 
+	"github.com/lomik/go-carbon/persister"
 	"github.com/lomik/go-carbon/qa"
 	"github.com/stretchr/testify/assert"
 )
@@ -82,3 +86,38 @@ func TestReloadAndCollectorDeadlock(t *testing.T) {
 		}
 	})
 }
+
+func TestReloadWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		configFile := TestConfig(root)
+		setWorkers := func(workers int) {
+			b, err := ioutil.ReadFile(configFile)
+			assert.NoError(err)
+			b = regexp.MustCompile(`(?m)^workers = \d+$`).ReplaceAll(b, []byte(fmt.Sprintf("workers = %d", workers)))
+			// free ports, default ones may be still used by apps of other tests
+			b = regexp.MustCompile(`(?m)^listen = ".*"$`).ReplaceAll(b, []byte(`listen = "127.0.0.1:0"`))
+			assert.NoError(ioutil.WriteFile(configFile, b, 0644))
+		}
+
+		setWorkers(2)
+		app := New(configFile)
+		assert.NoError(app.ParseConfig())
+		assert.NoError(app.Start())
+		defer app.Stop()
+
+		// resized without restart of persister
+		p := app.Persister
+		setWorkers(4)
+		assert.NoError(app.ReloadConfig())
+		assert.True(p == app.Persister)
+		assert.Equal(4, app.Config.Whisper.Workers)
+
+		// 0 - workers-per-cpu * GOMAXPROCS
+		setWorkers(0)
+		assert.NoError(app.ReloadConfig())
+		assert.True(p == app.Persister)
+		assert.Equal(persister.DefaultWorkers(1, 0), app.Config.Whisper.Workers)
+	})
+}
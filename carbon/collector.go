@@ -2,8 +2,10 @@ package carbon
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -22,9 +24,69 @@ type Collector struct {
 	helper.Stoppable
 	graphPrefix    string
 	metricInterval time.Duration
+	metricJitter   time.Duration
+	intervalMutex  sync.Mutex
 	endpoint       string
 	data           chan *points.Points
 	stats          []statFunc
+	lastMutex      sync.Mutex
+	last           map[string]float64 // module.metric -> value of last collect
+	sinkMutex      sync.Mutex
+	sink           helper.StatCallback
+	sinkOnly       bool
+}
+
+// SetStatSink sets callback called with full name and value of every stat in addition to metric-endpoint,
+// e.g. for external monitoring independent of cache and persister. Sink must not block. nil - disabled
+func (c *Collector) SetStatSink(sink func(metric string, value float64)) {
+	c.sinkMutex.Lock()
+	c.sink = sink
+	c.sinkMutex.Unlock()
+}
+
+// SetStatSinkOnly disables sending of stats to metric-endpoint, they are passed to stat sink only
+func (c *Collector) SetStatSinkOnly(only bool) {
+	c.sinkMutex.Lock()
+	c.sinkOnly = only
+	c.sinkMutex.Unlock()
+}
+
+// SetCheckpointInterval sets interval of stats collect. Every interval is randomly shifted by up to jitter
+// in both directions, so nodes started at the same time don't send stats at the same instant.
+// Average interval is not changed. Applied from next collect
+func (c *Collector) SetCheckpointInterval(d, jitter time.Duration) {
+	if jitter > d {
+		jitter = d
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+
+	c.intervalMutex.Lock()
+	c.metricInterval = d
+	c.metricJitter = jitter
+	c.intervalMutex.Unlock()
+}
+
+func (c *Collector) checkpointDelay(rnd *rand.Rand) time.Duration {
+	c.intervalMutex.Lock()
+	d, jitter := c.metricInterval, c.metricJitter
+	c.intervalMutex.Unlock()
+
+	return checkpointDelay(d, jitter, rnd)
+}
+
+// checkpointDelay returns d shifted by random value in [-jitter, jitter]
+func checkpointDelay(d, jitter time.Duration, rnd *rand.Rand) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	delay := d - jitter + time.Duration(rnd.Int63n(int64(2*jitter)+1))
+	if delay <= 0 {
+		// jitter equal to d
+		return time.Nanosecond
+	}
+	return delay
 }
 
 func NewCollector(app *App) *Collector {
@@ -36,14 +98,42 @@ func NewCollector(app *App) *Collector {
 		data:           make(chan *points.Points, 4096),
 		endpoint:       app.Config.Common.MetricEndpoint,
 		stats:          make([]statFunc, 0),
+		last:           make(map[string]float64),
 	}
 
+	c.SetCheckpointInterval(c.metricInterval, app.Config.Common.MetricIntervalJitter.Value())
 	c.Start()
 
+	if app.Config.Common.MetricSink != "" {
+		if sink, err := newStatSink(app.Config.Common.MetricSink); err != nil {
+			logrus.Errorf("[stat] metric-sink: %s", err.Error())
+		} else {
+			c.SetStatSink(sink.send)
+			c.SetStatSinkOnly(app.Config.Common.MetricSinkOnly)
+			c.Go(sink.run)
+		}
+	}
+
 	sendCallback := func(moduleName string) func(metric string, value float64) {
 		return func(metric string, value float64) {
 			key := fmt.Sprintf("%s.%s.%s", c.graphPrefix, moduleName, metric)
 			logrus.Infof("[stat] %s=%#v", key, value)
+
+			c.lastMutex.Lock()
+			c.last[moduleName+"."+metric] = value
+			c.lastMutex.Unlock()
+
+			c.sinkMutex.Lock()
+			sink, sinkOnly := c.sink, c.sinkOnly
+			c.sinkMutex.Unlock()
+
+			if sink != nil {
+				sink(key, value)
+				if sinkOnly {
+					return
+				}
+			}
+
 			select {
 			case c.data <- points.NowPoint(key, value):
 				// pass
@@ -86,15 +176,18 @@ func NewCollector(app *App) *Collector {
 
 	// collector worker
 	c.Go(func(exit chan bool) {
-		ticker := time.NewTicker(c.metricInterval)
-		defer ticker.Stop()
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+		timer := time.NewTimer(c.checkpointDelay(rnd))
+		defer timer.Stop()
 
 		for {
 			select {
 			case <-exit:
 				return
-			case <-ticker.C:
+			case <-timer.C:
 				c.collect()
+				timer.Reset(c.checkpointDelay(rnd))
 			}
 		}
 	})
@@ -196,3 +289,15 @@ func (c *Collector) collect() {
 		stat()
 	}
 }
+
+// Last returns copy of values sent by modules on last collect. Key is "module.metric"
+func (c *Collector) Last() map[string]float64 {
+	c.lastMutex.Lock()
+	defer c.lastMutex.Unlock()
+
+	res := make(map[string]float64, len(c.last))
+	for k, v := range c.last {
+		res[k] = v
+	}
+	return res
+}
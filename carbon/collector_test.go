@@ -0,0 +1,60 @@
+package carbon
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	rnd := rand.New(rand.NewSource(1))
+
+	assert.Equal(time.Minute, checkpointDelay(time.Minute, 0, rnd))
+
+	var sum time.Duration
+	min, max := time.Hour, time.Duration(0)
+	const n = 10000
+	for i := 0; i < n; i++ {
+		d := checkpointDelay(time.Minute, 10*time.Second, rnd)
+		sum += d
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+
+	assert.True(min >= 50*time.Second, min.String())
+	assert.True(max <= 70*time.Second, max.String())
+	// spread over all window
+	assert.True(min < 51*time.Second, min.String())
+	assert.True(max > 69*time.Second, max.String())
+	// average interval is not changed
+	avg := sum / n
+	assert.True(avg > 59*time.Second && avg < 61*time.Second, avg.String())
+
+	assert.True(checkpointDelay(time.Second, time.Second, rnd) > 0)
+}
+
+func TestSetCheckpointInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Collector{}
+	rnd := rand.New(rand.NewSource(1))
+
+	c.SetCheckpointInterval(time.Minute, 0)
+	assert.Equal(time.Minute, c.checkpointDelay(rnd))
+
+	// jitter is limited by interval
+	c.SetCheckpointInterval(time.Second, time.Hour)
+	assert.Equal(time.Second, c.metricJitter)
+	for i := 0; i < 100; i++ {
+		d := c.checkpointDelay(rnd)
+		assert.True(d > 0 && d <= 2*time.Second, d.String())
+	}
+}
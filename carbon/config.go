@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/user"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/lomik/go-carbon/persister"
+	"github.com/lomik/go-carbon/points"
 )
 
 const MetricEndpointLocal = "local"
@@ -41,28 +47,116 @@ type commonConfig struct {
 	User           string    `toml:"user"`
 	Logfile        string    `toml:"logfile"`
 	LogLevel       string    `toml:"log-level"`
+	LogFormat      string    `toml:"log-format"`
+	LogMaxSize     int64     `toml:"log-max-size"`
+	LogMaxAge      *Duration `toml:"log-max-age"`
+	LogBackups     int       `toml:"log-backups"`
+	LogCompress    bool      `toml:"log-compress"`
+	LogOutput      string    `toml:"log-output"`
 	GraphPrefix    string    `toml:"graph-prefix"`
 	MetricInterval *Duration `toml:"metric-interval"`
 	MetricEndpoint string    `toml:"metric-endpoint"`
 	MaxCPU         int       `toml:"max-cpu"`
+
+	MetricIntervalJitter *Duration `toml:"metric-interval-jitter"`
+	MetricRetention      string    `toml:"metric-retention"`
+	MetricAggregation    string    `toml:"metric-aggregation"`
+	MissingTimestamp     string    `toml:"missing-timestamp"`
+	MetricSink           string    `toml:"metric-sink"`
+	MetricSinkOnly       bool      `toml:"metric-sink-only"`
 }
 
 type whisperConfig struct {
-	DataDir             string `toml:"data-dir"`
-	SchemasFilename     string `toml:"schemas-file"`
-	AggregationFilename string `toml:"aggregation-file"`
-	Workers             int    `toml:"workers"`
-	MaxUpdatesPerSecond int    `toml:"max-updates-per-second"`
-	Sparse              bool   `toml:"sparse-create"`
-	Enabled             bool   `toml:"enabled"`
+	DataDir             string    `toml:"data-dir"`
+	SchemasFilename     string    `toml:"schemas-file"`
+	AggregationFilename string    `toml:"aggregation-file"`
+	DefaultXFilesFactor float64   `toml:"default-xfilesfactor"`
+	Workers             int       `toml:"workers"`
+	WorkersPerCPU       float64   `toml:"workers-per-cpu"`
+	MaxWorkers          int       `toml:"max-workers"`
+	WorkerChannelSize   int       `toml:"worker-channel-size"`
+	Sharding            string    `toml:"sharding"`
+	ShardingSegments    int       `toml:"sharding-segments"`
+	MatchMode           string    `toml:"match-mode"`
+	NamespaceDepth      int       `toml:"namespace-depth"`
+	NamespaceStats      bool      `toml:"namespace-stats"`
+	MaxUpdatesPerSecond int       `toml:"max-updates-per-second"`
+	MaxCreatesPerSecond int       `toml:"max-creates-per-second"`
+	MirrorMaxUpdates    int       `toml:"mirror-max-updates-per-second"`
+	CreateThreshold     int       `toml:"create-threshold"`
+	CreateWindow        *Duration `toml:"create-threshold-window"`
+	CreateRetries       int       `toml:"create-retries"`
+	CreateRetryBackoff  *Duration `toml:"create-retry-backoff"`
+	MaxRetentionAge     *Duration `toml:"max-retention-age"`
+	WriteStrategy       string    `toml:"write-strategy"`
+	OverflowPolicy      string    `toml:"overflow-policy"`
+	StopTimeout         *Duration `toml:"stop-timeout"`
+	FlushInterval       *Duration `toml:"flush-interval"`
+	FlushMaxPoints      int       `toml:"flush-max-points"`
+	MaxPointsPerUpdate  int       `toml:"max-points-per-update"`
+	Dedup               string    `toml:"dedup"`
+	BatchDedupSize      int       `toml:"batch-dedup-size"`
+	BatchDedupWindow    *Duration `toml:"batch-dedup-window"`
+	MaxOpenFiles        int       `toml:"max-open-files"`
+	SchemaReconcile     bool      `toml:"schema-reconcile"`
+	SchemaReconcileRate int       `toml:"schema-reconcile-rate"`
+	Sparse              bool      `toml:"sparse-create"`
+	Fsync               bool      `toml:"fsync"`
+	FadviseDontNeed     bool      `toml:"fadvise-dontneed"`
+	QuarantineCorrupt   bool      `toml:"quarantine-corrupt"`
+	VerifyOnOpen        bool      `toml:"verify-on-open"`
+	DirMode             string    `toml:"dir-mode"`
+	FileMode            string    `toml:"file-mode"`
+	Owner               string    `toml:"owner"`
+	MaxNameLength       int       `toml:"max-name-length"`
+	AllowedNames        string    `toml:"allowed-names"`
+	NameNormalize       string    `toml:"name-normalize"`
+	TimestampNormalize  string    `toml:"timestamp-normalize"`
+	TimestampAlign      string    `toml:"timestamp-align"`
+	DropFilename        string    `toml:"drop-file"`
+	HashedLayoutDepth   int       `toml:"hashed-layout-depth"`
+	DiskUsageInterval   *Duration `toml:"disk-usage-interval"`
+	DiskUsageMaxDepth   int       `toml:"disk-usage-max-depth"`
+	PunchHolesIdleAge   *Duration `toml:"punch-holes-idle-age"`
+	PunchHolesRate      int       `toml:"punch-holes-rate"`
+	WAL                 bool      `toml:"wal"`
+	WALDir              string    `toml:"wal-dir"`
+	IndexFilename       string    `toml:"index-file"`
+	AuditLog            string    `toml:"audit-log"`
+	ResolveRootOnReload bool      `toml:"resolve-root-on-reload"`
+	LogSamplingWindow   *Duration `toml:"log-sampling-window"`
+	LogSamplingRate     int       `toml:"log-sampling-rate"`
+	DegradedWriteErrors int       `toml:"degraded-write-errors"`
+	SlowWriteThreshold  *Duration `toml:"slow-write-threshold"`
+	StoreTimeout        *Duration `toml:"store-timeout"`
+	OneShotWindow       *Duration `toml:"one-shot-window"`
+	OneShotMaxTracked   int       `toml:"one-shot-max-tracked"`
+	MaxFutureDrift      *Duration `toml:"max-future-drift"`
+	StoreNonFinite      bool      `toml:"store-non-finite"`
+	ArchiveStats        bool      `toml:"archive-stats"`
+	Enabled             bool      `toml:"enabled"`
 	Schemas             persister.WhisperSchemas
 	Aggregation         *persister.WhisperAggregation
+	dirMode             os.FileMode
+	fileMode            os.FileMode
+	uid                 int
+	gid                 int
+	shardFunc           persister.ShardFunc
+	dedupPolicy         points.DedupPolicy
+	allowedNames        *regexp.Regexp
+	nameNormalizer      persister.NameNormalizer
+	timestampNormalizer persister.TimestampNormalizer
+	dropList            *persister.DropList
+
+	Pools    map[string]int `toml:"pools"`     // pool name -> workers
+	DataDirs map[string]int `toml:"data-dirs"` // path -> weight
 }
 
 type cacheConfig struct {
-	MaxSize       uint32 `toml:"max-size"`
-	InputBuffer   int    `toml:"input-buffer"`
-	WriteStrategy string `toml:"write-strategy"`
+	MaxSize        uint32 `toml:"max-size"`
+	InputBuffer    int    `toml:"input-buffer"`
+	WriteStrategy  string `toml:"write-strategy"`
+	OverflowPolicy string `toml:"overflow-policy"`
 }
 
 type udpConfig struct {
@@ -72,8 +166,9 @@ type udpConfig struct {
 }
 
 type tcpConfig struct {
-	Listen  string `toml:"listen"`
-	Enabled bool   `toml:"enabled"`
+	Listen      string `toml:"listen"`
+	Enabled     bool   `toml:"enabled"`
+	MaxLineSize int    `toml:"max-line-size"`
 }
 
 type pickleConfig struct {
@@ -105,6 +200,18 @@ type pprofConfig struct {
 	Enabled bool   `toml:"enabled"`
 }
 
+type prometheusConfig struct {
+	Listen        string  `toml:"listen"`
+	Enabled       bool    `toml:"enabled"`
+	HealthPath    string  `toml:"health-path"`
+	HealthMaxLoad float64 `toml:"health-max-load"`
+}
+
+type introspectConfig struct {
+	Listen  string `toml:"listen"`
+	Enabled bool   `toml:"enabled"`
+}
+
 type dumpConfig struct {
 	Enabled          bool   `toml:"enabled"`
 	Path             string `toml:"path"`
@@ -123,14 +230,23 @@ type Config struct {
 	Carbonserver carbonserverConfig `toml:"carbonserver"`
 	Dump         dumpConfig         `toml:"dump"`
 	Pprof        pprofConfig        `toml:"pprof"`
+	Prometheus   prometheusConfig   `toml:"prometheus"`
+	Introspect   introspectConfig   `toml:"introspection"`
 }
 
 // NewConfig ...
 func NewConfig() *Config {
 	cfg := &Config{
 		Common: commonConfig{
-			Logfile:     "/var/log/go-carbon/go-carbon.log",
-			LogLevel:    "info",
+			Logfile:    "/var/log/go-carbon/go-carbon.log",
+			LogLevel:   "info",
+			LogFormat:  "text",
+			LogMaxSize: 0,
+			LogMaxAge: &Duration{
+				Duration: 0,
+			},
+			LogBackups:  7,
+			LogOutput:   "file",
 			GraphPrefix: "carbon.agents.{host}",
 			MetricInterval: &Duration{
 				Duration: time.Minute,
@@ -138,20 +254,111 @@ func NewConfig() *Config {
 			MetricEndpoint: MetricEndpointLocal,
 			MaxCPU:         1,
 			User:           "",
+			MetricIntervalJitter: &Duration{
+				Duration: 0,
+			},
+			MissingTimestamp: "strict",
+			MetricSink:       "",
+			MetricSinkOnly:   false,
 		},
 		Whisper: whisperConfig{
 			DataDir:             "/data/graphite/whisper/",
 			SchemasFilename:     "/data/graphite/schemas",
 			AggregationFilename: "",
+			DefaultXFilesFactor: persister.DefaultXFilesFactor,
 			MaxUpdatesPerSecond: 0,
+			MaxCreatesPerSecond: 0,
+			MirrorMaxUpdates:    0,
+			CreateThreshold:     0,
+			CreateRetries:       0,
+			CreateRetryBackoff: &Duration{
+				Duration: time.Second,
+			},
+			CreateWindow: &Duration{
+				Duration: time.Minute,
+			},
+			BatchDedupWindow: &Duration{
+				Duration: time.Minute,
+			},
 			Enabled:             true,
-			Workers:             1,
+			Workers:             0,
+			WorkersPerCPU:       1,
+			MaxWorkers:          0,
+			WorkerChannelSize:   0,
+			Sharding:            "crc32",
+			ShardingSegments:    0,
+			MatchMode:           "first",
+			NamespaceDepth:      0,
+			NamespaceStats:      false,
 			Sparse:              false,
+			Fsync:               false,
+			FadviseDontNeed:     false,
+			QuarantineCorrupt:   false,
+			VerifyOnOpen:        false,
+			DirMode:             "",
+			FileMode:            "",
+			Owner:               "",
+			uid:                 -1,
+			gid:                 -1,
+			MaxNameLength:       0,
+			AllowedNames:        "",
+			NameNormalize:       "",
+			TimestampNormalize:  "",
+			TimestampAlign:      "off",
+			DropFilename:        "",
+			HashedLayoutDepth:   0,
+			WriteStrategy:       "noop",
+			OverflowPolicy:      "block",
+			Dedup:               "last",
+			BatchDedupSize:      0,
+			MaxOpenFiles:        0,
+			SchemaReconcile:     false,
+			SchemaReconcileRate: 10,
+			MaxRetentionAge: &Duration{
+				Duration: 0,
+			},
+			StopTimeout: &Duration{
+				Duration: 10 * time.Second,
+			},
+			FlushInterval: &Duration{
+				Duration: 0,
+			},
+			DiskUsageInterval: &Duration{
+				Duration: 0,
+			},
+			DiskUsageMaxDepth: 0,
+			PunchHolesIdleAge: &Duration{
+				Duration: 0,
+			},
+			PunchHolesRate: 10,
+			WAL:            false,
+			WALDir:         "/data/graphite/wal/",
+			LogSamplingWindow: &Duration{
+				Duration: time.Minute,
+			},
+			LogSamplingRate:     10,
+			DegradedWriteErrors: 100,
+			SlowWriteThreshold: &Duration{
+				Duration: 0,
+			},
+			StoreTimeout: &Duration{
+				Duration: 0,
+			},
+			OneShotWindow: &Duration{
+				Duration: 0,
+			},
+			OneShotMaxTracked: 100000,
+			MaxFutureDrift: &Duration{
+				Duration: 0,
+			},
+			StoreNonFinite: false,
+			ArchiveStats:   false,
 		},
 		Cache: cacheConfig{
-			MaxSize:       1000000,
-			InputBuffer:   51200,
-			WriteStrategy: "max",
+			MaxSize:        1000000,
+			InputBuffer:    51200,
+			WriteStrategy:  "max",
+			OverflowPolicy: "drop",
 		},
 		Udp: udpConfig{
 			Listen:        ":2003",
@@ -159,8 +366,9 @@ func NewConfig() *Config {
 			LogIncomplete: false,
 		},
 		Tcp: tcpConfig{
-			Listen:  ":2003",
-			Enabled: true,
+			Listen:      ":2003",
+			Enabled:     true,
+			MaxLineSize: 65536,
 		},
 		Pickle: pickleConfig{
 			Listen:         ":2004",
@@ -197,12 +405,78 @@ func NewConfig() *Config {
 			Listen:  "localhost:7007",
 			Enabled: false,
 		},
+		Prometheus: prometheusConfig{
+			Listen:        ":2112",
+			Enabled:       false,
+			HealthPath:    "/health",
+			HealthMaxLoad: 0.9,
+		},
+		Introspect: introspectConfig{
+			Listen:  "localhost:7008",
+			Enabled: false,
+		},
 		Dump: dumpConfig{},
 	}
 
 	return cfg
 }
 
+// whisperConfigEqual compares persister settings except schemas, aggregation, drop list and values derived from compared fields
+func whisperConfigEqual(a, b whisperConfig) bool {
+	// only resolved workers count is compared
+	a.WorkersPerCPU, b.WorkersPerCPU = 0, 0
+	a.MaxWorkers, b.MaxWorkers = 0, 0
+	a.Schemas, b.Schemas = nil, nil
+	a.Aggregation, b.Aggregation = nil, nil
+	a.dropList, b.dropList = nil, nil
+	a.shardFunc, b.shardFunc = nil, nil
+	a.allowedNames, b.allowedNames = nil, nil
+	a.nameNormalizer, b.nameNormalizer = nil, nil
+	a.timestampNormalizer, b.timestampNormalizer = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// whisperWorkersChanged returns true if persister settings compared by whisperConfigEqual differ by workers count only
+func whisperWorkersChanged(a, b whisperConfig) bool {
+	if a.Workers == b.Workers {
+		return false
+	}
+	a.Workers = b.Workers
+	return whisperConfigEqual(a, b)
+}
+
+// parseFileMode parses octal permissions. Empty string - 0 (default mode)
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid permissions %#v, use octal like \"0755\"", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// parseOwner returns uid and gid of user. Empty name - (-1, -1)
+func parseOwner(name string) (int, int, error) {
+	if name == "" {
+		return -1, -1, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, -1, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
+
 // PrintConfig ...
 func PrintConfig(cfg interface{}) error {
 	buf := new(bytes.Buffer)
@@ -0,0 +1,62 @@
+package carbon
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/lomik/go-carbon/persister"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhisperConfigEqual(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewConfig().Whisper
+	b := NewConfig().Whisper
+	b.Schemas = persister.WhisperSchemas{persister.Schema{Name: "default"}}
+	b.Aggregation = persister.NewWhisperAggregation()
+	b.dropList = &persister.DropList{}
+	a.timestampNormalizer = persister.FixTimestampUnits
+	b.timestampNormalizer = persister.FixTimestampUnits
+	assert.True(whisperConfigEqual(a, b))
+
+	b.Workers = 8
+	assert.False(whisperConfigEqual(a, b))
+	assert.True(whisperWorkersChanged(a, b))
+
+	b.Sparse = true
+	assert.False(whisperWorkersChanged(a, b))
+	assert.False(whisperWorkersChanged(b, b))
+}
+
+func TestInternalStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := persister.ParseRetentionDefs("60s:5y")
+
+	cfg := NewConfig()
+	cfg.Common.GraphPrefix = "carbon.agents.host"
+	cfg.Common.MetricRetention = "60s:7d"
+	cfg.Common.MetricAggregation = "max"
+	cfg.Whisper.Schemas = persister.WhisperSchemas{
+		persister.Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:5y", Retentions: retentions},
+	}
+	cfg.Whisper.Aggregation = persister.NewWhisperAggregation()
+
+	if !assert.NoError(internalStorage(cfg)) {
+		return
+	}
+
+	res := persister.NewWhisper("/", cfg.Whisper.Schemas, cfg.Whisper.Aggregation, nil, nil).
+		Validate([]string{"carbon.agents.host.cache.size", "carbon.agents.hostname.cache.size"})
+
+	assert.Equal("carbon-internal", res[0].Schema)
+	assert.Equal("60s:7d", res[0].Retentions)
+	assert.Equal("max", res[0].AggregationMethod)
+
+	assert.Equal("default", res[1].Schema)
+	assert.Equal("average", res[1].AggregationMethod)
+
+	cfg.Common.MetricAggregation = "unknown"
+	assert.Error(internalStorage(cfg))
+}
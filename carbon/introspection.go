@@ -0,0 +1,49 @@
+package carbon
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ServeConfig serves effective config of running persister as JSON. Persister is replaced or updated on
+// SIGHUP, so response shows settings loaded now
+func (app *App) ServeConfig(w http.ResponseWriter, r *http.Request) {
+	app.RLock()
+	p := app.Persister
+	app.RUnlock()
+
+	if p == nil {
+		http.Error(w, "persister is not running", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p.EffectiveConfig()); err != nil {
+		logrus.Debugf("[introspection] %s", err.Error())
+	}
+}
+
+// startIntrospection starts http listener of /config
+func (app *App) startIntrospection(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", app.ServeConfig)
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logrus.Debugf("[introspection] %s", err.Error())
+		}
+	}()
+
+	app.Introspection = listener
+	return nil
+}
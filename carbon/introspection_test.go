@@ -0,0 +1,44 @@
+package carbon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lomik/go-carbon/persister"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	app := &App{}
+
+	w := httptest.NewRecorder()
+	app.ServeConfig(w, httptest.NewRequest("GET", "/config", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	retentions, _ := persister.ParseRetentionDefs("60s:30d")
+	app.Persister = persister.NewWhisper("/data/whisper", persister.WhisperSchemas{
+		persister.Schema{Name: "default", RetentionStr: "60s:30d", Retentions: retentions},
+	}, persister.NewWhisperAggregation(), nil, nil)
+	app.Persister.SetWorkers(8)
+
+	w = httptest.NewRecorder()
+	app.ServeConfig(w, httptest.NewRequest("GET", "/config", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var c persister.EffectiveConfig
+	if assert.NoError(json.Unmarshal(w.Body.Bytes(), &c)) {
+		assert.Equal("/data/whisper", c.RootPath)
+		assert.Equal(8, c.Workers)
+		if assert.Len(c.Schemas, 1) {
+			assert.Equal("60s:30d", c.Schemas[0].Retentions)
+		}
+		if assert.Len(c.Aggregation, 1) {
+			assert.Equal("average", c.Aggregation[0].AggregationMethod)
+		}
+	}
+}
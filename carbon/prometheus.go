@@ -0,0 +1,133 @@
+package carbon
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// prometheusName converts "module.metric" to valid Prometheus metric name "carbon_module_metric"
+func prometheusName(key string) string {
+	b := []byte("carbon_" + key)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// writePrometheus writes stats in Prometheus text format. All values are gauges: counters
+// of modules are reset on every collect, so value is a delta for the last metric-interval
+func writePrometheus(w io.Writer, stats map[string]float64) {
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := prometheusName(k)
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %s\n", name, name, strconv.FormatFloat(stats[k], 'g', -1, 64))
+	}
+}
+
+// ServeMetrics serves values of last stat collect in Prometheus text format
+func (app *App) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	app.RLock()
+	collector := app.Collector
+	app.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if collector != nil {
+		writePrometheus(w, collector.Last())
+	}
+}
+
+// persisterState is part of persister.Whisper used by health check
+type persisterState interface {
+	Degraded() bool
+	Stalled() bool
+	Load() float64
+}
+
+// healthCheck returns reason why persister is not ready or "" if ready. stats are values of last collect
+func healthCheck(p persisterState, stats map[string]float64, maxLoad float64) string {
+	if p == nil {
+		return ""
+	}
+
+	if p.Degraded() {
+		return "persister is degraded"
+	}
+
+	if p.Stalled() {
+		return "persister worker is stalled in store"
+	}
+
+	if load := p.Load(); maxLoad > 0 && load >= maxLoad {
+		return fmt.Sprintf("persister load %g is over %g", load, maxLoad)
+	}
+
+	// points were queued during last metric-interval, but nothing written
+	updates, collected := stats["persister.updateOperations"]
+	if collected && updates == 0 && stats["persister.load"] > 0 && stats["persister.committedPoints"] == 0 {
+		return "no whisper updates during last metric-interval"
+	}
+
+	return ""
+}
+
+// ServeHealth responds 200 if persister is ready and 503 if it is degraded or backed up
+func (app *App) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	app.RLock()
+	var p persisterState
+	if app.Persister != nil {
+		p = app.Persister
+	}
+	collector := app.Collector
+	var maxLoad float64
+	if app.Config != nil {
+		maxLoad = app.Config.Prometheus.HealthMaxLoad
+	}
+	app.RUnlock()
+
+	var stats map[string]float64
+	if collector != nil {
+		stats = collector.Last()
+	}
+
+	if reason := healthCheck(p, stats, maxLoad); reason != "" {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// startPrometheus starts http listener of /metrics and health check on healthPath ("" - disabled)
+func (app *App) startPrometheus(addr string, healthPath string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", app.ServeMetrics)
+	if healthPath != "" {
+		mux.HandleFunc(healthPath, app.ServeHealth)
+	}
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logrus.Debugf("[prometheus] %s", err.Error())
+		}
+	}()
+
+	app.Prometheus = listener
+	return nil
+}
@@ -0,0 +1,73 @@
+package carbon
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	writePrometheus(buf, map[string]float64{
+		"persister.updateOperations": 10,
+		"persister.updateTime.p50":   0.0015,
+		"cache.size":                 42,
+	})
+
+	assert.Equal(`# TYPE carbon_cache_size gauge
+carbon_cache_size 42
+# TYPE carbon_persister_updateOperations gauge
+carbon_persister_updateOperations 10
+# TYPE carbon_persister_updateTime_p50 gauge
+carbon_persister_updateTime_p50 0.0015
+`, buf.String())
+}
+
+func TestServeHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	app := &App{}
+
+	w := httptest.NewRecorder()
+	app.ServeHealth(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("ok\n", w.Body.String())
+}
+
+type testPersisterState struct {
+	degraded bool
+	stalled  bool
+	load     float64
+}
+
+func (s testPersisterState) Degraded() bool { return s.degraded }
+func (s testPersisterState) Stalled() bool  { return s.stalled }
+func (s testPersisterState) Load() float64  { return s.load }
+
+func TestHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", healthCheck(nil, nil, 0.9))
+	assert.Equal("", healthCheck(testPersisterState{load: 0.5}, nil, 0.9))
+	assert.Equal("persister is degraded", healthCheck(testPersisterState{degraded: true}, nil, 0.9))
+	assert.Equal("persister worker is stalled in store", healthCheck(testPersisterState{stalled: true}, nil, 0.9))
+	assert.NotEqual("", healthCheck(testPersisterState{load: 0.95}, nil, 0.9))
+	// load is not checked
+	assert.Equal("", healthCheck(testPersisterState{load: 1}, nil, 0))
+
+	stalled := map[string]float64{
+		"persister.updateOperations": 0,
+		"persister.committedPoints":  0,
+		"persister.load":             0.1,
+	}
+	assert.NotEqual("", healthCheck(testPersisterState{}, stalled, 0.9))
+
+	stalled["persister.updateOperations"] = 10
+	stalled["persister.committedPoints"] = 100
+	assert.Equal("", healthCheck(testPersisterState{}, stalled, 0.9))
+}
@@ -0,0 +1,119 @@
+package carbon
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// statSinkQueueSize is count of stats waiting for send to sink, new stats are dropped if queue is full
+	statSinkQueueSize = 16384
+	statSinkTimeout   = 5 * time.Second
+)
+
+// statSink sends internal stats to external endpoint, independent of cache and persister, so stats of
+// go-carbon are received even if its own pipeline is broken. Stats are sent without retries
+type statSink struct {
+	network   string
+	address   string
+	endpoint  string
+	chunkSize int
+	format    func(metric string, value float64, now time.Time) string
+	queue     chan string
+}
+
+// newStatSink parses endpoint: "tcp://host:port" and "udp://host:port" - graphite plaintext protocol,
+// "statsd://host:port" - gauges of StatsD over udp
+func newStatSink(endpoint string) (*statSink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host in %#v", endpoint)
+	}
+
+	s := &statSink{
+		network:   u.Scheme,
+		address:   u.Host,
+		endpoint:  endpoint,
+		chunkSize: 32768,
+		format:    graphiteLine,
+		queue:     make(chan string, statSinkQueueSize),
+	}
+
+	switch u.Scheme {
+	case "tcp":
+	case "udp":
+		s.chunkSize = 1000 // mtu friendly
+	case "statsd":
+		s.network = "udp"
+		s.chunkSize = 1000
+		s.format = statsdLine
+	default:
+		return nil, fmt.Errorf("supports only tcp, udp and statsd protocols. %#v is unsupported", u.Scheme)
+	}
+
+	return s, nil
+}
+
+func graphiteLine(metric string, value float64, now time.Time) string {
+	return fmt.Sprintf("%s %s %d\n", metric, strconv.FormatFloat(value, 'f', -1, 64), now.Unix())
+}
+
+func statsdLine(metric string, value float64, now time.Time) string {
+	return fmt.Sprintf("%s:%s|g\n", metric, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// send queues stat, it is dropped if queue is full. Doesn't block
+func (s *statSink) send(metric string, value float64) {
+	select {
+	case s.queue <- s.format(metric, value, time.Now()):
+	default:
+		logrus.WithField("key", metric).WithField("value", value).
+			Warn("[stat] metric-sink queue is full. Metric dropped")
+	}
+}
+
+// run sends queued stats in chunks until exit
+func (s *statSink) run(exit chan bool) {
+	var chunk []byte
+	for {
+		select {
+		case <-exit:
+			return
+		case line := <-s.queue:
+			if len(chunk) > 0 && len(chunk)+len(line) > s.chunkSize {
+				s.write(chunk)
+				chunk = chunk[:0]
+			}
+			chunk = append(chunk, line...)
+			if len(s.queue) == 0 {
+				s.write(chunk)
+				chunk = chunk[:0]
+			}
+		}
+	}
+}
+
+// write sends chunk by new connection. Chunk is dropped on error
+func (s *statSink) write(chunk []byte) {
+	conn, err := net.DialTimeout(s.network, s.address, statSinkTimeout)
+	if err != nil {
+		logrus.Errorf("[stat] dial %s failed: %s", s.endpoint, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(statSinkTimeout)); err == nil {
+		_, err = conn.Write(chunk)
+	}
+	if err != nil {
+		logrus.Errorf("[stat] write to %s failed: %s", s.endpoint, err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package carbon
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatSink(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, endpoint := range []string{"tcp://localhost:2003", "udp://localhost:2003", "statsd://localhost:8125"} {
+		_, err := newStatSink(endpoint)
+		assert.NoError(err, endpoint)
+	}
+
+	for _, endpoint := range []string{"http://localhost:80", "localhost:2003", "tcp://", "%"} {
+		_, err := newStatSink(endpoint)
+		assert.Error(err, endpoint)
+	}
+}
+
+func TestStatSinkStatsd(t *testing.T) {
+	assert := assert.New(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	s, err := newStatSink("statsd://" + conn.LocalAddr().String())
+	if !assert.NoError(err) {
+		return
+	}
+	exit := make(chan bool)
+	defer close(exit)
+	go s.run(exit)
+
+	s.send("carbon.agents.host.cache.size", 42)
+	s.send("carbon.agents.host.persister.load", 0.5)
+
+	var lines []string
+	buf := make([]byte, 2048)
+	conn.SetDeadline(time.Now().Add(time.Second))
+	for len(lines) < 2 {
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.NoError(err) {
+			return
+		}
+		lines = append(lines, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+	}
+	assert.Equal([]string{"carbon.agents.host.cache.size:42|g", "carbon.agents.host.persister.load:0.5|g"}, lines)
+}
+
+func TestStatSinkGraphite(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer listener.Close()
+
+	s, err := newStatSink("tcp://" + listener.Addr().String())
+	if !assert.NoError(err) {
+		return
+	}
+	exit := make(chan bool)
+	defer close(exit)
+	go s.run(exit)
+
+	c := &Collector{}
+	c.SetStatSink(s.send)
+	c.sink("carbon.agents.host.cache.size", 42)
+
+	conn, err := listener.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if assert.NoError(err) {
+		fields := strings.Fields(line)
+		if assert.Len(fields, 3) {
+			assert.Equal("carbon.agents.host.cache.size", fields[0])
+			assert.Equal("42", fields[1])
+		}
+	}
+}
@@ -5,8 +5,7 @@ import "sync/atomic"
 type StatCallback func(metric string, value float64)
 
 func SendAndSubstractUint64(metric string, v *uint64, send StatCallback) {
-	res := atomic.LoadUint64(v)
-	atomic.AddUint64(v, ^uint64(res-1))
+	res := atomic.SwapUint64(v, 0)
 	send(metric, float64(res))
 }
 
@@ -16,8 +15,7 @@ func SendUint64(metric string, v *uint64, send StatCallback) {
 }
 
 func SendAndSubstractUint32(metric string, v *uint32, send StatCallback) {
-	res := atomic.LoadUint32(v)
-	atomic.AddUint32(v, ^uint32(res-1))
+	res := atomic.SwapUint32(v, 0)
 	send(metric, float64(res))
 }
 
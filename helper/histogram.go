@@ -0,0 +1,92 @@
+package helper
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const histogramBuckets = 40
+
+// Histogram counts durations in exponential buckets: [0, 2us), [2us, 4us), [4us, 8us), ...
+// Add is lock free, so it is cheap enough for hot paths
+type Histogram struct {
+	buckets [histogramBuckets]uint32
+}
+
+// HistogramSnapshot contains bucket counters of Histogram
+type HistogramSnapshot [histogramBuckets]uint32
+
+func histogramBucket(d time.Duration) int {
+	us := d.Nanoseconds() / 1000
+	i := 0
+	for us > 1 && i < histogramBuckets-1 {
+		us >>= 1
+		i++
+	}
+	return i
+}
+
+// bucket bounds in microseconds
+func histogramBucketBounds(i int) (float64, float64) {
+	if i == 0 {
+		return 0, 2
+	}
+	return float64(uint64(1) << uint(i)), float64(uint64(1) << uint(i+1))
+}
+
+// Add duration to histogram
+func (h *Histogram) Add(d time.Duration) {
+	atomic.AddUint32(&h.buckets[histogramBucket(d)], 1)
+}
+
+// Reset returns counters collected since previous Reset and substracts them from histogram
+func (h *Histogram) Reset() HistogramSnapshot {
+	var s HistogramSnapshot
+	for i := 0; i < histogramBuckets; i++ {
+		s[i] = atomic.LoadUint32(&h.buckets[i])
+		atomic.AddUint32(&h.buckets[i], ^uint32(s[i]-1))
+	}
+	return s
+}
+
+// Count returns total count of durations
+func (s *HistogramSnapshot) Count() uint64 {
+	var res uint64
+	for i := 0; i < histogramBuckets; i++ {
+		res += uint64(s[i])
+	}
+	return res
+}
+
+// Percentile returns estimated q-th (0 < q <= 1) percentile. Value is interpolated inside bucket
+func (s *HistogramSnapshot) Percentile(q float64) time.Duration {
+	count := s.Count()
+	if count == 0 {
+		return 0
+	}
+
+	rank := q * float64(count)
+	var seen float64
+	for i := 0; i < histogramBuckets; i++ {
+		if s[i] == 0 {
+			continue
+		}
+		if seen+float64(s[i]) >= rank {
+			low, high := histogramBucketBounds(i)
+			us := low + (high-low)*(rank-seen)/float64(s[i])
+			return time.Duration(us * float64(time.Microsecond))
+		}
+		seen += float64(s[i])
+	}
+
+	_, high := histogramBucketBounds(histogramBuckets - 1)
+	return time.Duration(high * float64(time.Microsecond))
+}
+
+// SendAndResetPercentiles sends p50, p95 and p99 (in seconds) of durations collected since previous call
+func SendAndResetPercentiles(metric string, h *Histogram, send StatCallback) {
+	s := h.Reset()
+	send(metric+".p50", s.Percentile(0.50).Seconds())
+	send(metric+".p95", s.Percentile(0.95).Seconds())
+	send(metric+".p99", s.Percentile(0.99).Seconds())
+}
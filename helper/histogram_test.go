@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Histogram{}
+
+	for i := 0; i < 90; i++ {
+		h.Add(100 * time.Microsecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Add(10 * time.Millisecond)
+	}
+	h.Add(time.Second)
+
+	s := h.Reset()
+	assert.Equal(uint64(100), s.Count())
+
+	// exponential buckets: value is in [v/2, 2v]
+	inBucket := func(expected time.Duration, actual time.Duration) {
+		assert.True(actual >= expected/2 && actual <= expected*2, "expected ~%s, actual %s", expected, actual)
+	}
+
+	inBucket(100*time.Microsecond, s.Percentile(0.5))
+	inBucket(10*time.Millisecond, s.Percentile(0.95))
+	inBucket(10*time.Millisecond, s.Percentile(0.99))
+	inBucket(time.Second, s.Percentile(1))
+
+	// reset
+	s = h.Reset()
+	assert.Equal(uint64(0), s.Count())
+	assert.Equal(time.Duration(0), s.Percentile(0.5))
+
+	// very long durations
+	h.Add(1000 * time.Hour)
+	s = h.Reset()
+	assert.Equal(uint64(1), s.Count())
+	assert.True(s.Percentile(0.5) > time.Hour)
+}
+
+func TestSendAndResetPercentiles(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Histogram{}
+	h.Add(time.Millisecond)
+
+	stat := make(map[string]float64)
+	send := func(metric string, value float64) {
+		stat[metric] = value
+	}
+
+	SendAndResetPercentiles("updateTime", h, send)
+	assert.Len(stat, 3)
+	assert.InDelta(0.001, stat["updateTime.p50"], 0.001)
+
+	SendAndResetPercentiles("updateTime", h, send)
+	assert.Equal(float64(0), stat["updateTime.p99"])
+}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -114,3 +115,34 @@ func (f *TextFormatter) appendKeyValue(b *bytes.Buffer, key, value interface{})
 		fmt.Fprintf(b, "%v=%v ", key, value)
 	}
 }
+
+// JSONTimeFormat is format of "time" field of JSONFormatter
+const JSONTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// JSONFormatter writes one JSON object per line with "time", "level", "msg" and fields of entry
+type JSONFormatter struct{}
+
+// Format returns JSON of entry
+func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data)+3)
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case error:
+			// errors are marshaled to {} by encoding/json
+			data[k] = v.Error()
+		default:
+			data[k] = v
+		}
+	}
+	prefixFieldClashes(data)
+
+	data["time"] = entry.Time.Format(JSONTimeFormat)
+	data["level"] = entry.Level.String()
+	data["msg"] = entry.Message
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal log fields to JSON: %s", err.Error())
+	}
+	return append(b, '\n'), nil
+}
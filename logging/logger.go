@@ -2,7 +2,9 @@ package logging
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"os/user"
@@ -10,6 +12,7 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/howeyc/fsnotify"
@@ -17,6 +20,13 @@ import (
 
 var std = NewFileLogger()
 
+// stdRotate is used instead of std if set by SetRotateFile
+var stdRotate *RotateWriter
+var stdRotateMutex sync.RWMutex
+
+// stdHook is set by SetOutput if logs are sent by hook, default output is discarded
+var stdHook *asyncHook
+
 func init() {
 	logrus.SetFormatter(&TextFormatter{})
 
@@ -28,6 +38,18 @@ func init() {
 		for {
 			select {
 			case <-signalChan:
+				reopenRegistered()
+				if hooked() {
+					continue
+				}
+				if w := rotateWriter(); w != nil {
+					err := w.Reopen()
+					logrus.Infof("HUP received, reopen log %#v", w.Filename())
+					if err != nil {
+						logrus.Errorf("Reopen log %#v failed: %s", w.Filename(), err.Error())
+					}
+					continue
+				}
 				err := std.Reopen()
 				logrus.Infof("HUP received, reopen log %#v", std.Filename())
 				if err != nil {
@@ -158,7 +180,66 @@ func (l *FileLogger) Filename() string {
 
 // SetFile for default logger
 func SetFile(filename string) error {
-	return std.Open(filename)
+	stdRotateMutex.Lock()
+	old := stdRotate
+	stdRotate = nil
+	unhook()
+	stdRotateMutex.Unlock()
+
+	err := std.Open(filename)
+
+	if old != nil {
+		old.Close()
+	}
+	return err
+}
+
+// SetRotateFile sets default logger output to RotateWriter. Can be used
+// instead of SetFile where rename of log by external tool is not supported. compress - gzip rotated files
+func SetRotateFile(filename string, maxSize int64, maxAge time.Duration, backups int, compress bool) error {
+	w, err := NewRotateWriter(filename, maxSize, maxAge, backups)
+	if err != nil {
+		return err
+	}
+	w.SetCompress(compress)
+
+	// stop fsnotify watcher and close file of std
+	if err := std.Open(""); err != nil {
+		return err
+	}
+
+	stdRotateMutex.Lock()
+	old := stdRotate
+	stdRotate = w
+	unhook()
+	stdRotateMutex.Unlock()
+
+	logrus.SetOutput(w)
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func rotateWriter() *RotateWriter {
+	stdRotateMutex.RLock()
+	defer stdRotateMutex.RUnlock()
+	return stdRotate
+}
+
+// Setup sets format ("text" or "json") and level of default logger
+func Setup(format string, lvl string) error {
+	switch format {
+	case "", "text":
+		logrus.SetFormatter(&TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %#v, valid values: \"text\", \"json\"", format)
+	}
+
+	return SetLevel(lvl)
 }
 
 // SetLevel for default logger
@@ -239,7 +320,11 @@ func Test(callable func(TestOut)) {
 	callable(buf)
 
 	var loggerOut io.Writer
-	if std.fd != nil {
+	if hooked() {
+		loggerOut = ioutil.Discard
+	} else if w := rotateWriter(); w != nil {
+		loggerOut = w
+	} else if std.fd != nil {
 		loggerOut = std.fd
 	} else {
 		loggerOut = os.Stderr
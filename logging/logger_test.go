@@ -1,7 +1,9 @@
 package logging
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -51,3 +53,38 @@ func TestSetLevel(t *testing.T) {
 	err := SetLevel("unknown")
 	assert.Error(err)
 }
+
+func TestSetupJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	defer logrus.SetFormatter(&TextFormatter{})
+	assert.Error(Setup("xml", "info"))
+
+	originalLevel := logrus.GetLevel()
+	defer logrus.SetLevel(originalLevel)
+	assert.NoError(Setup("json", "info"))
+
+	Test(func(log TestOut) {
+		logrus.WithFields(logrus.Fields{
+			"retention":   "60s:30d",
+			"schema":      "default",
+			"aggregation": "default",
+			"level":       1,
+		}).Info("[persister] Created file")
+
+		var data map[string]interface{}
+		if !assert.NoError(json.Unmarshal([]byte(log.String()), &data)) {
+			return
+		}
+
+		assert.Equal("[persister] Created file", data["msg"])
+		assert.Equal("info", data["level"])
+		assert.Equal("60s:30d", data["retention"])
+		assert.Equal("default", data["schema"])
+		assert.Equal("default", data["aggregation"])
+		assert.Equal(float64(1), data["fields.level"])
+
+		_, err := time.Parse(JSONTimeFormat, data["time"].(string))
+		assert.NoError(err)
+	})
+}
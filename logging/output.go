@@ -0,0 +1,289 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DefaultSyslogTag is tag of syslog messages if not set in output url
+const DefaultSyslogTag = "go-carbon"
+
+const (
+	netHookTimeout = time.Second
+	// netHookMaxBackoff is max wait of net hook between failed dials
+	netHookMaxBackoff = time.Minute
+	// asyncHookBuffer is count of entries waiting for write to unavailable or slow output
+	asyncHookBuffer = 4096
+)
+
+// errNetHookBackoff is returned by net hook after failed dial until next attempt, entry is dropped
+var errNetHookBackoff = errors.New("log output is unavailable")
+
+// Output is parsed destination of logs
+type Output struct {
+	Scheme  string // "file", "syslog", "tcp" or "udp"
+	Network string // network of syslog or net hook, empty for local syslog
+	Address string
+	Tag     string // syslog only
+}
+
+// IsFile returns true if logs are written to logfile (default)
+func (o *Output) IsFile() bool {
+	return o.Scheme == "file"
+}
+
+// ParseOutput parses destination of logs:
+//
+//	"" or "file" - logfile (default)
+//	"syslog://" - local syslog, "syslog://host:514" - remote syslog over udp, "syslog+tcp://host:514" - over tcp.
+//	Tag can be set by query: "syslog://?tag=carbon"
+//	"tcp://host:port", "udp://host:port" - formatted lines to network address
+func ParseOutput(output string) (*Output, error) {
+	if output == "" || output == "file" {
+		return &Output{Scheme: "file"}, nil
+	}
+
+	u, err := url.Parse(output)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "syslog", "syslog+tcp", "syslog+udp":
+		o := &Output{
+			Scheme:  "syslog",
+			Address: u.Host,
+			Tag:     DefaultSyslogTag,
+		}
+		if tag := u.Query().Get("tag"); tag != "" {
+			o.Tag = tag
+		}
+		if u.Host != "" {
+			o.Network = "udp"
+			if u.Scheme == "syslog+tcp" {
+				o.Network = "tcp"
+			}
+		} else if u.Scheme != "syslog" {
+			return nil, fmt.Errorf("log output %#v: address is required for %s", output, u.Scheme)
+		}
+		return o, nil
+	case "tcp", "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("log output %#v: address is required", output)
+		}
+		return &Output{Scheme: u.Scheme, Network: u.Scheme, Address: u.Host}, nil
+	}
+
+	return nil, fmt.Errorf("unknown log output %#v, valid values: \"file\", \"syslog://[host:port]\", \"syslog+tcp://host:port\", \"tcp://host:port\", \"udp://host:port\"", output)
+}
+
+// Hook returns logrus hook for output. Nil for file output
+func (o *Output) Hook() (logrus.Hook, error) {
+	switch o.Scheme {
+	case "syslog":
+		return syslogHook(o)
+	case "tcp", "udp":
+		return &netHook{network: o.Network, address: o.Address}, nil
+	}
+	return nil, nil
+}
+
+// SetOutput sends logs of default logger to destination parsed by ParseOutput instead of logfile.
+// Entries are written in background, so logging doesn't wait for unavailable destination: entries are buffered
+// and dropped if buffer is full. Other hooks of default logger are kept.
+// For "file" output does nothing, SetFile or SetRotateFile should be used
+func SetOutput(output string) error {
+	o, err := ParseOutput(output)
+	if err != nil {
+		return err
+	}
+	if o.IsFile() {
+		return nil
+	}
+
+	hook, err := o.Hook()
+	if err != nil {
+		return err
+	}
+
+	// close logfile
+	if err := SetFile(""); err != nil {
+		return err
+	}
+
+	async := newAsyncHook(hook, asyncHookBuffer)
+
+	stdRotateMutex.Lock()
+	unhook()
+	logrus.StandardLogger().Hooks.Add(async)
+	stdHook = async
+	stdRotateMutex.Unlock()
+
+	logrus.SetOutput(ioutil.Discard)
+
+	return nil
+}
+
+// unhook removes hook set by SetOutput, other hooks are kept. stdRotateMutex should be locked
+func unhook() {
+	if stdHook == nil {
+		return
+	}
+
+	hooks := logrus.StandardLogger().Hooks
+	for level, levelHooks := range hooks {
+		var kept []logrus.Hook
+		for _, h := range levelHooks {
+			if h != logrus.Hook(stdHook) {
+				kept = append(kept, h)
+			}
+		}
+		hooks[level] = kept
+	}
+
+	stdHook.close()
+	stdHook = nil
+}
+
+func hooked() bool {
+	stdRotateMutex.RLock()
+	defer stdRotateMutex.RUnlock()
+	return stdHook != nil
+}
+
+// asyncHook fires entries to hook in background. Fatal and panic entries are fired synchronously, so they are
+// written before exit. Entries dropped by full buffer or backoff of net hook are reported after next write
+type asyncHook struct {
+	hook    logrus.Hook
+	entries chan *logrus.Entry
+	done    chan struct{}
+	dropped uint32
+}
+
+func newAsyncHook(hook logrus.Hook, size int) *asyncHook {
+	h := &asyncHook{
+		hook:    hook,
+		entries: make(chan *logrus.Entry, size),
+		done:    make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *asyncHook) Levels() []logrus.Level {
+	return h.hook.Levels()
+}
+
+func (h *asyncHook) Fire(entry *logrus.Entry) error {
+	if entry.Level <= logrus.FatalLevel {
+		return h.hook.Fire(entry)
+	}
+
+	// entry is reused by logger
+	e := *entry
+	select {
+	case h.entries <- &e:
+	default:
+		atomic.AddUint32(&h.dropped, 1)
+	}
+	return nil
+}
+
+func (h *asyncHook) run() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case e := <-h.entries:
+			if dropped := atomic.SwapUint32(&h.dropped, 0); dropped > 0 {
+				h.fire(&logrus.Entry{
+					Logger:  e.Logger,
+					Data:    logrus.Fields{},
+					Time:    time.Now(),
+					Level:   logrus.WarnLevel,
+					Message: fmt.Sprintf("%d log entries dropped, output was unavailable", dropped),
+				})
+			}
+			h.fire(e)
+		}
+	}
+}
+
+func (h *asyncHook) fire(e *logrus.Entry) {
+	err := h.hook.Fire(e)
+	if err == errNetHookBackoff {
+		atomic.AddUint32(&h.dropped, 1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fire hook: %v\n", err)
+	}
+}
+
+// close stops background writes, buffered entries are dropped
+func (h *asyncHook) close() {
+	close(h.done)
+}
+
+// netHook writes formatted entries to tcp or udp address. Connection is established on first entry and after
+// write errors. After failed dial entries are dropped with errNetHookBackoff until next attempt, wait between
+// attempts is doubled up to netHookMaxBackoff
+type netHook struct {
+	sync.Mutex
+	network string
+	address string
+	conn    net.Conn
+	backoff time.Duration // after last failed dial, 0 - connected
+	retry   time.Time     // of next dial
+}
+
+func (h *netHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *netHook) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if h.conn == nil {
+		now := time.Now()
+		if now.Before(h.retry) {
+			return errNetHookBackoff
+		}
+
+		h.conn, err = net.DialTimeout(h.network, h.address, netHookTimeout)
+		if err != nil {
+			h.conn = nil
+			h.backoff *= 2
+			if h.backoff < netHookTimeout {
+				h.backoff = netHookTimeout
+			}
+			if h.backoff > netHookMaxBackoff {
+				h.backoff = netHookMaxBackoff
+			}
+			h.retry = now.Add(h.backoff)
+			return err
+		}
+		h.backoff = 0
+	}
+
+	h.conn.SetWriteDeadline(time.Now().Add(netHookTimeout))
+	if _, err = h.conn.Write([]byte(line)); err != nil {
+		h.conn.Close()
+		h.conn = nil
+		return err
+	}
+	return nil
+}
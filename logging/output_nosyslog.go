@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package logging
+
+import (
+	"errors"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func syslogHook(o *Output) (logrus.Hook, error) {
+	return nil, errors.New("syslog log output is not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logging
+
+import (
+	"log/syslog"
+
+	"github.com/Sirupsen/logrus"
+	logrus_syslog "github.com/Sirupsen/logrus/hooks/syslog"
+)
+
+func syslogHook(o *Output) (logrus.Hook, error) {
+	return logrus_syslog.NewSyslogHook(o.Network, o.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, o.Tag)
+}
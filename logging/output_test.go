@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		output   string
+		expected *Output
+	}{
+		{"", &Output{Scheme: "file"}},
+		{"file", &Output{Scheme: "file"}},
+		{"syslog://", &Output{Scheme: "syslog", Tag: "go-carbon"}},
+		{"syslog://?tag=carbon", &Output{Scheme: "syslog", Tag: "carbon"}},
+		{"syslog://127.0.0.1:514", &Output{Scheme: "syslog", Network: "udp", Address: "127.0.0.1:514", Tag: "go-carbon"}},
+		{"syslog+udp://127.0.0.1:514", &Output{Scheme: "syslog", Network: "udp", Address: "127.0.0.1:514", Tag: "go-carbon"}},
+		{"syslog+tcp://127.0.0.1:514?tag=c", &Output{Scheme: "syslog", Network: "tcp", Address: "127.0.0.1:514", Tag: "c"}},
+		{"tcp://127.0.0.1:2003", &Output{Scheme: "tcp", Network: "tcp", Address: "127.0.0.1:2003"}},
+		{"udp://127.0.0.1:2003", &Output{Scheme: "udp", Network: "udp", Address: "127.0.0.1:2003"}},
+		{"syslog+tcp://", nil},
+		{"tcp://", nil},
+		{"http://127.0.0.1", nil},
+		{"/var/log/go-carbon.log", nil},
+	}
+
+	for _, c := range table {
+		o, err := ParseOutput(c.output)
+		if c.expected == nil {
+			assert.Error(err, c.output)
+			continue
+		}
+		if assert.NoError(err, c.output) {
+			assert.Equal(c.expected, o, c.output)
+			assert.Equal(c.output == "" || c.output == "file", o.IsFile(), c.output)
+		}
+	}
+
+	o, _ := ParseOutput("file")
+	hook, err := o.Hook()
+	assert.NoError(err)
+	assert.Nil(hook)
+
+	o, _ = ParseOutput("tcp://127.0.0.1:2003")
+	hook, err = o.Hook()
+	assert.NoError(err)
+	assert.IsType(&netHook{}, hook)
+}
+
+func TestNetHook(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	logger := logrus.New()
+	logger.Formatter = &TextFormatter{}
+	logger.Hooks.Add(&netHook{network: "tcp", address: listener.Addr().String()})
+
+	logger.Info("hello")
+	logger.Debug("hidden")
+	logger.Warn("world")
+
+	for _, expected := range []string{"hello", "world"} {
+		select {
+		case line := <-lines:
+			assert.Contains(line, expected)
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", expected)
+		}
+	}
+}
+
+func TestNetHookBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	// address without listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	logger := logrus.New()
+	hook := &netHook{network: "tcp", address: address}
+	entry := logrus.NewEntry(logger)
+
+	err = hook.Fire(entry)
+	assert.Error(err)
+	assert.NotEqual(errNetHookBackoff, err)
+
+	// no dial until retry
+	start := time.Now()
+	assert.Equal(errNetHookBackoff, hook.Fire(entry))
+	assert.True(time.Since(start) < netHookTimeout)
+}
+
+// blockingHook records messages of entries, waits for release before each one
+type blockingHook struct {
+	release  chan bool
+	messages chan string
+}
+
+func (h *blockingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *blockingHook) Fire(entry *logrus.Entry) error {
+	<-h.release
+	h.messages <- entry.Message
+	return nil
+}
+
+func TestAsyncHook(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := &blockingHook{release: make(chan bool), messages: make(chan string, 10)}
+	hook := newAsyncHook(inner, 1)
+	defer hook.close()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+
+	// logging doesn't wait for output, "c" is dropped by full buffer
+	logger.Info("a")
+	for len(hook.entries) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	logger.Info("b")
+	logger.Info("c")
+
+	for _, expected := range []string{"a", "1 log entries dropped, output was unavailable", "b"} {
+		inner.release <- true
+		assert.Equal(expected, <-inner.messages)
+	}
+}
+
+func TestSetOutputKeepsHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	other := &blockingHook{}
+	logrus.StandardLogger().Hooks.Add(other)
+	defer func() {
+		logrus.StandardLogger().Hooks = make(logrus.LevelHooks)
+	}()
+
+	assert.NoError(SetOutput("tcp://" + listener.Addr().String()))
+	assert.True(hooked())
+	assert.Len(logrus.StandardLogger().Hooks[logrus.InfoLevel], 2)
+
+	// only hook of output is removed
+	assert.NoError(SetFile(""))
+	assert.False(hooked())
+	assert.Equal([]logrus.Hook{other}, logrus.StandardLogger().Hooks[logrus.InfoLevel])
+}
@@ -0,0 +1,242 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// RotateWriter writes log to file and rotates it when size or age of file exceeds limit. Rotated files are
+// renamed to filename.1 ... filename.N, older are removed. File is closed before rename, so rotation
+// works on Windows too
+type RotateWriter struct {
+	sync.Mutex
+	filename string
+	maxSize  int64         // 0 - unlimited
+	maxAge   time.Duration // 0 - unlimited
+	backups  int
+	fd       *os.File
+	size     int64
+	opened   time.Time
+	now      func() time.Time
+	compress bool
+	gzipping sync.WaitGroup // compression of filename.1 in background
+}
+
+// NewRotateWriter opens filename for append
+func NewRotateWriter(filename string, maxSize int64, maxAge time.Duration, backups int) (*RotateWriter, error) {
+	w := &RotateWriter{
+		filename: filename,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		backups:  backups,
+		now:      time.Now,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	fd, err := os.OpenFile(w.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+
+	w.fd = fd
+	w.size = info.Size()
+	w.opened = w.now()
+	return nil
+}
+
+func (w *RotateWriter) close() error {
+	if w.fd == nil {
+		return nil
+	}
+	err := w.fd.Close()
+	w.fd = nil
+	return err
+}
+
+// Write implements io.Writer. File is rotated before write if limit exceeded
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.fd == nil || (w.size > 0 && ((w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize) ||
+		(w.maxAge > 0 && w.now().Sub(w.opened) >= w.maxAge))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.fd.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// SetCompress enables gzip of rotated files in background: filename.1.gz ... filename.N.gz. If compression
+// fails, rotated file is left uncompressed
+func (w *RotateWriter) SetCompress(compress bool) {
+	w.Lock()
+	defer w.Unlock()
+	w.compress = compress
+}
+
+// Rotate renames current file to filename.1 and opens new one
+func (w *RotateWriter) Rotate() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.rotate()
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	backup := func(i int) string {
+		return fmt.Sprintf("%s.%d", w.filename, i)
+	}
+
+	if w.backups > 0 {
+		// filename.1 is renamed below
+		w.gzipping.Wait()
+
+		// compressed and not compressed (compression disabled or failed) backups are shifted
+		for _, suffix := range []string{"", ".gz"} {
+			if err := os.Remove(backup(w.backups) + suffix); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for i := w.backups - 1; i > 0; i-- {
+				if err := os.Rename(backup(i)+suffix, backup(i+1)+suffix); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(w.filename, backup(1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if w.compress {
+			w.gzipping.Add(1)
+			go func(filename string) {
+				err := gzipFile(filename)
+				// Done before log: rotate on write of warning waits for it
+				w.gzipping.Done()
+				if err != nil {
+					logrus.Warnf("[logging] Failed to compress %s: %s", filename, err.Error())
+				}
+			}(backup(1))
+		}
+	} else {
+		if err := os.Remove(w.filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return w.open()
+}
+
+// gzipFile compresses filename to filename.gz and removes filename. On error filename is kept
+func gzipFile(filename string) (err error) {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := filename + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filename+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// Reopen closes and opens file without rotation, e.g. after rename by external logrotate
+func (w *RotateWriter) Reopen() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if err := w.close(); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// Close log file. Waits for compression of rotated file
+func (w *RotateWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	w.gzipping.Wait()
+	return w.close()
+}
+
+// Filename returns log filename
+func (w *RotateWriter) Filename() string {
+	return w.filename
+}
+
+// reopened are writers registered by ReopenOnHUP
+var reopened = make(map[*RotateWriter]bool)
+var reopenedMutex sync.Mutex
+
+// ReopenOnHUP reopens w by SIGHUP together with default log, e.g. for separate log renamed by external
+// logrotate. Returned func cancels it
+func ReopenOnHUP(w *RotateWriter) func() {
+	reopenedMutex.Lock()
+	reopened[w] = true
+	reopenedMutex.Unlock()
+
+	return func() {
+		reopenedMutex.Lock()
+		delete(reopened, w)
+		reopenedMutex.Unlock()
+	}
+}
+
+func reopenRegistered() {
+	reopenedMutex.Lock()
+	defer reopenedMutex.Unlock()
+
+	for w := range reopened {
+		if err := w.Reopen(); err != nil {
+			logrus.Errorf("Reopen log %#v failed: %s", w.Filename(), err.Error())
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package logging
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -76,3 +78,185 @@ func TestFsnotifyRotate(t *testing.T) {
 		checkExists(msg)
 	}
 }
+
+func TestRotateWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "go-carbon.log")
+
+	content := func(name string) string {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+
+	w, err := NewRotateWriter(filename, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(w, "message%d\n", i)
+	}
+
+	assert.Equal("message3\n", content(filename))
+	assert.Equal("message2\n", content(filename+".1"))
+	assert.Equal("message1\n", content(filename+".2"))
+	_, err = os.Stat(filename + ".3")
+	assert.True(os.IsNotExist(err))
+
+	// explicit rotate
+	assert.NoError(w.Rotate())
+	assert.Equal("", content(filename))
+	assert.Equal("message3\n", content(filename+".1"))
+	assert.Equal("message2\n", content(filename+".2"))
+
+	// continue existing file on open
+	fmt.Fprint(w, "a\n")
+	assert.NoError(w.Close())
+	w, err = NewRotateWriter(filename, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "b\n")
+	assert.Equal("a\nb\n", content(filename))
+}
+
+func TestRotateWriterMaxAge(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "go-carbon.log")
+
+	now := time.Now()
+	w, err := NewRotateWriter(filename, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.now = func() time.Time { return now }
+	w.opened = now
+
+	fmt.Fprint(w, "first\n")
+	now = now.Add(time.Hour)
+	fmt.Fprint(w, "second\n")
+
+	// without backups old file is removed
+	b, err := ioutil.ReadFile(filename)
+	assert.NoError(err)
+	assert.Equal("second\n", string(b))
+	_, err = os.Stat(filename + ".1")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestRotateWriterCompress(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "go-carbon.log")
+
+	gunzip := func(name string) string {
+		f, err := os.Open(name)
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return ""
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+
+	w, err := NewRotateWriter(filename, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCompress(true)
+
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(w, "message%d\n", i)
+	}
+	// wait for compression
+	assert.NoError(w.Close())
+
+	assert.Equal("message2\n", gunzip(filename+".1.gz"))
+	assert.Equal("message1\n", gunzip(filename+".2.gz"))
+	for _, name := range []string{".1", ".2", ".3.gz", ".1.gz.tmp"} {
+		_, err = os.Stat(filename + name)
+		assert.True(os.IsNotExist(err), name)
+	}
+
+	// failed compression keeps rotated file
+	assert.NoError(os.Mkdir(filename+".1.gz.tmp", 0755))
+	w, err = NewRotateWriter(filename, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCompress(true)
+	assert.NoError(w.Rotate())
+	assert.NoError(w.Close())
+
+	b, err := ioutil.ReadFile(filename + ".1")
+	assert.NoError(err)
+	assert.Equal("message3\n", string(b))
+	assert.Equal("message2\n", gunzip(filename+".2.gz"))
+}
+
+func TestReopenOnHUP(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "audit.log")
+
+	w, err := NewRotateWriter(filename, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	cancel := ReopenOnHUP(w)
+
+	// renamed by logrotate
+	fmt.Fprint(w, "a\n")
+	assert.NoError(os.Rename(filename, filename+".old"))
+	reopenRegistered()
+	fmt.Fprint(w, "b\n")
+
+	b, err := ioutil.ReadFile(filename)
+	assert.NoError(err)
+	assert.Equal("b\n", string(b))
+
+	cancel()
+	reopenedMutex.Lock()
+	assert.False(reopened[w])
+	reopenedMutex.Unlock()
+}
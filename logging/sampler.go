@@ -0,0 +1,207 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Sampler limits logging of repeated messages. Messages with the same format string are logged
+// up to rate times in window, others are counted and reported by one summary line after window:
+// "N occurrences of ... in the last 1m0s". Summaries are logged by next message of the same format or by
+// periodic flush running while messages are suppressed. Nil Sampler logs all messages
+type Sampler struct {
+	sync.Mutex
+	window   time.Duration
+	rate     int
+	messages map[string]*sampledMessage
+	now      func() time.Time
+	tick     <-chan time.Time // periodic flush, nil - ticker of window
+	flushing bool             // periodic flush is running
+	closed   bool
+	exit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type sampledMessage struct {
+	level      logrus.Level
+	start      time.Time
+	count      int
+	suppressed int
+	last       string
+}
+
+// SamplerEntry is Sampler with fields added to every logged message
+type SamplerEntry struct {
+	sampler *Sampler
+	fields  logrus.Fields
+}
+
+// NewSampler creates sampler with rate messages of one format per window
+func NewSampler(window time.Duration, rate int) *Sampler {
+	return &Sampler{
+		window:   window,
+		rate:     rate,
+		messages: make(map[string]*sampledMessage),
+		now:      time.Now,
+		exit:     make(chan struct{}),
+	}
+}
+
+func logf(level logrus.Level, fields logrus.Fields, format string, args ...interface{}) {
+	entry := logrus.WithFields(fields)
+	switch level {
+	case logrus.ErrorLevel:
+		entry.Errorf(format, args...)
+	case logrus.WarnLevel:
+		entry.Warnf(format, args...)
+	case logrus.InfoLevel:
+		entry.Infof(format, args...)
+	default:
+		entry.Debugf(format, args...)
+	}
+}
+
+func (m *sampledMessage) summary(format string, window time.Duration) {
+	logf(m.level, nil, "%d occurrences of %#v in the last %s, last: %s", m.count, format, window.String(), m.last)
+}
+
+func (s *Sampler) log(level logrus.Level, fields logrus.Fields, format string, args ...interface{}) {
+	if s == nil || s.window <= 0 {
+		logf(level, fields, format, args...)
+		return
+	}
+
+	s.Lock()
+	now := s.now()
+	m, exists := s.messages[format]
+	if exists && now.Sub(m.start) >= s.window {
+		if m.suppressed > 0 {
+			m.summary(format, s.window)
+		}
+		exists = false
+	}
+	if !exists {
+		m = &sampledMessage{level: level, start: now}
+		s.messages[format] = m
+	}
+	m.count++
+	allowed := m.count <= s.rate
+	if !allowed {
+		m.suppressed++
+		m.last = fmt.Sprintf(format, args...)
+		if !s.flushing && !s.closed {
+			s.flushing = true
+			s.wg.Add(1)
+			go s.flusher()
+		}
+	}
+	s.Unlock()
+
+	if allowed {
+		logf(level, fields, format, args...)
+	}
+}
+
+// flusher logs summaries of expired windows periodically. Exits if all windows are expired or sampler is closed
+func (s *Sampler) flusher() {
+	defer s.wg.Done()
+
+	tick := s.tick
+	if tick == nil {
+		ticker := time.NewTicker(s.window)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.exit:
+			return
+		case <-tick:
+		}
+
+		s.Lock()
+		if !s.flushExpired(s.now()) {
+			s.flushing = false
+			s.Unlock()
+			return
+		}
+		s.Unlock()
+	}
+}
+
+// flushExpired logs summaries of windows expired at now and removes them. Returns false if no windows left.
+// Called under lock
+func (s *Sampler) flushExpired(now time.Time) bool {
+	for format, m := range s.messages {
+		if now.Sub(m.start) >= s.window {
+			if m.suppressed > 0 {
+				m.summary(format, s.window)
+			}
+			delete(s.messages, format)
+		}
+	}
+	return len(s.messages) > 0
+}
+
+// Close stops periodic flush. Summaries of later messages are logged by next message of the same format or by
+// Flush only
+func (s *Sampler) Close() {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.exit)
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+}
+
+// Flush logs summaries of suppressed messages and resets all windows
+func (s *Sampler) Flush() {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for format, m := range s.messages {
+		if m.suppressed > 0 {
+			m.summary(format, s.window)
+		}
+	}
+	s.messages = make(map[string]*sampledMessage)
+}
+
+// Errorf logs sampled message with level error
+func (s *Sampler) Errorf(format string, args ...interface{}) {
+	s.log(logrus.ErrorLevel, nil, format, args...)
+}
+
+// Warnf logs sampled message with level warning
+func (s *Sampler) Warnf(format string, args ...interface{}) {
+	s.log(logrus.WarnLevel, nil, format, args...)
+}
+
+// WithFields returns entry logging with fields. Fields are not included in summary
+func (s *Sampler) WithFields(fields logrus.Fields) *SamplerEntry {
+	return &SamplerEntry{sampler: s, fields: fields}
+}
+
+// Errorf logs sampled message with level error
+func (e *SamplerEntry) Errorf(format string, args ...interface{}) {
+	e.sampler.log(logrus.ErrorLevel, e.fields, format, args...)
+}
+
+// Warnf logs sampled message with level warning
+func (e *SamplerEntry) Warnf(format string, args ...interface{}) {
+	e.sampler.log(logrus.WarnLevel, e.fields, format, args...)
+}
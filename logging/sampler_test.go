@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	s := NewSampler(time.Minute, 2)
+	s.now = func() time.Time { return now }
+
+	Test(func(log TestOut) {
+		for i := 0; i < 5; i++ {
+			s.Errorf("No storage schema defined for metric%d", i)
+		}
+		s.WithFields(logrus.Fields{"path": "/a.wsp"}).Warnf("Other %d", 1)
+
+		lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+		if assert.Len(lines, 3) {
+			assert.Contains(lines[0], "metric0")
+			assert.Contains(lines[1], "metric1")
+			assert.Contains(lines[2], "Other 1")
+			assert.Contains(lines[2], "/a.wsp")
+		}
+
+		// summary on next message after window
+		now = now.Add(time.Minute)
+		s.Errorf("No storage schema defined for metric%d", 5)
+
+		lines = strings.Split(strings.TrimSpace(log.String()), "\n")
+		if assert.Len(lines, 5) {
+			assert.Contains(lines[3], "5 occurrences")
+			assert.Contains(lines[3], "last: No storage schema defined for metric4")
+			assert.Contains(lines[4], "metric5")
+		}
+
+		// nothing suppressed
+		s.Flush()
+		assert.Len(strings.Split(strings.TrimSpace(log.String()), "\n"), 5)
+	})
+
+	// nil sampler logs all
+	var nilSampler *Sampler
+	Test(func(log TestOut) {
+		for i := 0; i < 3; i++ {
+			nilSampler.Errorf("message %d", i)
+		}
+		nilSampler.Flush()
+		assert.Len(strings.Split(strings.TrimSpace(log.String()), "\n"), 3)
+	})
+}
+
+func TestSamplerPeriodicFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	tick := make(chan time.Time)
+	s := NewSampler(time.Minute, 1)
+	s.now = func() time.Time { return now }
+	s.tick = tick
+
+	Test(func(log TestOut) {
+		for i := 0; i < 3; i++ {
+			s.Errorf("Failed to store metric%d", i)
+		}
+		assert.Len(strings.Split(strings.TrimSpace(log.String()), "\n"), 1)
+
+		// window is not expired
+		tick <- now
+		assert.Len(strings.Split(strings.TrimSpace(log.String()), "\n"), 1)
+
+		// summary without next message, clock is read by flush under lock
+		s.Lock()
+		now = now.Add(time.Minute)
+		s.Unlock()
+		tick <- now
+		s.Close()
+		lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+		if assert.Len(lines, 2) {
+			assert.Contains(lines[1], "3 occurrences")
+			assert.Contains(lines[1], "last: Failed to store metric2")
+		}
+
+		// no periodic flush after close
+		for i := 0; i < 3; i++ {
+			s.Errorf("Failed to store metric%d", i)
+		}
+		s.Lock()
+		assert.False(s.flushing)
+		s.Unlock()
+	})
+}
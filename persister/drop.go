@@ -0,0 +1,101 @@
+package persister
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// DropList is list of patterns of metrics never written to disk
+type DropList struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+// ReadDropList reads patterns from file, one per line. Lines with "regexp:" prefix are regular expressions,
+// others are globs matched by segments of name: "*" matches any part of one segment, e.g. "debug.*.latency".
+// Empty lines and lines starting with "#" are skipped
+func ReadDropList(filename string) (*DropList, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d := &DropList{}
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "regexp:") {
+			re, err := regexp.Compile(strings.TrimPrefix(line, "regexp:"))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", filename, n, err.Error())
+			}
+			d.regexps = append(d.regexps, re)
+			continue
+		}
+
+		glob := strings.Replace(line, ".", "/", -1)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid glob %#v", filename, n, line)
+		}
+		d.globs = append(d.globs, glob)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Match returns true if metric matches any pattern of list
+func (d *DropList) Match(metric string) bool {
+	if d == nil {
+		return false
+	}
+
+	if len(d.globs) > 0 {
+		name := strings.Replace(metric, ".", "/", -1)
+		for _, glob := range d.globs {
+			if ok, _ := path.Match(glob, name); ok {
+				return true
+			}
+		}
+	}
+
+	for _, re := range d.regexps {
+		if re.MatchString(metric) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetDropList replaces list of metrics which are discarded by persister (persister.dropped metric).
+// Safe for running persister. nil - all metrics are written
+func (p *Whisper) SetDropList(d *DropList) {
+	p.dropList.Store(d)
+}
+
+// drop returns true and counts values of metric from drop list
+func (p *Whisper) drop(values *points.Points) bool {
+	d, _ := p.dropList.Load().(*DropList)
+	if !d.Match(values.Metric) {
+		return false
+	}
+	atomic.AddUint32(&p.dropped, 1)
+	return true
+}
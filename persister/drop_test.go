@@ -0,0 +1,99 @@
+package persister
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseDropList(t *testing.T, content string) (*DropList, error) {
+	tmpFile, err := ioutil.TempFile("", "drop-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Write([]byte(content))
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	return ReadDropList(tmpFile.Name())
+}
+
+func TestReadDropList(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := parseDropList(t, `
+# debug metrics
+debug.*.latency
+tmp.*
+
+regexp:\.test$
+`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, metric := range []string{"debug.a.latency", "tmp.a", "foo.bar.test"} {
+		assert.True(d.Match(metric), metric)
+	}
+	for _, metric := range []string{"debug.a.b.latency", "tmp.a.b", "foo.test.bar", "debug"} {
+		assert.False(d.Match(metric), metric)
+	}
+
+	var empty *DropList
+	assert.False(empty.Match("tmp.a"))
+
+	_, err = parseDropList(t, "regexp:(")
+	assert.Error(err)
+
+	_, err = parseDropList(t, "foo.[")
+	assert.Error(err)
+}
+
+func TestDropList(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		d, err := parseDropList(t, "debug.*")
+		if !assert.NoError(err) {
+			return
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetDropList(d)
+
+		now := time.Now().Unix()
+		store(p, points.OnePoint("debug.a", 1, now))
+		store(p, points.OnePoint("prod.a", 1, now))
+
+		_, err = os.Stat(filepath.Join(root, "debug", "a.wsp"))
+		assert.True(os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(root, "prod", "a.wsp"))
+		assert.NoError(err)
+
+		var dropped float64
+		p.Stat(func(metric string, value float64) {
+			if metric == "dropped" {
+				dropped = value
+			}
+		})
+		assert.Equal(float64(1), dropped)
+
+		// reload
+		p.SetDropList(nil)
+		store(p, points.OnePoint("debug.a", 1, now))
+		_, err = os.Stat(filepath.Join(root, "debug", "a.wsp"))
+		assert.NoError(err)
+	})
+}
@@ -0,0 +1,134 @@
+package persister
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+)
+
+// MatchMode is order in which patterns of storage schemas and aggregation sections are matched
+type MatchMode int
+
+const (
+	// MatchFirst - the first matching section wins: schemas by priority and position in file, aggregation by
+	// position in file. Default, as in graphite
+	MatchFirst MatchMode = iota
+	// MatchMostSpecific - matching section with the highest specificity score of pattern wins, sections with
+	// equal score are matched in MatchFirst order
+	MatchMostSpecific
+)
+
+// ParseMatchMode parses "first" (or "") and "most-specific"
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch s {
+	case "", "first":
+		return MatchFirst, nil
+	case "most-specific":
+		return MatchMostSpecific, nil
+	}
+	return MatchFirst, fmt.Errorf("unknown match mode %#v, supported: first, most-specific", s)
+}
+
+func (m MatchMode) String() string {
+	if m == MatchMostSpecific {
+		return "most-specific"
+	}
+	return "first"
+}
+
+// specificity returns score of pattern: count of literal characters which every matched name contains. E.g.
+// ^servers\.web\..*\.cpu$ - 16, ^servers\. - 8, .* - 0. Anchors, classes and optional parts score 0, the shortest
+// branch of alternation is counted, repetition is counted by its minimum
+func specificity(pattern *regexp.Regexp) int {
+	if pattern == nil {
+		return 0
+	}
+	re, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	return literalScore(re)
+}
+
+func literalScore(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalScore(re.Sub[0])
+	case syntax.OpRepeat:
+		return literalScore(re.Sub[0]) * re.Min
+	case syntax.OpConcat:
+		var score int
+		for _, sub := range re.Sub {
+			score += literalScore(sub)
+		}
+		return score
+	case syntax.OpAlternate:
+		score := -1
+		for _, sub := range re.Sub {
+			if s := literalScore(sub); score < 0 || s < score {
+				score = s
+			}
+		}
+		if score < 0 {
+			return 0
+		}
+		return score
+	}
+	return 0
+}
+
+// schemasBySpecificity sorts schemas by score, then by priority
+type schemasBySpecificity struct {
+	schemas WhisperSchemas
+	scores  []int
+}
+
+func (s schemasBySpecificity) Len() int { return len(s.schemas) }
+func (s schemasBySpecificity) Swap(i, j int) {
+	s.schemas[i], s.schemas[j] = s.schemas[j], s.schemas[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
+func (s schemasBySpecificity) Less(i, j int) bool {
+	if s.scores[i] != s.scores[j] {
+		return s.scores[i] > s.scores[j]
+	}
+	return s.schemas[i].Priority > s.schemas[j].Priority
+}
+
+// SetMatchMode reorders schemas in place, so Match returns schema chosen by mode
+func (s WhisperSchemas) SetMatchMode(mode MatchMode) {
+	if mode != MatchMostSpecific {
+		sort.Stable(s)
+		return
+	}
+
+	scores := make([]int, len(s))
+	for i := range s {
+		scores[i] = specificity(s[i].Pattern)
+	}
+	sort.Stable(schemasBySpecificity{schemas: s, scores: scores})
+}
+
+// aggregationBySpecificity sorts sections by score, then by position in file if byScore is set
+type aggregationBySpecificity struct {
+	items   []*whisperAggregationItem
+	byScore bool
+}
+
+func (a aggregationBySpecificity) Len() int      { return len(a.items) }
+func (a aggregationBySpecificity) Swap(i, j int) { a.items[i], a.items[j] = a.items[j], a.items[i] }
+func (a aggregationBySpecificity) Less(i, j int) bool {
+	if a.byScore && a.items[i].score != a.items[j].score {
+		return a.items[i].score > a.items[j].score
+	}
+	return a.items[i].position < a.items[j].position
+}
+
+// SetMatchMode reorders aggregation sections, so they are matched in order chosen by mode. Sections added by
+// Prepend later are matched before all
+func (a *WhisperAggregation) SetMatchMode(mode MatchMode) {
+	sort.Stable(aggregationBySpecificity{items: a.Data, byScore: mode == MatchMostSpecific})
+}
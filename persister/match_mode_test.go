@@ -0,0 +1,113 @@
+package persister
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMatchMode(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, expected := range map[string]MatchMode{"": MatchFirst, "first": MatchFirst, "most-specific": MatchMostSpecific} {
+		mode, err := ParseMatchMode(s)
+		assert.NoError(err)
+		assert.Equal(expected, mode)
+	}
+	_, err := ParseMatchMode("longest")
+	assert.Error(err)
+	assert.Equal("most-specific", MatchMostSpecific.String())
+}
+
+func TestSpecificity(t *testing.T) {
+	assert := assert.New(t)
+
+	for pattern, score := range map[string]int{
+		`^servers\.web\..*\.cpu$`: 16,
+		`^servers\.`:              8,
+		`.*`:                      0,
+		`^(foo|barbaz)\.`:         4,
+		`^a+b?c*`:                 1,
+		`^x{3}\.`:                 4,
+		`(?i)^CPU[0-9]`:           3,
+	} {
+		assert.Equal(score, specificity(regexp.MustCompile(pattern)), pattern)
+	}
+	assert.Equal(0, specificity(nil))
+}
+
+func TestSchemasMatchMode(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas, err := parseSchemas(t, `
+[servers]
+pattern = ^servers\.
+retentions = 60s:1d
+
+[web_cpu]
+pattern = ^servers\.web\..*\.cpu$
+retentions = 10s:1d
+
+[important]
+pattern = ^servers\.db\.
+retentions = 1s:1d
+priority = 10
+`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	match := func(metric string) string {
+		schema, _ := schemas.Match(metric)
+		return schema.Name
+	}
+
+	assert.Equal("servers", match("servers.web.host1.cpu"))
+	assert.Equal("important", match("servers.db.host1.cpu"))
+
+	schemas.SetMatchMode(MatchMostSpecific)
+	assert.Equal("web_cpu", match("servers.web.host1.cpu"))
+	assert.Equal("servers", match("servers.web.host1.mem"))
+	// priority orders only schemas with equal score
+	assert.Equal("important", match("servers.db.host1.cpu"))
+
+	schemas.SetMatchMode(MatchFirst)
+	assert.Equal("servers", match("servers.web.host1.cpu"))
+}
+
+func TestAggregationMatchMode(t *testing.T) {
+	assert := assert.New(t)
+
+	aggr, err := parseAggregation(t, `
+[count]
+pattern = \.count$
+aggregationMethod = sum
+
+[latency_count]
+pattern = \.latency\.count$
+aggregationMethod = max
+
+[default]
+aggregationMethod = average
+`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(whisper.Sum, aggr.match("app.latency.count").aggregationMethod)
+
+	aggr.SetMatchMode(MatchMostSpecific)
+	assert.Equal(whisper.Max, aggr.match("app.latency.count").aggregationMethod)
+	assert.Equal(whisper.Sum, aggr.match("app.requests.count").aggregationMethod)
+	assert.Equal(whisper.Average, aggr.match("app.requests.rate").aggregationMethod)
+
+	// prepended later is matched before all
+	assert.NoError(aggr.Prepend("internal", regexp.MustCompile(`^app\.`), "last"))
+	assert.Equal(whisper.Last, aggr.match("app.latency.count").aggregationMethod)
+
+	aggr.SetMatchMode(MatchFirst)
+	assert.Equal(whisper.Last, aggr.match("app.latency.count").aggregationMethod)
+	assert.Equal(whisper.Sum, aggr.match("foo.latency.count").aggregationMethod)
+}
@@ -0,0 +1,101 @@
+package persister
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetNameValidation sets max length of metric name (0 - unlimited) and regexp of allowed names (nil - any).
+// Names with control characters or empty segments are always rejected
+func (p *Whisper) SetNameValidation(maxLength int, allowed *regexp.Regexp) {
+	p.maxNameLength = maxLength
+	p.allowedNames = allowed
+}
+
+// NameNormalizer maps metric name to name used for schema matching and whisper file path
+type NameNormalizer func(metric string) string
+
+// NewNameNormalizer returns normalizer by name: "" - none (nil), "lower" - lowercase of name including tags
+func NewNameNormalizer(name string) (NameNormalizer, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "lower":
+		return strings.ToLower, nil
+	default:
+		return nil, fmt.Errorf("unknown name normalizer %#v", name)
+	}
+}
+
+// SetNameNormalizer enables normalization of metric names before store, so names differing only by
+// e.g. case are written to one whisper file. Values in cache keep received names. nil - disabled
+func (p *Whisper) SetNameNormalizer(fn NameNormalizer) {
+	p.nameNormalizer = fn
+}
+
+// normalizeName returns values with normalized metric name. Received values are not modified
+// because they are still visible for carbonlink until confirmed
+func (p *Whisper) normalizeName(values *points.Points) *points.Points {
+	if p.nameNormalizer == nil {
+		return values
+	}
+	metric := p.nameNormalizer(values.Metric)
+	if metric == values.Metric {
+		return values
+	}
+	return &points.Points{Metric: metric, Data: values.Data}
+}
+
+// validateName checks metric name before store
+func (p *Whisper) validateName(metric string) error {
+	if metric == "" {
+		return fmt.Errorf("empty metric name")
+	}
+
+	if p.maxNameLength > 0 && len(metric) > p.maxNameLength {
+		return fmt.Errorf("metric name is longer than %d bytes", p.maxNameLength)
+	}
+
+	for i := 0; i < len(metric); i++ {
+		if metric[i] < 0x20 || metric[i] == 0x7f {
+			return fmt.Errorf("control character %#x in metric name", metric[i])
+		}
+	}
+
+	if emptySegment(metric) {
+		return fmt.Errorf("empty segment in metric name")
+	}
+
+	if p.allowedNames != nil && !p.allowedNames.MatchString(metric) {
+		return fmt.Errorf("metric name doesn't match %s", p.allowedNames.String())
+	}
+
+	return nil
+}
+
+// emptySegment returns true if name (without tags) is empty or dot-only, starts or ends with dot or contains "..",
+// i.e. path of its file would have empty directory or be {root}/.wsp
+func emptySegment(metric string) bool {
+	name := metric
+	if i := strings.IndexByte(metric, ';'); i >= 0 {
+		name = metric[:i]
+	}
+	return name == "" || name[0] == '.' || name[len(name)-1] == '.' || strings.Contains(name, "..")
+}
+
+// rejectName counts invalid name and logs sample of rejected names, one per second
+func (p *Whisper) rejectName(metric string, err error) {
+	atomic.AddUint32(&p.invalidNames, 1)
+
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&p.invalidNameLogged)
+	if last != now && atomic.CompareAndSwapInt64(&p.invalidNameLogged, last, now) {
+		logrus.Warningf("[persister] Invalid metric name %#v: %s", metric, err.Error())
+	}
+}
@@ -0,0 +1,150 @@
+package persister
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateName(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("", nil, nil, nil, nil)
+
+	for _, metric := range []string{"a", "a.b.c", "a.b;tag=value", "a-b_c.d:e"} {
+		assert.NoError(p.validateName(metric), metric)
+	}
+
+	for _, metric := range []string{"", "a..b", ".a", "a.", ";tag=value", "a\x00b", "a\nb", "a\x7f"} {
+		assert.Error(p.validateName(metric), metric)
+	}
+
+	p.SetNameValidation(5, regexp.MustCompile(`^[a-z.]+$`))
+	assert.NoError(p.validateName("a.b.c"))
+	assert.Error(p.validateName("a.b.cd"))
+	assert.Error(p.validateName("a.B"))
+}
+
+func TestInvalidNames(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	s := &memoryStore{data: make(map[string][]points.Point)}
+	p := NewPersister(s, in, confirm)
+	p.SetNameValidation(0, regexp.MustCompile(`^a\.`))
+
+	in <- points.OnePoint("a.b", 1, 10)
+	in <- points.OnePoint("b.c", 1, 10)
+	in <- points.OnePoint("a..c", 1, 10)
+	close(in)
+
+	p.worker(in, make(chan bool), in)
+
+	assert.Len(s.data, 1)
+	assert.Len(s.data["a.b"], 1)
+	assert.Equal(uint32(2), p.invalidNames)
+
+	// rejected values are confirmed too
+	assert.Len(confirm, 3)
+}
+
+func TestStoreEmptySegment(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		// rejected even if path encoder accepts such names
+		p.SetPathEncoder(PlainPathEncoder{})
+
+		now := time.Now().Unix()
+		names := []string{"", ".", "..", "a..b", ".a", "a.", "a..b;tag=value"}
+		for _, metric := range names {
+			assert.NoError(store(p, points.OnePoint(metric, 1, now)), metric)
+		}
+		assert.Equal(uint32(len(names)), p.invalidNames)
+
+		files, err := ioutil.ReadDir(root)
+		if assert.NoError(err) {
+			assert.Len(files, 0)
+		}
+
+		assert.NoError(store(p, points.OnePoint("a.b", 1, now)))
+		assert.Equal(uint32(len(names)), p.invalidNames)
+	})
+}
+
+func TestNewNameNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	fn, err := NewNameNormalizer("")
+	assert.NoError(err)
+	assert.Nil(fn)
+
+	fn, err = NewNameNormalizer("lower")
+	if assert.NoError(err) {
+		assert.Equal("foo.bar;tag=value", fn("Foo.BAR;Tag=Value"))
+	}
+
+	_, err = NewNameNormalizer("upper")
+	assert.Error(err)
+}
+
+func TestNameNormalizerCollision(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		fn, _ := NewNameNormalizer("lower")
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetNameNormalizer(fn)
+
+		now := time.Now().Unix()
+		received := points.OnePoint("Foo.Bar", 1, now-2)
+		store(p, received)
+		store(p, points.OnePoint("foo.bar", 2, now-1))
+		store(p, points.OnePoint("FOO.bar", 3, now))
+
+		// received values are not modified
+		assert.Equal("Foo.Bar", received.Metric)
+
+		// one file for all names
+		files, err := ioutil.ReadDir(root)
+		if assert.NoError(err) && assert.Len(files, 1) {
+			assert.Equal("foo", files[0].Name())
+		}
+		files, err = ioutil.ReadDir(filepath.Join(root, "foo"))
+		if assert.NoError(err) && assert.Len(files, 1) {
+			assert.Equal("bar.wsp", files[0].Name())
+		}
+
+		w, err := whisper.Open(filepath.Join(root, "foo", "bar.wsp"))
+		if !assert.NoError(err) {
+			return
+		}
+		defer w.Close()
+
+		series, err := w.Fetch(int(now-3), int(now))
+		if assert.NoError(err) {
+			values := series.Values()
+			assert.Equal([]float64{1, 2, 3}, values[len(values)-3:])
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package persister
+
+import (
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+)
+
+// PathEncoder maps metric name to whisper file path
+type PathEncoder interface {
+	Path(root string, metric string) (string, error)
+}
+
+// PlainPathEncoder replaces dots with slashes: {root}/a/b/c.wsp for a.b.c. Tagged metrics are
+// stored in {root}/_tagged/ (see TaggedFilePath). Metric name is not validated
+type PlainPathEncoder struct{}
+
+// Path implements PathEncoder
+func (e PlainPathEncoder) Path(root string, metric string) (string, error) {
+	return MetricFilePath(root, metric)
+}
+
+// SafePathEncoder is PlainPathEncoder which rejects metric names with empty segments,
+// slashes and NUL bytes, so resulting path is always inside root
+type SafePathEncoder struct{}
+
+// Path implements PathEncoder
+func (e SafePathEncoder) Path(root string, metric string) (string, error) {
+	if metric == "" {
+		return "", fmt.Errorf("empty metric name")
+	}
+
+	if strings.IndexByte(metric, 0) >= 0 {
+		return "", fmt.Errorf("NUL byte in metric name")
+	}
+
+	if !IsTagged(metric) {
+		if strings.IndexByte(metric, '/') >= 0 || strings.IndexByte(metric, '\\') >= 0 {
+			return "", fmt.Errorf("slash in metric name")
+		}
+
+		for _, segment := range strings.Split(metric, ".") {
+			if segment == "" {
+				return "", fmt.Errorf("empty segment in metric name")
+			}
+		}
+	}
+
+	path, err := MetricFilePath(root, metric)
+	if err != nil {
+		return "", err
+	}
+
+	// paranoid check
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %#v is outside of root", path)
+	}
+
+	return path, nil
+}
+
+// SetPathEncoder sets metric to file path mapping. Default is SafePathEncoder
+func (p *Whisper) SetPathEncoder(encoder PathEncoder) {
+	p.pathEncoder = encoder
+}
+
+// HashedPathEncoderMaxDepth is max count of hash directory levels
+const HashedPathEncoderMaxDepth = 4
+
+// HashedPathEncoder inserts Depth directory levels named by bytes of metric name hash between root and
+// path of Encoder: {root}/1f/a0/a/b/c.wsp for a.b.c and Depth 2. Files are spread evenly by 256 directories
+// on each level. Not compatible with graphite-web and carbonserver, they look for {root}/a/b/c.wsp
+type HashedPathEncoder struct {
+	Encoder PathEncoder
+	Depth   int
+}
+
+// Path implements PathEncoder
+func (e HashedPathEncoder) Path(root string, metric string) (string, error) {
+	path, err := e.Encoder.Path(root, metric)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(metric))
+	levels := make([]string, 0, e.Depth+2)
+	levels = append(levels, root)
+	for i := 0; i < e.Depth && i < HashedPathEncoderMaxDepth; i++ {
+		levels = append(levels, fmt.Sprintf("%02x", byte(hash>>uint(24-8*i))))
+	}
+	levels = append(levels, rel)
+
+	return filepath.Join(levels...), nil
+}
+
+// SetHashedLayout enables HashedPathEncoder with depth levels (up to HashedPathEncoderMaxDepth) over current
+// path encoder. 0 - disabled. Existing files of other layout are not moved
+func (p *Whisper) SetHashedLayout(depth int) {
+	encoder := p.pathEncoder
+	if hashed, ok := encoder.(HashedPathEncoder); ok {
+		encoder = hashed.Encoder
+	}
+
+	if depth <= 0 {
+		p.pathEncoder = encoder
+		return
+	}
+	p.pathEncoder = HashedPathEncoder{Encoder: encoder, Depth: depth}
+}
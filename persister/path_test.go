@@ -0,0 +1,111 @@
+package persister
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlainPathEncoder(t *testing.T) {
+	assert := assert.New(t)
+
+	path, err := PlainPathEncoder{}.Path("/data", "carbon.agents.host1.cache.size")
+	if assert.NoError(err) {
+		assert.Equal("/data/carbon/agents/host1/cache/size.wsp", path)
+	}
+
+	// not validated
+	path, err = PlainPathEncoder{}.Path("/data", "a..b")
+	if assert.NoError(err) {
+		assert.Equal("/data/a/b.wsp", path)
+	}
+}
+
+func TestSafePathEncoder(t *testing.T) {
+	assert := assert.New(t)
+
+	good := map[string]string{
+		"carbon.agents.host1.cache.size": "/data/carbon/agents/host1/cache/size.wsp",
+		"a-b_c.d:e":                      "/data/a-b_c/d:e.wsp",
+	}
+
+	for metric, expected := range good {
+		path, err := SafePathEncoder{}.Path("/data", metric)
+		if assert.NoError(err, metric) {
+			assert.Equal(expected, path, metric)
+		}
+	}
+
+	bad := []string{
+		"",
+		".",
+		"..",
+		"a..b",
+		".a.b",
+		"a.b.",
+		"a/../../../etc/passwd",
+		"/etc/passwd",
+		"a\\b",
+		"a\x00b",
+		"a;tag",
+	}
+
+	for _, metric := range bad {
+		_, err := SafePathEncoder{}.Path("/data", metric)
+		assert.Error(err, "%#v", metric)
+	}
+
+	// tags are escaped
+	path, err := SafePathEncoder{}.Path("/data", "a.b;path=/../../etc")
+	if assert.NoError(err) {
+		assert.Contains(path, "/data/_tagged/")
+	}
+}
+
+type testPathEncoder struct{}
+
+func (e testPathEncoder) Path(root string, metric string) (string, error) {
+	return root + "/" + metric + ".wsp", nil
+}
+
+func TestSetPathEncoder(t *testing.T) {
+	p := NewWhisper("/data", nil, nil, nil, nil)
+	p.SetPathEncoder(testPathEncoder{})
+	assert.Equal(t, testPathEncoder{}, p.pathEncoder)
+}
+
+func TestHashedPathEncoder(t *testing.T) {
+	assert := assert.New(t)
+
+	e := HashedPathEncoder{Encoder: SafePathEncoder{}, Depth: 2}
+
+	path, err := e.Path("/data", "carbon.agents.host1.cache.size")
+	if assert.NoError(err) {
+		assert.Regexp(`^/data/[0-9a-f]{2}/[0-9a-f]{2}/carbon/agents/host1/cache/size\.wsp$`, path)
+	}
+
+	// consistent
+	again, _ := e.Path("/data", "carbon.agents.host1.cache.size")
+	assert.Equal(path, again)
+
+	// validated by base encoder
+	_, err = e.Path("/data", "a..b")
+	assert.Error(err)
+
+	// spread by directories
+	dirs := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		path, _ := HashedPathEncoder{Encoder: SafePathEncoder{}, Depth: 1}.Path("/data", fmt.Sprintf("metric%d", i))
+		dirs[filepath.Dir(path)] = true
+	}
+	assert.True(len(dirs) > 200, len(dirs))
+
+	p := NewWhisper("/data", nil, nil, nil, nil)
+	p.SetHashedLayout(1)
+	p.SetHashedLayout(2)
+	assert.Equal(HashedPathEncoder{Encoder: SafePathEncoder{}, Depth: 2}, p.pathEncoder)
+	p.SetHashedLayout(0)
+	assert.Equal(SafePathEncoder{}, p.pathEncoder)
+}
@@ -0,0 +1,217 @@
+// Package persistertest provides in-memory whisper files for tests of persister without disk I/O
+package persistertest
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lomik/go-carbon/persister"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-whisper"
+)
+
+// File is in-memory whisper file. Points are not aggregated, Fetch returns no data
+type File struct {
+	Path              string
+	Retentions        whisper.Retentions
+	AggregationMethod whisper.AggregationMethod
+	XFilesFactor      float32
+	Sparse            bool
+
+	mu      sync.Mutex
+	updates [][]*whisper.TimeSeriesPoint
+}
+
+// Updates returns points of all UpdateMany calls in order of calls
+func (f *File) Updates() [][]*whisper.TimeSeriesPoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([][]*whisper.TimeSeriesPoint, len(f.updates))
+	copy(result, f.updates)
+	return result
+}
+
+// Points returns all written points in order of writes
+func (f *File) Points() []points.Point {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []points.Point
+	for _, update := range f.updates {
+		for _, p := range update {
+			result = append(result, points.Point{Value: p.Value, Timestamp: int64(p.Time)})
+		}
+	}
+	return result
+}
+
+// handle is opened File
+type handle struct {
+	file *File
+}
+
+func (h handle) UpdateMany(points []*whisper.TimeSeriesPoint) error {
+	copied := make([]*whisper.TimeSeriesPoint, len(points))
+	for i, p := range points {
+		c := *p
+		copied[i] = &c
+	}
+
+	h.file.mu.Lock()
+	h.file.updates = append(h.file.updates, copied)
+	h.file.mu.Unlock()
+	return nil
+}
+
+func (h handle) Retentions() []whisper.Retention {
+	result := make([]whisper.Retention, len(h.file.Retentions))
+	for i, r := range h.file.Retentions {
+		result[i] = *r
+	}
+	return result
+}
+
+func (h handle) Fetch(from, until int) (*whisper.TimeSeries, error) {
+	return nil, nil
+}
+
+func (h handle) Close() error { return nil }
+
+func (h handle) AggregationMethod() whisper.AggregationMethod {
+	return h.file.AggregationMethod
+}
+
+func (h handle) XFilesFactor() float32 {
+	return h.file.XFilesFactor
+}
+
+// CreateOpener is in-memory persister.CreateOpener. Safe for concurrent use by workers
+type CreateOpener struct {
+	mu    sync.Mutex
+	files map[string]*File
+}
+
+var _ persister.CreateOpener = &CreateOpener{}
+
+// NewCreateOpener creates CreateOpener without files
+func NewCreateOpener() *CreateOpener {
+	return &CreateOpener{
+		files: make(map[string]*File),
+	}
+}
+
+// Open implements persister.CreateOpener. Returns error satisfying os.IsNotExist for file not created yet
+func (co *CreateOpener) Open(path string) (persister.WhisperFile, error) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	f, ok := co.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return handle{file: f}, nil
+}
+
+// Create implements persister.CreateOpener. Returns error satisfying os.IsExist for existing file
+func (co *CreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (persister.WhisperFile, error) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	if _, ok := co.files[path]; ok {
+		return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+	}
+
+	f := &File{
+		Path:              path,
+		Retentions:        retentions,
+		AggregationMethod: aggregationMethod,
+		XFilesFactor:      xFilesFactor,
+		Sparse:            sparse,
+	}
+	co.files[path] = f
+	return handle{file: f}, nil
+}
+
+// Stat implements persister.CreateOpener. Paths with .wsp suffix are files, they exist only if created.
+// Other paths are directories, they always exist
+func (co *CreateOpener) Stat(path string) (os.FileInfo, error) {
+	if !strings.HasSuffix(path, ".wsp") {
+		return fileInfo{name: filepath.Base(path), dir: true}, nil
+	}
+
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	if _, ok := co.files[path]; !ok {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return fileInfo{name: filepath.Base(path)}, nil
+}
+
+// MkdirAll implements persister.CreateOpener. Noop, directories always exist
+func (co *CreateOpener) MkdirAll(dir string, mode os.FileMode) error {
+	return nil
+}
+
+// Sync implements persister.CreateOpener. Noop
+func (co *CreateOpener) Sync(w persister.WhisperFile, path string) error {
+	return nil
+}
+
+// fileInfo is info of file or directory of CreateOpener
+type fileInfo struct {
+	name string
+	dir  bool
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return 0 }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+func (i fileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// File returns file by path. nil if not created
+func (co *CreateOpener) File(path string) *File {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	return co.files[path]
+}
+
+// Files returns sorted paths of created files
+func (co *CreateOpener) Files() []string {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	result := make([]string, 0, len(co.files))
+	for path := range co.files {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Points returns points written to metric in order of writes. Path of metric is {root}/a/b/c.wsp
+// (default persister.SafePathEncoder). nil if file is not created
+func (co *CreateOpener) Points(root string, metric string) []points.Point {
+	path, err := persister.SafePathEncoder{}.Path(root, metric)
+	if err != nil {
+		return nil
+	}
+	f := co.File(path)
+	if f == nil {
+		return nil
+	}
+	return f.Points()
+}
@@ -0,0 +1,218 @@
+package persistertest
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/persister"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOpener(t *testing.T) {
+	assert := assert.New(t)
+
+	co := NewCreateOpener()
+
+	_, err := co.Open("/a.wsp")
+	assert.True(os.IsNotExist(err))
+
+	retentions, _ := persister.ParseRetentionDefs("60s:1h")
+	w, err := co.Create("/a.wsp", retentions, whisper.Sum, 0.3, true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(w.UpdateMany([]*whisper.TimeSeriesPoint{{Time: 60, Value: 1}, {Time: 120, Value: 2}}))
+	w.Close()
+
+	_, err = co.Create("/a.wsp", retentions, whisper.Sum, 0.3, true)
+	assert.True(os.IsExist(err))
+
+	w, err = co.Open("/a.wsp")
+	if assert.NoError(err) {
+		assert.Equal(60, w.Retentions()[0].SecondsPerPoint())
+		assert.NoError(w.UpdateMany([]*whisper.TimeSeriesPoint{{Time: 180, Value: 3}}))
+	}
+
+	f := co.File("/a.wsp")
+	if assert.NotNil(f) {
+		assert.Equal(whisper.Sum, f.AggregationMethod)
+		assert.Equal(float32(0.3), f.XFilesFactor)
+		assert.True(f.Sparse)
+		assert.Len(f.Updates(), 2)
+		assert.Equal([]points.Point{{Value: 1, Timestamp: 60}, {Value: 2, Timestamp: 120}, {Value: 3, Timestamp: 180}}, f.Points())
+	}
+	assert.Equal([]string{"/a.wsp"}, co.Files())
+	assert.Nil(co.File("/b.wsp"))
+}
+
+func TestWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := persister.ParseRetentionDefs("60s:1h")
+	schemas := persister.WhisperSchemas{
+		persister.Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+	}
+
+	const metrics = 50
+	in := make(chan *points.Points, metrics*2)
+	confirm := make(chan *points.Points, metrics*2)
+
+	// root doesn't exist, nothing is written to disk
+	root := "/nonexistent/persistertest"
+
+	co := NewCreateOpener()
+	p := persister.NewWhisper(root, schemas, persister.NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(co)
+	p.SetWorkers(4)
+
+	now := time.Now().Unix()
+	now -= now % 60
+	for i := 0; i < metrics; i++ {
+		in <- points.OnePoint(fmt.Sprintf("a.b%d", i), float64(i), now-60)
+	}
+	for i := 0; i < metrics; i++ {
+		in <- points.OnePoint(fmt.Sprintf("a.b%d", i), float64(i+1), now)
+	}
+
+	p.Start()
+	for i := 0; i < metrics*2; i++ {
+		select {
+		case <-confirm:
+		case <-time.After(time.Second):
+			t.Fatal("not confirmed")
+		}
+	}
+	p.Stop()
+
+	assert.Len(co.Files(), metrics)
+	for i := 0; i < metrics; i++ {
+		metric := fmt.Sprintf("a.b%d", i)
+		// points of metric are written by the same worker in order of receive
+		assert.Equal([]points.Point{
+			{Value: float64(i), Timestamp: now - 60},
+			{Value: float64(i + 1), Timestamp: now},
+		}, co.Points(root, metric), metric)
+	}
+
+	_, err := os.Stat(root)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestOnCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := persister.ParseRetentionDefs("60s:1h")
+	schemas := persister.WhisperSchemas{
+		persister.Schema{Name: "hourly", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+	}
+
+	type created struct {
+		metric, path, schema, aggregation string
+	}
+	calls := make(chan created, 10)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+	p := persister.NewWhisper("/whisper", schemas, persister.NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(NewCreateOpener())
+	p.SetOnCreate(func(metric, path string, schema, aggregation string) {
+		calls <- created{metric, path, schema, aggregation}
+	})
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	now := time.Now().Unix()
+	in <- points.OnePoint("a.b", 1, now)
+	in <- points.OnePoint("a.b", 2, now)
+	for i := 0; i < 2; i++ {
+		<-confirm
+	}
+
+	select {
+	case c := <-calls:
+		assert.Equal(created{"a.b", "/whisper/a/b.wsp", "hourly", "default"}, c)
+	case <-time.After(time.Second):
+		t.Fatal("callback is not called")
+	}
+
+	// existing file
+	p.Stop()
+	assert.Len(calls, 0)
+}
+
+func TestMirror(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := persister.ParseRetentionDefs("60s:1d")
+	mirrorRetentions, _ := persister.ParseRetentionDefs("1h:10y")
+	schemas := persister.WhisperSchemas{
+		persister.Schema{Name: "billing", Pattern: regexp.MustCompile(`^billing\.`), RetentionStr: "60s:1d", Retentions: retentions,
+			Mirror: &persister.Mirror{Root: "/archive", RetentionStr: "1h:10y", Retentions: mirrorRetentions, AggregationMethod: "max"}},
+		persister.Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1d", Retentions: retentions},
+	}
+
+	co := NewCreateOpener()
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+	p := persister.NewWhisper("/whisper", schemas, persister.NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(co)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	// start of previous hour
+	now := time.Now().Unix()
+	now -= now%3600 + 3600
+	in <- points.OnePoint("billing.a", 3, now).Add(1, now+60)
+	in <- points.OnePoint("other.a", 3, now)
+	for i := 0; i < 2; i++ {
+		<-confirm
+	}
+
+	// aggregated into one point of hour by max
+	assert.Equal([]points.Point{{Value: 3, Timestamp: now}, {Value: 1, Timestamp: now + 60}}, co.Points("/whisper", "billing.a"))
+	assert.Equal([]points.Point{{Value: 3, Timestamp: now}}, co.Points("/archive", "billing.a"))
+	assert.Nil(co.Points("/archive", "other.a"))
+	assert.Equal([]string{"/archive/billing/a.wsp", "/whisper/billing/a.wsp", "/whisper/other/a.wsp"}, co.Files())
+
+	if f := co.File("/archive/billing/a.wsp"); assert.NotNil(f) {
+		assert.Equal(whisper.Max, f.AggregationMethod)
+		assert.Equal(3600, f.Retentions[0].SecondsPerPoint())
+	}
+	assert.Equal(whisper.Average, co.File("/whisper/billing/a.wsp").AggregationMethod)
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(1), stat["mirror.committedPoints"])
+	assert.Equal(float64(1), stat["mirror.updateOperations"])
+	assert.Equal(float64(1), stat["mirror.created"])
+	assert.Equal(float64(0), stat["mirror.throttled"])
+
+	// mirror is throttled, primary file is written
+	p.Stop()
+	p = persister.NewWhisper("/whisper", schemas, persister.NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(co)
+	p.SetMirrorMaxUpdatesPerSecond(1)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	in <- points.OnePoint("billing.b", 1, now)
+	in <- points.OnePoint("billing.b", 2, now)
+	for i := 0; i < 2; i++ {
+		<-confirm
+	}
+	assert.Len(co.Points("/whisper", "billing.b"), 2)
+	assert.True(len(co.Points("/archive", "billing.b")) <= 2)
+
+	stat = make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(2), stat["mirror.updateOperations"]+stat["mirror.throttled"])
+}
@@ -0,0 +1,86 @@
+package persister
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-carbon/points"
+)
+
+// ReplayStats is progress of Replay
+type ReplayStats struct {
+	Lines     int // read lines
+	Points    int // points sent to persister
+	Skipped   int // empty and comment lines
+	Malformed int // lines not in "metric value timestamp" format
+	Outdated  int // points older than max retention of schema, not sent
+}
+
+// Replay reads points from lines "metric value timestamp" of r and sends them to input of persister, so they are
+// written by workers as received ones (schemas, aggregation, validation, max-updates-per-second).
+// Empty lines and lines starting with "#" are skipped, malformed lines and points older than max retention of
+// schema are counted and skipped. Up to ratePerSec points per second are sent, 0 - no limit. progress (if not
+// nil) is called every progressInterval. Persister should be started, Replay returns when all points are sent
+func (p *Whisper) Replay(r io.Reader, ratePerSec int, progressInterval time.Duration, progress func(ReplayStats)) (ReplayStats, error) {
+	var stats ReplayStats
+
+	storage := p.loadStorageConfig()
+	maxRetentions := make(map[string]int64) // by schema name
+
+	start := time.Now()
+	lastProgress := start
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		stats.Lines++
+
+		if progress != nil && progressInterval > 0 && time.Since(lastProgress) >= progressInterval {
+			lastProgress = time.Now()
+			progress(stats)
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			stats.Skipped++
+			continue
+		}
+
+		values, err := points.ParseText(line)
+		if err != nil {
+			stats.Malformed++
+			logrus.Debugf("[persister] Replay: line %d skipped: %s", stats.Lines, err.Error())
+			continue
+		}
+
+		if schema, ok := storage.schemas.Match(values.Metric); ok {
+			age, ok := maxRetentions[schema.Name]
+			if !ok {
+				age = int64(maxRetention(schema.Retentions))
+				maxRetentions[schema.Name] = age
+			}
+			if values.Data[0].Timestamp < time.Now().Unix()-age {
+				stats.Outdated++
+				continue
+			}
+		}
+
+		if ratePerSec > 0 {
+			// sleep until time of point by rate
+			if ahead := time.Duration(stats.Points)*time.Second/time.Duration(ratePerSec) - time.Since(start); ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+
+		p.in <- values
+		stats.Points++
+	}
+
+	if progress != nil {
+		progress(stats)
+	}
+
+	return stats, scanner.Err()
+}
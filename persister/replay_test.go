@@ -0,0 +1,67 @@
+package persister
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := ParseRetentionDefs("60s:1d")
+	schemas := WhisperSchemas{
+		Schema{Name: "default", Pattern: regexp.MustCompile("^a\\."), RetentionStr: "60s:1d", Retentions: retentions},
+	}
+
+	in := make(chan *points.Points, 10)
+	p := NewWhisper("/", schemas, NewWhisperAggregation(), in, nil)
+
+	now := time.Now().Unix()
+	input := fmt.Sprintf(`# comment
+a.b 1 %d
+
+a.c 2 %d
+a.d not-a-number %d
+a.e 3 %d
+bad line
+b.c 4 %d
+`, now, now-60, now, now-2*86400, now-10*86400)
+
+	var progress []ReplayStats
+	stats, err := p.Replay(strings.NewReader(input), 0, time.Nanosecond, func(s ReplayStats) {
+		progress = append(progress, s)
+	})
+	assert.NoError(err)
+	assert.Equal(ReplayStats{Lines: 8, Points: 3, Skipped: 2, Malformed: 2, Outdated: 1}, stats)
+	assert.Equal(stats, progress[len(progress)-1])
+
+	if assert.Len(in, 3) {
+		assert.Equal("a.b", (<-in).Metric)
+		assert.Equal("a.c", (<-in).Metric)
+		// no schema, not outdated by replay
+		assert.Equal("b.c", (<-in).Metric)
+	}
+}
+
+func TestReplayRate(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, nil)
+
+	now := time.Now().Unix()
+	input := fmt.Sprintf("a 1 %d\na 2 %d\na 3 %d\n", now, now, now)
+
+	start := time.Now()
+	stats, err := p.Replay(strings.NewReader(input), 20, 0, nil)
+	assert.NoError(err)
+	assert.Equal(3, stats.Points)
+	// 2 intervals of 50ms
+	assert.True(time.Since(start) >= 100*time.Millisecond)
+}
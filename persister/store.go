@@ -0,0 +1,19 @@
+package persister
+
+import "github.com/lomik/go-carbon/points"
+
+// Store writes points to storage backend. Persister calls Store from all workers concurrently,
+// points of one metric are always passed to the same worker
+type Store interface {
+	Store(values *points.Points) error
+}
+
+// whisperStore writes points to whisper files. Created for each worker
+type whisperStore struct {
+	p     *Whisper
+	files *fileCache // nil - close file after each update
+}
+
+func (s *whisperStore) Store(values *points.Points) error {
+	return storeWithFiles(s.p, values, s.files)
+}
@@ -0,0 +1,91 @@
+package persister
+
+import (
+	"os"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+)
+
+// StoreOp is step of store at which StoreError happened
+type StoreOp int
+
+const (
+	// StoreOpName - invalid metric name
+	StoreOpName StoreOp = iota
+	// StoreOpSchema - no storage schema or aggregation for new file
+	StoreOpSchema
+	// StoreOpOpen - open of existing file
+	StoreOpOpen
+	// StoreOpCreate - create of file or its directory
+	StoreOpCreate
+	// StoreOpUpdate - UpdateMany or fsync
+	StoreOpUpdate
+	// StoreOpPanic - UpdateMany panic, e.g. on corrupt file
+	StoreOpPanic
+
+	storeOpCount
+)
+
+var storeOpNames = [storeOpCount]string{"name", "schema", "open", "create", "update", "panic"}
+
+func (op StoreOp) String() string {
+	if op < 0 || op >= storeOpCount {
+		return "unknown"
+	}
+	return storeOpNames[op]
+}
+
+// StoreError is error of store of metric values. Passed to handler set by SetErrorHandler
+type StoreError struct {
+	Op     StoreOp
+	Metric string
+	Path   string // "" for StoreOpName
+	Err    error  // description with path and cause
+	cause  error  // original error, used to detect transient failures
+}
+
+func (e *StoreError) Error() string {
+	return e.Err.Error()
+}
+
+// syscallCause returns errno of cause of StoreError unwrapped from path, link or syscall error
+func syscallCause(cause error) error {
+	switch c := cause.(type) {
+	case *os.PathError:
+		return c.Err
+	case *os.LinkError:
+		return c.Err
+	case *os.SyscallError:
+		return c.Err
+	}
+	return cause
+}
+
+// isWriteError returns true for failure of write to disk: open, create or update of whisper file
+func isWriteError(err error) bool {
+	e, ok := err.(*StoreError)
+	return ok && (e.Op == StoreOpOpen || e.Op == StoreOpCreate || e.Op == StoreOpUpdate)
+}
+
+// SetErrorHandler sets callback called by workers on every store error with metric name and error.
+// Error is *StoreError except of errors of custom Store. Handler must not block. nil - errors are only logged
+func (p *Whisper) SetErrorHandler(handler func(metric string, err error)) {
+	p.errorHandler = handler
+}
+
+// storeFailed counts error by step and passes it to error handler
+func (p *Whisper) storeFailed(metric string, err error) {
+	if e, ok := err.(*StoreError); ok && e.Op >= 0 && e.Op < storeOpCount {
+		atomic.AddUint32(&p.storeErrors[e.Op], 1)
+	}
+	if p.errorHandler != nil {
+		p.errorHandler(metric, err)
+	}
+}
+
+func (p *Whisper) storeErrorsStat(send helper.StatCallback) {
+	for op := StoreOp(0); op < storeOpCount; op++ {
+		helper.SendAndSubstractUint32("storeErrors."+op.String(), &p.storeErrors[op], send)
+	}
+}
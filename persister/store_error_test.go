@@ -0,0 +1,100 @@
+package persister
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreError(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile("^ok\\."), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		now := time.Now().Unix()
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+
+		err := store(p, points.OnePoint("no.schema", 1, now))
+		if assert.IsType(&StoreError{}, err) {
+			assert.Equal(StoreOpSchema, err.(*StoreError).Op)
+			assert.Equal("no.schema", err.(*StoreError).Metric)
+		}
+
+		p.SetCreateOpener(&readOnlyCreateOpener{writable: make(chan bool)})
+		err = store(p, points.OnePoint("ok.create", 1, now))
+		if assert.IsType(&StoreError{}, err) {
+			assert.Equal(StoreOpCreate, err.(*StoreError).Op)
+			assert.True(isWriteError(err))
+		}
+
+		p.SetCreateOpener(&panicCreateOpener{})
+		err = store(p, points.OnePoint("ok.panic", 1, now))
+		if assert.IsType(&StoreError{}, err) {
+			assert.Equal(StoreOpPanic, err.(*StoreError).Op)
+			assert.False(isWriteError(err))
+		}
+	})
+}
+
+func TestErrorHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile("^ok\\."), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+
+		failed := make(chan *StoreError, 10)
+		p.SetErrorHandler(func(metric string, err error) {
+			e, _ := err.(*StoreError)
+			failed <- e
+		})
+
+		now := time.Now().Unix()
+		in <- points.OnePoint("ok.a", 1, now)
+		in <- points.OnePoint("no.schema", 1, now)
+		in <- points.OnePoint("bad..name", 1, now)
+
+		p.Start()
+		defer p.Stop()
+
+		for i := 0; i < 3; i++ {
+			select {
+			case <-confirm:
+			case <-time.After(time.Second):
+				t.Fatal("not confirmed")
+			}
+		}
+
+		ops := make(map[string]StoreOp)
+		for len(failed) > 0 {
+			e := <-failed
+			if assert.NotNil(e) {
+				ops[e.Metric] = e.Op
+			}
+		}
+		assert.Equal(map[string]StoreOp{"no.schema": StoreOpSchema, "bad..name": StoreOpName}, ops)
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(1), stat["storeErrors.schema"])
+		assert.Equal(float64(0), stat["storeErrors.name"]) // counted by invalidNames
+	})
+}
@@ -0,0 +1,56 @@
+package persister
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	sync.Mutex
+	data map[string][]points.Point
+}
+
+func (s *memoryStore) Store(values *points.Points) error {
+	s.Lock()
+	defer s.Unlock()
+	s.data[values.Metric] = append(s.data[values.Metric], values.Data...)
+	return nil
+}
+
+func TestNewPersister(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 16)
+	confirm := make(chan *points.Points, 16)
+	s := &memoryStore{data: make(map[string][]points.Point)}
+
+	p := NewPersister(s, in, confirm)
+	p.SetWorkers(4)
+	p.Start()
+
+	for i := 0; i < 10; i++ {
+		in <- points.OnePoint("metric", float64(i), 1000+int64(i))
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-confirm:
+		case <-time.After(time.Second):
+			t.Fatal("not confirmed")
+		}
+	}
+	p.Stop()
+
+	s.Lock()
+	defer s.Unlock()
+	if assert.Len(s.data["metric"], 10) {
+		// one metric is written by one worker in order of receiving
+		for i, d := range s.data["metric"] {
+			assert.Equal(1000+int64(i), d.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package persister
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TaggedDir is directory in data root for tagged series (name;tag1=value1;tag2=value2)
+const TaggedDir = "_tagged"
+
+// IsTagged returns true if metric name contains tags
+func IsTagged(metric string) bool {
+	return strings.IndexByte(metric, ';') >= 0
+}
+
+// NormalizeTagged sorts tags by name, so the same series with different tags order maps to one file.
+// If tag repeated last value wins
+func NormalizeTagged(metric string) (string, error) {
+	parts := strings.Split(metric, ";")
+	if parts[0] == "" {
+		return "", fmt.Errorf("empty name in tagged metric %#v", metric)
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range parts[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return "", fmt.Errorf("bad tag %#v in metric %#v", tag, metric)
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := parts[0]
+	for _, k := range keys {
+		res += ";" + k + "=" + tags[k]
+	}
+
+	return res, nil
+}
+
+// TaggedNameExt is extension of sidecar file with normalized name of tagged metric, it is written next to
+// whisper file: {hash}.wsp and {hash}.name
+const TaggedNameExt = ".name"
+
+// TaggedFilePath returns path of whisper file for tagged metric in form
+// {root}/_tagged/{sha256[0:3]}/{sha256[3:6]}/{sha256}.wsp.
+// Filename has fixed length for names of any length, up to NAME_MAX of filesystem. Metric name is recoverable
+// by TaggedMetricFromPath from sidecar file written by WriteTaggedName
+func TaggedFilePath(root string, metric string) (string, error) {
+	normalized, err := NormalizeTagged(metric)
+	if err != nil {
+		return "", err
+	}
+
+	sum := taggedHash(normalized)
+
+	return filepath.Join(root, TaggedDir, sum[0:3], sum[3:6], sum+".wsp"), nil
+}
+
+func taggedHash(normalized string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(normalized)))
+}
+
+// TaggedNamePath returns path of sidecar file with name of tagged whisper file
+func TaggedNamePath(path string) string {
+	return strings.TrimSuffix(path, ".wsp") + TaggedNameExt
+}
+
+// WriteTaggedName writes normalized name of tagged metric to sidecar file of whisper file path
+func WriteTaggedName(path string, metric string) error {
+	normalized, err := NormalizeTagged(metric)
+	if err != nil {
+		return err
+	}
+
+	namePath := TaggedNamePath(path)
+	tmp := namePath + ".tmp"
+	if err = ioutil.WriteFile(tmp, []byte(normalized), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, namePath)
+}
+
+// TaggedMetricFromPath recovers tagged metric name of whisper file path from sidecar file
+func TaggedMetricFromPath(path string) (string, error) {
+	base := strings.TrimSuffix(filepath.Base(path), ".wsp")
+
+	name, err := ioutil.ReadFile(TaggedNamePath(path))
+	if err != nil {
+		return "", err
+	}
+
+	if taggedHash(string(name)) != base {
+		return "", fmt.Errorf("name %#v in %s doesn't match file", string(name), TaggedNamePath(path))
+	}
+	return string(name), nil
+}
+
+// MetricFilePath returns path of whisper file for plain or tagged metric
+func MetricFilePath(root string, metric string) (string, error) {
+	if IsTagged(metric) {
+		return TaggedFilePath(root, metric)
+	}
+	return filepath.Join(root, strings.Replace(metric, ".", "/", -1)+".wsp"), nil
+}
@@ -0,0 +1,157 @@
+package persister
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTagged(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		metric     string
+		normalized string
+	}{
+		{"cpu.usage;host=a", "cpu.usage;host=a"},
+		{"cpu.usage;host=a;dc=b", "cpu.usage;dc=b;host=a"},
+		{"cpu.usage;dc=b;host=a", "cpu.usage;dc=b;host=a"},
+		{"cpu.usage;z=1;a=2;m=3", "cpu.usage;a=2;m=3;z=1"},
+		{"cpu.usage;host=a;host=b", "cpu.usage;host=b"},
+		{"cpu.usage;path=/var/lib;url=http://host:80/?q=1", "cpu.usage;path=/var/lib;url=http://host:80/?q=1"},
+	}
+
+	for _, c := range table {
+		normalized, err := NormalizeTagged(c.metric)
+		if assert.NoError(err, c.metric) {
+			assert.Equal(c.normalized, normalized, c.metric)
+		}
+	}
+
+	for _, metric := range []string{";host=a", "cpu;host", "cpu;=a", "cpu;host=", "cpu;"} {
+		_, err := NormalizeTagged(metric)
+		assert.Error(err, metric)
+	}
+}
+
+func TestMetricFilePath(t *testing.T) {
+	assert := assert.New(t)
+
+	path, err := MetricFilePath("/data", "carbon.agents.host1.cache.size")
+	if assert.NoError(err) {
+		assert.Equal("/data/carbon/agents/host1/cache/size.wsp", path)
+	}
+
+	// tags order doesn't matter
+	path1, err := MetricFilePath("/data", "cpu.usage;host=a;dc=b")
+	assert.NoError(err)
+	path2, err := MetricFilePath("/data", "cpu.usage;dc=b;host=a")
+	assert.NoError(err)
+	assert.Equal(path1, path2)
+
+	for _, metric := range []string{
+		"cpu.usage;host=a;dc=b",
+		"cpu.usage;path=/var/lib/../../etc;url=http://host:80/?q=1&b=%20",
+		"disk.free;mount=/ ;dev=sda 1;percent=50%",
+	} {
+		path, err := MetricFilePath("/data", metric)
+		if !assert.NoError(err, metric) {
+			continue
+		}
+
+		rel, err := filepath.Rel("/data", path)
+		assert.NoError(err)
+		parts := strings.Split(rel, string(filepath.Separator))
+
+		// _tagged/xxx/yyy/name.wsp
+		if assert.Len(parts, 4, path) {
+			assert.Equal(TaggedDir, parts[0])
+			assert.Len(parts[1], 3)
+			assert.Len(parts[2], 3)
+			assert.Len(parts[3], 64+len(".wsp"))
+			assert.True(strings.HasPrefix(parts[3], parts[1]+parts[2]))
+		}
+	}
+
+	_, err = MetricFilePath("/data", "cpu.usage;host")
+	assert.Error(err)
+}
+
+func TestTaggedMetricFromPath(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		for _, metric := range []string{
+			"cpu.usage;host=a;dc=b",
+			"cpu.usage;path=/var/lib/../../etc;url=http://host:80/?q=1&b=%20",
+			"disk.free;mount=/ ;dev=sda 1;percent=50%",
+		} {
+			path, err := MetricFilePath(root, metric)
+			if !assert.NoError(err, metric) {
+				continue
+			}
+			assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+			assert.NoError(WriteTaggedName(path, metric))
+
+			normalized, _ := NormalizeTagged(metric)
+			recovered, err := TaggedMetricFromPath(path)
+			if assert.NoError(err) {
+				assert.Equal(normalized, recovered)
+			}
+		}
+
+		// no sidecar
+		path, _ := MetricFilePath(root, "cpu.usage;host=b")
+		_, err := TaggedMetricFromPath(path)
+		assert.Error(err)
+
+		// sidecar of other file
+		other, _ := MetricFilePath(root, "cpu.usage;host=a;dc=b")
+		name, _ := ioutil.ReadFile(TaggedNamePath(other))
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(ioutil.WriteFile(TaggedNamePath(path), name, 0644))
+		_, err = TaggedMetricFromPath(path)
+		assert.Error(err)
+	})
+}
+
+func TestTaggedLongName(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+
+		metric := "http.requests;url=/" + strings.Repeat("segment/", 40) + ";host=a"
+		assert.True(len(url.QueryEscape(metric)) > 255)
+
+		if !assert.NoError(store(p, points.OnePoint(metric, 42, time.Now().Unix()))) {
+			return
+		}
+
+		path, err := MetricFilePath(root, metric)
+		if !assert.NoError(err) {
+			return
+		}
+		_, err = os.Stat(path)
+		assert.NoError(err)
+
+		normalized, _ := NormalizeTagged(metric)
+		recovered, err := TaggedMetricFromPath(path)
+		if assert.NoError(err) {
+			assert.Equal(normalized, recovered)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package persister
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// Throttle passes values from input channel to Out with limited rate
+type Throttle struct {
+	out    chan *points.Points
+	rate   int    // effective values per second after rounding to ticks
+	waits  uint32 // counter
+	passed uint32 // counter
+}
+
+// ThrottleChan returns channel with values from in passed with rate ratePerSec. Out is closed after exit or close of in
+func ThrottleChan(in chan *points.Points, ratePerSec int, exit chan bool) chan *points.Points {
+	return NewThrottle(in, ratePerSec, exit).Out()
+}
+
+// NewThrottle starts throttling of in with rate ratePerSec. Out is closed after exit or close of in
+func NewThrottle(in chan *points.Points, ratePerSec int, exit chan bool) *Throttle {
+	out := make(chan *points.Points, cap(in))
+
+	delimeter := ratePerSec
+	chunk := 1
+
+	if ratePerSec > 1000 {
+		minRemainder := ratePerSec
+
+		for i := 100; i < 1000; i++ {
+			if ratePerSec%i < minRemainder {
+				delimeter = i
+				minRemainder = ratePerSec % delimeter
+			}
+		}
+
+		chunk = ratePerSec / delimeter
+	}
+
+	step := time.Duration(1e9/delimeter) * time.Nanosecond
+
+	t := &Throttle{
+		out:  out,
+		rate: chunk * delimeter,
+	}
+
+	var onceClose sync.Once
+
+	throttleWorker := func() {
+		var p *points.Points
+		var ok bool
+
+		defer onceClose.Do(func() { close(out) })
+
+		// start flight
+		throttleTicker := time.NewTicker(step)
+		defer throttleTicker.Stop()
+
+	LOOP:
+		for {
+			select {
+			case <-throttleTicker.C:
+				for i := 0; i < chunk; i++ {
+					select {
+					case p, ok = <-in:
+						// value was already waiting for tick
+						if ok {
+							atomic.AddUint32(&t.waits, 1)
+						}
+					default:
+						select {
+						case p, ok = <-in:
+						case <-exit:
+							break LOOP
+						}
+					}
+					if !ok {
+						break LOOP
+					}
+					out <- p
+					atomic.AddUint32(&t.passed, 1)
+				}
+			case <-exit:
+				break LOOP
+			}
+		}
+	}
+
+	go throttleWorker()
+
+	return t
+}
+
+// Out returns channel of throttled values
+func (t *Throttle) Out() chan *points.Points {
+	return t.out
+}
+
+// Rate returns effective limit of values per second. It may be less than requested because of rounding to ticks
+func (t *Throttle) Rate() int {
+	return t.rate
+}
+
+// Len returns count of values passed by throttle and not received from Out yet
+func (t *Throttle) Len() int {
+	return len(t.out)
+}
+
+// Cap returns capacity of Out
+func (t *Throttle) Cap() int {
+	return cap(t.out)
+}
+
+// Waits returns count of values which were ready before tick of throttle, i.e. delayed by rate limit.
+// Counted since last Stat
+func (t *Throttle) Waits() uint32 {
+	return atomic.LoadUint32(&t.waits)
+}
+
+// Passed returns count of values passed to Out since last Stat
+func (t *Throttle) Passed() uint32 {
+	return atomic.LoadUint32(&t.passed)
+}
+
+// Stat sends throttled values since last call, queue of Out and rate limit
+func (t *Throttle) Stat(send helper.StatCallback) {
+	helper.SendAndSubstractUint32("waits", &t.waits, send)
+	helper.SendAndSubstractUint32("passed", &t.passed, send)
+	send("queue", float64(t.Len()))
+	send("queueCap", float64(t.Cap()))
+	send("rate", float64(t.rate))
+}
@@ -49,3 +49,66 @@ func TestThrottleChan(t *testing.T) {
 		assert.True(t, float64(bw) <= max, fmt.Sprintf("perSecond: %d, bw: %d", perSecond, bw))
 	}
 }
+
+func TestThrottleStat(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	for i := 0; i < 3; i++ {
+		in <- points.OnePoint("metric", 1, 10)
+	}
+
+	exit := make(chan bool)
+	defer close(exit)
+	throttle := NewThrottle(in, 100, exit)
+	assert.Equal(100, throttle.Rate())
+	assert.Equal(10, throttle.Cap())
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-throttle.Out():
+		case <-time.After(time.Second):
+			t.Fatal("not passed")
+		}
+	}
+
+	stat := make(map[string]float64)
+	throttle.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(map[string]float64{
+		"waits":    3,
+		"passed":   3,
+		"queue":    0,
+		"queueCap": 10,
+		"rate":     100,
+	}, stat)
+	assert.Equal(uint32(0), throttle.Waits())
+
+	// rounded to ticks
+	for _, rate := range []int{1, 1001, 1999, 123457} {
+		r := NewThrottle(in, rate, exit).Rate()
+		assert.True(r <= rate && float64(r) >= float64(rate)*0.95, fmt.Sprintf("rate: %d, effective: %d", rate, r))
+	}
+}
+
+func TestWhisperThrottleStat(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, nil)
+	p.SetMockStore(func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {}, nil
+	})
+	p.SetMaxUpdatesPerSecond(50)
+	p.Start()
+	defer p.Stop()
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(10), stat["inputQueueCap"])
+	assert.Equal(float64(50), stat["throttle.rate"])
+	assert.Equal(float64(10), stat["throttle.queueCap"])
+}
@@ -0,0 +1,91 @@
+package persister
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// TimestampNormalizer returns corrected timestamp of point received at now (unix seconds) or timestamp itself
+type TimestampNormalizer func(timestamp int64, now int64) int64
+
+const (
+	// minPlausibleTimestamp is 2000-01-01, earlier corrected timestamps are not plausible
+	minPlausibleTimestamp = 946684800
+	// maxPlausibleDrift is allowed shift of corrected timestamp after now
+	maxPlausibleDrift = 86400
+)
+
+// timestampUnits are divisors of milli-, micro- and nanoseconds to seconds
+var timestampUnits = []int64{1000, 1000000, 1000000000}
+
+// FixTimestampUnits converts timestamp sent in milli-, micro- or nanoseconds instead of seconds. Timestamp is
+// converted only if it is later than now + day and exactly one of divisors 10^3, 10^6, 10^9 gives plausible
+// timestamp: from 2000-01-01 up to now + day. Plausible ranges of divisors don't overlap, so legitimate far future
+// timestamps in seconds (which are not plausible after division) are kept and can be dropped by max future drift
+func FixTimestampUnits(timestamp int64, now int64) int64 {
+	maxTimestamp := now + maxPlausibleDrift
+	if timestamp <= maxTimestamp {
+		return timestamp
+	}
+	for _, unit := range timestampUnits {
+		t := timestamp / unit
+		if t >= minPlausibleTimestamp && t <= maxTimestamp {
+			return t
+		}
+	}
+	return timestamp
+}
+
+// NewTimestampNormalizer returns normalizer by name: "" - none (nil), "units" - FixTimestampUnits
+func NewTimestampNormalizer(name string) (TimestampNormalizer, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "units":
+		return FixTimestampUnits, nil
+	default:
+		return nil, fmt.Errorf("unknown timestamp normalizer %#v", name)
+	}
+}
+
+// SetTimestampNormalizer enables correction of point timestamps before store (persister.timestampsCorrected
+// metric). nil - disabled
+func (p *Whisper) SetTimestampNormalizer(fn TimestampNormalizer) {
+	p.timestampNormalizer = fn
+}
+
+// normalizeTimestamps returns values with corrected timestamps. Received values are not modified
+// because they are still visible for carbonlink until confirmed
+func (p *Whisper) normalizeTimestamps(values *points.Points) *points.Points {
+	if p.timestampNormalizer == nil {
+		return values
+	}
+
+	now := p.now().Unix()
+	var data []points.Point
+	corrected := 0
+	for i, d := range values.Data {
+		t := p.timestampNormalizer(d.Timestamp, now)
+		if t == d.Timestamp {
+			if data != nil {
+				data = append(data, d)
+			}
+			continue
+		}
+		if data == nil {
+			data = make([]points.Point, i, len(values.Data))
+			copy(data, values.Data[:i])
+		}
+		data = append(data, points.Point{Value: d.Value, Timestamp: t})
+		corrected++
+	}
+	if data == nil {
+		return values
+	}
+
+	atomic.AddUint32(&p.timestampsCorrected, uint32(corrected))
+
+	return &points.Points{Metric: values.Metric, Data: data}
+}
@@ -0,0 +1,107 @@
+package persister
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	whisper "github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixTimestampUnits(t *testing.T) {
+	assert := assert.New(t)
+
+	const now = 1500000000
+
+	table := []struct {
+		timestamp int64
+		expected  int64
+	}{
+		{now, now},
+		{now - 3600, now - 3600},
+		{now + maxPlausibleDrift, now + maxPlausibleDrift},
+		// milli-, micro- and nanoseconds
+		{now*1000 + 123, now},
+		{(now - 3600) * 1000, now - 3600},
+		{now * 1000000, now},
+		{now*1000000000 + 999999999, now},
+		{minPlausibleTimestamp * 1000, minPlausibleTimestamp},
+		// far future seconds are not plausible in any unit
+		{now + 2*maxPlausibleDrift, now + 2*maxPlausibleDrift},
+		{4000000000, 4000000000},
+		{100000000000, 100000000000},
+		// milliseconds later than now + day or earlier than 2000
+		{(now + 2*maxPlausibleDrift) * 1000, (now + 2*maxPlausibleDrift) * 1000},
+		{(minPlausibleTimestamp - 1) * 1000, (minPlausibleTimestamp - 1) * 1000},
+		{0, 0},
+		{-1, -1},
+	}
+
+	for _, c := range table {
+		assert.Equal(c.expected, FixTimestampUnits(c.timestamp, now), "%d", c.timestamp)
+	}
+}
+
+func TestNewTimestampNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	fn, err := NewTimestampNormalizer("")
+	assert.NoError(err)
+	assert.Nil(fn)
+
+	fn, err = NewTimestampNormalizer("units")
+	if assert.NoError(err) {
+		assert.Equal(int64(1500000000), fn(1500000000000, 1500000000))
+	}
+
+	_, err = NewTimestampNormalizer("ms")
+	assert.Error(err)
+}
+
+func TestTimestampNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetTimestampNormalizer(FixTimestampUnits)
+
+		now := time.Now().Unix()
+		received := &points.Points{Metric: "a.b", Data: []points.Point{
+			{Value: 1, Timestamp: now - 2},
+			{Value: 2, Timestamp: (now - 1) * 1000},
+			{Value: 3, Timestamp: now * 1000000},
+		}}
+		assert.NoError(store(p, received))
+
+		// received values are not modified
+		assert.Equal((now-1)*1000, received.Data[1].Timestamp)
+
+		w, err := whisper.Open(filepath.Join(root, "a", "b.wsp"))
+		if !assert.NoError(err) {
+			return
+		}
+		defer w.Close()
+
+		series, err := w.Fetch(int(now-3), int(now))
+		if assert.NoError(err) {
+			values := series.Values()
+			assert.Equal([]float64{1, 2, 3}, values[len(values)-3:])
+		}
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(2), stat["timestampsCorrected"])
+	})
+}
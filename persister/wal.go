@@ -0,0 +1,266 @@
+package persister
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-carbon/points"
+)
+
+// walSegmentSize is size of WAL segment after which new segment is started
+const walSegmentSize = 64 * 1024 * 1024
+
+// SetWAL enables write-ahead log of values received by persister. Values are appended to segment
+// files in dir before passing to workers, segment is removed when all its values are stored. Segments
+// left after crash or with values failed to store are replayed on start
+func (p *Whisper) SetWAL(dir string, enabled bool) {
+	p.walDir = dir
+	p.walEnabled = enabled
+}
+
+type walSegment struct {
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	size    int
+	pending int
+}
+
+// wal is segmented append-only log of values not stored yet
+type wal struct {
+	sync.Mutex
+	dir     string
+	nextID  uint64
+	current *walSegment
+	values  map[*points.Points]*walSegment
+	replay  []string // segments of previous run
+}
+
+func walSegmentName(id uint64) string {
+	return fmt.Sprintf("wal.%020d", id)
+}
+
+// openWAL finds segments of previous run in dir and starts new segment
+func openWAL(dir string) (*wal, error) {
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{
+		dir:    dir,
+		values: make(map[*points.Points]*walSegment),
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), "wal.") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(f.Name(), "wal."), 10, 64)
+		if err != nil {
+			continue
+		}
+		if id >= w.nextID {
+			w.nextID = id + 1
+		}
+		w.replay = append(w.replay, filepath.Join(dir, f.Name()))
+	}
+	// zero padded names
+	sort.Strings(w.replay)
+
+	if err := w.rotate(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// rotate starts new segment. Must be called with lock
+func (w *wal) rotate() error {
+	path := filepath.Join(w.dir, walSegmentName(w.nextID))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.nextID++
+
+	if prev := w.current; prev != nil {
+		prev.writer.Flush()
+		prev.file.Close()
+		prev.file = nil
+		if prev.pending == 0 {
+			w.remove(prev)
+		}
+	}
+
+	w.current = &walSegment{
+		path:   path,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}
+	return nil
+}
+
+func (w *wal) remove(seg *walSegment) {
+	if err := os.Remove(seg.path); err != nil {
+		logrus.Errorf("[persister] Failed to remove WAL segment %s: %s", seg.path, err.Error())
+	}
+}
+
+// append writes values to current segment. Written data is passed to OS, so it is kept on crash of process
+func (w *wal) append(values *points.Points) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.current.size >= walSegmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	seg := w.current
+	for _, d := range values.Data {
+		n, err := fmt.Fprintf(seg.writer, "%s %v %v\n", values.Metric, d.Value, d.Timestamp)
+		seg.size += n
+		if err != nil {
+			return err
+		}
+	}
+	if err := seg.writer.Flush(); err != nil {
+		return err
+	}
+
+	seg.pending++
+	w.values[values] = seg
+	return nil
+}
+
+// release marks values as stored. Segment is removed when it is not current and all values are released
+func (w *wal) release(values *points.Points) {
+	w.Lock()
+	defer w.Unlock()
+
+	seg, exists := w.values[values]
+	if !exists {
+		return
+	}
+	delete(w.values, values)
+
+	seg.pending--
+	if seg.pending == 0 && seg != w.current {
+		w.remove(seg)
+	}
+}
+
+// keep marks values as failed. Segment is kept for replay after restart
+func (w *wal) keep(values *points.Points) {
+	w.Lock()
+	defer w.Unlock()
+
+	delete(w.values, values)
+}
+
+// close closes current segment. Segments with not released values are kept for replay
+func (w *wal) close() {
+	w.Lock()
+	defer w.Unlock()
+
+	seg := w.current
+	seg.writer.Flush()
+	seg.file.Close()
+	if seg.pending == 0 {
+		w.remove(seg)
+	}
+}
+
+// replaySegment sends values from segment of previous run to out
+func replaySegment(path string, out func(*points.Points) bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return true, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 1024*1024)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// last line without \n was not written completely
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+
+		values, err := points.ParseText(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			logrus.Warnf("[persister] Wrong WAL line %#v in %s", line, path)
+			continue
+		}
+		if !out(values) {
+			return false, nil
+		}
+	}
+}
+
+// walWriter appends values from in to WAL and sends them to out. Segments of previous run are sent first,
+// their values are appended to new segments too, so replayed segment is removed after reading
+func (p *Whisper) walWriter(w *wal, in chan *points.Points, out chan *points.Points, exit chan bool) {
+	defer close(out)
+
+	send := func(values *points.Points) bool {
+		if err := w.append(values); err != nil {
+			logrus.Errorf("[persister] Failed to write WAL: %s", err.Error())
+		}
+		select {
+		case out <- values:
+			return true
+		case <-exit:
+			return false
+		}
+	}
+
+	for _, path := range w.replay {
+		logrus.Infof("[persister] Replay WAL segment %s", path)
+		completed, err := replaySegment(path, send)
+		if !completed {
+			return
+		}
+		if err != nil {
+			// kept for next start
+			logrus.Errorf("[persister] Failed to replay WAL segment %s: %s", path, err.Error())
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logrus.Errorf("[persister] Failed to remove WAL segment %s: %s", path, err.Error())
+		}
+	}
+
+	for {
+		select {
+		case <-exit:
+			return
+		case values, ok := <-in:
+			if !ok {
+				return
+			}
+			if !send(values) {
+				return
+			}
+		}
+	}
+}
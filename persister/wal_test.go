@@ -0,0 +1,168 @@
+package persister
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func walSegments(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestWAL(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		w, err := openWAL(root)
+		if !assert.NoError(err) {
+			return
+		}
+
+		a := points.OnePoint("a", 1, 10).Add(2, 20)
+		b := points.OnePoint("b", 3, 10)
+		assert.NoError(w.append(a))
+
+		w.Lock()
+		assert.NoError(w.rotate())
+		w.Unlock()
+		assert.NoError(w.append(b))
+
+		data, err := ioutil.ReadFile(filepath.Join(root, walSegmentName(0)))
+		assert.NoError(err)
+		assert.Equal("a 1 10\na 2 20\n", string(data))
+		assert.Equal([]string{walSegmentName(0), walSegmentName(1)}, walSegments(t, root))
+
+		// segment is removed after release of all values
+		w.release(a)
+		assert.Equal([]string{walSegmentName(1)}, walSegments(t, root))
+
+		// segment with not released values is kept
+		w.close()
+		assert.Equal([]string{walSegmentName(1)}, walSegments(t, root))
+
+		w, err = openWAL(root)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal([]string{filepath.Join(root, walSegmentName(1))}, w.replay)
+		assert.Equal(walSegmentName(2), filepath.Base(w.current.path))
+		w.close()
+	})
+}
+
+func TestWALReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		// segment of previous run, last line is not completed
+		if err := ioutil.WriteFile(filepath.Join(root, walSegmentName(5)), []byte("a 1 10\nb 2 10\nc 3"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		in := make(chan *points.Points, 16)
+		confirm := make(chan *points.Points, 16)
+		s := &memoryStore{data: make(map[string][]points.Point)}
+
+		p := NewPersister(s, in, confirm)
+		p.SetWAL(root, true)
+		if !assert.NoError(p.Start()) {
+			return
+		}
+
+		in <- points.OnePoint("d", 4, 10)
+
+		for i := 0; i < 3; i++ {
+			select {
+			case <-confirm:
+			case <-time.After(time.Second):
+				t.Fatal("not confirmed")
+			}
+		}
+		p.Stop()
+
+		s.Lock()
+		assert.Len(s.data, 3)
+		assert.Equal([]points.Point{points.Point{Value: 1, Timestamp: 10}}, s.data["a"])
+		s.Unlock()
+
+		// all segments are removed
+		assert.Len(walSegments(t, root), 0)
+	})
+}
+
+// failingStore fails to store values while fail is set
+type failingStore struct {
+	memoryStore
+	fail bool
+}
+
+func (s *failingStore) Store(values *points.Points) error {
+	s.Lock()
+	fail := s.fail
+	s.Unlock()
+	if fail {
+		return errors.New("store failed")
+	}
+	return s.memoryStore.Store(values)
+}
+
+func TestWALFailedStore(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		run := func(s Store, metric string) {
+			in := make(chan *points.Points, 16)
+			confirm := make(chan *points.Points, 16)
+
+			p := NewPersister(s, in, confirm)
+			p.SetWAL(root, true)
+			if !assert.NoError(p.Start()) {
+				return
+			}
+			defer p.Stop()
+
+			in <- points.OnePoint(metric, 1, 10)
+			for {
+				select {
+				case values := <-confirm:
+					if values.Metric == metric {
+						return
+					}
+				case <-time.After(time.Second):
+					t.Fatal("not confirmed")
+				}
+			}
+		}
+
+		s := &failingStore{memoryStore: memoryStore{data: make(map[string][]points.Point)}, fail: true}
+		run(s, "a")
+		// segment with failed values is kept
+		assert.Len(walSegments(t, root), 1)
+
+		s.Lock()
+		s.fail = false
+		s.Unlock()
+
+		// failed values are replayed after restart
+		run(s, "b")
+		s.Lock()
+		assert.Equal([]points.Point{points.Point{Value: 1, Timestamp: 10}}, s.data["a"])
+		s.Unlock()
+		assert.Len(walSegments(t, root), 0)
+	})
+}
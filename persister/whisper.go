@@ -1,10 +1,12 @@
 package persister
 
 import (
-	"hash/crc32"
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"regexp"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,7 +14,9 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/lomik/go-whisper"
 
+	"github.com/lomik/go-carbon/cache"
 	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/logging"
 	"github.com/lomik/go-carbon/points"
 )
 
@@ -21,31 +25,186 @@ type StoreFunc func(p *Whisper, values *points.Points)
 // Whisper write data to *.wsp files
 type Whisper struct {
 	helper.Stoppable
-	updateOperations    uint32
-	committedPoints     uint32
-	in                  chan *points.Points
-	confirm             chan *points.Points
-	schemas             WhisperSchemas
-	aggregation         *WhisperAggregation
-	workersCount        int
-	rootPath            string
-	created             uint32 // counter
-	sparse              bool
-	maxUpdatesPerSecond int
-	mockStore           func() (StoreFunc, func())
+	updateOperations       counter
+	committedPoints        counter
+	in                     chan *points.Points
+	confirm                chan *points.Points
+	storage                atomic.Value // *storageConfig
+	workersCount           int
+	shardFunc              ShardFunc
+	rootPath               string
+	created                uint32 // counter
+	outdatedPoints         uint32 // counter
+	maxFutureDrift         time.Duration
+	futurePoints           uint32 // counter
+	futurePointLogged      int64  // unix time of last log, changing via atomic
+	nowFunc                func() time.Time
+	sparse                 bool
+	fsync                  bool
+	maxUpdatesPerSecond    int
+	maxRetentionAge        time.Duration
+	writeStrategy          WriteStrategy
+	stopTimeout            time.Duration
+	flushInterval          time.Duration
+	flushMaxPoints         int
+	dedupPolicy            points.DedupPolicy
+	maxOpenFiles           int
+	openFileHits           uint32 // counter
+	openFileMisses         uint32 // counter
+	schemaReconcile        bool
+	reconcileLimiter       *rateLimiter
+	reconcileQueue         chan reconcileRequest
+	reconcileQueued        sync.Map // path of queued reconcileRequest => true
+	reconciled             sync.Map // path rebuilt by reconciler => true, until reopen by worker
+	rebuilt                uint32   // counter
+	updateTime             helper.Histogram
+	slowWriteThreshold     time.Duration
+	slowWrites             uint32 // counter
+	lag                    lagTracker
+	dataDirs               []*dataDir
+	pathEncoder            PathEncoder
+	maxNameLength          int
+	allowedNames           *regexp.Regexp
+	nameNormalizer         NameNormalizer
+	timestampNormalizer    TimestampNormalizer
+	timestampsCorrected    uint32 // counter
+	cacheQuery             chan *cache.Query
+	cacheQueryTimeout      time.Duration
+	fileLocks              fileLocks
+	openFiles              fileCaches
+	invalidNames           uint32 // counter
+	dropList               atomic.Value
+	dropped                uint32 // counter
+	invalidNameLogged      int64  // unix time of last log, changing via atomic
+	createOpener           CreateOpener
+	diskUsageInterval      time.Duration
+	diskUsageMaxDepth      int
+	fileCount              int64 // result of last disk usage scan
+	diskUsedBytes          int64 // result of last disk usage scan
+	punchIdleAge           time.Duration
+	punchRate              int
+	punchedFiles           uint32 // counter
+	punchedBytes           uint64 // counter
+	internalChannelSize    int
+	pools                  map[string]int
+	degradedThreshold      int
+	degradedRetryMin       time.Duration
+	degraded               uint32 // 0 or 1, changing via atomic
+	writeErrors            uint32 // counter
+	consecutiveWriteErrors uint32
+	exit                   chan bool        // exit of last start, nil if never started
+	log                    *logging.Sampler // nil - log everything
+	walDir                 string
+	walEnabled             bool
+	wal                    *wal
+	indexPath              string
+	maxPointsPerUpdate     int
+	oneShot                *oneShotTracker // nil - disabled
+	fadviseDontNeed        bool
+	auditOptions           auditOptions
+	audit                  *auditLog
+	index                  *metricIndex
+	updateErrors           uint32 // counter
+	openErrors             uint32 // counter
+	overflowPolicy         OverflowPolicy
+	overflowBlocked        uint32               // counter
+	overflowDroppedOldest  uint32               // counter
+	overflowDroppedNewest  uint32               // counter
+	storeErrors            [storeOpCount]uint32 // counters by StoreOp
+	errorHandler           func(metric string, err error)
+	maxCreatesPerSecond    int
+	createLimiter          *rateLimiter
+	createThrottled        uint32 // counter
+	createRetries          int
+	createRetryBackoff     time.Duration
+	createRetried          uint32 // counter
+	quarantineCorrupt      bool
+	dirMode                os.FileMode
+	fileMode               os.FileMode
+	chown                  bool
+	uid                    int
+	gid                    int
+	drainDeadline          int64        // unix nano, changing via atomic
+	drainIncomplete        uint32       // changing via atomic
+	queues                 atomic.Value // []chan *points.Points, buffers measured by Load
+	workerStats            atomic.Value // []*workerStat of workers of shuffler
+	resizer                *resizer     // of last start with shuffler
+	workerTime             workerTime
+	throttle               atomic.Value // *Throttle of last start if max-updates-per-second is set
+	resolvedRoot           atomic.Value // string, rootPath resolved by ResolveRoot
+	backend                Store
+	mockStore              func() (StoreFunc, func())
+	freezeRequests         chan *freezeRequest // of last start, read by shuffler or solo worker
+	thaw                   chan bool           // closed by Thaw, nil if not frozen
+	sideWrites             sync.RWMutex        // read locked by writers outside of workers, see beginSideWrite
+	onCreate               func(metric, path string, schema, aggregation string)
+	onCreateQueue          chan createdFile // of last start if onCreate is set
+	onCreateDropped        uint32           // counter
+	archiveStatsEnabled    bool
+	archiveStats           archiveStats
+	namespaceDepth         int
+	namespaceStatsEnabled  bool
+	namespaceStats         namespaceStats
+	verifyOnOpen           bool
+	verifyFailed           uint32 // counter
+	storeTimeout           time.Duration
+	storeWatchdog          storeWatchdog
+	storeTimeouts          uint32 // counter
+	storeNonFinite         bool
+	nonFinitePoints        uint32 // counter
+	mirrorLimiter          *rateLimiter
+	mirrorStats            mirrorStats
+	createGate             *createGate // nil - disabled
+	batchDedup             *batchDedup // nil - disabled
+	timestampAlign         TimestampAlign
+	timestampsAligned      uint32 // counter
 }
 
-// NewWhisper create instance of Whisper
-func NewWhisper(rootPath string, schemas WhisperSchemas, aggregation *WhisperAggregation, in chan *points.Points, confirm chan *points.Points) *Whisper {
+// NewPersister creates persister which writes points from in to store. nil store - whisper files
+func NewPersister(store Store, in chan *points.Points, confirm chan *points.Points) *Whisper {
 	return &Whisper{
 		in:                  in,
 		confirm:             confirm,
-		schemas:             schemas,
-		aggregation:         aggregation,
+		backend:             store,
 		workersCount:        1,
-		rootPath:            rootPath,
 		maxUpdatesPerSecond: 0,
+		stopTimeout:         10 * time.Second,
+		degradedRetryMin:    time.Second,
+		pathEncoder:         SafePathEncoder{},
+		createOpener:        osCreateOpener{},
+	}
+}
+
+// NewWhisper create instance of Whisper
+func NewWhisper(rootPath string, schemas WhisperSchemas, aggregation *WhisperAggregation, in chan *points.Points, confirm chan *points.Points) *Whisper {
+	p := NewPersister(nil, in, confirm)
+	p.rootPath = rootPath
+	p.SetStorageConfig(schemas, aggregation)
+	return p
+}
+
+// storageConfig is replaced atomically on reload
+type storageConfig struct {
+	schemas     WhisperSchemas
+	aggregation *WhisperAggregation
+	mirrors     bool // some schemas have mirror
+}
+
+// SetStorageConfig replaces schemas and aggregation. Safe for running persister: next opened
+// and created files use new settings, writes in progress are not affected
+func (p *Whisper) SetStorageConfig(schemas WhisperSchemas, aggregation *WhisperAggregation) {
+	p.storage.Store(&storageConfig{
+		schemas:     schemas,
+		aggregation: aggregation,
+		mirrors:     schemas.hasMirrors(),
+	})
+}
+
+func (p *Whisper) loadStorageConfig() *storageConfig {
+	if c, ok := p.storage.Load().(*storageConfig); ok {
+		return c
 	}
+	return &storageConfig{}
 }
 
 // SetMaxUpdatesPerSecond enable throttling
@@ -58,115 +217,848 @@ func (p *Whisper) GetMaxUpdatesPerSecond() int {
 	return p.maxUpdatesPerSecond
 }
 
+// SetMaxRetentionAge sets max age of points for new whisper files. Older points are dropped
+// and files with only outdated points are not created. 0 - use max retention of schema
+func (p *Whisper) SetMaxRetentionAge(maxRetentionAge time.Duration) {
+	p.maxRetentionAge = maxRetentionAge
+}
+
+// SetStopTimeout sets max time of writing points buffered in input channel on Stop
+func (p *Whisper) SetStopTimeout(timeout time.Duration) {
+	p.stopTimeout = timeout
+}
+
+// SetMaxOpenFiles sets size of LRU cache of opened whisper files in each worker. 0 - close file after each update
+func (p *Whisper) SetMaxOpenFiles(maxOpenFiles int) {
+	p.maxOpenFiles = maxOpenFiles
+}
+
 // SetWorkers count
 func (p *Whisper) SetWorkers(count int) {
 	p.workersCount = count
 }
 
-// SetSparse creation
+// SetInternalChannelSize sets buffer size of channels from shuffler to workers. Every buffered value
+// holds all points of metric popped from cache, so memory is up to "workers * size" values. 0 - default
+func (p *Whisper) SetInternalChannelSize(size int) {
+	p.internalChannelSize = size
+}
+
+// workerChannelSize returns buffer size of worker channel. Default is 256 values in total, but not less than 32 per worker
+func (p *Whisper) workerChannelSize() int {
+	if p.internalChannelSize > 0 {
+		return p.internalChannelSize
+	}
+	if p.workersCount <= 0 || 256/p.workersCount < 32 {
+		return 32
+	}
+	return 256 / p.workersCount
+}
+
+// SetSparse enables sparse creation of new whisper files. Default false
 func (p *Whisper) SetSparse(sparse bool) {
 	p.sparse = sparse
 }
 
+// SetDedupPolicy sets value of points with the same timestamp in one update. Default - points.DedupLast
+func (p *Whisper) SetDedupPolicy(policy points.DedupPolicy) {
+	p.dedupPolicy = policy
+}
+
+// SetFsync enables fsync of whisper file after each update
+func (p *Whisper) SetFsync(fsync bool) {
+	p.fsync = fsync
+}
+
+// SetLogSampling limits repeated error messages of persister to rate per window, the rest are
+// reported by summary. 0 window - log all messages
+func (p *Whisper) SetLogSampling(window time.Duration, rate int) {
+	p.log.Close()
+	p.log.Flush()
+	if window <= 0 {
+		p.log = nil
+		return
+	}
+	p.log = logging.NewSampler(window, rate)
+}
+
 func (p *Whisper) SetMockStore(fn func() (StoreFunc, func())) {
 	p.mockStore = fn
 }
 
-func store(p *Whisper, values *points.Points) {
-	path := filepath.Join(p.rootPath, strings.Replace(values.Metric, ".", "/", -1)+".wsp")
+func store(p *Whisper, values *points.Points) error {
+	return storeWithFiles(p, values, nil)
+}
 
-	if p.confirm != nil {
-		defer func() { p.confirm <- values }()
+// storeWithFiles writes values to whisper file. If files is not nil opened files are kept in it.
+// Returns *StoreError on failure or errCreateThrottled
+func storeWithFiles(p *Whisper, values *points.Points, files *fileCache) (err error) {
+	// values are hashed as received, so retransmission is dropped before any processing
+	if p.batchDedup != nil {
+		key := batchKey(values)
+		if p.batchDedup.seen(key, p.now()) {
+			return nil
+		}
+		// failed values are not remembered, so their retries are written
+		defer func() {
+			if err == nil {
+				p.batchDedup.written(key, p.now())
+			}
+		}()
 	}
 
-	w, err := whisper.Open(path)
+	values = p.normalizeName(values)
+	values = p.normalizeTimestamps(values)
+
+	// checked after normalize and for any path encoder, workers validate names before store
+	if emptySegment(values.Metric) {
+		atomic.AddUint32(&p.invalidNames, 1)
+		logrus.Debugf("[persister] Empty segment in metric name %#v, values dropped", values.Metric)
+		return nil
+	}
+
+	if p.drop(values) {
+		return nil
+	}
+
+	path, dir, err := p.metricPath(values.Metric)
 	if err != nil {
-		// create new whisper if file not exists
-		if !os.IsNotExist(err) {
-			logrus.Errorf("[persister] Failed to open whisper file %s: %s", path, err.Error())
-			return
+		return &StoreError{Op: StoreOpName, Metric: values.Metric, Err: fmt.Errorf("Bad metric name %#v: %s", values.Metric, err.Error())}
+	}
+
+	// samples of pre-aggregated metric are not deduplicated, all of them are used for aggregation
+	storage := p.loadStorageConfig()
+	pre := storage.aggregation.preAggregation(values.Metric)
+	var data []points.Point
+	if pre != nil {
+		data = values.Data
+	} else {
+		data = values.Dedup(p.dedupPolicy).Data
+	}
+
+	if data = p.dropFuture(values.Metric, data); len(data) == 0 {
+		return nil
+	}
+	if data = p.dropNonFinite(data); len(data) == 0 {
+		return nil
+	}
+
+	p.trackUpdated(values.Metric)
+
+	var w WhisperFile
+	if files != nil {
+		if w = files.get(path); w != nil {
+			atomic.AddUint32(&p.openFileHits, 1)
+		} else {
+			atomic.AddUint32(&p.openFileMisses, 1)
 		}
+	}
 
-		schema, ok := p.schemas.Match(values.Metric)
-		if !ok {
-			logrus.Errorf("[persister] No storage schema defined for %s", values.Metric)
-			return
+	if w == nil {
+		if w, err = openOrCreate(p, values, path, &data); w == nil {
+			return err
+		}
+		if files != nil {
+			files.add(path, w)
 		}
+	}
 
-		aggr := p.aggregation.match(values.Metric)
-		if aggr == nil {
-			logrus.Errorf("[persister] No storage aggregation defined for %s", values.Metric)
-			return
+	if retentions := w.Retentions(); len(retentions) > 0 {
+		data = p.alignTimestamps(data, retentions[0].SecondsPerPoint(), pre == nil)
+	}
+
+	if pre != nil {
+		if retentions := w.Retentions(); len(retentions) > 0 {
+			data = pre.apply(data, retentions[0].SecondsPerPoint())
 		}
+	}
 
-		logrus.WithFields(logrus.Fields{
-			"retention":    schema.RetentionStr,
-			"schema":       schema.Name,
-			"aggregation":  aggr.name,
-			"xFilesFactor": aggr.xFilesFactor,
-			"method":       aggr.aggregationMethodStr,
-		}).Debugf("[persister] Creating %s", path)
+	retentions := w.Retentions()
+	now := p.now().Unix()
+	if p.archiveStatsEnabled {
+		p.archiveStats.countArchives(data, retentions, now)
+	}
+	chunks := updateChunks(data, retentions, now, p.maxPointsPerUpdate)
 
-		if err = os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
-			logrus.Error(err)
-			return
+	p.committedPoints.add(len(data))
+	p.updateOperations.add(len(chunks))
+	p.namespaceStored(values.Metric, len(data), len(chunks))
+
+	// deferred before Close, so file is already closed on quarantine
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint32(&p.updateErrors, 1)
+			p.log.WithFields(logrus.Fields{
+				"metric": values.Metric,
+				"path":   path,
+			}).Errorf("[persister] UpdateMany %s recovered: %s", path, r)
+			err = &StoreError{Op: StoreOpPanic, Metric: values.Metric, Path: path, Err: fmt.Errorf("UpdateMany %s recovered: %v", path, r)}
+			if files != nil {
+				files.remove(path)
+			}
+			if p.quarantineCorrupt {
+				quarantine(path)
+			}
 		}
+	}()
 
-		w, err = whisper.CreateWithOptions(path, schema.Retentions, aggr.aggregationMethod, float32(aggr.xFilesFactor), &whisper.Options{
-			Sparse: p.sparse,
-		})
+	if files == nil {
+		// w is replaced by file rebuilt by reconciler
+		defer func() { w.Close() }()
+	}
+
+	lock := p.fileLocks.get(path)
+	update := func(chunk []*whisper.TimeSeriesPoint) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if p.schemaReconcile {
+			nw, reopened, err := p.reopenRebuilt(w, path)
+			if err != nil {
+				atomic.AddUint32(&p.openErrors, 1)
+				return &StoreError{Op: StoreOpOpen, Metric: values.Metric, Path: path, Err: fmt.Errorf("Failed to open rebuilt whisper file %s: %s", path, err.Error())}
+			}
+			if reopened {
+				if files != nil {
+					// closes replaced file
+					files.add(path, nw)
+				} else {
+					w.Close()
+				}
+				w = nw
+			}
+		}
+
+		start := time.Now()
+		err := w.UpdateMany(chunk)
+		duration := time.Now().Sub(start)
+		p.updateTime.Add(duration)
+		p.checkSlowWrite(values.Metric, path, duration)
+		return err
+	}
+
+	// lock is released between chunks, so readers of file are not blocked by whole batch
+	for _, chunk := range chunks {
+		if err = update(chunk); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		if files != nil {
+			files.remove(path)
+		}
+		if e, ok := err.(*StoreError); ok {
+			return e
+		}
+		return &StoreError{Op: StoreOpUpdate, Metric: values.Metric, Path: path, Err: fmt.Errorf("Failed to update whisper file %s: %s", path, err.Error()), cause: err}
+	}
+
+	if p.fsync {
+		if err := p.syncFile(w, path); err != nil {
+			return &StoreError{Op: StoreOpUpdate, Metric: values.Metric, Path: path, Err: fmt.Errorf("Failed to fsync whisper file %s: %s", path, err.Error()), cause: err}
+		}
+	}
+
+	if p.fadviseDontNeed {
+		if err := dropPageCache(w); err != nil {
+			p.log.Warnf("[persister] Failed to fadvise whisper file %s: %s", path, err.Error())
+		}
+	}
+
+	dir.updated()
+	p.writeSucceeded()
+
+	if storage.mirrors {
+		p.storeMirror(storage, values.Metric, data, now)
+	}
+	return nil
+}
+
+// fileNotExists returns true only if stat of path fails with not exist error
+func (p *Whisper) fileNotExists(path string) bool {
+	_, err := p.createOpener.Stat(path)
+	return os.IsNotExist(err)
+}
+
+// openOrCreate opens whisper file or creates new if not exists. Points for new file are filtered
+// by max retention age in data. Returns nil if file not opened: with error if creation is throttled or failed,
+// without error if all points are outdated or create is delayed by create threshold
+func openOrCreate(p *Whisper, values *points.Points, path string, data *[]points.Point) (WhisperFile, error) {
+	w, err := p.createOpener.Open(path)
+	if err == nil && p.verifyOnOpen {
+		// quarantined file doesn't exist anymore, so it is created below
+		err = p.verifyOpened(w, values.Metric, path)
+	}
+	if err != nil {
+		// create new whisper if file not exists. Error of Open is not checked: it may be wrapped or caused by
+		// corrupt header, existing file with permission or format problem must not be created again
+		if !p.fileNotExists(path) {
+			atomic.AddUint32(&p.openErrors, 1)
+			return nil, &StoreError{Op: StoreOpOpen, Metric: values.Metric, Path: path, Err: fmt.Errorf("Failed to open whisper file %s: %s", path, err.Error())}
+		}
+
+		schema, aggr, err := p.matchStorage(values.Metric, path)
 		if err != nil {
-			logrus.Errorf("[persister] Failed to create new whisper file %s: %s", path, err.Error())
-			return
+			return nil, err
 		}
 
-		atomic.AddUint32(&p.created, 1)
+		maxAge := int64(p.maxRetentionAge.Seconds())
+		if maxAge <= 0 {
+			maxAge = int64(maxRetention(schema.Retentions))
+		}
+
+		received := len(*data)
+		*data = freshPoints(*data, p.now().Unix()-maxAge)
+		if outdated := received - len(*data); outdated > 0 {
+			atomic.AddUint32(&p.outdatedPoints, uint32(outdated))
+		}
+		if len(*data) == 0 {
+			logrus.Debugf("[persister] All points of %s are outdated, file not created", values.Metric)
+			return nil, nil
+		}
+
+		var pass bool
+		if *data, pass = p.passCreate(values.Metric, *data); !pass {
+			// buffered until create threshold
+			return nil, nil
+		}
+
+		var created bool
+		if w, created, err = p.createExclusive(values.Metric, path, schema, aggr); err != nil {
+			return nil, err
+		}
+		if created {
+			p.trackCreated(values.Metric)
+			p.trackCreateGate(values.Metric)
+		}
+	} else if p.schemaReconcile {
+		p.requestReconcile(w, values.Metric, path)
 	}
 
-	points := make([]*whisper.TimeSeriesPoint, len(values.Data))
-	for i, r := range values.Data {
-		points[i] = &whisper.TimeSeriesPoint{Time: int(r.Timestamp), Value: r.Value}
+	return w, nil
+}
+
+// matchStorage returns schema and aggregation of new whisper file of metric
+func (p *Whisper) matchStorage(metric string, path string) (Schema, *whisperAggregationItem, error) {
+	storage := p.loadStorageConfig()
+
+	schema, ok := storage.schemas.Match(metric)
+	if !ok {
+		return schema, nil, &StoreError{Op: StoreOpSchema, Metric: metric, Path: path, Err: fmt.Errorf("No storage schema defined for %s", metric)}
 	}
 
-	atomic.AddUint32(&p.committedPoints, uint32(len(values.Data)))
-	atomic.AddUint32(&p.updateOperations, 1)
+	aggr := storage.aggregation.match(metric)
+	if aggr == nil {
+		return schema, nil, &StoreError{Op: StoreOpSchema, Metric: metric, Path: path, Err: fmt.Errorf("No storage aggregation defined for %s", metric)}
+	}
 
-	defer w.Close()
+	return schema, aggr, nil
+}
 
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("[persister] UpdateMany %s recovered: %s", path, r)
+// create creates new whisper file of metric with schema and aggregation. Returns errCreateThrottled if
+// creation is limited by max creates per second
+func (p *Whisper) create(metric string, path string, schema Schema, aggr *whisperAggregationItem) (WhisperFile, error) {
+	if p.createLimiter != nil && !p.createLimiter.allow() {
+		return nil, errCreateThrottled
+	}
+
+	fields := logrus.Fields{
+		"metric":       metric,
+		"retention":    schema.RetentionStr,
+		"schema":       schema.Name,
+		"aggregation":  aggr.name,
+		"xFilesFactor": aggr.xFilesFactor,
+		"method":       aggr.aggregationMethodStr,
+	}
+	logrus.WithFields(fields).Debugf("[persister] Creating %s", path)
+
+	if err := p.mkdirAll(filepath.Dir(path)); err != nil {
+		return nil, &StoreError{Op: StoreOpCreate, Metric: metric, Path: path, Err: fmt.Errorf("Failed to create directory of %s: %s", path, err.Error()), cause: err}
+	}
+
+	w, err := p.createOpener.Create(path, schema.Retentions, aggr.aggregationMethod, float32(aggr.xFilesFactor), p.sparse)
+	if err != nil {
+		return nil, &StoreError{Op: StoreOpCreate, Metric: metric, Path: path, Err: fmt.Errorf("Failed to create new whisper file %s: %s", path, err.Error()), cause: err}
+	}
+
+	if err = p.applyOwnership(path, p.fileMode); err != nil {
+		p.log.Errorf("[persister] Failed to set permissions of new whisper file %s: %s", path, err.Error())
+	}
+	p.writeTaggedName(w, metric, path)
+
+	atomic.AddUint32(&p.created, 1)
+	if p.audit != nil {
+		p.audit.created(path, fields)
+	}
+	p.notifyCreated(createdFile{metric: metric, path: path, schema: schema.Name, aggregation: aggr.name})
+	p.addToIndex(metric)
+	return w, nil
+}
+
+// createExclusive creates whisper file of metric under lock of path, so it is not created concurrently by workers
+// and Precreate: create replaces file by temporary file. Returns opened file and false if file is created meanwhile
+func (p *Whisper) createExclusive(metric string, path string, schema Schema, aggr *whisperAggregationItem) (WhisperFile, bool, error) {
+	return p.openOrCreate(metric, path, func() (WhisperFile, error) {
+		return p.create(metric, path, schema, aggr)
+	})
+}
+
+// openOrCreate opens file of path or creates it by create under lock of path. Returns true if file is created
+func (p *Whisper) openOrCreate(metric string, path string, create func() (WhisperFile, error)) (WhisperFile, bool, error) {
+	lock := p.fileLocks.get(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	w, err := p.createOpener.Open(path)
+	if err == nil {
+		return w, false, nil
+	}
+	if !p.fileNotExists(path) {
+		return nil, false, &StoreError{Op: StoreOpOpen, Metric: metric, Path: path, Err: fmt.Errorf("Failed to open whisper file %s: %s", path, err.Error())}
+	}
+
+	if w, err = create(); err != nil {
+		return nil, false, err
+	}
+	return w, true, nil
+}
+
+// writeTaggedName writes name of new tagged whisper file w to sidecar file, so it is recoverable from path.
+// Skipped for files without descriptor
+func (p *Whisper) writeTaggedName(w WhisperFile, metric string, path string) {
+	if _, ok := w.(descriptor); !ok || !IsTagged(metric) {
+		return
+	}
+
+	if err := WriteTaggedName(path, metric); err != nil {
+		p.log.Errorf("[persister] Failed to write name of tagged whisper file %s: %s", path, err.Error())
+		return
+	}
+	if err := p.applyOwnership(TaggedNamePath(path), p.fileMode); err != nil {
+		p.log.Errorf("[persister] Failed to set permissions of name of tagged whisper file %s: %s", path, err.Error())
+	}
+}
+
+// maxRetention returns the longest retention window in seconds
+func maxRetention(retentions whisper.Retentions) int {
+	max := 0
+	for _, r := range retentions {
+		if r.MaxRetention() > max {
+			max = r.MaxRetention()
 		}
-	}()
-	w.UpdateMany(points)
+	}
+	return max
+}
+
+// freshPoints returns points with timestamp >= minTimestamp. Source slice is not modified
+// because it is still visible for carbonlink until confirmed
+func freshPoints(data []points.Point, minTimestamp int64) []points.Point {
+	for i, d := range data {
+		if d.Timestamp < minTimestamp {
+			fresh := make([]points.Point, i, len(data))
+			copy(fresh, data[:i])
+			for _, d := range data[i+1:] {
+				if d.Timestamp >= minTimestamp {
+					fresh = append(fresh, d)
+				}
+			}
+			return fresh
+		}
+	}
+	return data
+}
+
+// syncer is implemented by whisper files which flush their data to disk by opened descriptor
+type syncer interface {
+	Sync() error
+}
+
+// syncFile flushes data of opened whisper file to disk by opener
+func (p *Whisper) syncFile(w WhisperFile, path string) error {
+	return p.createOpener.Sync(w, path)
 }
 
-func (p *Whisper) worker(in chan *points.Points, exit chan bool) {
-	storeFunc := store
+// fsyncFile flushes file data to disk. fsync on any descriptor of the same file flushes all its dirty pages
+func fsyncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// drain calls callback for values buffered in channel at the moment of call.
+// Stops if drain deadline exceeded
+func (p *Whisper) drain(in chan *points.Points, callback func(*points.Points)) {
+	for n := len(in); n > 0; n-- {
+		if deadline := atomic.LoadInt64(&p.drainDeadline); deadline != 0 && time.Now().UnixNano() > deadline {
+			atomic.StoreUint32(&p.drainIncomplete, 1)
+			return
+		}
+
+		select {
+		case values, ok := <-in:
+			if !ok {
+				return
+			}
+			callback(values)
+		default:
+			return
+		}
+	}
+}
+
+// worker stores values from in. After exit or closing of in writes values buffered in drainFrom
+func (p *Whisper) worker(in chan *points.Points, exit chan bool, drainFrom chan *points.Points) {
+	p.countedWorker(in, exit, drainFrom, nil, nil, nil)
+}
+
+// countedWorker is worker which counts stored values in stat (if not nil). Solo worker handles requests of Freeze.
+// Worker of shuffler takes throttled and retried creates of previous workers from h and puts own ones to h if it
+// is stopped by resize or freeze
+func (p *Whisper) countedWorker(in chan *points.Points, exit chan bool, drainFrom chan *points.Points, stat *workerStat, freeze chan *freezeRequest, h *workerHandover) {
+	backend := p.backend
+	if backend == nil {
+		ws := &whisperStore{p: p}
+		if p.maxOpenFiles > 0 {
+			ws.files = newFileCache(p.maxOpenFiles)
+			defer ws.files.closeAll()
+			p.openFiles.register(ws.files)
+			defer p.openFiles.unregister(ws.files)
+		}
+		backend = ws
+	}
+
+	// exit of persister, the worker exit is nil if values are sharded by shuffler
+	stop := p.exit
+	if stop == nil {
+		stop = exit
+	}
+
+	// values are released in WAL only if stored, failed ones are replayed after restart
+	wal := p.wal
+	confirmOne := func(values *points.Points, stored bool) {
+		if wal != nil {
+			if stored {
+				wal.release(values)
+			} else {
+				wal.keep(values)
+			}
+		}
+		if p.confirm != nil {
+			p.confirm <- values
+		}
+	}
+	confirm := confirmOne
+
+	// received values merged by flush interval are confirmed with merged values, so values kept by
+	// pending and retries or not written because of degraded mode stay in cache
+	var mergedFrom func(merged *points.Points, queued []*points.Points)
+	var merges map[*points.Points][]*points.Points
+	if p.flushInterval > 0 {
+		merges = make(map[*points.Points][]*points.Points)
+		confirm = func(values *points.Points, stored bool) {
+			confirmOne(values, stored)
+			if queued, ok := merges[values]; ok {
+				delete(merges, values)
+				for _, q := range queued {
+					confirmOne(q, stored)
+				}
+			}
+		}
+		mergedFrom = func(merged *points.Points, queued []*points.Points) {
+			merges[merged] = queued
+		}
+	}
+
+	// values of throttled creates are confirmed after retry
+	var pending *pendingCreates
+	var retryTick <-chan time.Time
+	if p.maxCreatesPerSecond > 0 {
+		pending = newPendingCreates(createRetryMaxPending)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		retryTick = ticker.C
+	}
+
+	// values of creates failed with transient error are confirmed after last attempt
+	var retries *createRetries
+	var createRetryTick <-chan time.Time
+	if p.createRetries > 0 {
+		retries = newCreateRetries(createRetryMaxPending, p.createRetryBackoff)
+		ticker := time.NewTicker(p.createRetryBackoff)
+		defer ticker.Stop()
+		createRetryTick = ticker.C
+	}
+
+	// values throttled again are kept by pending during timeout, dropped ones are logged after retry
+	pendingTimeout := createRetryTimeout
+	var pendingDropped int
+
+	// attempt - number of retry of failed create, 0 for received values. since - time of first throttled
+	// create of values retried by pending, zero for others
+	var storeAttempt func(values *points.Points, attempt int, since time.Time)
+	storeFunc := func(p *Whisper, values *points.Points) {
+		storeAttempt(values, 0, time.Time{})
+	}
+	retryCreate := func(values *points.Points, attempt int) {
+		storeAttempt(values, attempt, time.Time{})
+	}
+	retryPending := func(timeout time.Duration) {
+		pendingTimeout = timeout
+		pending.retry(p.createLimiter, func(values *points.Points, since time.Time) {
+			storeAttempt(values, 0, since)
+		}, func(values *points.Points, since time.Time) {
+			if p.now().Sub(since) < pendingTimeout && pending.add(values, since) {
+				return
+			}
+			pendingDropped++
+			confirm(values, false)
+		})
+		pendingTimeout = createRetryTimeout
+
+		if pendingDropped > 0 {
+			logrus.Warnf("[persister] Dropped %d throttled creates of whisper files", pendingDropped)
+			pendingDropped = 0
+		}
+	}
+
+	storeAttempt = func(values *points.Points, attempt int, since time.Time) {
+		if err := p.validateName(values.Metric); err != nil {
+			p.rejectName(values.Metric, err)
+			// counted by invalidNames
+			if p.errorHandler != nil {
+				p.errorHandler(values.Metric, &StoreError{Op: StoreOpName, Metric: values.Metric, Err: err})
+			}
+			// never stored, so not kept in WAL
+			confirm(values, true)
+			return
+		}
+
+		err := backend.Store(values)
+		if retries != nil && attempt < p.createRetries && isTransientCreateError(err) && retries.add(values, attempt, p.now()) {
+			atomic.AddUint32(&p.createRetried, 1)
+			return
+		}
+		if err != nil && err != errCreateThrottled {
+			p.storeFailed(values.Metric, err)
+		}
+		if isFilesystemError(err) {
+			if !p.writeFailed(err) {
+				// logged by writeFailed, values are dropped
+				confirm(values, false)
+				return
+			}
+			// degraded, don't read new values until write succeeds or retries of values are exhausted
+			var completed bool
+			if completed, err = p.retryDegraded(func() error { return backend.Store(values) }, stop); !completed {
+				// stopped, values are left in cache
+				return
+			}
+		} else if isWriteError(err) {
+			// error of single file (e.g. corrupt or permission denied) is not retried, values are dropped
+			atomic.AddUint32(&p.writeErrors, 1)
+		}
+
+		if err == errCreateThrottled {
+			now := p.now()
+			retried := !since.IsZero()
+			if !retried {
+				atomic.AddUint32(&p.createThrottled, 1)
+				since = now
+			}
+			if pending != nil && now.Sub(since) < pendingTimeout && pending.add(values, since) {
+				return
+			}
+			if retried {
+				pendingDropped++
+			}
+		} else if err != nil {
+			p.log.Errorf("[persister] Failed to store %s: %s", values.Metric, err.Error())
+		} else {
+			stat.stored(values)
+		}
+		confirm(values, err == nil)
+	}
+
+	// creates of previous workers of shuffler
+	inherited := h.take(in)
+	for merged, queued := range inherited.merges {
+		if mergedFrom != nil {
+			mergedFrom(merged, queued)
+		} else {
+			// flush interval is not changed between workers, just in case
+			for _, q := range queued {
+				confirmOne(q, false)
+			}
+		}
+	}
+	for _, item := range inherited.pending {
+		if pending == nil || !pending.add(item.values, item.since) {
+			storeAttempt(item.values, 0, item.since)
+		}
+	}
+	for _, item := range inherited.retries {
+		if retries == nil || !retries.restore(item) {
+			retryCreate(item.values, item.attempt+1)
+		}
+	}
+
 	var doneCb func()
 	if p.mockStore != nil {
 		storeFunc, doneCb = p.mockStore()
 	}
+	storeFunc = p.workerTime.timed(storeFunc)
+	defer p.workerTime.start()()
+
+	if p.storeTimeout > 0 {
+		slot := p.storeWatchdog.register()
+		defer p.storeWatchdog.unregister(slot)
+		storeFunc = slot.watched(storeFunc)
+	}
+
+	batchSize := cap(in)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	b := make(batch, 0, batchSize)
+
+	var c *coalescer
+	var flushTick <-chan time.Time
+	if p.flushInterval > 0 {
+		c = newCoalescer()
+		ticker := time.NewTicker(p.flushInterval)
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+	lag := p.lag.register()
+	defer p.lag.unregister(lag)
+
+	// flush writes merged values, received ones are confirmed with them. Confirm of merged values is noop for cache
+	flush := func() {
+		b = c.merged(b[:0], p.dedupPolicy)
+		p.sortBatch(b)
+		for i, v := range b {
+			if queued := c.queued(v.Metric); !(len(queued) == 1 && queued[0] == v) {
+				mergedFrom(v, queued)
+			}
+			storeFunc(p, v)
+			if doneCb != nil {
+				doneCb()
+			}
+			b[i] = nil
+		}
+		c.reset()
+		lag.done()
+	}
 
 LOOP:
 	for {
 		select {
 		case <-exit:
 			break LOOP
+		case <-flushTick:
+			flush()
+		case <-retryTick:
+			retryPending(createRetryTimeout)
+		case <-createRetryTick:
+			retries.retry(retryCreate, p.now())
+		case req := <-freeze:
+			if c != nil && c.len() > 0 {
+				flush()
+			}
+			close(req.done)
+			waitThaw(req, stop)
 		case values, ok := <-in:
 			if !ok {
 				break LOOP
 			}
-			storeFunc(p, values)
-			if doneCb != nil {
-				doneCb()
+			lag.take(values)
+
+			if c != nil {
+				c.add(values)
+				if p.flushMaxPoints > 0 && c.points >= p.flushMaxPoints {
+					flush()
+				}
+				continue LOOP
+			}
+
+			if p.writeStrategy == Noop {
+				storeFunc(p, values)
+				if doneCb != nil {
+					doneCb()
+				}
+				lag.done()
+				continue LOOP
+			}
+
+			b, ok = readBatch(b[:0], values, in, batchSize)
+			p.sortBatch(b)
+			for i, v := range b {
+				storeFunc(p, v)
+				if doneCb != nil {
+					doneCb()
+				}
+				b[i] = nil
+			}
+			lag.done()
+			if !ok {
+				break LOOP
 			}
 		}
 	}
+
+	if c != nil && c.len() > 0 {
+		flush()
+	}
+
+	p.drain(drainFrom, func(values *points.Points) {
+		storeFunc(p, values)
+		if doneCb != nil {
+			doneCb()
+		}
+	})
+
+	// stopped by resize or freeze, creates are passed to new workers
+	if h.collecting() {
+		h.put(pending.takeAll(), retries.takeAll(), merges)
+		return
+	}
+
+	// last attempt without backoff, values failed again are dropped
+	if retries != nil && retries.len() > 0 {
+		retries.flush(func(values *points.Points) {
+			retryCreate(values, p.createRetries)
+		})
+	}
+
+	// last try, still throttled values are dropped
+	if pending != nil && pending.len() > 0 {
+		retryPending(0)
+	}
 }
 
-func (p *Whisper) shuffler(in chan *points.Points, out [](chan *points.Points), exit chan bool) {
-	workers := uint32(len(out))
+// shuffler shards values from in by workers. After exit or closing of in shards values buffered in drainFrom
+func (p *Whisper) shuffler(in chan *points.Points, out [](chan *points.Points), exit chan bool, drainFrom chan *points.Points) {
+	route := p.workerRoute(len(out))
+	send := func(values *points.Points) {
+		p.sendToWorker(out[route(values.Metric)], values)
+	}
+
+	// nil if shuffler is not started by Start
+	resizer := p.resizer
+	var resize chan chan bool
+	var freeze chan *freezeRequest
+	if resizer != nil {
+		resize = resizer.requests
+		freeze = p.freezeRequests
+	}
+	stop := p.exit
 
 LOOP:
 	for {
@@ -177,11 +1069,23 @@ LOOP:
 			if !ok {
 				break LOOP
 			}
-			index := crc32.ChecksumIEEE([]byte(values.Metric)) % workers
-			out[index] <- values
+			send(values)
+		case done := <-resize:
+			out = p.resize(resizer, out)
+			route = p.workerRoute(len(out))
+			close(done)
+		case req := <-freeze:
+			stopWorkers(resizer, out)
+			close(req.done)
+			waitThaw(req, stop)
+			// restarted on exit too for drain of buffered values
+			out = p.restartWorkers(resizer)
+			route = p.workerRoute(len(out))
 		}
 	}
 
+	p.drain(drainFrom, send)
+
 	for _, ch := range out {
 		close(ch)
 	}
@@ -189,118 +1093,262 @@ LOOP:
 
 // Stat callback
 func (p *Whisper) Stat(send helper.StatCallback) {
-	updateOperations := atomic.LoadUint32(&p.updateOperations)
-	committedPoints := atomic.LoadUint32(&p.committedPoints)
-	atomic.AddUint32(&p.updateOperations, -updateOperations)
-	atomic.AddUint32(&p.committedPoints, -committedPoints)
-
-	created := atomic.LoadUint32(&p.created)
-	atomic.AddUint32(&p.created, -created)
-
-	send("updateOperations", float64(updateOperations))
-	send("committedPoints", float64(committedPoints))
-	if updateOperations > 0 {
-		send("pointsPerUpdate", float64(committedPoints)/float64(updateOperations))
-	} else {
-		send("pointsPerUpdate", 0.0)
-	}
+	p.countersStat(send)
+	p.namespaceStat(send)
 
-	send("created", float64(created))
+	send("created", float64(atomic.SwapUint32(&p.created, 0)))
+	p.onCreateStat(send)
+	p.createGateStat(send)
+	p.batchDedupStat(send)
+	p.alignStat(send)
+	p.oneShotStat(send)
 
-}
-
-func ThrottleChan(in chan *points.Points, ratePerSec int, exit chan bool) chan *points.Points {
-	out := make(chan *points.Points, cap(in))
+	helper.SendAndResetPercentiles("updateTime", &p.updateTime, send)
 
-	delimeter := ratePerSec
-	chunk := 1
+	if p.slowWriteThreshold > 0 {
+		helper.SendAndSubstractUint32("slowWrites", &p.slowWrites, send)
+	}
+	p.watchdogStat(send)
 
-	if ratePerSec > 1000 {
-		minRemainder := ratePerSec
+	helper.SendAndSubstractUint32("outdatedPoints", &p.outdatedPoints, send)
+	p.archiveStat(send)
+	if p.maxFutureDrift > 0 {
+		helper.SendAndSubstractUint32("futurePoints", &p.futurePoints, send)
+	}
+	p.nonFiniteStat(send)
+	p.mirrorStat(send)
+	if p.timestampNormalizer != nil {
+		helper.SendAndSubstractUint32("timestampsCorrected", &p.timestampsCorrected, send)
+	}
+	helper.SendAndSubstractUint32("updateErrors", &p.updateErrors, send)
+	helper.SendAndSubstractUint32("openErrors", &p.openErrors, send)
+	if p.verifyOnOpen {
+		helper.SendAndSubstractUint32("verifyFailed", &p.verifyFailed, send)
+	}
+	helper.SendAndSubstractUint32("invalidNames", &p.invalidNames, send)
+	helper.SendAndSubstractUint32("dropped", &p.dropped, send)
+	p.dataDirsStat(send)
+	helper.SendAndSubstractUint32("writeErrors", &p.writeErrors, send)
+	p.storeErrorsStat(send)
 
-		for i := 100; i < 1000; i++ {
-			if ratePerSec%i < minRemainder {
-				delimeter = i
-				minRemainder = ratePerSec % delimeter
-			}
+	if p.degradedThreshold > 0 {
+		if p.Degraded() {
+			send("degraded", 1)
+		} else {
+			send("degraded", 0)
 		}
+	}
+
+	if p.maxCreatesPerSecond > 0 {
+		helper.SendAndSubstractUint32("createThrottled", &p.createThrottled, send)
+	}
+
+	if p.createRetries > 0 {
+		helper.SendAndSubstractUint32("createRetries", &p.createRetried, send)
+	}
 
-		chunk = ratePerSec / delimeter
+	if p.workersCount > 1 || p.poolWorkers() > 0 {
+		p.overflowStat(send)
+		p.workersStat(send)
+	}
+
+	send("inputQueue", float64(len(p.in)))
+	send("inputQueueCap", float64(cap(p.in)))
+	if throttle, _ := p.throttle.Load().(*Throttle); throttle != nil && p.maxUpdatesPerSecond > 0 {
+		throttle.Stat(func(metric string, value float64) {
+			send("throttle."+metric, value)
+		})
 	}
 
-	step := time.Duration(1e9/delimeter) * time.Nanosecond
+	send("load", p.Load())
+	p.workerTimeStat(send)
+	send("maxLagSeconds", float64(p.lag.maxLag(p.now().Unix())))
 
-	var onceClose sync.Once
+	if p.diskUsageInterval > 0 {
+		send("fileCount", float64(atomic.LoadInt64(&p.fileCount)))
+		send("diskUsedBytes", float64(atomic.LoadInt64(&p.diskUsedBytes)))
+	}
 
-	throttleWorker := func() {
-		var p *points.Points
-		var ok bool
+	if p.punchIdleAge > 0 {
+		helper.SendAndSubstractUint32("punchedFiles", &p.punchedFiles, send)
+		helper.SendAndSubstractUint64("punchedBytes", &p.punchedBytes, send)
+	}
 
-		defer onceClose.Do(func() { close(out) })
+	if p.schemaReconcile {
+		helper.SendAndSubstractUint32("rebuilt", &p.rebuilt, send)
+	}
 
-		// start flight
-		throttleTicker := time.NewTicker(step)
-		defer throttleTicker.Stop()
+	if p.maxOpenFiles > 0 {
+		openFileHits := atomic.SwapUint32(&p.openFileHits, 0)
+		openFileMisses := atomic.SwapUint32(&p.openFileMisses, 0)
 
-	LOOP:
-		for {
-			select {
-			case <-throttleTicker.C:
-				for i := 0; i < chunk; i++ {
-					select {
-					case p, ok = <-in:
-						if !ok {
-							break LOOP
-						}
-					case <-exit:
-						break LOOP
-					}
-					out <- p
-				}
-			case <-exit:
-				break LOOP
-			}
+		send("openFileHits", float64(openFileHits))
+		send("openFileMisses", float64(openFileMisses))
+		if openFileHits+openFileMisses > 0 {
+			send("openFileHitRatio", float64(openFileHits)/float64(openFileHits+openFileMisses))
+		} else {
+			send("openFileHitRatio", 0.0)
 		}
 	}
 
-	go throttleWorker()
+}
+
+// StartContext starts persister and stops it with Stop on cancel of ctx
+func (p *Whisper) StartContext(ctx context.Context) error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+
+	p.RLock()
+	exit := p.exit
+	p.RUnlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-exit:
+			// stopped by Stop
+		}
+	}()
 
-	return out
+	return nil
 }
 
 // Start worker
 func (p *Whisper) Start() error {
 
 	return p.StartFunc(func() error {
+		atomic.StoreInt64(&p.drainDeadline, 0)
+		atomic.StoreUint32(&p.drainIncomplete, 0)
+		p.workerTime.reset(time.Now())
+
+		if err := p.ResolveRoot(); err != nil {
+			return err
+		}
+
+		p.index = nil
+		if p.indexPath != "" {
+			index, err := openIndex(p.indexPath, p.indexedMetrics)
+			if err != nil {
+				return fmt.Errorf("open index: %s", err.Error())
+			}
+			p.index = index
+		}
+
+		p.wal = nil
+		if p.walEnabled {
+			w, err := openWAL(p.walDir)
+			if err != nil {
+				if p.index != nil {
+					p.index.close()
+				}
+				return fmt.Errorf("open WAL: %s", err.Error())
+			}
+			p.wal = w
+		}
+
+		p.audit = nil
+		if p.auditOptions.path != "" {
+			audit, err := openAuditLog(p.auditOptions)
+			if err != nil {
+				if p.index != nil {
+					p.index.close()
+				}
+				if p.wal != nil {
+					p.wal.close()
+				}
+				return fmt.Errorf("open audit log: %s", err.Error())
+			}
+			p.audit = audit
+		}
 
 		p.WithExit(func(exitChan chan bool) {
+			p.exit = exitChan
 
 			inChan := p.in
+			queues := []chan *points.Points{p.in}
 
 			readerExit := exitChan
 
+			if p.wal != nil {
+				// unbuffered, values logged but not received by workers on stop are replayed on next start
+				walChan := make(chan *points.Points)
+				p.Go(func(e chan bool) {
+					p.walWriter(p.wal, p.in, walChan, e)
+				})
+				inChan = walChan
+			}
+
 			if p.maxUpdatesPerSecond > 0 {
-				inChan = ThrottleChan(inChan, p.maxUpdatesPerSecond, exitChan)
+				throttle := NewThrottle(inChan, p.maxUpdatesPerSecond, exitChan)
+				p.throttle.Store(throttle)
+				inChan = throttle.Out()
 				readerExit = nil // read all before channel is closed
+				queues = append(queues, inChan)
+			}
+
+			p.onCreateQueue = nil
+			if p.onCreate != nil {
+				queue := make(chan createdFile, onCreateQueueSize)
+				p.onCreateQueue = queue
+				onCreate := p.onCreate
+				p.Go(func(e chan bool) {
+					p.onCreateNotifier(onCreate, queue, e)
+				})
 			}
 
-			if p.workersCount <= 1 { // solo worker
+			p.resizer = nil
+			p.thaw = nil
+			freeze := make(chan *freezeRequest)
+			p.freezeRequests = freeze
+			if p.workersCount <= 1 && p.poolWorkers() == 0 { // solo worker
+				p.queues.Store(queues)
 				p.Go(func(e chan bool) {
-					p.worker(inChan, readerExit)
+					p.countedWorker(inChan, readerExit, p.in, nil, freeze, nil)
 				})
 			} else {
-				var channels [](chan *points.Points)
-
-				for i := 0; i < p.workersCount; i++ {
-					ch := make(chan *points.Points, 32)
-					channels = append(channels, ch)
-					p.Go(func(e chan bool) {
-						p.worker(ch, nil)
-					})
+				workers := p.workersCount
+				if workers < 1 {
+					workers = 1
+				}
+
+				handover := newWorkerHandover()
+				channels, wg := p.startWorkers(workers, handover)
+				p.resizer = &resizer{
+					requests: make(chan chan bool),
+					queues:   queues,
+					workers:  wg,
+					handover: handover,
 				}
+				p.queues.Store(append(queues[:len(queues):len(queues)], channels...))
+
+				p.Go(func(e chan bool) {
+					p.shuffler(inChan, channels, readerExit, p.in)
+				})
+			}
+
+			if p.diskUsageInterval > 0 {
+				p.Go(func(e chan bool) {
+					p.diskUsageScanner(e)
+				})
+			}
+
+			if p.punchIdleAge > 0 {
+				p.Go(func(e chan bool) {
+					p.holePuncher(e)
+				})
+			}
+
+			if p.schemaReconcile {
+				p.Go(func(e chan bool) {
+					p.reconciler(e)
+				})
+			}
 
+			if p.storeTimeout > 0 {
 				p.Go(func(e chan bool) {
-					p.shuffler(inChan, channels, readerExit)
+					p.watchdog(e)
 				})
 			}
 
@@ -309,3 +1357,62 @@ func (p *Whisper) Start() error {
 		return nil
 	})
 }
+
+var errNotStarted = errors.New("persister is not started")
+
+// Stop workers. Points buffered in input channel are written before exit during stop timeout
+func (p *Whisper) Stop() {
+	if err := p.StopWithTimeout(p.stopTimeout); err != nil {
+		logrus.Warnf("[persister] %s", err.Error())
+	}
+}
+
+// StopWithTimeout stops workers. Points buffered in input channel are written before exit.
+// Returns error if not all points written in timeout. 0 - no timeout.
+// Repeated calls are noop, error is returned if persister was never started
+func (p *Whisper) StopWithTimeout(timeout time.Duration) error {
+	p.RLock()
+	started := p.exit != nil
+	p.RUnlock()
+	if !started {
+		return errNotStarted
+	}
+
+	var deadline int64
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout).UnixNano()
+	}
+	atomic.StoreInt64(&p.drainDeadline, deadline)
+
+	var stopped bool
+	var wal *wal
+	var index *metricIndex
+	var audit *auditLog
+	p.StopFunc(func() {
+		stopped = true
+		// captured under lock, persister can be started again right after StopFunc
+		wal = p.wal
+		index = p.index
+		audit = p.audit
+	})
+
+	if stopped {
+		p.log.Flush()
+	}
+
+	if wal != nil {
+		wal.close()
+	}
+	if index != nil {
+		index.close()
+	}
+	if audit != nil {
+		audit.close()
+	}
+
+	if stopped && atomic.LoadUint32(&p.drainIncomplete) != 0 {
+		return fmt.Errorf("drain of input channel not completed in %s", timeout.String())
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ Schemas read code from https://github.com/grobian/carbonwriter/
 */
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,12 +21,18 @@ type whisperAggregationItem struct {
 	xFilesFactor         float64
 	aggregationMethodStr string
 	aggregationMethod    whisper.AggregationMethod
+	preAggregation       *preAggregation // nil for methods of whisper
+	xFilesFactorSet      bool            // set by [default] section, for fallback only
+	score                int             // specificity of pattern
+	position             int             // order of match in MatchFirst mode
 }
 
 // WhisperAggregation ...
 type WhisperAggregation struct {
 	Data    []*whisperAggregationItem
 	Default *whisperAggregationItem
+
+	preAggregated bool // some items are pre-aggregated by persister
 }
 
 // NewWhisperAggregation create instance of WhisperAggregation
@@ -42,8 +49,28 @@ func NewWhisperAggregation() *WhisperAggregation {
 	}
 }
 
+// DefaultXFilesFactor is used for aggregation sections without xFilesFactor
+const DefaultXFilesFactor = 0.5
+
 // ReadWhisperAggregation ...
 func ReadWhisperAggregation(file string) (*WhisperAggregation, error) {
+	return ReadWhisperAggregationWithDefault(file, DefaultXFilesFactor)
+}
+
+// defaultAggregationSection is name of section with fallback aggregation for metrics not matched by other sections.
+// Its xFilesFactor and aggregationMethod are inherited by sections without them
+const defaultAggregationSection = "default"
+
+// ReadWhisperAggregationWithDefault reads aggregation config. Sections are matched in order of file, first match wins
+// (see SetMatchMode).
+// Section [default] without pattern is the final fallback, its xFilesFactor and aggregationMethod are inherited by
+// sections without them. [default] with pattern is also matched in order of file (and shadows sections below
+// for its pattern). defaultXFilesFactor is used if xFilesFactor is set neither in section nor in [default]
+func ReadWhisperAggregationWithDefault(file string, defaultXFilesFactor float64) (*WhisperAggregation, error) {
+	if defaultXFilesFactor < 0 || defaultXFilesFactor > 1 {
+		return nil, fmt.Errorf("[persister] Default xFilesFactor %g is out of range [0, 1]", defaultXFilesFactor)
+	}
+
 	config, err := configparser.Read(file)
 	if err != nil {
 		return nil, err
@@ -55,16 +82,40 @@ func ReadWhisperAggregation(file string) (*WhisperAggregation, error) {
 	}
 
 	result := NewWhisperAggregation()
+	result.Default.xFilesFactor = defaultXFilesFactor
 
+	// [default] is read first, so it is inherited by sections above it too
+	var defaultSection *configparser.Section
 	for _, s := range sections {
-		item := &whisperAggregationItem{}
-		// this is mildly stupid, but I don't feel like forking
-		// configparser just for this
-		item.name =
-			strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
-		if item.name == "" || strings.HasPrefix(item.name, "#") {
+		if sectionName(s) != defaultAggregationSection {
 			continue
 		}
+		if defaultSection != nil {
+			return nil, fmt.Errorf("[persister] Duplicate aggregation section [%s]", defaultAggregationSection)
+		}
+		defaultSection = s
+	}
+
+	if defaultSection != nil {
+		if err := parseAggregationItem(defaultSection, result.Default, result.Default); err != nil {
+			return nil, err
+		}
+		if result.Default.preAggregation != nil {
+			result.preAggregated = true
+		}
+	}
+
+	for _, s := range sections {
+		name := sectionName(s)
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if s == defaultSection && s.ValueOf("pattern") == "" {
+			// final fallback
+			continue
+		}
+
+		item := &whisperAggregationItem{name: name}
 
 		item.pattern, err = regexp.Compile(s.ValueOf("pattern"))
 		if err != nil {
@@ -73,41 +124,174 @@ func ReadWhisperAggregation(file string) (*WhisperAggregation, error) {
 			return nil, err
 		}
 
-		item.xFilesFactor, err = strconv.ParseFloat(s.ValueOf("xFilesFactor"), 64)
-		if err != nil {
-			logrus.Errorf("failed to parse xFilesFactor '%s' in %s: %s",
-				s.ValueOf("xFilesFactor"), item.name, err.Error())
-			continue
+		if err := parseAggregationItem(s, item, result.Default); err != nil {
+			return nil, err
 		}
-
-		item.aggregationMethodStr = s.ValueOf("aggregationMethod")
-		switch item.aggregationMethodStr {
-		case "average", "avg":
-			item.aggregationMethod = whisper.Average
-		case "sum":
-			item.aggregationMethod = whisper.Sum
-		case "last":
-			item.aggregationMethod = whisper.Last
-		case "max":
-			item.aggregationMethod = whisper.Max
-		case "min":
-			item.aggregationMethod = whisper.Min
-		default:
-			logrus.Errorf("unknown aggregation method '%s'",
-				s.ValueOf("aggregationMethod"))
-			continue
+		if item.preAggregation != nil {
+			result.preAggregated = true
+		}
+		if counterSectionName(item.name) && item.xFilesFactor > 0 {
+			logrus.Warningf("[persister] Aggregation [%s] looks like counters, but xFilesFactor = %g drops rollups of sparse points. Set xFilesFactor = 0 to aggregate every point",
+				item.name, item.xFilesFactor)
 		}
 
-		logrus.Debugf("[persister] Adding aggregation [%s] pattern = %s aggregationMethod = %s xFilesFactor = %f",
+		logrus.Debugf("[persister] Adding aggregation [%s] pattern = %s aggregationMethod = %s xFilesFactor = %g",
 			item.name, s.ValueOf("pattern"),
 			item.aggregationMethodStr, item.xFilesFactor)
 
+		item.score = specificity(item.pattern)
+		item.position = len(result.Data)
 		result.Data = append(result.Data, item)
 	}
 
+	logrus.Infof("[persister] Loaded %d aggregation sections, other metrics [%s]: aggregationMethod = %s xFilesFactor = %g",
+		len(result.Data), result.Default.name, result.Default.aggregationMethodStr, result.Default.xFilesFactor)
+
 	return result, nil
 }
 
+// aggregationMethodAliases maps common alternative names of aggregation methods to names used by whisper
+var aggregationMethodAliases = map[string]string{
+	"avg":     "average",
+	"mean":    "average",
+	"total":   "sum",
+	"latest":  "last",
+	"maximum": "max",
+	"minimum": "min",
+}
+
+// validAggregationMethods lists names and aliases of aggregation methods for errors
+const validAggregationMethods = "average (avg, mean), sum (total), last (latest), max (maximum), min (minimum), " +
+	"count, median, p<rank> (e.g. p95)"
+
+func sectionName(s *configparser.Section) string {
+	// this is mildly stupid, but I don't feel like forking
+	// configparser just for this
+	return strings.Trim(strings.SplitN(s.String(), "\n", 2)[0], " []")
+}
+
+// counterSectionName returns true if some word of aggregation section name is "count" or "counter" (e.g. [counters],
+// [http_requests_count]). Rollups of counters usually want xFilesFactor 0
+func counterSectionName(name string) bool {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for _, word := range words {
+		switch word {
+		case "count", "counts", "counter", "counters":
+			return true
+		}
+	}
+	return false
+}
+
+// parseAggregationItem sets xFilesFactor and aggregation method of item from section. Values not set in section
+// are inherited from parent
+func parseAggregationItem(s *configparser.Section, item *whisperAggregationItem, parent *whisperAggregationItem) error {
+	var err error
+
+	if s.ValueOf("xFilesFactor") == "" {
+		if item != parent {
+			if parent.xFilesFactorSet {
+				logrus.Debugf("[persister] xFilesFactor is not set for [%s], using %g of [%s]", item.name, parent.xFilesFactor, parent.name)
+			} else {
+				logrus.Warningf("[persister] xFilesFactor is not set for [%s], using %g", item.name, parent.xFilesFactor)
+			}
+			item.xFilesFactor = parent.xFilesFactor
+		}
+	} else {
+		item.xFilesFactor, err = strconv.ParseFloat(s.ValueOf("xFilesFactor"), 64)
+		if err != nil {
+			return fmt.Errorf("[persister] Failed to parse xFilesFactor %#v for [%s]: %s",
+				s.ValueOf("xFilesFactor"), item.name, err.Error())
+		}
+		if item.xFilesFactor < 0 || item.xFilesFactor > 1 {
+			return fmt.Errorf("[persister] xFilesFactor %g for [%s] is out of range [0, 1]",
+				item.xFilesFactor, item.name)
+		}
+		if item == parent {
+			item.xFilesFactorSet = true
+		}
+	}
+
+	method := s.ValueOf("aggregationMethod")
+	if method == "" {
+		item.aggregationMethodStr = parent.aggregationMethodStr
+		item.aggregationMethod = parent.aggregationMethod
+		item.preAggregation = parent.preAggregation
+		return nil
+	}
+
+	// whisper file has single aggregation method for all archives
+	if strings.Contains(method, ",") {
+		return fmt.Errorf("[persister] Per-archive aggregation methods %#v for [%s] are not supported by whisper, use single method",
+			method, item.name)
+	}
+
+	if !item.setMethod(method) {
+		return fmt.Errorf("[persister] Unknown aggregation method %#v for [%s], valid methods: %s",
+			method, item.name, validAggregationMethods)
+	}
+
+	return nil
+}
+
+// setMethod sets native or pre-computed aggregation method by name or alias, case insensitive. Name of method
+// is kept without alias. Returns false for unknown method
+func (item *whisperAggregationItem) setMethod(method string) bool {
+	method = strings.ToLower(strings.TrimSpace(method))
+	if name, ok := aggregationMethodAliases[method]; ok {
+		method = name
+	}
+	item.aggregationMethodStr = method
+	item.preAggregation = nil
+
+	switch method {
+	case "average":
+		item.aggregationMethod = whisper.Average
+	case "sum":
+		item.aggregationMethod = whisper.Sum
+	case "last":
+		item.aggregationMethod = whisper.Last
+	case "max":
+		item.aggregationMethod = whisper.Max
+	case "min":
+		item.aggregationMethod = whisper.Min
+	default:
+		pre, ok := parsePreAggregation(method)
+		if !ok {
+			return false
+		}
+		item.aggregationMethod = pre.native
+		item.preAggregation = pre
+	}
+	return true
+}
+
+// Prepend adds aggregation matched before all sections, e.g. for internal metrics of go-carbon.
+// xFilesFactor of default aggregation is used
+func (a *WhisperAggregation) Prepend(name string, pattern *regexp.Regexp, method string) error {
+	item := &whisperAggregationItem{
+		name:         name,
+		pattern:      pattern,
+		xFilesFactor: a.Default.xFilesFactor,
+	}
+	if !item.setMethod(method) {
+		return fmt.Errorf("unknown aggregation method %#v, valid methods: %s", method, validAggregationMethods)
+	}
+	if item.preAggregation != nil {
+		a.preAggregated = true
+	}
+	item.score = specificity(pattern)
+	for _, d := range a.Data {
+		if d.position <= item.position {
+			item.position = d.position - 1
+		}
+	}
+	a.Data = append([]*whisperAggregationItem{item}, a.Data...)
+	return nil
+}
+
 // Match find schema for metric
 func (a *WhisperAggregation) match(metric string) *whisperAggregationItem {
 	for _, s := range a.Data {
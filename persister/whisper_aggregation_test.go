@@ -0,0 +1,337 @@
+package persister
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lomik/go-carbon/logging"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseAggregation(t *testing.T, content string) (*WhisperAggregation, error) {
+	tmpFile, err := ioutil.TempFile("", "aggregation-")
+	if err != nil {
+		t.Fatal(err)
+		return nil, nil
+	}
+	tmpFile.Write([]byte(content))
+	tmpFile.Close()
+
+	aggr, err := ReadWhisperAggregation(tmpFile.Name())
+
+	if removeErr := os.Remove(tmpFile.Name()); removeErr != nil {
+		t.Fatal(removeErr)
+	}
+
+	return aggr, err
+}
+
+func TestReadWhisperAggregation(t *testing.T) {
+	assert := assert.New(t)
+
+	aggr, err := parseAggregation(t, `
+[min]
+pattern = \.min$
+xFilesFactor = 0.1
+aggregationMethod = min
+
+[default]
+pattern = .*
+xFilesFactor = 0.5
+aggregationMethod = avg
+`)
+
+	if assert.NoError(err) && assert.Len(aggr.Data, 2) {
+		assert.Equal(whisper.Min, aggr.match("foo.min").aggregationMethod)
+		assert.Equal(0.1, aggr.match("foo.min").xFilesFactor)
+		assert.Equal(whisper.Average, aggr.match("foo.bar").aggregationMethod)
+	}
+}
+
+func TestReadWhisperAggregationPerArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := parseAggregation(t, `
+[sum]
+pattern = \.count$
+xFilesFactor = 0
+aggregationMethod = sum,average
+`)
+
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "not supported")
+		assert.Contains(err.Error(), "[sum]")
+	}
+}
+
+func TestReadWhisperAggregationXFilesFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	logging.Test(func(log logging.TestOut) {
+		aggr, err := parseAggregation(t, `
+[sum]
+pattern = \.count$
+xFilesFactor = 0
+aggregationMethod = sum
+
+[max]
+pattern = \.max$
+aggregationMethod = max
+`)
+		if assert.NoError(err) && assert.Len(aggr.Data, 2) {
+			assert.Equal(0.0, aggr.match("foo.count").xFilesFactor)
+			assert.Equal(DefaultXFilesFactor, aggr.match("foo.max").xFilesFactor)
+		}
+		assert.NotContains(log.String(), "xFilesFactor is not set for [sum]")
+		assert.Contains(log.String(), "xFilesFactor is not set for [max]")
+	})
+
+	// keys are case sensitive, there are no aliases of xFilesFactor
+	for _, key := range []string{"xfilesfactor", "XFilesFactor", "xff"} {
+		aggr, err := parseAggregation(t, `
+[sum]
+pattern = \.count$
+`+key+` = 0
+aggregationMethod = sum
+`)
+		if assert.NoError(err, key) && assert.Len(aggr.Data, 1, key) {
+			assert.Equal(DefaultXFilesFactor, aggr.match("foo.count").xFilesFactor, key)
+		}
+	}
+
+	for _, value := range []string{"1.5", "-0.1", "half"} {
+		_, err := parseAggregation(t, `
+[sum]
+pattern = \.count$
+xFilesFactor = `+value+`
+aggregationMethod = sum
+`)
+		assert.Error(err, value)
+	}
+}
+
+func TestReadWhisperAggregationLog(t *testing.T) {
+	assert := assert.New(t)
+
+	content := `
+[default]
+xFilesFactor = 0.2
+
+[sum]
+pattern = \.count$
+aggregationMethod = sum
+
+[max]
+pattern = \.max$
+aggregationMethod = max
+`
+
+	// sections are logged at debug level, summary at info
+	logging.TestWithLevel("info", func(log logging.TestOut) {
+		_, err := parseAggregation(t, content)
+		assert.NoError(err)
+		lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+		if assert.Len(lines, 1) {
+			assert.Contains(lines[0], "Loaded 2 aggregation sections")
+			assert.Contains(lines[0], "xFilesFactor = 0.2")
+		}
+	})
+
+	logging.TestWithLevel("debug", func(log logging.TestOut) {
+		_, err := parseAggregation(t, content)
+		assert.NoError(err)
+		assert.Contains(log.String(), "Adding aggregation [sum] pattern = \\.count$ aggregationMethod = sum xFilesFactor = 0.2")
+		assert.Contains(log.String(), "Adding aggregation [max]")
+	})
+}
+
+func TestReadWhisperAggregationCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, expected := range map[string]bool{
+		"counters": true, "http_requests_count": true, "Counter-Metrics": true,
+		"account": false, "counting": false, "default": false,
+	} {
+		assert.Equal(expected, counterSectionName(name), name)
+	}
+
+	logging.Test(func(log logging.TestOut) {
+		aggr, err := parseAggregation(t, `
+[counters]
+pattern = \.count$
+xFilesFactor = 0
+aggregationMethod = sum
+
+[requests_count]
+pattern = \.requests$
+aggregationMethod = sum
+`)
+		if assert.NoError(err) {
+			// explicit 0 is not replaced by default
+			assert.Equal(0.0, aggr.match("foo.count").xFilesFactor)
+			assert.Equal(DefaultXFilesFactor, aggr.match("foo.requests").xFilesFactor)
+		}
+		assert.NotContains(log.String(), "Aggregation [counters] looks like counters")
+		assert.Contains(log.String(), "Aggregation [requests_count] looks like counters")
+	})
+}
+
+func TestReadWhisperAggregationDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	// [default] without pattern is fallback and inherited by sections above and below
+	aggr, err := parseAggregation(t, `
+[min]
+pattern = \.min$
+aggregationMethod = min
+
+[default]
+xFilesFactor = 0.2
+aggregationMethod = sum
+
+[max]
+pattern = \.max$
+xFilesFactor = 0.9
+aggregationMethod = max
+
+[count]
+pattern = \.count$
+xFilesFactor = 0
+`)
+
+	if assert.NoError(err) && assert.Len(aggr.Data, 3) {
+		assert.Equal(whisper.Min, aggr.match("foo.min").aggregationMethod)
+		assert.Equal(0.2, aggr.match("foo.min").xFilesFactor)
+
+		assert.Equal(whisper.Max, aggr.match("foo.max").aggregationMethod)
+		assert.Equal(0.9, aggr.match("foo.max").xFilesFactor)
+
+		assert.Equal(whisper.Sum, aggr.match("foo.count").aggregationMethod)
+		assert.Equal(0.0, aggr.match("foo.count").xFilesFactor)
+
+		assert.Equal(whisper.Sum, aggr.match("foo.bar").aggregationMethod)
+		assert.Equal(0.2, aggr.match("foo.bar").xFilesFactor)
+		assert.Equal("default", aggr.match("foo.bar").name)
+	}
+}
+
+func TestReadWhisperAggregationOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	// first match wins
+	aggr, err := parseAggregation(t, `
+[all_min]
+pattern = \.min$
+xFilesFactor = 0.1
+aggregationMethod = min
+
+[foo_min]
+pattern = ^foo\.
+xFilesFactor = 0.3
+aggregationMethod = last
+`)
+
+	if assert.NoError(err) {
+		assert.Equal("all_min", aggr.match("foo.min").name)
+		assert.Equal("foo_min", aggr.match("foo.last").name)
+		assert.Equal("default", aggr.match("bar.last").name)
+		assert.Equal(whisper.Average, aggr.match("bar.last").aggregationMethod)
+		assert.Equal(DefaultXFilesFactor, aggr.match("bar.last").xFilesFactor)
+	}
+}
+
+func TestReadWhisperAggregationDefaultInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, content := range map[string]string{
+		"duplicate": `
+[default]
+aggregationMethod = sum
+
+[default]
+aggregationMethod = max
+`,
+		"method": `
+[default]
+aggregationMethod = unknown
+`,
+		"xFilesFactor": `
+[default]
+xFilesFactor = 2
+`,
+	} {
+		_, err := parseAggregation(t, content)
+		if assert.Error(err, name) {
+			assert.Contains(err.Error(), "[default]", name)
+		}
+	}
+}
+
+func TestReadWhisperAggregationAliases(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		method   string
+		expected whisper.AggregationMethod
+		name     string
+	}{
+		{"average", whisper.Average, "average"},
+		{"avg", whisper.Average, "average"},
+		{"mean", whisper.Average, "average"},
+		{"AVG", whisper.Average, "average"},
+		{"sum", whisper.Sum, "sum"},
+		{"total", whisper.Sum, "sum"},
+		{"last", whisper.Last, "last"},
+		{"latest", whisper.Last, "last"},
+		{"max", whisper.Max, "max"},
+		{"maximum", whisper.Max, "max"},
+		{"Max", whisper.Max, "max"},
+		{"min", whisper.Min, "min"},
+		{"minimum", whisper.Min, "min"},
+		{"count", whisper.Sum, "count"},
+		{"median", whisper.Max, "median"},
+		{"p50", whisper.Max, "p50"},
+	}
+
+	for _, c := range table {
+		aggr, err := parseAggregation(t, `
+[section]
+pattern = .*
+aggregationMethod = `+c.method+`
+`)
+		if assert.NoError(err, c.method) && assert.Len(aggr.Data, 1, c.method) {
+			assert.Equal(c.expected, aggr.Data[0].aggregationMethod, c.method)
+			assert.Equal(c.name, aggr.Data[0].aggregationMethodStr, c.method)
+		}
+	}
+}
+
+func TestReadWhisperAggregationUnknown(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, method := range []string{"uknown", "p50,avg", "p101", "averages"} {
+		_, err := parseAggregation(t, `
+[section]
+pattern = .*
+aggregationMethod = `+method+`
+`)
+		if assert.Error(err, method) {
+			assert.Contains(err.Error(), "[section]", method)
+		}
+	}
+
+	_, err := parseAggregation(t, `
+[section]
+pattern = .*
+aggregationMethod = agv
+`)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), `"agv"`)
+		assert.Contains(err.Error(), "average (avg, mean)")
+	}
+
+	assert.Error(NewWhisperAggregation().Prepend("carbon", nil, "agv"))
+}
@@ -0,0 +1,101 @@
+package persister
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// TimestampAlign defines snapping of point timestamps to interval of the most precise archive of whisper file
+type TimestampAlign int
+
+const (
+	// AlignOff writes timestamps as received, whisper puts point into slot of interval containing it
+	AlignOff TimestampAlign = iota
+	// AlignDown snaps timestamp to start of its interval: 10:00:07 of 10s archive is 10:00:00
+	AlignDown
+	// AlignNearest snaps timestamp to the nearest interval boundary: 10:00:03 is 10:00:00, 10:00:07 is 10:00:10
+	AlignNearest
+)
+
+// ParseTimestampAlign parses alignment name: "off" (or ""), "down" or "nearest"
+func ParseTimestampAlign(s string) (TimestampAlign, error) {
+	switch s {
+	case "", "off":
+		return AlignOff, nil
+	case "down":
+		return AlignDown, nil
+	case "nearest":
+		return AlignNearest, nil
+	default:
+		return AlignOff, fmt.Errorf("Unknown timestamp align '%s', should be one of: off, down, nearest", s)
+	}
+}
+
+// SetTimestampAlign sets snapping of timestamps before update to interval of the most precise archive of file
+// (first retention of schema for new files), e.g. for jittery agents. Points snapped to the same timestamp are
+// collapsed by dedup policy, samples of pre-aggregated metrics are aggregated. Values: "off" (default), "down",
+// "nearest". Snapped points are counted by persister.timestampsAligned metric
+func (p *Whisper) SetTimestampAlign(s string) error {
+	align, err := ParseTimestampAlign(s)
+	if err != nil {
+		return err
+	}
+	p.timestampAlign = align
+	return nil
+}
+
+// alignTimestamps returns data with timestamps snapped to step. If dedup is true points with equal timestamps
+// are collapsed. Source slice is not modified because it is still visible for carbonlink until confirmed
+func (p *Whisper) alignTimestamps(data []points.Point, step int, dedup bool) []points.Point {
+	if p.timestampAlign == AlignOff || step <= 1 {
+		return data
+	}
+
+	var result []points.Point
+	aligned := 0
+	for i, d := range data {
+		t := alignTimestamp(d.Timestamp, int64(step), p.timestampAlign)
+		if t == d.Timestamp {
+			if result != nil {
+				result = append(result, d)
+			}
+			continue
+		}
+		if result == nil {
+			result = make([]points.Point, i, len(data))
+			copy(result, data[:i])
+		}
+		result = append(result, points.Point{Value: d.Value, Timestamp: t})
+		aligned++
+	}
+	if result == nil {
+		return data
+	}
+
+	atomic.AddUint32(&p.timestampsAligned, uint32(aligned))
+	if dedup {
+		return (&points.Points{Data: result}).Dedup(p.dedupPolicy).Data
+	}
+	return result
+}
+
+func alignTimestamp(timestamp int64, step int64, align TimestampAlign) int64 {
+	if align == AlignNearest {
+		timestamp += step / 2
+	}
+	// floor for negative timestamps too
+	rem := timestamp % step
+	if rem < 0 {
+		rem += step
+	}
+	return timestamp - rem
+}
+
+func (p *Whisper) alignStat(send helper.StatCallback) {
+	if p.timestampAlign != AlignOff {
+		helper.SendAndSubstractUint32("timestampsAligned", &p.timestampsAligned, send)
+	}
+}
@@ -0,0 +1,99 @@
+package persister
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlignTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		timestamp int64
+		align     TimestampAlign
+		expected  int64
+	}{
+		{1003, AlignDown, 1000},
+		{1007, AlignDown, 1000},
+		{1010, AlignDown, 1010},
+		{1003, AlignNearest, 1000},
+		{1005, AlignNearest, 1010},
+		{1007, AlignNearest, 1010},
+		{-3, AlignDown, -10},
+	} {
+		assert.Equal(c.expected, alignTimestamp(c.timestamp, 10, c.align), fmt.Sprintf("%#v", c))
+	}
+
+	for s, expected := range map[string]TimestampAlign{"": AlignOff, "off": AlignOff, "down": AlignDown, "nearest": AlignNearest} {
+		align, err := ParseTimestampAlign(s)
+		assert.NoError(err)
+		assert.Equal(expected, align)
+	}
+	_, err := ParseTimestampAlign("up")
+	assert.Error(err)
+}
+
+func TestTimestampAlign(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("10s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "10s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		base := time.Now().Unix()/10*10 - 100
+
+		fetch := func(metric string) map[int]float64 {
+			result := make(map[int]float64)
+			w, err := whisper.Open(filepath.Join(root, metric+".wsp"))
+			if !assert.NoError(err) {
+				return result
+			}
+			defer w.Close()
+			series, err := w.Fetch(int(base-10), int(base+20))
+			if !assert.NoError(err) {
+				return result
+			}
+			for _, point := range series.Points() {
+				if point.Value == point.Value {
+					result[point.Time] = point.Value
+				}
+			}
+			return result
+		}
+
+		received := points.OnePoint("off", 1, base+3).Add(2, base+7)
+		assert.NoError(store(p, received))
+		assert.Equal(map[int]float64{int(base): 2}, fetch("off"))
+
+		assert.NoError(p.SetTimestampAlign("nearest"))
+		received = points.OnePoint("nearest", 1, base+3).Add(2, base+7)
+		assert.NoError(store(p, received))
+		assert.Equal(map[int]float64{int(base): 1, int(base + 10): 2}, fetch("nearest"))
+		// received values are not modified
+		assert.Equal(base+7, received.Data[1].Timestamp)
+
+		assert.NoError(p.SetTimestampAlign("down"))
+		p.SetDedupPolicy(points.DedupSum)
+		assert.NoError(store(p, points.OnePoint("down", 1, base+3).Add(2, base+7)))
+		assert.Equal(map[int]float64{int(base): 3}, fetch("down"))
+
+		aligned := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			aligned[metric] = value
+		})
+		assert.Equal(float64(4), aligned["timestampsAligned"])
+
+		assert.Error(p.SetTimestampAlign("up"))
+	})
+}
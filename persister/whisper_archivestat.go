@@ -0,0 +1,82 @@
+package persister
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// maxArchiveStats is count of archives with own counter, points of lower archives are counted by the last one
+const maxArchiveStats = 8
+
+// archiveStats counts written points by archive of whisper file they fall into
+type archiveStats struct {
+	points  [maxArchiveStats]uint32 // counters
+	expired uint32                  // counter, older than max retention of file
+}
+
+// SetArchiveStats enables counting of written points by archive they fall into: persister.archivePoints.N
+// (0 - the most precise archive) and persister.archivePoints.expired for points older than retention of file,
+// which are dropped by whisper. Shows clients sending mostly old points, they are written with rollups of all
+// lower archives
+func (p *Whisper) SetArchiveStats(enabled bool) {
+	p.archiveStatsEnabled = enabled
+}
+
+// countArchives classifies points by archive of retentions at now. Archive of point is the first archive
+// with max retention covering age of point, like in whisper UpdateMany
+func (s *archiveStats) countArchives(data []points.Point, retentions []whisper.Retention, now int64) {
+	var counts [maxArchiveStats + 1]uint32
+	n := len(retentions)
+	for _, d := range data {
+		age := int(now - d.Timestamp)
+		i := sort.Search(n, func(i int) bool { return age <= retentions[i].MaxRetention() })
+		switch {
+		case i == n:
+			counts[maxArchiveStats]++
+		case i >= maxArchiveStats:
+			counts[maxArchiveStats-1]++
+		default:
+			counts[i]++
+		}
+	}
+
+	for i := 0; i < maxArchiveStats; i++ {
+		if counts[i] > 0 {
+			atomic.AddUint32(&s.points[i], counts[i])
+		}
+	}
+	if counts[maxArchiveStats] > 0 {
+		atomic.AddUint32(&s.expired, counts[maxArchiveStats])
+	}
+}
+
+// archiveStat sends counters of archives up to max count of archives in storage schemas and expired points
+func (p *Whisper) archiveStat(send helper.StatCallback) {
+	if !p.archiveStatsEnabled {
+		return
+	}
+
+	archives := 1
+	for _, schema := range p.loadStorageConfig().schemas {
+		if len(schema.Retentions) > archives {
+			archives = len(schema.Retentions)
+		}
+	}
+	if archives > maxArchiveStats {
+		archives = maxArchiveStats
+	}
+
+	for i := 0; i < maxArchiveStats; i++ {
+		// lower archives of files created by previous schemas are sent if not empty
+		if i < archives || atomic.LoadUint32(&p.archiveStats.points[i]) > 0 {
+			helper.SendAndSubstractUint32(fmt.Sprintf("archivePoints.%d", i), &p.archiveStats.points[i], send)
+		}
+	}
+	helper.SendAndSubstractUint32("archivePoints.expired", &p.archiveStats.expired, send)
+}
@@ -0,0 +1,82 @@
+package persister
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountArchives(t *testing.T) {
+	assert := assert.New(t)
+
+	// 1h, 1d, 30d
+	retentions := []whisper.Retention{
+		whisper.NewRetention(60, 60),
+		whisper.NewRetention(3600, 24),
+		whisper.NewRetention(86400, 30),
+	}
+	const now = 1500000000
+
+	var s archiveStats
+	s.countArchives([]points.Point{
+		{Timestamp: now},
+		{Timestamp: now - 3600},
+		{Timestamp: now - 3601},
+		{Timestamp: now - 86400},
+		{Timestamp: now - 86401},
+		{Timestamp: now - 30*86400},
+		{Timestamp: now - 30*86400 - 1},
+		{Timestamp: now + 60},
+	}, retentions, now)
+
+	assert.Equal([maxArchiveStats]uint32{3, 2, 2}, s.points)
+	assert.Equal(uint32(1), s.expired)
+
+	// lower archives are counted by the last counter
+	many := make([]whisper.Retention, maxArchiveStats+2)
+	for i := range many {
+		many[i] = whisper.NewRetention(1, 10*(i+1))
+	}
+	s = archiveStats{}
+	s.countArchives([]points.Point{{Timestamp: now - 15}, {Timestamp: now - 85}, {Timestamp: now - 95}}, many, now)
+	assert.Equal(uint32(1), s.points[1])
+	assert.Equal(uint32(2), s.points[maxArchiveStats-1])
+}
+
+func TestArchiveStats(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h,1h:1d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h,1h:1d", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetArchiveStats(true)
+
+		now := time.Now().Unix()
+		assert.NoError(store(p, points.OnePoint("a.b", 1, now)))
+		assert.NoError(store(p, &points.Points{Metric: "a.b", Data: []points.Point{
+			{Value: 2, Timestamp: now - 60},
+			{Value: 3, Timestamp: now - 7200},
+			{Value: 4, Timestamp: now - 2*86400},
+		}}))
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(2), stat["archivePoints.0"])
+		assert.Equal(float64(1), stat["archivePoints.1"])
+		assert.Equal(float64(1), stat["archivePoints.expired"])
+		_, ok := stat["archivePoints.2"]
+		assert.False(ok)
+	})
+}
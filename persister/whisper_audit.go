@@ -0,0 +1,65 @@
+package persister
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lomik/go-carbon/logging"
+)
+
+// auditOptions are options of audit log set by SetAuditLog
+type auditOptions struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	backups  int
+	compress bool
+}
+
+// SetAuditLog enables audit log of created whisper files in path, separate from main log: line per file with
+// metric, path, matched schema, retention, aggregation method and xFilesFactor. Log is rotated by maxSize
+// (bytes) or maxAge like main log (0 - disabled) and reopened by SIGHUP. Opened by Start. "" - disabled
+func (p *Whisper) SetAuditLog(path string, maxSize int64, maxAge time.Duration, backups int, compress bool) {
+	p.auditOptions = auditOptions{
+		path:     path,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		backups:  backups,
+		compress: compress,
+	}
+}
+
+// auditLog writes entries with formatter of main log to own rotated file
+type auditLog struct {
+	writer     *logging.RotateWriter
+	logger     *logrus.Logger
+	stopReopen func()
+}
+
+func openAuditLog(o auditOptions) (*auditLog, error) {
+	w, err := logging.NewRotateWriter(o.path, o.maxSize, o.maxAge, o.backups)
+	if err != nil {
+		return nil, err
+	}
+	w.SetCompress(o.compress)
+
+	logger := logrus.New()
+	logger.Out = w
+	logger.Formatter = logrus.StandardLogger().Formatter
+
+	return &auditLog{
+		writer:     w,
+		logger:     logger,
+		stopReopen: logging.ReopenOnHUP(w),
+	}, nil
+}
+
+func (a *auditLog) created(path string, fields logrus.Fields) {
+	a.logger.WithFields(fields).WithField("path", path).Info("created")
+}
+
+func (a *auditLog) close() error {
+	a.stopReopen()
+	return a.writer.Close()
+}
@@ -0,0 +1,55 @@
+package persister
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "hourly", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		auditPath := filepath.Join(root, "audit.log")
+		in := make(chan *points.Points, 10)
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, nil)
+		p.SetAuditLog(auditPath, 0, 0, 0, false)
+		assert.NoError(p.Start())
+
+		now := time.Now().Unix()
+		assert.NoError(store(p, points.OnePoint("a.b", 1, now)))
+		// existing file
+		assert.NoError(store(p, points.OnePoint("a.b", 2, now)))
+		p.Stop()
+
+		b, err := ioutil.ReadFile(auditPath)
+		assert.NoError(err)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if assert.Len(lines, 1) {
+			assert.Contains(lines[0], "created")
+			assert.Contains(lines[0], `metric="a.b"`)
+			assert.Contains(lines[0], `schema="hourly"`)
+			assert.Contains(lines[0], `retention="60s:1h"`)
+			assert.Contains(lines[0], `method="average"`)
+			assert.Contains(lines[0], "xFilesFactor=0.5")
+			assert.Contains(lines[0], filepath.Join(root, "a", "b.wsp"))
+		}
+
+		// not writable path
+		p = NewWhisper(root, schemas, NewWhisperAggregation(), in, nil)
+		p.SetAuditLog(filepath.Join(root, "missing", "audit.log"), 0, 0, 0, false)
+		assert.Error(p.Start())
+	})
+}
@@ -0,0 +1,108 @@
+package persister
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetBatchDedup enables drop of values identical to values of the same metric written during window, e.g.
+// batches retransmitted by client on reconnect (persister.dedupDropped metric). Values are compared by hash of
+// metric, timestamps and values of all points, unlike "dedup" option collapsing points with the same timestamp
+// inside of one update. Up to size hashes of recently written values are kept (LRU). size <= 0 or window <= 0 - disabled
+func (p *Whisper) SetBatchDedup(size int, window time.Duration) {
+	if size <= 0 || window <= 0 {
+		p.batchDedup = nil
+		return
+	}
+	p.batchDedup = &batchDedup{
+		size:   size,
+		window: window,
+		ll:     list.New(),
+		items:  make(map[uint64]*list.Element),
+	}
+}
+
+// batchDedup is LRU cache of hashes of written values shared by workers
+type batchDedup struct {
+	sync.Mutex
+	size    int
+	window  time.Duration
+	ll      *list.List
+	items   map[uint64]*list.Element
+	dropped uint32 // counter
+}
+
+type batchDedupItem struct {
+	key     uint64
+	written time.Time
+}
+
+// batchKey returns hash of metric and points of values
+func batchKey(values *points.Points) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(values.Metric))
+	h.Write([]byte{0})
+
+	var b [16]byte
+	for _, d := range values.Data {
+		binary.BigEndian.PutUint64(b[:8], uint64(d.Timestamp))
+		binary.BigEndian.PutUint64(b[8:], math.Float64bits(d.Value))
+		h.Write(b[:])
+	}
+	return h.Sum64()
+}
+
+// seen returns true if values with key were written during window before now
+func (c *batchDedup) seen(key uint64, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	if now.Sub(e.Value.(*batchDedupItem).written) > c.window {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return false
+	}
+	c.dropped++
+	return true
+}
+
+// written adds key of written values. Least recently written key is removed if cache is full
+func (c *batchDedup) written(key uint64, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*batchDedupItem).written = now
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&batchDedupItem{key: key, written: now})
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*batchDedupItem).key)
+	}
+}
+
+func (p *Whisper) batchDedupStat(send helper.StatCallback) {
+	if p.batchDedup == nil {
+		return
+	}
+	p.batchDedup.Lock()
+	dropped := p.batchDedup.dropped
+	p.batchDedup.dropped = 0
+	p.batchDedup.Unlock()
+	send("dedupDropped", float64(dropped))
+}
@@ -0,0 +1,88 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.nowFunc = func() time.Time { return now }
+	p.SetBatchDedup(2, time.Minute)
+
+	stat := func() float64 {
+		var dropped float64
+		p.Stat(func(metric string, value float64) {
+			if metric == "dedupDropped" {
+				dropped = value
+			}
+		})
+		return dropped
+	}
+
+	ts := now.Unix()
+	batch := func(metric string, value float64) *points.Points {
+		return points.OnePoint(metric, value, ts).Add(value+1, ts+1)
+	}
+
+	var stored int
+	for _, values := range []*points.Points{
+		batch("a", 1),
+		batch("a", 1), // retransmission
+		batch("a", 2), // other values
+		batch("b", 1), // other metric
+	} {
+		if p.batchDedup.seen(batchKey(values), now) {
+			continue
+		}
+		stored++
+		p.batchDedup.written(batchKey(values), now)
+	}
+	assert.Equal(3, stored)
+	assert.Equal(float64(1), stat())
+	assert.Equal(float64(0), stat())
+
+	// LRU: "a 1" is removed by "a 2" and "b 1"
+	assert.False(p.batchDedup.seen(batchKey(batch("a", 1)), now))
+	assert.True(p.batchDedup.seen(batchKey(batch("b", 1)), now))
+
+	// expired
+	assert.False(p.batchDedup.seen(batchKey(batch("b", 1)), now.Add(2*time.Minute)))
+	assert.Len(p.batchDedup.items, 1)
+
+	p.SetBatchDedup(0, time.Minute)
+	assert.Nil(p.batchDedup)
+}
+
+func TestBatchDedupStore(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.SetBatchDedup(100, time.Minute)
+
+	committed := func() float64 {
+		var committed float64
+		p.Stat(func(metric string, value float64) {
+			if metric == "committedPoints" {
+				committed = value
+			}
+		})
+		return committed
+	}
+
+	now := time.Now().Unix()
+	assert.NoError(store(p, points.OnePoint("a", 1, now).Add(2, now+1)))
+	assert.NoError(store(p, points.OnePoint("a", 1, now).Add(2, now+1)))
+	assert.Equal(float64(2), committed())
+
+	assert.NoError(store(p, points.OnePoint("a", 1, now).Add(3, now+1)))
+	assert.Equal(float64(2), committed())
+}
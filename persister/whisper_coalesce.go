@@ -0,0 +1,88 @@
+package persister
+
+import (
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetFlushInterval enables merging of values of the same metric received during interval into single update.
+// 0 - write values immediately
+func (p *Whisper) SetFlushInterval(d time.Duration) {
+	p.flushInterval = d
+}
+
+// SetFlushMaxPoints limits count of points buffered by each worker during flush interval. Buffer is flushed
+// before interval end when limit is reached. 0 - unlimited
+func (p *Whisper) SetFlushMaxPoints(n int) {
+	p.flushMaxPoints = n
+}
+
+// coalescer buffers values by metric until flush. Not thread safe, used inside one worker
+type coalescer struct {
+	pending map[string][]*points.Points
+	order   []string // metrics in order of first arrival
+	points  int      // count of buffered points
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		pending: make(map[string][]*points.Points),
+	}
+}
+
+func (c *coalescer) add(values *points.Points) {
+	queued, exists := c.pending[values.Metric]
+	if !exists {
+		c.order = append(c.order, values.Metric)
+	}
+	c.pending[values.Metric] = append(queued, values)
+	c.points += len(values.Data)
+}
+
+// merged appends merged values of each buffered metric to b
+func (c *coalescer) merged(b batch, policy points.DedupPolicy) batch {
+	for _, metric := range c.order {
+		b = append(b, mergePoints(c.pending[metric], policy))
+	}
+	return b
+}
+
+// queued returns buffered values of metric in order of arrival
+func (c *coalescer) queued(metric string) []*points.Points {
+	return c.pending[metric]
+}
+
+func (c *coalescer) reset() {
+	c.pending = make(map[string][]*points.Points)
+	c.order = c.order[:0]
+	c.points = 0
+}
+
+func (c *coalescer) len() int {
+	return len(c.order)
+}
+
+// mergePoints joins data of values of one metric sorted by timestamp. Duplicate timestamps are
+// collapsed by policy in order of receiving. Source values are not modified because they are still
+// visible for carbonlink until confirmed
+func mergePoints(queued []*points.Points, policy points.DedupPolicy) *points.Points {
+	if len(queued) == 1 {
+		return queued[0].Dedup(policy)
+	}
+
+	size := 0
+	for _, values := range queued {
+		size += len(values.Data)
+	}
+
+	merged := &points.Points{
+		Metric: queued[0].Metric,
+		Data:   make([]points.Point, 0, size),
+	}
+	for _, values := range queued {
+		merged.Data = append(merged.Data, values.Data...)
+	}
+
+	return merged.Dedup(policy)
+}
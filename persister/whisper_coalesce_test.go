@@ -0,0 +1,199 @@
+package persister
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePoints(t *testing.T) {
+	assert := assert.New(t)
+
+	a := points.OnePoint("metric", 1, 20)
+	a.Add(2, 10)
+	b := points.OnePoint("metric", 3, 30)
+	b.Add(4, 10)
+	c := points.OnePoint("metric", 5, 20)
+
+	merged := mergePoints([]*points.Points{a, b, c}, points.DedupLast)
+	assert.Equal("metric", merged.Metric)
+	assert.Equal([]points.Point{
+		points.Point{Value: 4, Timestamp: 10},
+		points.Point{Value: 5, Timestamp: 20},
+		points.Point{Value: 3, Timestamp: 30},
+	}, merged.Data)
+
+	// sources are not modified
+	assert.Equal(points.OnePoint("metric", 1, 20).Add(2, 10), a)
+
+	single := points.OnePoint("metric", 1, 10)
+	assert.True(mergePoints([]*points.Points{single}, points.DedupLast) == single)
+	assert.Equal(points.OnePoint("metric", 6, 10).Add(1, 20).Add(3, 30), mergePoints([]*points.Points{a, b}, points.DedupSum))
+}
+
+// recordStore records stored values, first throttled ones returns errCreateThrottled
+type recordStore struct {
+	sync.Mutex
+	stored    []*points.Points
+	throttled int
+}
+
+func (s *recordStore) Store(values *points.Points) error {
+	s.Lock()
+	defer s.Unlock()
+	s.stored = append(s.stored, values)
+	if s.throttled > 0 {
+		s.throttled--
+		return errCreateThrottled
+	}
+	return nil
+}
+
+func (s *recordStore) len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.stored)
+}
+
+func TestFlushInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	s := &recordStore{}
+	p := NewPersister(s, in, confirm)
+	p.SetFlushInterval(time.Hour)
+
+	in <- points.OnePoint("a", 1, 10)
+	in <- points.OnePoint("b", 2, 10)
+	in <- points.OnePoint("a", 3, 20)
+	in <- points.OnePoint("a", 4, 10)
+	close(in)
+
+	// flushes on close of in
+	p.worker(in, make(chan bool), in)
+
+	if assert.Len(s.stored, 2) {
+		assert.Equal(points.OnePoint("a", 4, 10).Add(3, 20), s.stored[0])
+		assert.Equal(points.OnePoint("b", 2, 10), s.stored[1])
+	}
+
+	// all coalesced values confirmed with merged one
+	assert.Len(confirm, 5)
+}
+
+func TestFlushMaxPoints(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	s := &recordStore{}
+	p := NewPersister(s, in, confirm)
+	p.SetFlushInterval(time.Hour)
+	p.SetFlushMaxPoints(3)
+
+	in <- points.OnePoint("a", 1, 20).Add(2, 10)
+	in <- points.OnePoint("b", 3, 10)
+	// flushed by overflow
+	in <- points.OnePoint("a", 4, 30)
+	close(in)
+
+	p.worker(in, make(chan bool), in)
+
+	if assert.Len(s.stored, 3) {
+		assert.Equal(points.OnePoint("a", 2, 10).Add(1, 20), s.stored[0])
+		assert.Equal(points.OnePoint("b", 3, 10), s.stored[1])
+		assert.Equal(points.OnePoint("a", 4, 30), s.stored[2])
+	}
+	// sorted copy of first "a" is confirmed with original
+	assert.Len(confirm, 4)
+}
+
+func TestFlushThrottled(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	s := &recordStore{throttled: 1}
+	p := NewPersister(s, in, confirm)
+	p.SetFlushInterval(time.Hour)
+	p.SetFlushMaxPoints(2)
+	p.SetMaxCreatesPerSecond(1)
+
+	done := make(chan bool)
+	go func() {
+		p.worker(in, make(chan bool), nil)
+		close(done)
+	}()
+
+	in <- points.OnePoint("a", 1, 10)
+	in <- points.OnePoint("a", 2, 20)
+	for s.len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// merged values are kept for retry of create, received ones are not confirmed
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(confirm, 0)
+
+	// retried on stop
+	close(in)
+	<-done
+	assert.Equal(2, s.len())
+	assert.Len(confirm, 3)
+}
+
+func benchmarkFlushInterval(b *testing.B, flushInterval time.Duration) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	retentions, _ := ParseRetentionDefs("1s:1d")
+	schemas := WhisperSchemas{
+		Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1d", Retentions: retentions},
+	}
+
+	const metrics = 10
+	const pointsPerMetric = 10
+
+	now := time.Now().Unix()
+	var updates uint64
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		in := make(chan *points.Points, metrics*pointsPerMetric)
+		for i := 0; i < pointsPerMetric; i++ {
+			for j := 0; j < metrics; j++ {
+				in <- points.OnePoint(fmt.Sprintf("metric%d", j), float64(i), now-int64(i))
+			}
+		}
+		close(in)
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, nil)
+		p.SetFlushInterval(flushInterval)
+		p.worker(in, make(chan bool), in)
+		updates += p.updateOperations.load()
+	}
+
+	b.Logf("updates per %d points: %d", metrics*pointsPerMetric, updates/uint64(b.N))
+}
+
+func BenchmarkFlushIntervalDisabled(b *testing.B) {
+	benchmarkFlushInterval(b, 0)
+}
+
+func BenchmarkFlushInterval(b *testing.B) {
+	benchmarkFlushInterval(b, time.Second)
+}
@@ -0,0 +1,45 @@
+package persister
+
+import (
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+)
+
+// counter is monotonic total, it is never reset. Stat sends delta since previous stat for compatibility
+// and total, so rate can be derived downstream and nothing is lost if sending of one stat fails
+type counter struct {
+	total uint64 // changing via atomic
+	sent  uint64 // total at previous stat, changing via atomic
+}
+
+func (c *counter) add(n int) {
+	atomic.AddUint64(&c.total, uint64(n))
+}
+
+func (c *counter) load() uint64 {
+	return atomic.LoadUint64(&c.total)
+}
+
+// delta returns increase of total since previous call
+func (c *counter) delta() uint64 {
+	total := atomic.LoadUint64(&c.total)
+	return total - atomic.SwapUint64(&c.sent, total)
+}
+
+// countersStat sends committedPoints, updateOperations and pointsPerUpdate of the last interval and their totals
+func (p *Whisper) countersStat(send helper.StatCallback) {
+	updateOperations := p.updateOperations.delta()
+	committedPoints := p.committedPoints.delta()
+
+	send("updateOperations", float64(updateOperations))
+	send("committedPoints", float64(committedPoints))
+	if updateOperations > 0 {
+		send("pointsPerUpdate", float64(committedPoints)/float64(updateOperations))
+	} else {
+		send("pointsPerUpdate", 0.0)
+	}
+
+	send("updateOperationsTotal", float64(p.updateOperations.load()))
+	send("committedPointsTotal", float64(p.committedPoints.load()))
+}
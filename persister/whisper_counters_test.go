@@ -0,0 +1,104 @@
+package persister
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountersConcurrent(t *testing.T) {
+	const writers = 8
+	const adds = 100000
+
+	tests := []struct {
+		name     string
+		add      func(p *Whisper)
+		stat     func(p *Whisper, send helper.StatCallback)
+		expected map[string]float64
+	}{
+		{
+			name: "counters",
+			add: func(p *Whisper) {
+				p.committedPoints.add(3)
+				p.updateOperations.add(1)
+			},
+			stat: (*Whisper).countersStat,
+			expected: map[string]float64{
+				"committedPoints":  writers * adds * 3,
+				"updateOperations": writers * adds,
+			},
+		},
+		{
+			name: "stat",
+			add: func(p *Whisper) {
+				atomic.AddUint32(&p.created, 1)
+				atomic.AddUint32(&p.dropped, 1)
+			},
+			stat: (*Whisper).Stat,
+			expected: map[string]float64{
+				"created": writers * adds,
+				"dropped": writers * adds,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < adds; j++ {
+						test.add(p)
+					}
+				}()
+			}
+
+			written := make(chan bool)
+			go func() {
+				wg.Wait()
+				close(written)
+			}()
+
+			sums := make(map[string]float64)
+			stat := func() {
+				test.stat(p, func(metric string, value float64) {
+					if _, ok := test.expected[metric]; ok {
+						sums[metric] += value
+					}
+				})
+			}
+
+			for running := true; running; {
+				select {
+				case <-written:
+					running = false
+				default:
+					stat()
+				}
+			}
+			stat()
+
+			// sum of deltas is not less than written by concurrent writers
+			assert.Equal(test.expected, sums)
+
+			// deltas are sent once
+			stat()
+			assert.Equal(test.expected, sums)
+		})
+	}
+
+	// totals of counters are not reset by stat
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	p.committedPoints.add(3)
+	p.countersStat(func(string, float64) {})
+	assert.Equal(t, uint64(3), p.committedPoints.load())
+}
@@ -0,0 +1,82 @@
+package persister
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+var errCreateThrottled = errors.New("creation of whisper file throttled")
+
+const (
+	// points of throttled creates are retried during this time
+	createRetryTimeout = time.Minute
+	// max count of throttled values kept by worker
+	createRetryMaxPending = 100000
+)
+
+// SetMaxCreatesPerSecond limits creation of new whisper files. Updates of existing files are not limited.
+// Points of throttled metrics are kept by worker and retried for a minute. 0 - unlimited
+func (p *Whisper) SetMaxCreatesPerSecond(maxCreatesPerSecond int) {
+	p.maxCreatesPerSecond = maxCreatesPerSecond
+	p.createLimiter = &rateLimiter{rate: maxCreatesPerSecond}
+}
+
+type pendingCreate struct {
+	values *points.Points
+	since  time.Time
+}
+
+// pendingCreates keeps values of metrics with throttled creation. Not thread safe, one instance per worker
+type pendingCreates struct {
+	items []pendingCreate
+	max   int
+}
+
+func newPendingCreates(max int) *pendingCreates {
+	return &pendingCreates{max: max}
+}
+
+// add returns false if buffer is full
+func (c *pendingCreates) add(values *points.Points, since time.Time) bool {
+	if len(c.items) >= c.max {
+		return false
+	}
+	c.items = append(c.items, pendingCreate{values: values, since: since})
+	return true
+}
+
+// takeAll removes and returns pending values. Nil for nil pendingCreates
+func (c *pendingCreates) takeAll() []pendingCreate {
+	if c == nil {
+		return nil
+	}
+	items := c.items
+	c.items = nil
+	return items
+}
+
+func (c *pendingCreates) len() int {
+	return len(c.items)
+}
+
+// retry passes pending values to store in order of receiving with time of their first throttled create.
+// Store adds values again if create is throttled and they are younger than timeout. Retry stops at the first
+// denial of limiter, so files of the rest values are not touched: they are passed to skip
+func (c *pendingCreates) retry(limiter *rateLimiter, store func(values *points.Points, since time.Time), skip func(values *points.Points, since time.Time)) {
+	items := c.items
+	c.items = nil
+
+	denied := false
+	for _, item := range items {
+		if !denied && !limiter.available() {
+			denied = true
+		}
+		if denied {
+			skip(item.values, item.since)
+		} else {
+			store(item.values, item.since)
+		}
+	}
+}
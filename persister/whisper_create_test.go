@@ -0,0 +1,247 @@
+package persister
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingCreates(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newPendingCreates(2)
+	now := time.Now()
+
+	a := points.OnePoint("a", 1, 10)
+	b := points.OnePoint("b", 1, 10)
+	assert.True(c.add(a, now.Add(-time.Hour)))
+	assert.True(c.add(b, now))
+	assert.False(c.add(points.OnePoint("c", 1, 10), now))
+
+	type retried struct {
+		values *points.Points
+		since  time.Time
+	}
+	var stored, skipped []retried
+	store := func(v *points.Points, since time.Time) {
+		stored = append(stored, retried{v, since})
+	}
+	skip := func(v *points.Points, since time.Time) {
+		skipped = append(skipped, retried{v, since})
+	}
+	c.retry(nil, store, skip)
+
+	// in order of receiving with time of first throttle
+	assert.Equal([]retried{{a, now.Add(-time.Hour)}, {b, now}}, stored)
+	assert.Len(skipped, 0)
+	assert.Equal(0, c.len())
+
+	// stopped at the first denial of limiter
+	limiter := &rateLimiter{rate: 1}
+	c.add(a, now)
+	c.add(b, now)
+	stored = nil
+	c.retry(limiter, func(v *points.Points, since time.Time) {
+		store(v, since)
+		limiter.allow()
+	}, skip)
+	assert.Equal([]retried{{a, now}}, stored)
+	assert.Equal([]retried{{b, now}}, skipped)
+}
+
+func TestMaxCreatesPerSecond(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetMaxCreatesPerSecond(2)
+		p.createLimiter.second = time.Now().Unix() + 10 // freeze second
+
+		now := time.Now().Unix()
+		for i := 0; i < 5; i++ {
+			in <- points.OnePoint(fmt.Sprintf("metric%d", i), 1, now)
+		}
+		// updates of existing files are not throttled
+		in <- points.OnePoint("metric0", 2, now-1)
+		close(in)
+
+		p.worker(in, make(chan bool), nil)
+
+		created := 0
+		for i := 0; i < 5; i++ {
+			if _, err := os.Stat(filepath.Join(root, fmt.Sprintf("metric%d.wsp", i))); err == nil {
+				created++
+			}
+		}
+		assert.Equal(2, created)
+
+		// throttled values are confirmed on exit
+		assert.Len(confirm, 6)
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		// counted on receive only, not on retry
+		assert.Equal(float64(3), stat["createThrottled"])
+		assert.Equal(float64(2), stat["created"])
+		assert.Equal(float64(3), stat["updateOperations"])
+	})
+}
+
+// openCountingOpener counts opens of whisper files on disk
+type openCountingOpener struct {
+	osCreateOpener
+	opens *int
+}
+
+func (co openCountingOpener) Open(path string) (WhisperFile, error) {
+	*co.opens++
+	return co.osCreateOpener.Open(path)
+}
+
+func TestRetryPendingDenied(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		var opens int
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetCreateOpener(openCountingOpener{opens: &opens})
+		p.SetMaxCreatesPerSecond(1)
+
+		now := time.Now().Unix()
+		in <- points.OnePoint("a", 1, now)
+		in <- points.OnePoint("b", 1, now)
+		close(in)
+
+		p.worker(in, make(chan bool), nil)
+
+		// open before and under lock of create, b is not opened again by retry on exit
+		assert.Equal(4, opens)
+		assert.Len(confirm, 2)
+	})
+}
+
+func TestCreateCounterXFilesFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	aggr, err := parseAggregation(t, `
+[counters]
+pattern = \.count$
+xFilesFactor = 0
+aggregationMethod = sum
+
+[default]
+xFilesFactor = 0.5
+aggregationMethod = average
+`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h,1m:1d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h,1m:1d", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, aggr, nil, nil)
+		now := time.Now().Unix()
+		assert.NoError(store(p, points.OnePoint("app.requests.count", 1, now)))
+		assert.NoError(store(p, points.OnePoint("app.requests.rate", 1, now)))
+
+		for metric, xff := range map[string]float32{"app.requests.count": 0, "app.requests.rate": 0.5} {
+			w, err := whisper.Open(filepath.Join(root, "app", "requests", metric[len("app.requests."):]+".wsp"))
+			if assert.NoError(err, metric) {
+				assert.Equal(xff, w.XFilesFactor(), metric)
+				w.Close()
+			}
+		}
+	})
+}
+
+func TestThrottledCreateError(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetCreateOpener(deniedCreateOpener{})
+		p.SetMaxCreatesPerSecond(1)
+		p.createLimiter.second = time.Now().Unix() + 10 // freeze second
+
+		failed := make(chan string, 10)
+		p.SetErrorHandler(func(metric string, err error) {
+			failed <- metric
+		})
+
+		now := time.Now().Unix()
+		in <- points.OnePoint("a", 1, now)
+		in <- points.OnePoint("b", 1, now)
+
+		done := make(chan bool)
+		go func() {
+			p.worker(in, make(chan bool), nil)
+			close(done)
+		}()
+
+		// b is throttled
+		assert.Equal("a", <-failed)
+		for len(in) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// failed retry of b on stop is handled like failed store
+		p.createLimiter.Lock()
+		p.createLimiter.second = 0
+		p.createLimiter.Unlock()
+		close(in)
+		<-done
+
+		select {
+		case metric := <-failed:
+			assert.Equal("b", metric)
+		default:
+			t.Fatal("error of retry is not handled")
+		}
+		assert.Len(confirm, 2)
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(2), stat["storeErrors.create"])
+		assert.Equal(float64(1), stat["createThrottled"])
+	})
+}
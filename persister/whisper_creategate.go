@@ -0,0 +1,137 @@
+package persister
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+const (
+	// createGateMaxPending is limit of metrics waiting for create threshold, points of other new metrics are dropped
+	createGateMaxPending = 100000
+	// createGateMaxPoints is limit of buffered points of one pending metric, later points are dropped
+	createGateMaxPoints = 64
+)
+
+// SetCreateThreshold delays create of whisper file until its metric is stored count times within window after the
+// first store, e.g. to skip transient clients spraying unique names. Points of pending metric are buffered in memory
+// (up to 64 per metric, 100000 metrics) and written to file on create. Metrics not reaching count within window are
+// discarded with their points (persister.createDiscarded metric). count <= 1 or window <= 0 - disabled
+func (p *Whisper) SetCreateThreshold(count int, window time.Duration) {
+	if count <= 1 || window <= 0 {
+		p.createGate = nil
+		return
+	}
+	p.createGate = &createGate{
+		count:   count,
+		window:  window,
+		pending: make(map[string]*pendingMetric),
+	}
+}
+
+// createGate counts stores of metrics without whisper file
+type createGate struct {
+	sync.Mutex
+	count     int
+	window    time.Duration
+	pending   map[string]*pendingMetric
+	discarded int // since last expire
+}
+
+// pendingMetric is metric waiting for create threshold
+type pendingMetric struct {
+	first time.Time
+	seen  int
+	data  []points.Point
+}
+
+// pass counts store of metric. Returns true with buffered points and data if file of metric can be created,
+// otherwise data is buffered. Metric is pending until created, so buffered points are kept if create is throttled.
+// Source slice is not modified because it is still visible for carbonlink until confirmed
+func (g *createGate) pass(metric string, data []points.Point, now time.Time) ([]points.Point, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	m := g.pending[metric]
+	if m != nil && now.Sub(m.first) > g.window {
+		delete(g.pending, metric)
+		g.discarded++
+		m = nil
+	}
+	if m == nil {
+		if len(g.pending) >= createGateMaxPending {
+			g.discarded++
+			return nil, false
+		}
+		m = &pendingMetric{first: now}
+		g.pending[metric] = m
+	}
+
+	m.seen++
+	if m.seen >= g.count {
+		if len(m.data) == 0 {
+			return data, true
+		}
+		result := make([]points.Point, 0, len(m.data)+len(data))
+		return append(append(result, m.data...), data...), true
+	}
+
+	if room := createGateMaxPoints - len(m.data); room > 0 {
+		if len(data) > room {
+			data = data[:room]
+		}
+		m.data = append(m.data, data...)
+	}
+	return nil, false
+}
+
+// created removes pending metric
+func (g *createGate) created(metric string) {
+	g.Lock()
+	delete(g.pending, metric)
+	g.Unlock()
+}
+
+// expire removes metrics not reached count within window. Returns count of discarded metrics since last call
+// and count of pending ones
+func (g *createGate) expire(now time.Time) (int, int) {
+	g.Lock()
+	defer g.Unlock()
+
+	for metric, m := range g.pending {
+		if now.Sub(m.first) > g.window {
+			delete(g.pending, metric)
+			g.discarded++
+		}
+	}
+
+	discarded := g.discarded
+	g.discarded = 0
+	return discarded, len(g.pending)
+}
+
+// passCreate returns true with points for new file of metric if create is not delayed by create threshold
+func (p *Whisper) passCreate(metric string, data []points.Point) ([]points.Point, bool) {
+	if p.createGate == nil {
+		return data, true
+	}
+	return p.createGate.pass(metric, data, p.now())
+}
+
+// trackCreateGate removes created metric from pending ones if create threshold is enabled
+func (p *Whisper) trackCreateGate(metric string) {
+	if p.createGate != nil {
+		p.createGate.created(metric)
+	}
+}
+
+func (p *Whisper) createGateStat(send helper.StatCallback) {
+	if p.createGate == nil {
+		return
+	}
+	discarded, pending := p.createGate.expire(p.now())
+	send("createPending", float64(pending))
+	send("createDiscarded", float64(discarded))
+}
@@ -0,0 +1,113 @@
+package persister
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateThreshold(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		now := time.Now()
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.nowFunc = func() time.Time { return now }
+		p.SetCreateThreshold(3, time.Minute)
+
+		stat := func() map[string]float64 {
+			result := make(map[string]float64)
+			p.Stat(func(metric string, value float64) {
+				result[metric] = value
+			})
+			return result
+		}
+		exists := func(metric string) bool {
+			_, err := os.Stat(filepath.Join(root, metric+".wsp"))
+			return err == nil
+		}
+
+		ts := now.Unix()
+		for i := 0; i < 2; i++ {
+			assert.NoError(store(p, points.OnePoint("seen", float64(i), ts-10+int64(i))))
+		}
+		assert.NoError(store(p, points.OnePoint("once", 1, ts)))
+		assert.False(exists("seen"))
+		assert.False(exists("once"))
+
+		s := stat()
+		assert.Equal(float64(2), s["createPending"])
+		assert.Equal(float64(0), s["createDiscarded"])
+
+		// buffered points are written on create
+		assert.NoError(store(p, points.OnePoint("seen", 2, ts)))
+		if assert.True(exists("seen")) {
+			w, err := whisper.Open(filepath.Join(root, "seen.wsp"))
+			if assert.NoError(err) {
+				series, err := w.Fetch(int(ts-20), int(ts))
+				if assert.NoError(err) {
+					var values []float64
+					for _, v := range series.Values() {
+						if v == v {
+							values = append(values, v)
+						}
+					}
+					assert.Equal([]float64{0, 1, 2}, values)
+				}
+				w.Close()
+			}
+		}
+
+		// existing file is not gated
+		assert.NoError(store(p, points.OnePoint("seen", 3, ts)))
+		assert.Equal(float64(1), stat()["createPending"])
+
+		// not reached threshold within window
+		now = now.Add(2 * time.Minute)
+		s = stat()
+		assert.Equal(float64(0), s["createPending"])
+		assert.Equal(float64(1), s["createDiscarded"])
+		assert.Equal(float64(0), stat()["createDiscarded"])
+		assert.False(exists("once"))
+
+		// disabled
+		p.SetCreateThreshold(1, time.Minute)
+		assert.NoError(store(p, points.OnePoint("once", 1, now.Unix())))
+		assert.True(exists("once"))
+		_, ok := stat()["createPending"]
+		assert.False(ok)
+	})
+}
+
+func TestCreateGateLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	g := &createGate{count: 2, window: time.Minute, pending: make(map[string]*pendingMetric)}
+	now := time.Now()
+
+	data := make([]points.Point, createGateMaxPoints+10)
+	_, ok := g.pass("a", data, now)
+	assert.False(ok)
+	assert.Len(g.pending["a"].data, createGateMaxPoints)
+
+	// kept until created
+	merged, ok := g.pass("a", []points.Point{{Value: 1}}, now)
+	assert.True(ok)
+	assert.Len(merged, createGateMaxPoints+1)
+	_, ok = g.pass("a", nil, now)
+	assert.True(ok)
+	g.created("a")
+	assert.Len(g.pending, 0)
+}
@@ -0,0 +1,118 @@
+package persister
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetCreateRetry enables retry of whisper file creation failed with transient error (too many open files).
+// No space left on device is error of filesystem handled by degraded mode. Values are kept by worker and stored again up to retries times with backoff doubled
+// after every attempt, then failure is counted and values are dropped. Input of worker is not blocked by
+// retries. 0 - disabled
+func (p *Whisper) SetCreateRetry(retries int, backoff time.Duration) {
+	p.createRetries = retries
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	p.createRetryBackoff = backoff
+}
+
+// isTransientCreateError returns true if create of file or its directory failed with error which can pass
+// without intervention
+func isTransientCreateError(err error) bool {
+	e, ok := err.(*StoreError)
+	if !ok || e.Op != StoreOpCreate {
+		return false
+	}
+
+	switch syscallCause(e.cause) {
+	case syscall.EMFILE, syscall.ENFILE, syscall.EAGAIN:
+		return true
+	}
+	return false
+}
+
+type createRetry struct {
+	values  *points.Points
+	attempt int // count of failed retries
+	next    time.Time
+}
+
+// createRetries keeps values of metrics with failed creation until next attempt. Not thread safe, one instance
+// per worker
+type createRetries struct {
+	items   []createRetry
+	max     int
+	backoff time.Duration
+}
+
+func newCreateRetries(max int, backoff time.Duration) *createRetries {
+	return &createRetries{max: max, backoff: backoff}
+}
+
+// add schedules next attempt after backoff * 2^attempt. Returns false if buffer is full
+func (c *createRetries) add(values *points.Points, attempt int, now time.Time) bool {
+	if len(c.items) >= c.max {
+		return false
+	}
+	c.items = append(c.items, createRetry{
+		values:  values,
+		attempt: attempt,
+		next:    now.Add(c.backoff << uint(attempt)),
+	})
+	return true
+}
+
+// restore adds item of other worker with its attempt and time. Returns false if buffer is full
+func (c *createRetries) restore(item createRetry) bool {
+	if len(c.items) >= c.max {
+		return false
+	}
+	c.items = append(c.items, item)
+	return true
+}
+
+// takeAll removes and returns scheduled values. Nil for nil createRetries
+func (c *createRetries) takeAll() []createRetry {
+	if c == nil {
+		return nil
+	}
+	items := c.items
+	c.items = nil
+	return items
+}
+
+func (c *createRetries) len() int {
+	return len(c.items)
+}
+
+// retry passes values with attempt time before now to store in order of failure. Store may add them again
+func (c *createRetries) retry(store func(values *points.Points, attempt int), now time.Time) {
+	items := c.items
+	c.items = nil
+
+	var due []createRetry
+	for _, item := range items {
+		if now.Before(item.next) {
+			c.items = append(c.items, item)
+		} else {
+			due = append(due, item)
+		}
+	}
+
+	for _, item := range due {
+		store(item.values, item.attempt+1)
+	}
+}
+
+// flush passes all values to store without waiting for backoff
+func (c *createRetries) flush(store func(values *points.Points)) {
+	items := c.items
+	c.items = nil
+
+	for _, item := range items {
+		store(item.values)
+	}
+}
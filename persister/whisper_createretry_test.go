@@ -0,0 +1,161 @@
+package persister
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyCreateOpener fails first failures creates of every path with err
+type flakyCreateOpener struct {
+	sync.Mutex
+	osCreateOpener
+	failures int
+	err      error
+	attempts map[string]int
+}
+
+func (co *flakyCreateOpener) Open(path string) (WhisperFile, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: syscall.ENOENT}
+}
+
+func (co *flakyCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	co.Lock()
+	defer co.Unlock()
+	co.attempts[path]++
+	if co.attempts[path] <= co.failures {
+		return nil, &os.PathError{Op: "open", Path: path, Err: co.err}
+	}
+	return nopFile{}, nil
+}
+
+func (co *flakyCreateOpener) count(path string) int {
+	co.Lock()
+	defer co.Unlock()
+	return co.attempts[path]
+}
+
+func TestIsTransientCreateError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isTransientCreateError(&StoreError{Op: StoreOpCreate, cause: &os.PathError{Err: syscall.EMFILE}}))
+	assert.True(isTransientCreateError(&StoreError{Op: StoreOpCreate, cause: &os.LinkError{Err: syscall.EAGAIN}}))
+	assert.True(isTransientCreateError(&StoreError{Op: StoreOpCreate, cause: syscall.ENFILE}))
+	// error of filesystem, retried by degraded mode
+	assert.False(isTransientCreateError(&StoreError{Op: StoreOpCreate, cause: &os.PathError{Err: syscall.ENOSPC}}))
+	assert.False(isTransientCreateError(&StoreError{Op: StoreOpCreate, cause: &os.PathError{Err: syscall.EACCES}}))
+	assert.False(isTransientCreateError(&StoreError{Op: StoreOpUpdate, cause: syscall.EMFILE}))
+	assert.False(isTransientCreateError(&StoreError{Op: StoreOpCreate}))
+	assert.False(isTransientCreateError(errCreateThrottled))
+	assert.False(isTransientCreateError(nil))
+}
+
+func TestCreateRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newCreateRetries(2, time.Second)
+	now := time.Now()
+
+	a := points.OnePoint("a", 1, 10)
+	b := points.OnePoint("b", 1, 10)
+	assert.True(c.add(a, 0, now))
+	assert.True(c.add(b, 2, now))
+	assert.False(c.add(points.OnePoint("c", 1, 10), 0, now))
+
+	var stored []string
+	store := func(values *points.Points, attempt int) {
+		stored = append(stored, fmt.Sprintf("%s:%d", values.Metric, attempt))
+	}
+
+	c.retry(store, now)
+	assert.Empty(stored)
+
+	// backoff of b is 4s
+	c.retry(store, now.Add(time.Second))
+	assert.Equal([]string{"a:1"}, stored)
+	assert.Equal(1, c.len())
+
+	c.flush(func(values *points.Points) { store(values, 0) })
+	assert.Equal([]string{"a:1", "b:0"}, stored)
+	assert.Equal(0, c.len())
+}
+
+func TestCreateRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+		exit := make(chan bool)
+
+		co := &flakyCreateOpener{failures: 2, err: syscall.EMFILE, attempts: make(map[string]int)}
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetCreateOpener(co)
+		p.SetCreateRetry(3, 10*time.Millisecond)
+
+		done := make(chan bool)
+		go func() {
+			p.worker(in, exit, nil)
+			close(done)
+		}()
+
+		now := time.Now().Unix()
+		in <- points.OnePoint("retried", 1, now)
+
+		select {
+		case v := <-confirm:
+			assert.Equal("retried", v.Metric)
+		case <-time.After(time.Second):
+			t.Fatal("not confirmed")
+		}
+		close(exit)
+		<-done
+		assert.Equal(3, co.count(filepath.Join(root, "retried.wsp")))
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(2), stat["createRetries"])
+		assert.Equal(float64(0), stat["storeErrors.create"])
+
+		// not waiting for backoff on exit, values failed again are dropped
+		in = make(chan *points.Points, 10)
+		co = &flakyCreateOpener{failures: 10, err: syscall.ENFILE, attempts: make(map[string]int)}
+		p = NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetCreateOpener(co)
+		p.SetCreateRetry(3, time.Hour)
+
+		for i := 0; i < 5; i++ {
+			in <- points.OnePoint(fmt.Sprintf("failed%d", i), 1, now)
+		}
+		close(in)
+		p.worker(in, make(chan bool), nil)
+
+		assert.Len(confirm, 5)
+		for i := 0; i < 5; i++ {
+			assert.Equal(2, co.count(filepath.Join(root, fmt.Sprintf("failed%d.wsp", i))))
+		}
+
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(5), stat["createRetries"])
+		assert.Equal(float64(5), stat["storeErrors.create"])
+	})
+}
@@ -0,0 +1,117 @@
+package persister
+
+import (
+	"hash/fnv"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+)
+
+// dataDir is one of data dirs set by SetDataDirs
+type dataDir struct {
+	path    string
+	weight  float64
+	name    string // for stats: path with "_" instead of separators
+	updates uint32 // counter
+}
+
+// SetDataDirs spreads whisper files by several data dirs (e.g. mount points of disks) instead of root path.
+// Dir of metric is selected by weighted rendezvous hash of name: metric always lands on the same dir, share
+// of metrics is proportional to weight (e.g. capacity of disk), new dir takes metrics only from other dirs
+// proportionally. Updates of each dir are reported by persister.dataDirUpdates.<dir> metrics. Empty - root path is used
+func (p *Whisper) SetDataDirs(dirs map[string]int) {
+	p.dataDirs = nil
+	for path, weight := range dirs {
+		if weight <= 0 {
+			continue
+		}
+		path = filepath.Clean(path)
+		p.dataDirs = append(p.dataDirs, &dataDir{
+			path:   path,
+			weight: float64(weight),
+			name:   strings.Trim(strings.Replace(path, string(filepath.Separator), "_", -1), "_"),
+		})
+	}
+	sort.Sort(byDataDirPath(p.dataDirs))
+}
+
+type byDataDirPath []*dataDir
+
+func (v byDataDirPath) Len() int           { return len(v) }
+func (v byDataDirPath) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byDataDirPath) Less(i, j int) bool { return v[i].path < v[j].path }
+
+// dataDirOf returns data dir of metric with the highest weighted score -weight/ln(hash). nil if data dirs are not set
+func (p *Whisper) dataDirOf(metric string) *dataDir {
+	if len(p.dataDirs) == 0 {
+		return nil
+	}
+	if len(p.dataDirs) == 1 {
+		return p.dataDirs[0]
+	}
+
+	var best *dataDir
+	var bestScore float64
+	for _, d := range p.dataDirs {
+		h := fnv.New64a()
+		h.Write([]byte(d.path))
+		h.Write([]byte{0})
+		h.Write([]byte(metric))
+		// uniform in (0, 1)
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		score := -d.weight / math.Log(u)
+		if best == nil || score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+	return best
+}
+
+// mix64 is finalizer of murmur3: last bytes of fnv input change only low and middle bits of hash
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// metricPath returns path of whisper file of metric and its data dir (nil if data dirs are not set)
+func (p *Whisper) metricPath(metric string) (string, *dataDir, error) {
+	root := p.root()
+	d := p.dataDirOf(metric)
+	if d != nil {
+		root = d.path
+	}
+	path, err := p.pathEncoder.Path(root, metric)
+	return path, d, err
+}
+
+// dataDirPaths returns all dirs with whisper files
+func (p *Whisper) dataDirPaths() []string {
+	if len(p.dataDirs) == 0 {
+		return []string{filepath.Clean(p.root())}
+	}
+	paths := make([]string, len(p.dataDirs))
+	for i, d := range p.dataDirs {
+		paths[i] = d.path
+	}
+	return paths
+}
+
+func (p *Whisper) dataDirsStat(send helper.StatCallback) {
+	for _, d := range p.dataDirs {
+		helper.SendAndSubstractUint32("dataDirUpdates."+d.name, &d.updates, send)
+	}
+}
+
+func (d *dataDir) updated() {
+	if d != nil {
+		atomic.AddUint32(&d.updates, 1)
+	}
+}
@@ -0,0 +1,101 @@
+package persister
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataDirOf(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("/data", nil, nil, nil, nil)
+	assert.Nil(p.dataDirOf("metric"))
+	assert.Equal([]string{"/data"}, p.dataDirPaths())
+
+	p.SetDataDirs(map[string]int{"/disk1/": 1, "/disk2": 3, "/disk3": 0})
+	assert.Equal([]string{"/disk1", "/disk2"}, p.dataDirPaths())
+	assert.Equal("disk1", p.dataDirs[0].name)
+
+	const metrics = 10000
+	placement := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < metrics; i++ {
+		metric := fmt.Sprintf("metric%d", i)
+		d := p.dataDirOf(metric)
+		placement[metric] = d.path
+		count[d.path]++
+	}
+
+	// proportional to weight
+	assert.InDelta(metrics/4, count["/disk1"], metrics/20)
+	assert.InDelta(metrics*3/4, count["/disk2"], metrics/20)
+
+	// consistent: new dir takes metrics from others only
+	p.SetDataDirs(map[string]int{"/disk1": 1, "/disk2": 3, "/disk3": 4})
+	moved := 0
+	for metric, path := range placement {
+		if d := p.dataDirOf(metric); d.path != path {
+			assert.Equal("/disk3", d.path)
+			moved++
+		}
+	}
+	assert.InDelta(metrics/2, moved, metrics/20)
+}
+
+func TestDataDirs(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		disk1 := filepath.Join(root, "disk1")
+		disk2 := filepath.Join(root, "disk2")
+
+		p := NewWhisper(filepath.Join(root, "unused"), schemas, NewWhisperAggregation(), nil, nil)
+		p.SetDataDirs(map[string]int{disk1: 1, disk2: 1})
+
+		now := time.Now().Unix()
+		for i := 0; i < 20; i++ {
+			metric := fmt.Sprintf("metric%d", i)
+			store(p, points.OnePoint(metric, float64(i), now))
+
+			path := filepath.Join(p.dataDirOf(metric).path, metric+".wsp")
+			_, err := os.Stat(path)
+			assert.NoError(err, path)
+
+			// read from the same dir
+			data, err := p.Query(metric, int(now-10), int(now))
+			if assert.NoError(err) {
+				assert.Equal([]points.Point{{Value: float64(i), Timestamp: now}}, data)
+			}
+		}
+
+		_, err := os.Stat(filepath.Join(root, "unused"))
+		assert.True(os.IsNotExist(err))
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		name1 := p.dataDirs[0].name
+		name2 := p.dataDirs[1].name
+		assert.Equal(float64(20), stat["dataDirUpdates."+name1]+stat["dataDirUpdates."+name2])
+		assert.True(stat["dataDirUpdates."+name1] > 0)
+		assert.True(stat["dataDirUpdates."+name2] > 0)
+
+		// disk usage of all dirs
+		assert.True(p.scanDiskUsage(make(chan bool)))
+		assert.Equal(int64(20), p.fileCount)
+	})
+}
@@ -0,0 +1,99 @@
+package persister
+
+import (
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// store of values is retried with backoff from degradedRetryMin (field of Whisper) to degradedRetryMax while
+// persister is degraded
+const degradedRetryMax = time.Minute
+
+// degradedRetryAttempts limits retries of one batch, then values are dropped and worker takes next batch
+const degradedRetryAttempts = 10
+
+// SetDegradedThreshold sets count of consecutive errors of filesystem (read-only, no space left, I/O error on create
+// or update) after which persister becomes degraded: workers stop reading new points and retry failed store with
+// exponential backoff until write succeeds, up to 10 attempts per batch. Errors of single file (open of corrupt
+// file, permission denied) are not retried. 0 - disabled, points with write errors are dropped
+func (p *Whisper) SetDegradedThreshold(writeErrors int) {
+	p.degradedThreshold = writeErrors
+}
+
+// Degraded returns true if persister can't write to disk
+func (p *Whisper) Degraded() bool {
+	return atomic.LoadUint32(&p.degraded) != 0
+}
+
+// isFilesystemError returns true if create or update of file failed with error of whole filesystem:
+// read-only, no space left or I/O error
+func isFilesystemError(err error) bool {
+	e, ok := err.(*StoreError)
+	if !ok || (e.Op != StoreOpCreate && e.Op != StoreOpUpdate) {
+		return false
+	}
+	switch syscallCause(e.cause) {
+	case syscall.EROFS, syscall.ENOSPC, syscall.EIO:
+		return true
+	}
+	return false
+}
+
+// writeFailed counts consecutive write error. Returns true if persister is degraded
+func (p *Whisper) writeFailed(err error) bool {
+	atomic.AddUint32(&p.writeErrors, 1)
+	n := atomic.AddUint32(&p.consecutiveWriteErrors, 1)
+
+	if p.degradedThreshold <= 0 || int(n) < p.degradedThreshold {
+		p.log.Errorf("[persister] %s", err.Error())
+		return false
+	}
+
+	if atomic.CompareAndSwapUint32(&p.degraded, 0, 1) {
+		logrus.Errorf("[persister] %d consecutive write errors, persister is degraded, last error: %s", n, err.Error())
+	}
+	return true
+}
+
+// writeSucceeded resets count of consecutive write errors and degraded state
+func (p *Whisper) writeSucceeded() {
+	if atomic.LoadUint32(&p.consecutiveWriteErrors) != 0 {
+		atomic.StoreUint32(&p.consecutiveWriteErrors, 0)
+	}
+	if atomic.CompareAndSwapUint32(&p.degraded, 1, 0) {
+		logrus.Info("[persister] Write succeeded, persister recovered from degraded state")
+	}
+}
+
+// retryDegraded calls store with exponential backoff while it returns error of filesystem, up to
+// degradedRetryAttempts times. Returns false if interrupted by exit
+func (p *Whisper) retryDegraded(store func() error, exit chan bool) (bool, error) {
+	backoff := p.degradedRetryMin
+	var err error
+	for attempt := 1; attempt <= degradedRetryAttempts; attempt++ {
+		select {
+		case <-exit:
+			return false, nil
+		case <-time.After(backoff):
+		}
+
+		err = store()
+		if !isFilesystemError(err) {
+			return true, err
+		}
+		atomic.AddUint32(&p.writeErrors, 1)
+
+		backoff *= 2
+		if backoff > degradedRetryMax {
+			backoff = degradedRetryMax
+		}
+		if attempt < degradedRetryAttempts {
+			logrus.Errorf("[persister] Degraded, next retry in %s: %s", backoff.String(), err.Error())
+		}
+	}
+	logrus.Errorf("[persister] Degraded, values dropped after %d retries: %s", degradedRetryAttempts, err.Error())
+	return true, err
+}
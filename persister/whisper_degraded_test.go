@@ -0,0 +1,159 @@
+package persister
+
+import (
+	"os"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+// readOnlyCreateOpener fails to create files until writable is set
+type readOnlyCreateOpener struct {
+	osCreateOpener
+	writable chan bool
+	created  int
+}
+
+func (co *readOnlyCreateOpener) Open(path string) (WhisperFile, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
+
+func (co *readOnlyCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	select {
+	case <-co.writable:
+		co.created++
+		return nopFile{}, nil
+	default:
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EROFS}
+	}
+}
+
+func TestDegraded(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		co := &readOnlyCreateOpener{writable: make(chan bool)}
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetCreateOpener(co)
+		p.SetDegradedThreshold(2)
+		p.degradedRetryMin = 10 * time.Millisecond
+
+		now := time.Now().Unix()
+		in <- points.OnePoint("a", 1, now)
+		in <- points.OnePoint("b", 1, now)
+		in <- points.OnePoint("c", 1, now)
+
+		p.Start()
+		defer p.Stop()
+
+		// first write error drops values, on second persister is degraded and retries
+		select {
+		case <-confirm:
+		case <-time.After(time.Second):
+			t.Fatal("not confirmed")
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for !p.Degraded() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.True(p.Degraded())
+		assert.Equal(float64(1), p.Load())
+		assert.Len(confirm, 0)
+
+		// disk is writable again
+		close(co.writable)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-confirm:
+			case <-time.After(time.Second):
+				t.Fatal("not confirmed")
+			}
+		}
+		assert.False(p.Degraded())
+		assert.Equal(2, co.created)
+	})
+}
+
+// deniedCreateOpener fails to create any file with permission denied
+type deniedCreateOpener struct {
+	osCreateOpener
+}
+
+func (deniedCreateOpener) Open(path string) (WhisperFile, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
+
+func (deniedCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EACCES}
+}
+
+func TestIsFilesystemError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isFilesystemError(&StoreError{Op: StoreOpCreate, cause: &os.PathError{Err: syscall.EROFS}}))
+	assert.True(isFilesystemError(&StoreError{Op: StoreOpUpdate, cause: syscall.ENOSPC}))
+	assert.True(isFilesystemError(&StoreError{Op: StoreOpUpdate, cause: &os.PathError{Err: syscall.EIO}}))
+	assert.False(isFilesystemError(&StoreError{Op: StoreOpCreate, cause: &os.PathError{Err: syscall.EACCES}}))
+	assert.False(isFilesystemError(&StoreError{Op: StoreOpOpen, cause: syscall.EIO}))
+	assert.False(isFilesystemError(&StoreError{Op: StoreOpUpdate}))
+	assert.False(isFilesystemError(nil))
+}
+
+func TestDegradedFileError(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetCreateOpener(deniedCreateOpener{})
+		p.SetDegradedThreshold(1)
+
+		now := time.Now().Unix()
+		for _, metric := range []string{"a", "b", "c"} {
+			in <- points.OnePoint(metric, 1, now)
+		}
+
+		p.Start()
+		defer p.Stop()
+
+		// errors of single files are counted and values are dropped without retry
+		for i := 0; i < 3; i++ {
+			select {
+			case <-confirm:
+			case <-time.After(500 * time.Millisecond):
+				t.Fatal("not confirmed")
+			}
+		}
+		assert.False(p.Degraded())
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(3), stat["writeErrors"])
+		assert.Equal(float64(3), stat["storeErrors.create"])
+	})
+}
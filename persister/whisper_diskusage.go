@@ -0,0 +1,104 @@
+package persister
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// walk of data dir sleeps diskUsagePause after every diskUsageBatch files to avoid hammering the disk
+const diskUsageBatch = 1000
+const diskUsagePause = 10 * time.Millisecond
+
+var errDiskUsageInterrupted = errors.New("interrupted")
+
+// SetDiskUsageScan enables periodic count of whisper files and their size in data dir
+// (persister.fileCount and persister.diskUsedBytes metrics). 0 interval - disabled.
+// Directories deeper than maxDepth relative to data dir are not scanned. 0 maxDepth - unlimited
+func (p *Whisper) SetDiskUsageScan(interval time.Duration, maxDepth int) {
+	p.diskUsageInterval = interval
+	p.diskUsageMaxDepth = maxDepth
+}
+
+func (p *Whisper) diskUsageScanner(exit chan bool) {
+	ticker := time.NewTicker(p.diskUsageInterval)
+	defer ticker.Stop()
+
+	for {
+		if !p.scanDiskUsage(exit) {
+			return
+		}
+
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanDiskUsage walks data dirs and stores results. Returns false if interrupted by exit
+func (p *Whisper) scanDiskUsage(exit chan bool) bool {
+	var files, size, walked int64
+	start := time.Now()
+
+	for _, root := range p.dataDirPaths() {
+		err := p.scanDir(root, exit, &files, &size, &walked)
+
+		if err == errDiskUsageInterrupted {
+			return false
+		}
+
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("[persister] Disk usage scan of %s failed: %s", root, err.Error())
+			return true
+		}
+	}
+
+	atomic.StoreInt64(&p.fileCount, files)
+	atomic.StoreInt64(&p.diskUsedBytes, size)
+
+	logrus.Debugf("[persister] Disk usage scan: %d files, %d bytes in %s", files, size, time.Since(start).String())
+	return true
+}
+
+// scanDir adds count and size of whisper files in root to files and size
+func (p *Whisper) scanDir(root string, exit chan bool, files, size, walked *int64) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// file removed during walk or permission denied, skip it
+			if path == root {
+				return err
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if p.diskUsageMaxDepth > 0 && path != root &&
+				strings.Count(path[len(root):], string(os.PathSeparator)) > p.diskUsageMaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(path, ".wsp") {
+			*files++
+			*size += info.Size()
+		}
+
+		*walked++
+		if *walked%diskUsageBatch == 0 {
+			select {
+			case <-exit:
+				return errDiskUsageInterrupted
+			case <-time.After(diskUsagePause):
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package persister
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanDiskUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		files := map[string]int{
+			"a.wsp":         10,
+			"b/c.wsp":       20,
+			"b/d/e.wsp":     30,
+			"b/d/e.wsp.tmp": 40,
+		}
+		for name, size := range files {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p := NewWhisper(root, nil, nil, nil, nil)
+		p.SetDiskUsageScan(time.Minute, 0)
+		assert.True(p.scanDiskUsage(make(chan bool)))
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(3), stat["fileCount"])
+		assert.Equal(float64(60), stat["diskUsedBytes"])
+
+		p.SetDiskUsageScan(time.Minute, 1)
+		assert.True(p.scanDiskUsage(make(chan bool)))
+		assert.Equal(int64(2), p.fileCount)
+		assert.Equal(int64(30), p.diskUsedBytes)
+
+		// missing data dir
+		p = NewWhisper(filepath.Join(root, "missing"), nil, nil, nil, nil)
+		assert.True(p.scanDiskUsage(make(chan bool)))
+		assert.Equal(int64(0), p.fileCount)
+	})
+}
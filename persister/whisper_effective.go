@@ -0,0 +1,87 @@
+package persister
+
+// EffectiveConfig is live settings of persister: storage config replaced by SetStorageConfig (e.g. on SIGHUP)
+// and workers count changed by Resize are reported as they are used now
+type EffectiveConfig struct {
+	RootPath            string                 `json:"rootPath"` // resolved by ResolveRoot
+	Workers             int                    `json:"workers"`
+	Pools               map[string]int         `json:"pools,omitempty"`
+	MaxUpdatesPerSecond int                    `json:"maxUpdatesPerSecond"`
+	MaxCreatesPerSecond int                    `json:"maxCreatesPerSecond"`
+	Schemas             []EffectiveSchema      `json:"schemas"`     // in order of match by priority
+	Aggregation         []EffectiveAggregation `json:"aggregation"` // in order of match, fallback is the last
+}
+
+// EffectiveSchema is storage schema of EffectiveConfig
+type EffectiveSchema struct {
+	Name              string `json:"name"`
+	Pattern           string `json:"pattern"`
+	Retentions        string `json:"retentions"`
+	Pool              string `json:"pool,omitempty"`
+	MirrorRoot        string `json:"mirrorRoot,omitempty"`
+	MirrorRetentions  string `json:"mirrorRetentions,omitempty"`
+	MirrorAggregation string `json:"mirrorAggregation,omitempty"`
+}
+
+// EffectiveAggregation is aggregation section of EffectiveConfig
+type EffectiveAggregation struct {
+	Name              string  `json:"name"`
+	Pattern           string  `json:"pattern"` // "" for fallback
+	AggregationMethod string  `json:"aggregationMethod"`
+	XFilesFactor      float64 `json:"xFilesFactor"`
+}
+
+// EffectiveConfig returns settings used by persister now
+func (p *Whisper) EffectiveConfig() *EffectiveConfig {
+	p.RLock()
+	workers := p.workersCount
+	p.RUnlock()
+
+	c := &EffectiveConfig{
+		RootPath:            p.root(),
+		Workers:             workers,
+		Pools:               p.pools,
+		MaxUpdatesPerSecond: p.maxUpdatesPerSecond,
+		MaxCreatesPerSecond: p.maxCreatesPerSecond,
+		Schemas:             []EffectiveSchema{},
+		Aggregation:         []EffectiveAggregation{},
+	}
+
+	storage := p.loadStorageConfig()
+	for _, schema := range storage.schemas {
+		s := EffectiveSchema{
+			Name:       schema.Name,
+			Retentions: schema.RetentionStr,
+			Pool:       schema.Pool,
+		}
+		if schema.Pattern != nil {
+			s.Pattern = schema.Pattern.String()
+		}
+		if schema.Mirror != nil {
+			s.MirrorRoot = schema.Mirror.Root
+			s.MirrorRetentions = schema.Mirror.RetentionStr
+			s.MirrorAggregation = schema.Mirror.AggregationMethod
+		}
+		c.Schemas = append(c.Schemas, s)
+	}
+
+	if storage.aggregation != nil {
+		items := append([]*whisperAggregationItem{}, storage.aggregation.Data...)
+		if storage.aggregation.Default != nil {
+			items = append(items, storage.aggregation.Default)
+		}
+		for _, item := range items {
+			a := EffectiveAggregation{
+				Name:              item.name,
+				AggregationMethod: item.aggregationMethodStr,
+				XFilesFactor:      item.xFilesFactor,
+			}
+			if item.pattern != nil {
+				a.Pattern = item.pattern.String()
+			}
+			c.Aggregation = append(c.Aggregation, a)
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,52 @@
+package persister
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas, err := parseSchemas(t, `
+[carbon]
+pattern = ^carbon\.
+retentions = 60s:90d
+priority = 10
+
+[default]
+pattern = .*
+retentions = 1m:30d
+`)
+	if !assert.NoError(err) {
+		return
+	}
+	aggr := NewWhisperAggregation()
+	assert.NoError(aggr.Prepend("internal", regexp.MustCompile(`^carbon\.`), "max"))
+
+	p := NewWhisper("/data/whisper", schemas, aggr, nil, nil)
+	p.SetWorkers(4)
+	p.SetMaxUpdatesPerSecond(100)
+
+	c := p.EffectiveConfig()
+	assert.Equal("/data/whisper", c.RootPath)
+	assert.Equal(4, c.Workers)
+	assert.Equal(100, c.MaxUpdatesPerSecond)
+	if assert.Len(c.Schemas, 2) {
+		assert.Equal(EffectiveSchema{Name: "carbon", Pattern: `^carbon\.`, Retentions: "60s:90d"}, c.Schemas[0])
+	}
+	assert.Equal([]EffectiveAggregation{
+		{Name: "internal", Pattern: `^carbon\.`, AggregationMethod: "max", XFilesFactor: 0.5},
+		{Name: "default", AggregationMethod: "average", XFilesFactor: 0.5},
+	}, c.Aggregation)
+
+	// reloaded storage config
+	p.SetStorageConfig(schemas[1:], NewWhisperAggregation())
+	c = p.EffectiveConfig()
+	if assert.Len(c.Schemas, 1) {
+		assert.Equal("default", c.Schemas[0].Name)
+	}
+	assert.Len(c.Aggregation, 1)
+}
@@ -0,0 +1,32 @@
+package persister
+
+import (
+	"os"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// SetFadviseDontNeed enables posix_fadvise(POSIX_FADV_DONTNEED) of whisper file after each update, so written
+// pages don't evict more useful pages from page cache. Kernel drops only clean pages: dirty pages are dropped
+// after writeback, or immediately if fsync is enabled. Supported on Linux only
+func (p *Whisper) SetFadviseDontNeed(enabled bool) {
+	if enabled && !fadviseSupported {
+		logrus.Warn("[persister] fadvise of whisper files is not supported on this platform")
+		enabled = false
+	}
+	p.fadviseDontNeed = enabled
+}
+
+// osFile is implemented by whisper files on disk
+type osFile interface {
+	File() *os.File
+}
+
+// dropPageCache advises kernel to drop cached pages of w. Files not on disk are skipped
+func dropPageCache(w WhisperFile) error {
+	f, ok := w.(osFile)
+	if !ok {
+		return nil
+	}
+	return fadviseDontNeed(f.File())
+}
@@ -0,0 +1,23 @@
+//go:build amd64 || arm64
+// +build amd64 arm64
+
+package persister
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadviseSupported = true
+
+// advice of fadvise64(2)
+const fadvDontNeed = 4
+
+// fadviseDontNeed advises kernel to drop cached pages of whole file
+func fadviseDontNeed(f *os.File) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
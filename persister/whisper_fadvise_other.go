@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package persister
+
+import "os"
+
+const fadviseSupported = false
+
+func fadviseDontNeed(f *os.File) error {
+	return nil
+}
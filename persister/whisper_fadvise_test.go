@@ -0,0 +1,81 @@
+package persister
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFadviseDontNeed(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetFadviseDontNeed(true)
+		assert.Equal(fadviseSupported, p.fadviseDontNeed)
+
+		now := time.Now().Unix()
+		files := newFileCache(1)
+		for i := 0; i < 3; i++ {
+			assert.NoError(storeWithFiles(p, points.OnePoint("a", float64(i), now-int64(i)), files))
+		}
+		f := files.get(filepath.Join(root, "a.wsp"))
+		if assert.NotNil(f) {
+			assert.NoError(dropPageCache(f))
+		}
+		files.closeAll()
+
+		// written data is not changed
+		assert.NoError(store(p, points.OnePoint("a", 10, now-3)))
+		w, err := osCreateOpener{}.Open(filepath.Join(root, "a.wsp"))
+		if assert.NoError(err) {
+			defer w.Close()
+			ts, err := w.Fetch(int(now-4), int(now))
+			if assert.NoError(err) {
+				assert.Equal([]float64{10, 2, 1, 0}, ts.Values())
+			}
+		}
+	})
+}
+
+func benchmarkFadvise(b *testing.B, enabled bool) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	retentions, _ := ParseRetentionDefs("1s:1d")
+	schemas := WhisperSchemas{
+		Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1d", Retentions: retentions},
+	}
+
+	p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+	p.SetFadviseDontNeed(enabled)
+
+	const metrics = 100
+	files := newFileCache(metrics)
+	defer files.closeAll()
+
+	now := time.Now().Unix()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		storeWithFiles(p, points.OnePoint(fmt.Sprintf("m%d", i%metrics), float64(i), now-int64(i/metrics)%86400), files)
+	}
+}
+
+func BenchmarkStore(b *testing.B)                { benchmarkFadvise(b, false) }
+func BenchmarkStoreFadviseDontNeed(b *testing.B) { benchmarkFadvise(b, true) }
@@ -0,0 +1,157 @@
+package persister
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-whisper"
+)
+
+// WhisperFile is opened whisper file
+type WhisperFile interface {
+	UpdateMany(points []*whisper.TimeSeriesPoint) error
+	Retentions() []whisper.Retention
+	Fetch(from, until int) (*whisper.TimeSeries, error)
+	Close() error
+}
+
+// CreateOpener opens and creates whisper files. File is created if Open fails and Stat of path returns error
+// satisfying os.IsNotExist
+type CreateOpener interface {
+	Open(path string) (WhisperFile, error)
+	Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error)
+	// Stat returns info of file or directory of path, like os.Stat
+	Stat(path string) (os.FileInfo, error)
+	// MkdirAll creates directory of new file with parents, like os.MkdirAll
+	MkdirAll(dir string, mode os.FileMode) error
+	// Sync flushes data of file w opened from path to disk
+	Sync(w WhisperFile, path string) error
+}
+
+// descriptor is implemented by whisper files on disk. Header of file without descriptor is not verified and
+// name of such tagged file is not written to sidecar file
+type descriptor interface {
+	File() *os.File
+}
+
+// SetCreateOpener replaces access to whisper files on disk, e.g. for tests
+func (p *Whisper) SetCreateOpener(co CreateOpener) {
+	p.createOpener = co
+}
+
+type whisperFile struct {
+	*whisper.Whisper
+}
+
+// Sync flushes data by opened descriptor of file
+func (f whisperFile) Sync() error {
+	return f.Whisper.File().Sync()
+}
+
+// osCreateOpener works with whisper files on disk
+type osCreateOpener struct{}
+
+func (osCreateOpener) Open(path string) (WhisperFile, error) {
+	w, err := whisper.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return whisperFile{w}, nil
+}
+
+// Create writes new file to temporary path in the same directory and links it into place, so file
+// interrupted by kill is never left at path: next Create replaces the temporary file. File created at path
+// meanwhile (e.g. by other process) is not replaced, it is opened instead
+func (osCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	tmp := createTempPath(path)
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	w, err := whisper.CreateWithOptions(tmp, retentions, aggregationMethod, xFilesFactor, &whisper.Options{
+		Sparse: sparse,
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	// opened file stays valid after removal of temporary path
+	err = os.Link(tmp, path)
+	os.Remove(tmp)
+	if err != nil {
+		w.Close()
+		if os.IsExist(err) {
+			return osCreateOpener{}.Open(path)
+		}
+		return nil, err
+	}
+
+	if err = syncDir(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return whisperFile{w}, nil
+}
+
+// syncDir flushes entries of directory, e.g. of created file
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Stat implements CreateOpener
+func (osCreateOpener) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// MkdirAll implements CreateOpener
+func (osCreateOpener) MkdirAll(dir string, mode os.FileMode) error {
+	return os.MkdirAll(dir, mode)
+}
+
+// Sync implements CreateOpener. Files without Sync are synced by new descriptor of path
+func (osCreateOpener) Sync(w WhisperFile, path string) error {
+	if s, ok := w.(syncer); ok {
+		return s.Sync()
+	}
+	return fsyncFile(path)
+}
+
+// Rename implements RenameCreateOpener
+func (osCreateOpener) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+// Remove implements RenameCreateOpener
+func (osCreateOpener) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// createTempPath returns path of file being created. Doesn't end with .wsp, so it is skipped by
+// disk usage scan, punch of holes and carbonserver
+func createTempPath(path string) string {
+	return path + ".tmp"
+}
+
+// SetQuarantineCorrupt enables renaming of whisper file to *.corrupt after UpdateMany panic,
+// so next update creates clean file
+func (p *Whisper) SetQuarantineCorrupt(enabled bool) {
+	p.quarantineCorrupt = enabled
+}
+
+func quarantine(path string) {
+	if err := os.Rename(path, path+".corrupt"); err != nil {
+		logrus.Errorf("[persister] Failed to quarantine %s: %s", path, err.Error())
+		return
+	}
+	logrus.Warnf("[persister] Corrupt whisper file moved to %s.corrupt", path)
+}
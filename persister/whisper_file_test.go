@@ -0,0 +1,229 @@
+package persister
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+type panicFile struct {
+	closed *bool
+}
+
+func (f panicFile) UpdateMany(points []*whisper.TimeSeriesPoint) error { panic("corrupt archive") }
+func (f panicFile) Retentions() []whisper.Retention                    { return nil }
+func (f panicFile) Fetch(from, until int) (*whisper.TimeSeries, error) { return nil, nil }
+func (f panicFile) Close() error                                       { *f.closed = true; return nil }
+
+type panicCreateOpener struct {
+	osCreateOpener
+	closed bool
+}
+
+func (co *panicCreateOpener) Open(path string) (WhisperFile, error) {
+	return panicFile{closed: &co.closed}, nil
+}
+
+func (co *panicCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	return panicFile{closed: &co.closed}, nil
+}
+
+func TestUpdateErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		path := filepath.Join(root, "metric.wsp")
+		if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		co := &panicCreateOpener{}
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetCreateOpener(co)
+
+		store(p, points.OnePoint("metric", 1, time.Now().Unix()))
+		assert.True(co.closed)
+
+		// not quarantined
+		_, err := os.Stat(path)
+		assert.NoError(err)
+
+		p.SetQuarantineCorrupt(true)
+		store(p, points.OnePoint("metric", 1, time.Now().Unix()))
+
+		_, err = os.Stat(path)
+		assert.True(os.IsNotExist(err))
+		_, err = os.Stat(path + ".corrupt")
+		assert.NoError(err)
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(2), stat["updateErrors"])
+	})
+}
+
+type nopFile struct{}
+
+func (nopFile) UpdateMany(points []*whisper.TimeSeriesPoint) error { return nil }
+func (nopFile) Retentions() []whisper.Retention                    { return nil }
+func (nopFile) Fetch(from, until int) (*whisper.TimeSeries, error) { return nil, nil }
+func (nopFile) Close() error                                       { return nil }
+
+// sparseCreateOpener records sparse flag of created files
+type sparseCreateOpener struct {
+	osCreateOpener
+	created []bool
+}
+
+func (co *sparseCreateOpener) Open(path string) (WhisperFile, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
+
+func (co *sparseCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	co.created = append(co.created, sparse)
+	return nopFile{}, nil
+}
+
+func TestSparseCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		co := &sparseCreateOpener{}
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetCreateOpener(co)
+
+		store(p, points.OnePoint("metric1", 1, time.Now().Unix()))
+		p.SetSparse(true)
+		store(p, points.OnePoint("metric2", 1, time.Now().Unix()))
+
+		assert.Equal([]bool{false, true}, co.created)
+	})
+}
+
+// brokenOpenCreateOpener fails Open with error which is not os.IsNotExist and counts creates
+type brokenOpenCreateOpener struct {
+	osCreateOpener
+	created int
+}
+
+func (co *brokenOpenCreateOpener) Open(path string) (WhisperFile, error) {
+	return nil, fmt.Errorf("open %s: broken", path)
+}
+
+func (co *brokenOpenCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	co.created++
+	return nopFile{}, nil
+}
+
+func TestOpenErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(root, "exists.wsp"), []byte("garbage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		co := &brokenOpenCreateOpener{}
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetCreateOpener(co)
+
+		// existing file is not created again
+		err := store(p, points.OnePoint("exists", 1, time.Now().Unix()))
+		if assert.IsType(&StoreError{}, err) {
+			assert.Equal(StoreOpOpen, err.(*StoreError).Op)
+		}
+		assert.Equal(0, co.created)
+
+		// missing file is created
+		assert.NoError(store(p, points.OnePoint("missing", 1, time.Now().Unix())))
+		assert.Equal(1, co.created)
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(1), stat["openErrors"])
+		assert.Equal(float64(1), stat["created"])
+	})
+}
+
+func TestCreateInterrupted(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		// header of file created before kill is not completed
+		path := filepath.Join(root, "metric.wsp")
+		if err := ioutil.WriteFile(createTempPath(path), []byte{0, 0, 0}, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		assert.NoError(store(p, points.OnePoint("metric", 42, time.Now().Unix())))
+
+		w, err := whisper.Open(path)
+		if assert.NoError(err) {
+			assert.Len(w.Retentions(), 1)
+			w.Close()
+		}
+
+		_, err = os.Stat(createTempPath(path))
+		assert.True(os.IsNotExist(err))
+	})
+}
+
+func TestCreateExisting(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		// created by other process after failed open
+		path := filepath.Join(root, "metric.wsp")
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		other, err := whisper.Create(path, retentions, whisper.Max, 0.5)
+		if !assert.NoError(err) {
+			return
+		}
+		other.Close()
+
+		newRetentions, _ := ParseRetentionDefs("1s:1h")
+		w, err := osCreateOpener{}.Create(path, newRetentions, whisper.Average, 0.5, false)
+		if assert.NoError(err) {
+			// not replaced
+			assert.Equal(60, w.Retentions()[0].SecondsPerPoint())
+			w.Close()
+		}
+
+		_, err = os.Stat(createTempPath(path))
+		assert.True(os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,145 @@
+package persister
+
+import (
+	"container/list"
+	"sync"
+)
+
+// fileCache is LRU cache of opened whisper files, one instance per worker. Files are used by worker only,
+// others read headers of them by peek
+type fileCache struct {
+	sync.Mutex
+	maxSize int
+	ll      *list.List
+	items   map[string]*list.Element
+}
+
+type fileCacheItem struct {
+	path string
+	w    WhisperFile
+}
+
+func newFileCache(maxSize int) *fileCache {
+	return &fileCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// get returns opened file or nil
+func (c *fileCache) get(path string) WhisperFile {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[path]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*fileCacheItem).w
+	}
+	return nil
+}
+
+// add opened file to cache. Least recently used file is closed if cache is full
+func (c *fileCache) add(path string, w WhisperFile) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[path]; ok {
+		c.ll.MoveToFront(e)
+		item := e.Value.(*fileCacheItem)
+		if item.w != w {
+			item.w.Close()
+			item.w = w
+		}
+		return
+	}
+
+	c.items[path] = c.ll.PushFront(&fileCacheItem{path: path, w: w})
+
+	for c.ll.Len() > c.maxSize {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// remove and close file
+func (c *fileCache) remove(path string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[path]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *fileCache) removeElement(e *list.Element) {
+	item := e.Value.(*fileCacheItem)
+	c.ll.Remove(e)
+	delete(c.items, item.path)
+	item.w.Close()
+}
+
+// closeAll closes all files and clears cache
+func (c *fileCache) closeAll() {
+	c.Lock()
+	defer c.Unlock()
+
+	for c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *fileCache) len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.ll.Len()
+}
+
+// peek calls f with opened file of path if it is in cache and returns true. File can't be closed during call of
+// f, recently used order is not changed
+func (c *fileCache) peek(path string, f func(w WhisperFile)) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.items[path]
+	if ok {
+		f(e.Value.(*fileCacheItem).w)
+	}
+	return ok
+}
+
+// fileCaches is set of file caches of running workers
+type fileCaches struct {
+	sync.Mutex
+	caches map[*fileCache]bool
+}
+
+func (s *fileCaches) register(c *fileCache) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.caches == nil {
+		s.caches = make(map[*fileCache]bool)
+	}
+	s.caches[c] = true
+}
+
+func (s *fileCaches) unregister(c *fileCache) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.caches, c)
+}
+
+// peek calls f with file of path opened by any worker, returns false if file is not opened
+func (s *fileCaches) peek(path string, f func(w WhisperFile)) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for c := range s.caches {
+		if c.peek(path, f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package persister
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCache(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+
+		create := func(name string) (string, WhisperFile) {
+			path := filepath.Join(root, name+".wsp")
+			w, err := whisper.Create(path, retentions, whisper.Average, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return path, whisperFile{w}
+		}
+
+		c := newFileCache(2)
+
+		pathA, a := create("a")
+		pathB, b := create("b")
+		pathC, c3 := create("c")
+
+		c.add(pathA, a)
+		c.add(pathB, b)
+		assert.Equal(2, c.len())
+
+		// a is recently used, b evicted
+		assert.Equal(a, c.get(pathA))
+		c.add(pathC, c3)
+		assert.Equal(2, c.len())
+		assert.Nil(c.get(pathB))
+		assert.Equal(a, c.get(pathA))
+		assert.Equal(c3, c.get(pathC))
+
+		c.remove(pathA)
+		assert.Nil(c.get(pathA))
+		assert.Equal(1, c.len())
+
+		c.closeAll()
+		assert.Equal(0, c.len())
+	})
+}
+
+func TestStoreWithFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetMaxOpenFiles(2)
+
+		files := newFileCache(p.maxOpenFiles)
+		now := time.Now().Unix()
+
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				storeWithFiles(p, points.OnePoint(fmt.Sprintf("metric%d", j), float64(i), now-int64(i)), files)
+			}
+			storeWithFiles(p, points.OnePoint("metric0", float64(i), now-int64(i)-10), files)
+		}
+		files.closeAll()
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+
+		assert.Equal(float64(2), stat["openFileHits"])
+		assert.Equal(float64(10), stat["openFileMisses"])
+		assert.Equal(float64(3), stat["created"])
+
+		w, err := whisper.Open(filepath.Join(root, "metric0.wsp"))
+		if assert.NoError(err) {
+			defer w.Close()
+			ts, err := w.Fetch(int(now-20), int(now))
+			if assert.NoError(err) {
+				written := 0
+				for _, v := range ts.Values() {
+					if !math.IsNaN(v) {
+						written++
+					}
+				}
+				assert.Equal(6, written)
+			}
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package persister
+
+import (
+	"errors"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var errFrozen = errors.New("persister is frozen")
+
+// freezeRequest is sent by Freeze to reader of input: shuffler or solo worker
+type freezeRequest struct {
+	done chan bool // closed by reader after received values are written
+	thaw chan bool // closed by Thaw
+}
+
+// Freeze stops writes to whisper files, e.g. for consistent snapshot of data dir. Returns after values received
+// by workers are written to disk (including values merged by flush interval), then no file is written until Thaw.
+// Throttled and retried creates are kept like on Resize: workers of shuffler pass them to workers started on Thaw,
+// solo worker keeps them until Thaw. Points not received by workers yet (e.g. in cache) are not written before
+// snapshot. New points are buffered by input channel and by cache: it grows up to cache.max-size during freeze,
+// then new points are dropped or evicted by cache.overflow-policy. Files are not changed by hole puncher, Precreate
+// and reconciler too, Freeze waits for their writes in progress. Stop of frozen persister writes buffered values
+// as usual. Repeated calls are noop
+func (p *Whisper) Freeze() error {
+	p.Lock()
+	exit := p.exit
+	freeze := p.freezeRequests
+	if exit == nil {
+		p.Unlock()
+		return errNotStarted
+	}
+	if p.thaw != nil {
+		p.Unlock()
+		return nil
+	}
+	req := &freezeRequest{done: make(chan bool), thaw: make(chan bool)}
+	p.thaw = req.thaw
+	p.Unlock()
+
+	// writes started before freeze are finished, next ones see Frozen
+	p.sideWrites.Lock()
+	p.sideWrites.Unlock()
+
+	select {
+	case freeze <- req:
+		select {
+		case <-req.done:
+			logrus.Info("[persister] Frozen, whisper files are not written until thaw")
+			return nil
+		case <-exit:
+		}
+	case <-exit:
+	}
+
+	p.Lock()
+	if p.thaw == req.thaw {
+		p.thaw = nil
+	}
+	p.Unlock()
+	return errNotStarted
+}
+
+// Thaw resumes writes stopped by Freeze. Noop if not frozen
+func (p *Whisper) Thaw() {
+	p.Lock()
+	thaw := p.thaw
+	p.thaw = nil
+	p.Unlock()
+
+	if thaw != nil {
+		close(thaw)
+		logrus.Info("[persister] Thawed")
+	}
+}
+
+// Frozen returns true between Freeze and Thaw
+func (p *Whisper) Frozen() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.thaw != nil
+}
+
+// beginSideWrite is called by writers of files outside of workers (hole puncher, Precreate, reconciler) before
+// change of file. Returns false if frozen, else endSideWrite must be called after change
+func (p *Whisper) beginSideWrite() bool {
+	p.sideWrites.RLock()
+	if p.Frozen() {
+		p.sideWrites.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (p *Whisper) endSideWrite() {
+	p.sideWrites.RUnlock()
+}
+
+// waitThaw blocks until Thaw or exit of persister
+func waitThaw(req *freezeRequest, exit chan bool) {
+	select {
+	case <-req.thaw:
+	case <-exit:
+	}
+}
@@ -0,0 +1,159 @@
+package persister
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeze(t *testing.T) {
+	const metrics = 100
+
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			assert := assert.New(t)
+
+			in := make(chan *points.Points, metrics)
+			confirm := make(chan *points.Points, 3*metrics)
+
+			p := NewWhisper("/", nil, NewWhisperAggregation(), in, confirm)
+			p.SetCreateOpener(slowCreateOpener{})
+			p.SetWorkers(workers)
+			// values are kept by workers until freeze
+			p.SetFlushInterval(time.Hour)
+			assert.Equal(errNotStarted, p.Freeze())
+
+			assert.NoError(p.Start())
+			defer p.Stop()
+
+			now := time.Now().Unix()
+			send := func() {
+				for i := 0; i < metrics; i++ {
+					in <- points.OnePoint(fmt.Sprintf("a.b%d", i), 1, now)
+				}
+			}
+
+			send()
+			for len(in) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			assert.Len(confirm, 0)
+
+			// received values are written
+			assert.NoError(p.Freeze())
+			assert.True(p.Frozen())
+			assert.Len(confirm, metrics)
+			assert.NoError(p.Freeze())
+
+			// new values are buffered
+			send()
+			time.Sleep(50 * time.Millisecond)
+			assert.Len(confirm, metrics)
+			if workers > 1 {
+				assert.Equal(errFrozen, p.Resize(workers+1))
+			}
+
+			p.Thaw()
+			assert.False(p.Frozen())
+			p.Thaw()
+			for len(in) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			// stop of frozen persister writes buffered values
+			assert.NoError(p.Freeze())
+			send()
+			p.Stop()
+			assert.Len(confirm, 3*metrics)
+			p.Thaw()
+		})
+	}
+}
+
+func TestFreezeThrottled(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			assert := assert.New(t)
+
+			in := make(chan *points.Points, 10)
+			confirm := make(chan *points.Points, 10)
+
+			// throttled until stop
+			s := &recordStore{throttled: 1000}
+			p := NewPersister(s, in, confirm)
+			p.SetMaxCreatesPerSecond(1)
+			p.SetWorkers(workers)
+			assert.NoError(p.Start())
+			defer p.Stop()
+
+			in <- points.OnePoint("a", 1, 10)
+			in <- points.OnePoint("b", 1, 10)
+			for s.len() < 2 {
+				time.Sleep(time.Millisecond)
+			}
+
+			// throttled values are kept across freeze, not retried and confirmed
+			assert.NoError(p.Freeze())
+			p.Thaw()
+			assert.NoError(p.Freeze())
+			assert.Len(confirm, 0)
+			p.Thaw()
+
+			// dropped on stop
+			p.Stop()
+			assert.Len(confirm, 2)
+		})
+	}
+}
+
+func TestFreezeSideWrites(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1m:30d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1m:30d", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), make(chan *points.Points), make(chan *points.Points, 1))
+		p.SetHolePunching(time.Hour, 0)
+
+		idle := filepath.Join(root, "idle.wsp")
+		assert.NoError(store(p, points.OnePoint("idle", 42, time.Now().Unix()-60)))
+		mtime := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+		assert.NoError(os.Chtimes(idle, mtime, mtime))
+		before, _ := os.Stat(idle)
+
+		assert.NoError(p.Start())
+		defer p.Stop()
+		assert.NoError(p.Freeze())
+
+		// hole puncher skips files
+		assert.True(p.punchFiles(make(chan bool)))
+		info, err := os.Stat(idle)
+		if assert.NoError(err) {
+			assert.Equal(allocatedSize(before), allocatedSize(info))
+			assert.Equal(mtime, info.ModTime())
+		}
+
+		// files are not precreated
+		err = p.Precreate([]string{"new"})
+		if assert.Error(err) {
+			assert.Contains(err.Error(), errFrozen.Error())
+		}
+		_, err = os.Stat(filepath.Join(root, "new.wsp"))
+		assert.True(os.IsNotExist(err))
+
+		p.Thaw()
+		assert.NoError(p.Precreate([]string{"new"}))
+		_, err = os.Stat(filepath.Join(root, "new.wsp"))
+		assert.NoError(err)
+	})
+}
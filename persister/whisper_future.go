@@ -0,0 +1,60 @@
+package persister
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetMaxFutureDrift enables rejection of points with timestamp later than now + drift (e.g. from clients with
+// clock skew), so they don't overwrite current points in archives (persister.futurePoints metric). 0 - disabled
+func (p *Whisper) SetMaxFutureDrift(drift time.Duration) {
+	p.maxFutureDrift = drift
+}
+
+// now returns current time, overridden by tests
+func (p *Whisper) now() time.Time {
+	if p.nowFunc != nil {
+		return p.nowFunc()
+	}
+	return time.Now()
+}
+
+// dropFuture returns points of metric not later than now + max future drift. Source slice is not modified
+// because it is still visible for carbonlink until confirmed
+func (p *Whisper) dropFuture(metric string, data []points.Point) []points.Point {
+	if p.maxFutureDrift <= 0 {
+		return data
+	}
+
+	now := p.now()
+	maxTimestamp := now.Add(p.maxFutureDrift).Unix()
+
+	var result []points.Point
+	for i, d := range data {
+		if d.Timestamp <= maxTimestamp {
+			if result != nil {
+				result = append(result, d)
+			}
+			continue
+		}
+		if result == nil {
+			result = make([]points.Point, i, len(data))
+			copy(result, data[:i])
+		}
+	}
+	if result == nil {
+		return data
+	}
+
+	atomic.AddUint32(&p.futurePoints, uint32(len(data)-len(result)))
+
+	// sample of metric names, one per second
+	last := atomic.LoadInt64(&p.futurePointLogged)
+	if last != now.Unix() && atomic.CompareAndSwapInt64(&p.futurePointLogged, last, now.Unix()) {
+		logrus.Debugf("[persister] %d points of %s with timestamp later than %d dropped", len(data)-len(result), metric, maxTimestamp)
+	}
+	return result
+}
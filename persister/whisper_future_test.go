@@ -0,0 +1,39 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxFutureDrift(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.nowFunc = func() time.Time { return now }
+
+	data := []points.Point{{Value: 1, Timestamp: 990}, {Value: 2, Timestamp: 1070}, {Value: 3, Timestamp: 1060}, {Value: 4, Timestamp: 1000}}
+
+	// disabled
+	assert.Equal(data, p.dropFuture("foo", data))
+
+	p.SetMaxFutureDrift(time.Minute)
+	assert.Equal([]points.Point{{Value: 1, Timestamp: 990}, {Value: 3, Timestamp: 1060}, {Value: 4, Timestamp: 1000}}, p.dropFuture("foo", data))
+	// source is not modified
+	assert.Equal(int64(1070), data[1].Timestamp)
+
+	assert.NoError(store(p, &points.Points{Metric: "foo", Data: []points.Point{{Value: 1, Timestamp: 1061}, {Value: 2, Timestamp: 1062}}}))
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(3), stat["futurePoints"])
+	// nothing written
+	assert.Equal(float64(0), stat["updateOperations"])
+}
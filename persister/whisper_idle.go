@@ -0,0 +1,63 @@
+package persister
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// workerTime accumulates time spent by workers in store, the rest of their time they wait for input
+type workerTime struct {
+	busy    int64 // nanoseconds in store since last stat, changing via atomic
+	since   int64 // unix nano of last stat or start, changing via atomic
+	running int32 // count of running workers, changing via atomic
+}
+
+// start counts running worker and returns func called on its exit
+func (t *workerTime) start() func() {
+	atomic.AddInt32(&t.running, 1)
+	return func() {
+		atomic.AddInt32(&t.running, -1)
+	}
+}
+
+// timed returns store func which adds its duration to busy time
+func (t *workerTime) timed(store StoreFunc) StoreFunc {
+	return func(p *Whisper, values *points.Points) {
+		start := time.Now()
+		store(p, values)
+		atomic.AddInt64(&t.busy, int64(time.Since(start)))
+	}
+}
+
+// reset starts measurement period
+func (t *workerTime) reset(now time.Time) {
+	atomic.StoreInt64(&t.busy, 0)
+	atomic.StoreInt64(&t.since, now.UnixNano())
+}
+
+// idleRatio returns share of time of running workers spent waiting for input since last call and starts new period.
+// Workers are saturated by slow disk if close to 0, starved by low input if close to 1
+func (t *workerTime) idleRatio(now time.Time) float64 {
+	since := atomic.SwapInt64(&t.since, now.UnixNano())
+	busy := atomic.SwapInt64(&t.busy, 0)
+	running := atomic.LoadInt32(&t.running)
+
+	total := float64(now.UnixNano()-since) * float64(running)
+	if since == 0 || total <= 0 {
+		return 0
+	}
+
+	ratio := 1 - float64(busy)/total
+	if ratio < 0 {
+		// store started before period
+		ratio = 0
+	}
+	return ratio
+}
+
+func (p *Whisper) workerTimeStat(send helper.StatCallback) {
+	send("workerIdleRatio", p.workerTime.idleRatio(time.Now()))
+}
@@ -0,0 +1,69 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerIdleRatio(t *testing.T) {
+	assert := assert.New(t)
+
+	var wt workerTime
+	now := time.Now()
+
+	// not started
+	assert.Equal(0.0, wt.idleRatio(now))
+
+	wt.reset(now)
+	stop := wt.start()
+	wt.start()
+	store := wt.timed(func(p *Whisper, values *points.Points) {
+		time.Sleep(10 * time.Millisecond)
+	})
+	store(nil, nil)
+
+	// 2 workers during 100ms, one of them stored 10ms
+	ratio := wt.idleRatio(now.Add(100 * time.Millisecond))
+	assert.True(ratio > 0.9 && ratio <= 0.95, ratio)
+
+	// busy time is reset
+	assert.Equal(1.0, wt.idleRatio(now.Add(200*time.Millisecond)))
+
+	// store longer than period
+	store(nil, nil)
+	assert.Equal(0.0, wt.idleRatio(now.Add(201*time.Millisecond)))
+
+	stop()
+	assert.Equal(1.0, wt.idleRatio(now.Add(300*time.Millisecond)))
+}
+
+func TestWorkerIdleRatioStat(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.SetWorkers(2)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	in <- points.OnePoint("slow", 1, time.Now().Unix())
+	select {
+	case <-confirm:
+	case <-time.After(time.Second):
+		t.Fatal("not confirmed")
+	}
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	ratio, ok := stat["workerIdleRatio"]
+	assert.True(ok)
+	assert.True(ratio > 0 && ratio < 1, ratio)
+}
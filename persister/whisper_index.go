@@ -0,0 +1,218 @@
+package persister
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// SetIndex enables index of created metrics in file path: names are appended on creation of whisper file, so
+// List answers without walk of data dirs. If file not exists on Start it is rebuilt from data dirs (remove it
+// to rebuild after changes of files by other tools). "" - disabled
+func (p *Whisper) SetIndex(path string) {
+	p.indexPath = path
+}
+
+// List returns sorted names of metrics created by persister with prefix. Index should be enabled by SetIndex
+// and persister started, otherwise returns nil
+func (p *Whisper) List(prefix string) []string {
+	p.RLock()
+	index := p.index
+	p.RUnlock()
+
+	if index == nil {
+		return nil
+	}
+	return index.list(prefix)
+}
+
+// metricIndex is append-only file of metric names, one per line, with in-memory copy
+type metricIndex struct {
+	sync.Mutex
+	file   *os.File
+	names  map[string]bool
+	sorted []string // cache of list, nil after add
+}
+
+// openIndex loads index from path. If path not exists index is written with names returned by rebuild
+func openIndex(path string, rebuild func() ([]string, error)) (*metricIndex, error) {
+	index := &metricIndex{names: make(map[string]bool)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if err = writeIndex(path, rebuild); err != nil {
+			return nil, err
+		}
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name := scanner.Text(); name != "" {
+			index.names[name] = true
+		}
+	}
+	f.Close()
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	index.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// writeIndex writes names returned by rebuild to temporary file and renames it to path
+func writeIndex(path string, rebuild func() ([]string, error)) error {
+	names, err := rebuild()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, name := range names {
+		w.WriteString(name)
+		w.WriteByte('\n')
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// add appends new metric to index
+func (i *metricIndex) add(metric string) error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.names[metric] {
+		return nil
+	}
+
+	if _, err := i.file.WriteString(metric + "\n"); err != nil {
+		return err
+	}
+	i.names[metric] = true
+	i.sorted = nil
+	return nil
+}
+
+func (i *metricIndex) list(prefix string) []string {
+	i.Lock()
+	if i.sorted == nil {
+		i.sorted = make([]string, 0, len(i.names))
+		for name := range i.names {
+			i.sorted = append(i.sorted, name)
+		}
+		sort.Strings(i.sorted)
+	}
+	sorted := i.sorted
+	i.Unlock()
+
+	start := sort.SearchStrings(sorted, prefix)
+	end := start
+	for end < len(sorted) && strings.HasPrefix(sorted[end], prefix) {
+		end++
+	}
+
+	result := make([]string, end-start)
+	copy(result, sorted[start:end])
+	return result
+}
+
+func (i *metricIndex) close() error {
+	i.Lock()
+	defer i.Unlock()
+	return i.file.Close()
+}
+
+// addToIndex adds created metric to index if enabled
+func (p *Whisper) addToIndex(metric string) {
+	if p.index == nil {
+		return
+	}
+	if err := p.index.add(metric); err != nil {
+		p.log.Errorf("[persister] Failed to add %s to index: %s", metric, err.Error())
+	}
+}
+
+// indexedMetrics walks data dirs and returns names of metrics of whisper files
+func (p *Whisper) indexedMetrics() ([]string, error) {
+	depth := 0
+	if hashed, ok := p.pathEncoder.(HashedPathEncoder); ok {
+		depth = hashed.Depth
+	}
+
+	var names []string
+	for _, root := range p.dataDirPaths() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if path == root {
+					return err
+				}
+				return nil
+			}
+			if info.IsDir() || !strings.HasSuffix(path, ".wsp") {
+				return nil
+			}
+
+			name, err := metricFromPath(root, path, depth)
+			if err != nil {
+				logrus.Warnf("[persister] Index: %s skipped: %s", path, err.Error())
+				return nil
+			}
+			names = append(names, name)
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	logrus.Infof("[persister] Index rebuilt from data dirs: %d metrics", len(names))
+	return names, nil
+}
+
+// metricFromPath returns metric name of whisper file path in root, with depth levels of hashed layout
+func metricFromPath(root string, path string, depth int) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) <= depth {
+		return "", fmt.Errorf("not in hashed layout")
+	}
+	parts = parts[depth:]
+
+	if parts[0] == TaggedDir {
+		return TaggedMetricFromPath(path)
+	}
+	return strings.TrimSuffix(strings.Join(parts, "."), ".wsp"), nil
+}
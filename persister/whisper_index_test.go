@@ -0,0 +1,119 @@
+package persister
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricFromPath(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		table := []struct {
+			metric string
+			depth  int
+		}{
+			{"a.b.c", 0},
+			{"a", 0},
+			{"a.b.c", 2},
+			{"cpu;host=a;dc=b", 0},
+			{"cpu;host=a;dc=b", 1},
+		}
+
+		for _, c := range table {
+			var encoder PathEncoder = SafePathEncoder{}
+			if c.depth > 0 {
+				encoder = HashedPathEncoder{Encoder: encoder, Depth: c.depth}
+			}
+			path, err := encoder.Path(root, c.metric)
+			if !assert.NoError(err) {
+				continue
+			}
+			normalized := c.metric
+			if IsTagged(c.metric) {
+				normalized, _ = NormalizeTagged(c.metric)
+				// name of tagged metric is read from sidecar file
+				assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+				assert.NoError(WriteTaggedName(path, c.metric))
+			}
+			metric, err := metricFromPath(root, path, c.depth)
+			assert.NoError(err)
+			assert.Equal(normalized, metric, path)
+		}
+
+		_, err := metricFromPath(root, filepath.Join(root, "a.wsp"), 2)
+		assert.Error(err)
+	})
+}
+
+func TestIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		dataDir := filepath.Join(root, "data")
+		indexPath := filepath.Join(root, "index")
+
+		// existing files are indexed by rebuild
+		for _, f := range []string{"a/b.wsp", "a/c.wsp", "other.wsp", "a/skip.txt"} {
+			os.MkdirAll(filepath.Dir(filepath.Join(dataDir, f)), 0755)
+			if err := ioutil.WriteFile(filepath.Join(dataDir, f), []byte{}, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+		p := NewWhisper(dataDir, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetIndex(indexPath)
+
+		assert.Nil(p.List(""))
+
+		if !assert.NoError(p.Start()) {
+			return
+		}
+		assert.Equal([]string{"a.b", "a.c", "other"}, p.List(""))
+
+		in <- points.OnePoint("a.d", 1, time.Now().Unix())
+		select {
+		case <-confirm:
+		case <-time.After(time.Second):
+			t.Fatal("not confirmed")
+		}
+		p.Stop()
+
+		assert.Equal([]string{"a.b", "a.c", "a.d"}, p.List("a."))
+		assert.Equal([]string{"other"}, p.List("o"))
+		assert.Empty(p.List("b"))
+
+		// index is loaded from file, not rebuilt
+		os.Remove(filepath.Join(dataDir, "other.wsp"))
+		p = NewWhisper(dataDir, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetIndex(indexPath)
+		if assert.NoError(p.Start()) {
+			assert.Equal([]string{"a.b", "a.c", "a.d", "other"}, p.List(""))
+			p.Stop()
+		}
+
+		// rebuild after removal of index
+		os.Remove(indexPath)
+		p = NewWhisper(dataDir, schemas, NewWhisperAggregation(), in, confirm)
+		p.SetIndex(indexPath)
+		if assert.NoError(p.Start()) {
+			assert.Equal([]string{"a.b", "a.c", "a.d"}, p.List(""))
+			p.Stop()
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package persister
+
+import (
+	"fmt"
+
+	"github.com/lomik/go-whisper"
+)
+
+// NotFoundError is returned by RetentionInfo if whisper file of metric doesn't exist
+type NotFoundError struct {
+	Metric string
+	Path   string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("whisper file %s of %s not found", e.Path, e.Metric)
+}
+
+// whisperHeader is WhisperFile which reports aggregation of file, e.g. file of go-whisper
+type whisperHeader interface {
+	AggregationMethod() whisper.AggregationMethod
+	XFilesFactor() float32
+}
+
+// RetentionInfo returns retentions, aggregation method and xFilesFactor from header of whisper file of metric,
+// i.e. actual storage of metric on disk (Validate reports schema which would be used for new file). Header of file
+// opened by worker is read from its open file cache, else file is opened by CreateOpener like in Query and closed
+// after read. Returns *NotFoundError if file doesn't exist
+func (p *Whisper) RetentionInfo(metric string) (retentions whisper.Retentions, method string, xff float32, err error) {
+	name := metric
+	if p.nameNormalizer != nil {
+		name = p.nameNormalizer(metric)
+	}
+	path, _, err := p.metricPath(name)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	lock := p.fileLocks.get(path)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	read := func(w WhisperFile) {
+		retentions, method, xff, err = headerInfo(w, path)
+	}
+	if p.openFiles.peek(path, read) {
+		return
+	}
+
+	if p.fileNotExists(path) {
+		return nil, "", 0, &NotFoundError{Metric: metric, Path: path}
+	}
+	w, err := p.createOpener.Open(path)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer w.Close()
+
+	read(w)
+	return
+}
+
+// headerInfo returns retentions, aggregation method and xFilesFactor of opened file w of path
+func headerInfo(w WhisperFile, path string) (whisper.Retentions, string, float32, error) {
+	h, ok := w.(whisperHeader)
+	if !ok {
+		return nil, "", 0, fmt.Errorf("aggregation of %s is unknown", path)
+	}
+
+	var retentions whisper.Retentions
+	for _, r := range w.Retentions() {
+		r := r
+		retentions = append(retentions, &r)
+	}
+	return retentions, h.AggregationMethod().String(), h.XFilesFactor(), nil
+}
@@ -0,0 +1,92 @@
+package persister
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h,1h:1d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h,1h:1d", Retentions: retentions},
+		}
+
+		aggr := NewWhisperAggregation()
+		aggr.Default.setMethod("max")
+		aggr.Default.xFilesFactor = 0.25
+
+		p := NewWhisper(root, schemas, aggr, nil, nil)
+		_, _, _, err := p.RetentionInfo("a.b")
+		if assert.IsType(&NotFoundError{}, err) {
+			assert.Equal("a.b", err.(*NotFoundError).Metric)
+		}
+
+		assert.NoError(store(p, points.OnePoint("a.b", 1, time.Now().Unix())))
+
+		// schemas changed after create, file keeps its retentions
+		newRetentions, _ := ParseRetentionDefs("10s:1d")
+		p.SetStorageConfig(WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "10s:1d", Retentions: newRetentions},
+		}, NewWhisperAggregation())
+
+		r, method, xff, err := p.RetentionInfo("a.b")
+		if assert.NoError(err) && assert.Len(r, 2) {
+			assert.Equal(60, r[0].SecondsPerPoint())
+			assert.Equal(60, r[0].NumberOfPoints())
+			assert.Equal(3600, r[1].SecondsPerPoint())
+			assert.Equal(24, r[1].NumberOfPoints())
+		}
+		assert.Equal("max", method)
+		assert.Equal(float32(0.25), xff)
+
+		_, _, _, err = p.RetentionInfo("a..b")
+		assert.Error(err)
+	})
+}
+
+func TestRetentionInfoOpenFile(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		assert.NoError(store(p, points.OnePoint("a.b", 1, time.Now().Unix())))
+
+		path := filepath.Join(root, "a", "b.wsp")
+		w, err := p.createOpener.Open(path)
+		if !assert.NoError(err) {
+			return
+		}
+
+		// header of file opened by worker is read from its cache
+		files := newFileCache(1)
+		files.add(path, w)
+		p.openFiles.register(files)
+		assert.NoError(os.Remove(path))
+
+		r, method, _, err := p.RetentionInfo("a.b")
+		if assert.NoError(err) && assert.Len(r, 1) {
+			assert.Equal(60, r[0].SecondsPerPoint())
+		}
+		assert.Equal("average", method)
+
+		p.openFiles.unregister(files)
+		files.closeAll()
+		_, _, _, err = p.RetentionInfo("a.b")
+		assert.IsType(&NotFoundError{}, err)
+	})
+}
@@ -0,0 +1,97 @@
+package persister
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// lagTracker keeps timestamps of the oldest points taken by each worker: of points not written yet and of points
+// taken since previous collection of lag, so lag of worker with queue which never drains is not stuck
+type lagTracker struct {
+	sync.Mutex
+	heads []*lagHead
+}
+
+// lagHead is the oldest timestamps of worker, 0 - nothing taken
+type lagHead struct {
+	pending  int64 // of points not written yet, reset by done
+	interval int64 // of points taken since previous maxLag, reset by maxLag
+}
+
+// register returns slot of new worker
+func (t *lagTracker) register() *lagHead {
+	t.Lock()
+	defer t.Unlock()
+
+	head := &lagHead{}
+	t.heads = append(t.heads, head)
+	return head
+}
+
+// unregister removes slot of stopped worker
+func (t *lagTracker) unregister(head *lagHead) {
+	t.Lock()
+	defer t.Unlock()
+
+	for i, h := range t.heads {
+		if h == head {
+			t.heads = append(t.heads[:i], t.heads[i+1:]...)
+			return
+		}
+	}
+}
+
+// maxLag returns now minus the oldest timestamp of points not written yet or taken since previous call,
+// and starts next interval. 0 if nothing is taken
+func (t *lagTracker) maxLag(now int64) int64 {
+	t.Lock()
+	defer t.Unlock()
+
+	var oldest int64
+	for _, h := range t.heads {
+		for _, ts := range []int64{atomic.LoadInt64(&h.pending), atomic.SwapInt64(&h.interval, 0)} {
+			if ts != 0 && (oldest == 0 || ts < oldest) {
+				oldest = ts
+			}
+		}
+	}
+	if oldest == 0 || oldest > now {
+		return 0
+	}
+	return now - oldest
+}
+
+// take records values taken by worker from queue
+func (h *lagHead) take(values *points.Points) {
+	if len(values.Data) == 0 {
+		return
+	}
+	oldest := values.Data[0].Timestamp
+	for _, d := range values.Data[1:] {
+		if d.Timestamp < oldest {
+			oldest = d.Timestamp
+		}
+	}
+	keepOldest(&h.pending, oldest)
+	keepOldest(&h.interval, oldest)
+}
+
+// done resets pending timestamp after taken values are written
+func (h *lagHead) done() {
+	atomic.StoreInt64(&h.pending, 0)
+}
+
+// keepOldest stores timestamp to addr if it is older or addr is 0. Interval is reset concurrently by maxLag
+func keepOldest(addr *int64, timestamp int64) {
+	for {
+		ts := atomic.LoadInt64(addr)
+		if ts != 0 && ts <= timestamp {
+			return
+		}
+		if atomic.CompareAndSwapInt64(addr, ts, timestamp) {
+			return
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagTracker(t *testing.T) {
+	assert := assert.New(t)
+
+	var tracker lagTracker
+	assert.Equal(int64(0), tracker.maxLag(100))
+
+	a := tracker.register()
+	b := tracker.register()
+
+	a.take(points.OnePoint("a", 1, 80).Add(2, 70))
+	b.take(points.OnePoint("b", 1, 90))
+	assert.Equal(int64(30), tracker.maxLag(100))
+
+	// newer values don't move head
+	a.take(points.OnePoint("a", 1, 95))
+	assert.Equal(int64(30), tracker.maxLag(100))
+
+	// reset after write, b is still pending
+	a.done()
+	assert.Equal(int64(10), tracker.maxLag(100))
+	// interval is reset
+	assert.Equal(int64(10), tracker.maxLag(100))
+	b.done()
+	assert.Equal(int64(0), tracker.maxLag(100))
+
+	// worker with queue which never drains: old points written during interval
+	a.take(points.OnePoint("a", 1, 60))
+	a.done()
+	a.take(points.OnePoint("a", 1, 98))
+	a.done()
+	assert.Equal(int64(40), tracker.maxLag(100))
+	a.take(points.OnePoint("a", 1, 97))
+	a.done()
+	assert.Equal(int64(3), tracker.maxLag(100))
+
+	tracker.unregister(b)
+	assert.Equal(int64(0), tracker.maxLag(100))
+	assert.Len(tracker.heads, 1)
+
+	// future timestamps
+	a.take(points.OnePoint("a", 1, 200))
+	assert.Equal(int64(0), tracker.maxLag(100))
+}
+
+func TestWorkerLag(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+
+	p := NewWhisper("", nil, nil, in, nil)
+
+	var lags []int64
+	p.mockStore = func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {
+			lags = append(lags, p.lag.maxLag(100))
+		}, nil
+	}
+
+	in <- points.OnePoint("a", 1, 50)
+	in <- points.OnePoint("b", 1, 60)
+	close(in)
+
+	p.worker(in, make(chan bool), nil)
+
+	// a is written before b
+	assert.Equal([]int64{50, 40}, lags)
+	// worker is unregistered on exit
+	assert.Equal(int64(0), p.lag.maxLag(100))
+}
+
+func TestWorkerLagQueueNotDrained(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+
+	p := NewWhisper("", nil, nil, in, nil)
+
+	// next values are queued during each store, so queue is never empty after write
+	var lags []int64
+	p.mockStore = func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {
+			lags = append(lags, p.lag.maxLag(100))
+			if len(lags) < 3 {
+				in <- points.OnePoint("a", 1, int64(90+len(lags)))
+			} else {
+				close(in)
+			}
+		}, nil
+	}
+
+	in <- points.OnePoint("a", 1, 50)
+	p.worker(in, make(chan bool), nil)
+
+	// lag of written old values is not kept
+	assert.Equal([]int64{50, 9, 8}, lags)
+}
+
+func TestStatMaxLag(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("", nil, nil, nil, nil)
+	p.nowFunc = func() time.Time { return time.Unix(100, 0) }
+	p.lag.register().take(points.OnePoint("a", 1, 70))
+
+	var lag float64
+	p.Stat(func(metric string, value float64) {
+		if metric == "maxLagSeconds" {
+			lag = value
+		}
+	})
+	assert.Equal(float64(30), lag)
+}
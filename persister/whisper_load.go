@@ -0,0 +1,26 @@
+package persister
+
+import "github.com/lomik/go-carbon/points"
+
+// Load returns fill level (0..1) of the most loaded persister buffer: input channel, throttled channel
+// (if max-updates-per-second is set) or worker channel. Load close to 1 means disk or throttling can't keep up
+// with incoming points, so producers should slow down: e.g. TCP receiver may stop accepting new connections
+// and UDP receiver may drop packets until load decreases. Returns 0 if persister is not started, 1 if degraded
+func (p *Whisper) Load() float64 {
+	if p.Degraded() {
+		return 1
+	}
+
+	queues, _ := p.queues.Load().([]chan *points.Points)
+
+	var load float64
+	for _, ch := range queues {
+		if cap(ch) == 0 {
+			continue
+		}
+		if l := float64(len(ch)) / float64(cap(ch)); l > load {
+			load = l
+		}
+	}
+	return load
+}
@@ -0,0 +1,46 @@
+package persister
+
+import (
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	ch := make(chan *points.Points, 10)
+	p := NewWhisper("", nil, nil, ch, nil)
+	assert.Equal(float64(0), p.Load())
+
+	storeStarted := make(chan bool, 10)
+	storeWait := make(chan bool)
+	p.mockStore = func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {
+			storeStarted <- true
+			<-storeWait
+		}, nil
+	}
+
+	p.Start()
+
+	for i := 0; i < 10; i++ {
+		ch <- points.NowPoint("metric", float64(i))
+	}
+
+	// worker blocked on first point
+	<-storeStarted
+	assert.InDelta(0.9, p.Load(), 0.01)
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.InDelta(0.9, stat["load"], 0.01)
+
+	close(storeWait)
+	p.Stop()
+
+	assert.Equal(float64(0), p.Load())
+}
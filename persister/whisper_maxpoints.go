@@ -0,0 +1,49 @@
+package persister
+
+import (
+	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetMaxPointsPerUpdate limits count of points written by one UpdateMany, so worker doesn't hold file lock for
+// long time on large batch (e.g. after WAL replay or restore of cache). Oversized batch is split by archives of
+// file first, then by n points in order of data. 0 - unlimited
+func (p *Whisper) SetMaxPointsPerUpdate(n int) {
+	p.maxPointsPerUpdate = n
+}
+
+// updateChunks returns points of data for UpdateMany calls, at most max points in every chunk (0 - single chunk).
+// Chunks of oversized data don't cross windows of archives from oldest to newest, order inside window is kept
+func updateChunks(data []points.Point, retentions []whisper.Retention, now int64, max int) [][]*whisper.TimeSeriesPoint {
+	if max <= 0 || len(data) <= max {
+		return [][]*whisper.TimeSeriesPoint{timeSeriesPoints(data)}
+	}
+
+	// ascending boundaries: max retention of archives is growing with index
+	boundaries := make([]int, len(retentions))
+	for i, r := range retentions {
+		boundaries[len(retentions)-1-i] = int(now) - r.MaxRetention()
+	}
+
+	var chunks [][]*whisper.TimeSeriesPoint
+	for _, window := range (&points.Points{Data: data}).Split(boundaries) {
+		for d := window.Data; len(d) > 0; {
+			n := max
+			if len(d) < n {
+				n = len(d)
+			}
+			chunks = append(chunks, timeSeriesPoints(d[:n]))
+			d = d[n:]
+		}
+	}
+	return chunks
+}
+
+func timeSeriesPoints(data []points.Point) []*whisper.TimeSeriesPoint {
+	result := make([]*whisper.TimeSeriesPoint, len(data))
+	for i, r := range data {
+		result[i] = &whisper.TimeSeriesPoint{Time: int(r.Timestamp), Value: r.Value}
+	}
+	return result
+}
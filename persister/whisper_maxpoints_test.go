@@ -0,0 +1,94 @@
+package persister
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions := []whisper.Retention{whisper.NewRetention(1, 100), whisper.NewRetention(10, 100)}
+	now := int64(10000)
+
+	timestamps := func(chunks [][]*whisper.TimeSeriesPoint) [][]int {
+		var result [][]int
+		for _, chunk := range chunks {
+			var ts []int
+			for _, p := range chunk {
+				ts = append(ts, p.Time)
+			}
+			result = append(result, ts)
+		}
+		return result
+	}
+
+	data := []points.Point{
+		{Value: 1, Timestamp: now - 10},
+		{Value: 2, Timestamp: now - 500},
+		{Value: 3, Timestamp: now - 9},
+		{Value: 4, Timestamp: now - 8},
+		{Value: 5, Timestamp: now - 400},
+		{Value: 6, Timestamp: now - 7},
+	}
+
+	// unlimited or small batch
+	assert.Equal([][]int{{9990, 9500, 9991, 9992, 9600, 9993}}, timestamps(updateChunks(data, retentions, now, 0)))
+	assert.Equal([][]int{{9990, 9500, 9991, 9992, 9600, 9993}}, timestamps(updateChunks(data, retentions, now, 6)))
+
+	// split by archives, then by count
+	assert.Equal([][]int{{9500, 9600}, {9990, 9991, 9992, 9993}}, timestamps(updateChunks(data, retentions, now, 5)))
+	assert.Equal([][]int{{9500, 9600}, {9990, 9991}, {9992, 9993}}, timestamps(updateChunks(data, retentions, now, 2)))
+	assert.Equal([][]int{{9500}, {9600}, {9990}, {9991}, {9992}, {9993}}, timestamps(updateChunks(data, retentions, now, 1)))
+}
+
+func TestMaxPointsPerUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetMaxPointsPerUpdate(3)
+
+		now := time.Now().Unix()
+		values := &points.Points{Metric: "split"}
+		for i := 0; i < 10; i++ {
+			values.Add(float64(i), now-int64(10-i))
+		}
+		assert.NoError(store(p, values))
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(10), stat["committedPoints"])
+		assert.Equal(float64(4), stat["updateOperations"])
+
+		w, err := whisper.Open(filepath.Join(root, "split.wsp"))
+		if assert.NoError(err) {
+			defer w.Close()
+			ts, err := w.Fetch(int(now-11), int(now-1))
+			if assert.NoError(err) {
+				var written []float64
+				for _, v := range ts.Values() {
+					if !math.IsNaN(v) {
+						written = append(written, v)
+					}
+				}
+				assert.Equal([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, written)
+			}
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package persister
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alyu/configparser"
+	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// Mirror is additional whisper file of metrics of schema in other root with own retentions and aggregation method,
+// e.g. long-retention copy for compliance. Set by mirror-root, mirror-retentions and mirror-aggregation options of
+// storage-schemas.conf section. Points of each update are aggregated by the method into intervals of the first
+// archive of mirror before write
+type Mirror struct {
+	Root              string
+	RetentionStr      string
+	Retentions        whisper.Retentions
+	AggregationMethod string // "" - aggregation of primary file
+}
+
+// parseMirror returns mirror of schema section, nil if mirror-root is not set
+func parseMirror(sec *configparser.Section) (*Mirror, error) {
+	root := strings.TrimSpace(sec.ValueOf("mirror-root"))
+	if root == "" {
+		return nil, nil
+	}
+
+	m := &Mirror{
+		Root:              root,
+		RetentionStr:      sec.ValueOf("mirror-retentions"),
+		AggregationMethod: strings.TrimSpace(sec.ValueOf("mirror-aggregation")),
+	}
+	if m.RetentionStr == "" {
+		return nil, fmt.Errorf("Empty mirror-retentions")
+	}
+
+	var err error
+	if m.Retentions, err = ParseRetentionDefs(m.RetentionStr); err != nil {
+		return nil, fmt.Errorf("Failed to parse mirror-retentions %q: %s", m.RetentionStr, err.Error())
+	}
+
+	if m.AggregationMethod != "" {
+		item := &whisperAggregationItem{}
+		if !item.setMethod(m.AggregationMethod) || item.preAggregation != nil {
+			return nil, fmt.Errorf("Unknown mirror-aggregation %#v, valid methods: average, sum, last, max, min", m.AggregationMethod)
+		}
+	}
+	return m, nil
+}
+
+func (s WhisperSchemas) hasMirrors() bool {
+	for _, schema := range s {
+		if schema.Mirror != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMirrorMaxUpdatesPerSecond limits updates of mirror files independently of primary ones. Throttled
+// updates of mirror are dropped (persister.mirror.throttled metric), primary file is written anyway. 0 - no limit
+func (p *Whisper) SetMirrorMaxUpdatesPerSecond(rate int) {
+	if rate <= 0 {
+		p.mirrorLimiter = nil
+		return
+	}
+	p.mirrorLimiter = &rateLimiter{rate: rate}
+}
+
+// mirrorStats is counters of mirror files
+type mirrorStats struct {
+	updateOperations uint32 // counter
+	committedPoints  uint32 // counter
+	created          uint32 // counter
+	errors           uint32 // counter
+	throttled        uint32 // counter
+}
+
+// storeMirror writes data written to primary file of metric to its mirror, if schema of metric has one.
+// Mirror files are opened for each update and not kept by max-open-files cache. Errors are logged and counted
+func (p *Whisper) storeMirror(storage *storageConfig, metric string, data []points.Point, now int64) {
+	schema, ok := storage.schemas.Match(metric)
+	if !ok || schema.Mirror == nil {
+		return
+	}
+
+	if p.mirrorLimiter != nil && !p.mirrorLimiter.allow() {
+		atomic.AddUint32(&p.mirrorStats.throttled, 1)
+		return
+	}
+
+	path, err := p.pathEncoder.Path(schema.Mirror.Root, metric)
+	if err == nil {
+		err = p.writeMirror(storage, metric, path, schema.Mirror, data, now)
+	}
+	if err != nil {
+		atomic.AddUint32(&p.mirrorStats.errors, 1)
+		p.log.Errorf("[persister] Failed to write mirror of %s: %s", metric, err.Error())
+	}
+}
+
+func (p *Whisper) writeMirror(storage *storageConfig, metric string, path string, mirror *Mirror, data []points.Point, now int64) (err error) {
+	aggr, err := mirrorAggregation(storage, metric, mirror)
+	if err != nil {
+		return err
+	}
+
+	w, err := p.createOpener.Open(path)
+	if err != nil {
+		if !p.fileNotExists(path) {
+			return fmt.Errorf("Failed to open whisper file %s: %s", path, err.Error())
+		}
+		var created bool
+		if w, created, err = p.openOrCreate(metric, path, func() (WhisperFile, error) {
+			return p.createMirror(metric, path, mirror, aggr)
+		}); err != nil {
+			return err
+		}
+		if created {
+			atomic.AddUint32(&p.mirrorStats.created, 1)
+		}
+	}
+	defer w.Close()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("UpdateMany %s recovered: %v", path, r)
+		}
+	}()
+
+	// whisper keeps the last point of interval
+	if retentions := w.Retentions(); len(retentions) > 0 {
+		pre := &preAggregation{aggregate: aggr.aggregateFunc()}
+		data = pre.apply(data, retentions[0].SecondsPerPoint())
+	}
+	chunks := updateChunks(data, w.Retentions(), now, p.maxPointsPerUpdate)
+
+	lock := p.fileLocks.get(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, chunk := range chunks {
+		if err = w.UpdateMany(chunk); err != nil {
+			return fmt.Errorf("Failed to update whisper file %s: %s", path, err.Error())
+		}
+	}
+
+	atomic.AddUint32(&p.mirrorStats.committedPoints, uint32(len(data)))
+	atomic.AddUint32(&p.mirrorStats.updateOperations, uint32(len(chunks)))
+	return nil
+}
+
+// mirrorAggregation returns aggregation of metric, method is replaced by mirror-aggregation
+func mirrorAggregation(storage *storageConfig, metric string, mirror *Mirror) (*whisperAggregationItem, error) {
+	aggr := storage.aggregation.match(metric)
+	if aggr == nil {
+		return nil, fmt.Errorf("No storage aggregation defined for %s", metric)
+	}
+	if mirror.AggregationMethod != "" {
+		item := *aggr
+		item.setMethod(mirror.AggregationMethod)
+		aggr = &item
+	}
+	return aggr, nil
+}
+
+// aggregateFunc returns function computing value of point of the first archive by method of item
+func (item *whisperAggregationItem) aggregateFunc() aggregateFunc {
+	if item.preAggregation != nil {
+		return item.preAggregation.aggregate
+	}
+
+	switch item.aggregationMethod {
+	case whisper.Average:
+		return func(values []float64) float64 {
+			return sumValues(values) / float64(len(values))
+		}
+	case whisper.Sum:
+		return sumValues
+	case whisper.First:
+		return func(values []float64) float64 {
+			return values[0]
+		}
+	case whisper.Max:
+		return func(values []float64) float64 {
+			max := values[0]
+			for _, v := range values[1:] {
+				max = math.Max(max, v)
+			}
+			return max
+		}
+	case whisper.Min:
+		return func(values []float64) float64 {
+			min := values[0]
+			for _, v := range values[1:] {
+				min = math.Min(min, v)
+			}
+			return min
+		}
+	}
+	return func(values []float64) float64 {
+		return values[len(values)-1]
+	}
+}
+
+func sumValues(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// createMirror creates mirror file with aggregation aggr. Called by openOrCreate under lock of path
+func (p *Whisper) createMirror(metric string, path string, mirror *Mirror, aggr *whisperAggregationItem) (WhisperFile, error) {
+	if err := p.mkdirAll(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("Failed to create directory of %s: %s", path, err.Error())
+	}
+
+	w, err := p.createOpener.Create(path, mirror.Retentions, aggr.aggregationMethod, float32(aggr.xFilesFactor), p.sparse)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new whisper file %s: %s", path, err.Error())
+	}
+
+	if err = p.applyOwnership(path, p.fileMode); err != nil {
+		p.log.Errorf("[persister] Failed to set permissions of new whisper file %s: %s", path, err.Error())
+	}
+	p.writeTaggedName(w, metric, path)
+	return w, nil
+}
+
+func (p *Whisper) mirrorStat(send helper.StatCallback) {
+	if !p.loadStorageConfig().mirrors {
+		return
+	}
+	helper.SendAndSubstractUint32("mirror.updateOperations", &p.mirrorStats.updateOperations, send)
+	helper.SendAndSubstractUint32("mirror.committedPoints", &p.mirrorStats.committedPoints, send)
+	helper.SendAndSubstractUint32("mirror.created", &p.mirrorStats.created, send)
+	helper.SendAndSubstractUint32("mirror.errors", &p.mirrorStats.errors, send)
+	helper.SendAndSubstractUint32("mirror.throttled", &p.mirrorStats.throttled, send)
+}
@@ -0,0 +1,95 @@
+package persister
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMirror(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas, err := parseSchemas(t, `
+[billing]
+pattern = ^billing\.
+retentions = 60s:1d
+mirror-root = /data/archive/
+mirror-retentions = 1h:10y
+mirror-aggregation = maximum
+
+[default]
+pattern = .*
+retentions = 60s:1d
+`)
+	if assert.NoError(err) && assert.Len(schemas, 2) {
+		if m := schemas[0].Mirror; assert.NotNil(m) {
+			assert.Equal("/data/archive/", m.Root)
+			assert.Equal("1h:10y", m.RetentionStr)
+			assert.Equal(3600, m.Retentions[0].SecondsPerPoint())
+			assert.Equal("maximum", m.AggregationMethod)
+		}
+		assert.Nil(schemas[1].Mirror)
+		assert.True(schemas.hasMirrors())
+		assert.False(schemas[1:].hasMirrors())
+	}
+
+	for name, mirror := range map[string]string{
+		"no retentions":           "mirror-root = /archive",
+		"bad retentions":          "mirror-root = /archive\nmirror-retentions = 1h",
+		"unknown aggregation":     "mirror-root = /archive\nmirror-retentions = 1h:1y\nmirror-aggregation = foo",
+		"pre-aggregation of file": "mirror-root = /archive\nmirror-retentions = 1h:1y\nmirror-aggregation = p95",
+	} {
+		_, err := parseSchemas(t, "[billing]\npattern = .*\nretentions = 60s:1d\n"+mirror+"\n")
+		assert.Error(err, name)
+	}
+}
+
+func TestWriteMirror(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1d")
+		mirrorRetentions, _ := ParseRetentionDefs("1h:30d")
+		for _, test := range []struct {
+			method   string
+			expected float64
+		}{
+			{"", 2}, // average of primary file
+			{"sum", 6},
+			{"max", 3},
+			{"min", 1},
+			{"last", 2},
+		} {
+			archive := filepath.Join(root, "archive"+test.method)
+			schemas := WhisperSchemas{
+				Schema{Name: "billing", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1d", Retentions: retentions,
+					Mirror: &Mirror{Root: archive, RetentionStr: "1h:30d", Retentions: mirrorRetentions, AggregationMethod: test.method}},
+			}
+			p := NewWhisper(filepath.Join(root, "whisper"), schemas, NewWhisperAggregation(), nil, nil)
+
+			// start of previous hour
+			now := time.Now().Unix()
+			now -= now%3600 + 3600
+			if !assert.NoError(store(p, points.OnePoint("a", 1, now).Add(3, now+60).Add(2, now+120)), test.method) {
+				continue
+			}
+
+			w, err := whisper.Open(filepath.Join(archive, "a.wsp"))
+			if !assert.NoError(err, test.method) {
+				continue
+			}
+			series, err := w.Fetch(int(now-1), int(now+3599))
+			w.Close()
+			if assert.NoError(err, test.method) {
+				assert.Equal([]float64{test.expected}, series.Values(), test.method)
+				assert.Equal(int(now), series.FromTime(), test.method)
+			}
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package persister
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+)
+
+const (
+	// maxNamespaces is count of namespaces with own stats, points of others are counted by namespaceOther
+	maxNamespaces  = 1000
+	namespaceOther = "_other"
+)
+
+// SetNamespaceDepth sets namespace of metric to first n segments of name (e.g. tenant prefix). Metrics of one
+// namespace are written by one worker: values are sharded by namespace with shard func (combined with its
+// segments if set). 0 - disabled
+func (p *Whisper) SetNamespaceDepth(n int) {
+	p.namespaceDepth = n
+}
+
+// SetNamespaceStats enables persister.namespace.<namespace>.committedPoints and updateOperations metrics.
+// Dots of namespace are replaced by "_". Up to 1000 namespaces have own metrics, others are counted by
+// namespace "_other". Requires namespace depth
+func (p *Whisper) SetNamespaceStats(enabled bool) {
+	p.namespaceStatsEnabled = enabled
+}
+
+// namespaceShard returns shard func which keeps namespace on one worker
+func (p *Whisper) namespaceShard(shard ShardFunc) ShardFunc {
+	if p.namespaceDepth <= 0 {
+		return shard
+	}
+	return PrefixShard(p.namespaceDepth, shard)
+}
+
+// namespace returns first segments of metric name without tags
+func namespace(metric string, depth int) string {
+	if i := strings.IndexByte(metric, ';'); i >= 0 {
+		metric = metric[:i]
+	}
+	return metricPrefix(metric, depth)
+}
+
+type namespaceCounters struct {
+	committedPoints  uint32 // counter
+	updateOperations uint32 // counter
+}
+
+// namespaceStats are counters of namespaces, safe for concurrent use by workers
+type namespaceStats struct {
+	sync.RWMutex
+	counters map[string]*namespaceCounters
+}
+
+// add counts points and updates of namespace. Counters are changed under read lock, so they are not removed
+// by stat concurrently
+func (s *namespaceStats) add(ns string, points int, updates int) {
+	s.RLock()
+	if c := s.counters[ns]; c != nil {
+		c.add(points, updates)
+		s.RUnlock()
+		return
+	}
+	s.RUnlock()
+
+	s.Lock()
+	defer s.Unlock()
+	if s.counters == nil {
+		s.counters = make(map[string]*namespaceCounters)
+	}
+	c := s.counters[ns]
+	if c == nil && len(s.counters) >= maxNamespaces {
+		ns = namespaceOther
+		c = s.counters[ns]
+	}
+	if c == nil {
+		c = &namespaceCounters{}
+		s.counters[ns] = c
+	}
+	c.add(points, updates)
+}
+
+func (c *namespaceCounters) add(points int, updates int) {
+	atomic.AddUint32(&c.committedPoints, uint32(points))
+	atomic.AddUint32(&c.updateOperations, uint32(updates))
+}
+
+// namespaceStored counts written points and updates of metric
+func (p *Whisper) namespaceStored(metric string, points int, updates int) {
+	if !p.namespaceStatsEnabled || p.namespaceDepth <= 0 {
+		return
+	}
+	p.namespaceStats.add(namespace(metric, p.namespaceDepth), points, updates)
+}
+
+// namespaceStat sends counters of namespaces. Namespaces without writes since previous stat are removed after send
+func (p *Whisper) namespaceStat(send helper.StatCallback) {
+	if !p.namespaceStatsEnabled || p.namespaceDepth <= 0 {
+		return
+	}
+
+	// counters are taken under lock, send may block
+	s := &p.namespaceStats
+	s.Lock()
+	taken := make(map[string]namespaceCounters, len(s.counters))
+	for ns, c := range s.counters {
+		committedPoints := atomic.SwapUint32(&c.committedPoints, 0)
+		updateOperations := atomic.SwapUint32(&c.updateOperations, 0)
+		if committedPoints == 0 && updateOperations == 0 {
+			delete(s.counters, ns)
+		}
+		taken[ns] = namespaceCounters{committedPoints: committedPoints, updateOperations: updateOperations}
+	}
+	s.Unlock()
+
+	for ns, c := range taken {
+		prefix := "namespace." + strings.Replace(ns, ".", "_", -1) + "."
+		send(prefix+"committedPoints", float64(c.committedPoints))
+		send(prefix+"updateOperations", float64(c.updateOperations))
+	}
+}
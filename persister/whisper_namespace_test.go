@@ -0,0 +1,95 @@
+package persister
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("tenant1.app", namespace("tenant1.app.host.cpu", 2))
+	assert.Equal("tenant1", namespace("tenant1.app.host.cpu", 1))
+	assert.Equal("tenant1.app", namespace("tenant1.app;tag=a.b", 3))
+	assert.Equal("cpu", namespace("cpu", 2))
+}
+
+func TestNamespaceShard(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	assert.Equal(CRC32Shard("a.b.c", 16), p.namespaceShard(CRC32Shard)("a.b.c", 16))
+
+	p.SetNamespaceDepth(1)
+	shard := p.namespaceShard(CRC32Shard)
+	for i := 0; i < 100; i++ {
+		assert.Equal(CRC32Shard("tenant", 16), shard(fmt.Sprintf("tenant.m%d", i), 16))
+	}
+
+	// combined with segments of shard func
+	shard = p.namespaceShard(PrefixShard(2, CRC32Shard))
+	assert.Equal(CRC32Shard("tenant", 16), shard("tenant.a.b", 16))
+}
+
+func TestNamespaceStats(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.SetWorkers(4)
+	p.SetNamespaceDepth(2)
+	p.SetNamespaceStats(true)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	now := time.Now().Unix()
+	in <- &points.Points{Metric: "t1.app.a", Data: []points.Point{{Value: 1, Timestamp: now - 1}, {Value: 2, Timestamp: now}}}
+	in <- points.OnePoint("t1.app.b", 1, now)
+	in <- points.OnePoint("t2.app.a", 1, now)
+	for i := 0; i < 3; i++ {
+		<-confirm
+	}
+
+	stat := func() map[string]float64 {
+		result := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			result[metric] = value
+		})
+		return result
+	}
+
+	s := stat()
+	assert.Equal(float64(3), s["namespace.t1_app.committedPoints"])
+	assert.Equal(float64(2), s["namespace.t1_app.updateOperations"])
+	assert.Equal(float64(1), s["namespace.t2_app.committedPoints"])
+
+	// sent with zero once, then removed
+	s = stat()
+	assert.Equal(float64(0), s["namespace.t1_app.committedPoints"])
+	_, ok := stat()["namespace.t1_app.committedPoints"]
+	assert.False(ok)
+
+	// counters are not locked during send
+	p.namespaceStored("t3.app.a", 1, 1)
+	p.Stat(func(metric string, value float64) {
+		p.namespaceStored("t3.app.a", 1, 1)
+	})
+	assert.True(stat()["namespace.t3_app.committedPoints"] > 1)
+}
+
+func TestNamespaceStatsLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	var s namespaceStats
+	for i := 0; i < maxNamespaces+10; i++ {
+		s.add(fmt.Sprintf("ns%d", i), 1, 1)
+	}
+	assert.Len(s.counters, maxNamespaces+1)
+	assert.Equal(uint32(10), s.counters[namespaceOther].committedPoints)
+}
@@ -0,0 +1,49 @@
+package persister
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// SetStoreNonFinite enables store of NaN and ±Inf values. By default they are dropped (persister.nonFinitePoints
+// metric): NaN is empty point for whisper readers and Inf breaks rendering of graphs
+func (p *Whisper) SetStoreNonFinite(enabled bool) {
+	p.storeNonFinite = enabled
+}
+
+// dropNonFinite returns finite points of data. Source slice is not modified because it is still visible
+// for carbonlink until confirmed
+func (p *Whisper) dropNonFinite(data []points.Point) []points.Point {
+	if p.storeNonFinite {
+		return data
+	}
+
+	var result []points.Point
+	for i, d := range data {
+		if !math.IsNaN(d.Value) && !math.IsInf(d.Value, 0) {
+			if result != nil {
+				result = append(result, d)
+			}
+			continue
+		}
+		if result == nil {
+			result = make([]points.Point, i, len(data))
+			copy(result, data[:i])
+		}
+	}
+	if result == nil {
+		return data
+	}
+
+	atomic.AddUint32(&p.nonFinitePoints, uint32(len(data)-len(result)))
+	return result
+}
+
+func (p *Whisper) nonFiniteStat(send helper.StatCallback) {
+	if !p.storeNonFinite {
+		helper.SendAndSubstractUint32("nonFinitePoints", &p.nonFinitePoints, send)
+	}
+}
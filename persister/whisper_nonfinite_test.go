@@ -0,0 +1,53 @@
+package persister
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropNonFinite(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	p.SetCreateOpener(slowCreateOpener{})
+
+	now := time.Now().Unix()
+	values := &points.Points{Metric: "foo", Data: []points.Point{
+		{Value: 1, Timestamp: now - 3},
+		{Value: math.NaN(), Timestamp: now - 2},
+		{Value: math.Inf(1), Timestamp: now - 1},
+		{Value: math.Inf(-1), Timestamp: now},
+	}}
+
+	assert.Equal([]points.Point{{Value: 1, Timestamp: now - 3}}, p.dropNonFinite(values.Data))
+	// source is not modified
+	assert.True(math.IsNaN(values.Data[1].Value))
+
+	assert.NoError(store(p, values))
+	assert.NoError(store(p, points.OnePoint("bar", math.NaN(), now)))
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	// counted by direct call too
+	assert.Equal(float64(7), stat["nonFinitePoints"])
+	assert.Equal(float64(1), stat["committedPoints"])
+
+	// stored as received
+	p.SetStoreNonFinite(true)
+	assert.Len(p.dropNonFinite(values.Data), 4)
+	assert.NoError(store(p, values))
+
+	stat = make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	_, ok := stat["nonFinitePoints"]
+	assert.False(ok)
+	assert.Equal(float64(4), stat["committedPoints"])
+}
@@ -0,0 +1,68 @@
+package persister
+
+import (
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+)
+
+// onCreateQueueSize is count of notifications about created files waiting for callback of SetOnCreate
+const onCreateQueueSize = 10000
+
+type createdFile struct {
+	metric      string
+	path        string
+	schema      string
+	aggregation string
+}
+
+// SetOnCreate sets callback called on every created whisper file with metric name, path and names of matched
+// schema and aggregation sections, e.g. for external catalog of metrics. Callback is called by goroutine of
+// persister from queue of 10000 files, files created when queue is full are not passed to it
+// (persister.onCreateDropped metric), so slow callback doesn't block writes. Set before Start. nil - disabled
+func (p *Whisper) SetOnCreate(fn func(metric, path string, schema, aggregation string)) {
+	p.onCreate = fn
+}
+
+// notifyCreated queues created file for callback of SetOnCreate, file is dropped if queue is full
+func (p *Whisper) notifyCreated(file createdFile) {
+	queue := p.onCreateQueue
+	if queue == nil {
+		return
+	}
+	select {
+	case queue <- file:
+	default:
+		atomic.AddUint32(&p.onCreateDropped, 1)
+	}
+}
+
+// onCreateNotifier calls callback for queued files. Files queued before exit are passed too, files created
+// by drain of workers after it may be not
+func (p *Whisper) onCreateNotifier(fn func(metric, path string, schema, aggregation string), queue chan createdFile, exit chan bool) {
+	call := func(f createdFile) {
+		fn(f.metric, f.path, f.schema, f.aggregation)
+	}
+
+	for {
+		select {
+		case <-exit:
+			for {
+				select {
+				case f := <-queue:
+					call(f)
+				default:
+					return
+				}
+			}
+		case f := <-queue:
+			call(f)
+		}
+	}
+}
+
+func (p *Whisper) onCreateStat(send helper.StatCallback) {
+	if p.onCreate != nil {
+		helper.SendAndSubstractUint32("onCreateDropped", &p.onCreateDropped, send)
+	}
+}
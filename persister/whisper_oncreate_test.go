@@ -0,0 +1,39 @@
+package persister
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnCreateQueueFull(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	release := make(chan bool)
+	called := make(chan string, onCreateQueueSize+10)
+	p.SetOnCreate(func(metric, path string, schema, aggregation string) {
+		<-release
+		called <- metric
+	})
+	assert.NoError(p.Start())
+
+	p.notifyCreated(createdFile{metric: "first"})
+	for i := 0; i < onCreateQueueSize+10; i++ {
+		p.notifyCreated(createdFile{metric: "a.b"})
+	}
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	// one may be taken by blocked callback
+	dropped := int(stat["onCreateDropped"])
+	assert.True(dropped == 10 || dropped == 11, "%d", dropped)
+
+	// queued before stop are passed
+	close(release)
+	p.Stop()
+	assert.Equal(onCreateQueueSize+11-dropped, len(called))
+	assert.Equal("first", <-called)
+}
@@ -0,0 +1,108 @@
+package persister
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lomik/go-carbon/helper"
+)
+
+// oneShotLogSample is max count of one-shot metric names logged by every Stat
+const oneShotLogSample = 10
+
+// SetOneShotTracking enables tracking of created whisper files without further updates during window: count of
+// them is reported by persister.oneShotMetrics, sample of names is logged at debug level. Clients spraying unique
+// metric names are found by it. At most maxTracked created metrics are tracked at once, others are not counted.
+// 0 window - disabled
+func (p *Whisper) SetOneShotTracking(window time.Duration, maxTracked int) {
+	if window <= 0 || maxTracked <= 0 {
+		p.oneShot = nil
+		return
+	}
+	p.oneShot = &oneShotTracker{
+		window:  window,
+		max:     maxTracked,
+		created: make(map[string]time.Time),
+	}
+}
+
+// oneShotTracker keeps creation time of metrics not updated since creation
+type oneShotTracker struct {
+	sync.Mutex
+	window  time.Duration
+	max     int
+	created map[string]time.Time
+}
+
+// add starts tracking of created metric. Ignored if tracker is full
+func (t *oneShotTracker) add(metric string, now time.Time) {
+	t.Lock()
+	if len(t.created) < t.max {
+		t.created[metric] = now
+	}
+	t.Unlock()
+}
+
+// updated stops tracking of metric updated again
+func (t *oneShotTracker) updated(metric string) {
+	t.Lock()
+	delete(t.created, metric)
+	t.Unlock()
+}
+
+// expire removes metrics created before now - window and returns their count with up to sample names
+func (t *oneShotTracker) expire(now time.Time, sample int) (int, []string) {
+	deadline := now.Add(-t.window)
+
+	t.Lock()
+	defer t.Unlock()
+
+	count := 0
+	var names []string
+	for metric, created := range t.created {
+		if created.After(deadline) {
+			continue
+		}
+		delete(t.created, metric)
+		count++
+		if len(names) < sample {
+			names = append(names, metric)
+		}
+	}
+	return count, names
+}
+
+func (t *oneShotTracker) len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.created)
+}
+
+// trackCreated starts tracking of created metric if enabled
+func (p *Whisper) trackCreated(metric string) {
+	if p.oneShot != nil {
+		p.oneShot.add(metric, p.now())
+	}
+}
+
+// trackUpdated stops tracking of metric stored again if enabled
+func (p *Whisper) trackUpdated(metric string) {
+	if p.oneShot != nil {
+		p.oneShot.updated(metric)
+	}
+}
+
+func (p *Whisper) oneShotStat(send helper.StatCallback) {
+	if p.oneShot == nil {
+		return
+	}
+
+	count, names := p.oneShot.expire(p.now(), oneShotLogSample)
+	if count > 0 {
+		logrus.WithField("sample", names).Debugf("[persister] %d metrics not updated during %s after creation", count, p.oneShot.window.String())
+	}
+	send("oneShotMetrics", float64(count))
+	send("oneShotTracked", float64(p.oneShot.len()))
+}
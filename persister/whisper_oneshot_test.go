@@ -0,0 +1,59 @@
+package persister
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOneShotMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: retentions},
+		}
+
+		now := time.Now()
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.nowFunc = func() time.Time { return now }
+		p.SetOneShotTracking(time.Minute, 2)
+
+		stat := func() map[string]float64 {
+			result := make(map[string]float64)
+			p.Stat(func(metric string, value float64) {
+				result[metric] = value
+			})
+			return result
+		}
+
+		ts := now.Unix()
+		assert.NoError(store(p, points.OnePoint("once", 1, ts)))
+		assert.NoError(store(p, points.OnePoint("twice", 1, ts)))
+		// tracker is full
+		assert.NoError(store(p, points.OnePoint("untracked", 1, ts)))
+		assert.NoError(store(p, points.OnePoint("twice", 2, ts+1)))
+
+		s := stat()
+		assert.Equal(float64(0), s["oneShotMetrics"])
+		assert.Equal(float64(1), s["oneShotTracked"])
+
+		now = now.Add(time.Minute)
+		s = stat()
+		assert.Equal(float64(1), s["oneShotMetrics"])
+		assert.Equal(float64(0), s["oneShotTracked"])
+
+		// counted once
+		assert.Equal(float64(0), stat()["oneShotMetrics"])
+
+		// disabled
+		p.SetOneShotTracking(0, 2)
+		_, ok := stat()["oneShotMetrics"]
+		assert.False(ok)
+	})
+}
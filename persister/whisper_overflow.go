@@ -0,0 +1,102 @@
+package persister
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// OverflowPolicy defines what shuffler does with values for worker with full channel
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for worker, so reading from cache is paused (backpressure)
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest values queued to worker, so fresh data wins
+	OverflowDropOldest
+	// OverflowDropNewest drops incoming values
+	OverflowDropNewest
+)
+
+// ParseOverflowPolicy parses policy name: "block", "drop-oldest" or "drop-newest"
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "block":
+		return OverflowBlock, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "drop-newest":
+		return OverflowDropNewest, nil
+	default:
+		return OverflowBlock, fmt.Errorf("Unknown overflow policy '%s', should be one of: block, drop-oldest, drop-newest", s)
+	}
+}
+
+// SetOverflowPolicy sets handling of full worker channels. Values: "block" (default), "drop-oldest", "drop-newest".
+// Dropped values are confirmed to cache as written. Applied by shuffler only, solo worker reads input directly.
+// Channel of shuffler is filled at rate of max-updates-per-second if it is set: drop policies drop values
+// throttled by ThrottleChan only if workers are slower than throttled rate
+func (p *Whisper) SetOverflowPolicy(s string) error {
+	policy, err := ParseOverflowPolicy(s)
+	if err != nil {
+		return err
+	}
+	p.overflowPolicy = policy
+	return nil
+}
+
+// sendToWorker sends values to worker channel by overflow policy
+func (p *Whisper) sendToWorker(ch chan *points.Points, values *points.Points) {
+	select {
+	case ch <- values:
+		return
+	default:
+	}
+
+	switch p.overflowPolicy {
+	case OverflowDropNewest:
+		atomic.AddUint32(&p.overflowDroppedNewest, 1)
+		p.discard(values)
+	case OverflowDropOldest:
+		for {
+			select {
+			case ch <- values:
+				return
+			default:
+			}
+			// worker could take values meanwhile
+			select {
+			case old := <-ch:
+				atomic.AddUint32(&p.overflowDroppedOldest, 1)
+				p.discard(old)
+			default:
+			}
+		}
+	default:
+		atomic.AddUint32(&p.overflowBlocked, 1)
+		ch <- values
+	}
+}
+
+// discard confirms values which are not written
+func (p *Whisper) discard(values *points.Points) {
+	if p.wal != nil {
+		p.wal.release(values)
+	}
+	if p.confirm != nil {
+		p.confirm <- values
+	}
+}
+
+func (p *Whisper) overflowStat(send helper.StatCallback) {
+	switch p.overflowPolicy {
+	case OverflowDropOldest:
+		helper.SendAndSubstractUint32("overflowDroppedOldest", &p.overflowDroppedOldest, send)
+	case OverflowDropNewest:
+		helper.SendAndSubstractUint32("overflowDroppedNewest", &p.overflowDroppedNewest, send)
+	default:
+		helper.SendAndSubstractUint32("overflowBlocked", &p.overflowBlocked, send)
+	}
+}
@@ -0,0 +1,71 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	a := points.OnePoint("a", 1, 10)
+	b := points.OnePoint("b", 1, 10)
+
+	newWorkerChan := func() chan *points.Points {
+		ch := make(chan *points.Points, 1)
+		ch <- a
+		return ch
+	}
+
+	stat := func(p *Whisper) map[string]float64 {
+		result := make(map[string]float64)
+		p.overflowStat(func(metric string, value float64) {
+			result[metric] = value
+		})
+		return result
+	}
+
+	// drop-newest
+	confirm := make(chan *points.Points, 1)
+	p := NewWhisper("", nil, nil, nil, confirm)
+	assert.NoError(p.SetOverflowPolicy("drop-newest"))
+	ch := newWorkerChan()
+	p.sendToWorker(ch, b)
+	assert.Equal(a, <-ch)
+	assert.Equal(b, <-confirm)
+	assert.Equal(map[string]float64{"overflowDroppedNewest": 1}, stat(p))
+
+	// drop-oldest
+	p = NewWhisper("", nil, nil, nil, confirm)
+	assert.NoError(p.SetOverflowPolicy("drop-oldest"))
+	ch = newWorkerChan()
+	p.sendToWorker(ch, b)
+	assert.Equal(b, <-ch)
+	assert.Equal(a, <-confirm)
+	assert.Equal(map[string]float64{"overflowDroppedOldest": 1}, stat(p))
+
+	// block
+	p = NewWhisper("", nil, nil, nil, confirm)
+	ch = newWorkerChan()
+	sent := make(chan bool)
+	go func() {
+		p.sendToWorker(ch, b)
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("not blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.Equal(a, <-ch)
+	<-sent
+	assert.Equal(b, <-ch)
+	assert.Len(confirm, 0)
+	assert.Equal(map[string]float64{"overflowBlocked": 1}, stat(p))
+
+	assert.Error(p.SetOverflowPolicy("drop-random"))
+}
@@ -0,0 +1,70 @@
+package persister
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SetFileMode sets permissions of new whisper files and directories. 0 - default (0777 & ~umask for directories,
+// go-whisper default for files)
+func (p *Whisper) SetFileMode(dirMode, fileMode os.FileMode) {
+	p.dirMode = dirMode
+	p.fileMode = fileMode
+}
+
+// SetOwner enables chown of new whisper files and directories. -1 - don't change uid or gid
+func (p *Whisper) SetOwner(uid, gid int) {
+	p.uid = uid
+	p.gid = gid
+	p.chown = uid != -1 || gid != -1
+}
+
+// mkdirAll creates dir with parents. Permissions and owner are applied to created directories only
+func (p *Whisper) mkdirAll(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := p.createOpener.Stat(d); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		created = append(created, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if len(created) == 0 {
+		return nil
+	}
+
+	mode := p.dirMode
+	if mode == 0 {
+		mode = os.ModePerm
+	}
+
+	if err := p.createOpener.MkdirAll(dir, os.ModeDir|mode); err != nil {
+		return err
+	}
+
+	// from parents to children
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := p.applyOwnership(created[i], p.dirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOwnership sets mode (if not 0) and owner (if enabled) of path. Umask is not applied
+func (p *Whisper) applyOwnership(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if p.chown {
+		if err := os.Chown(path, p.uid, p.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package persister
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMode(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetFileMode(0750, 0640)
+		// chown to current owner is allowed for everyone
+		p.SetOwner(os.Getuid(), os.Getgid())
+
+		store(p, points.OnePoint("a.b.c", 1, time.Now().Unix()))
+
+		stat, err := os.Stat(filepath.Join(root, "a.wsp"))
+		assert.True(os.IsNotExist(err))
+
+		for _, dir := range []string{"a", "a/b"} {
+			stat, err = os.Stat(filepath.Join(root, dir))
+			if assert.NoError(err) {
+				assert.Equal(os.ModeDir|0750, stat.Mode(), dir)
+			}
+		}
+
+		stat, err = os.Stat(filepath.Join(root, "a/b/c.wsp"))
+		if assert.NoError(err) {
+			assert.Equal(os.FileMode(0640), stat.Mode())
+		}
+
+		// root is not changed
+		stat, err = os.Stat(root)
+		if assert.NoError(err) {
+			assert.NotEqual(os.ModeDir|0750, stat.Mode())
+		}
+	})
+}
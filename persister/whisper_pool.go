@@ -0,0 +1,65 @@
+package persister
+
+import "sort"
+
+// SetPools sets dedicated worker pools: pool name -> workers count. Metrics of schemas with "pool" in
+// storage-schemas.conf are written by workers of the pool, so slow writes of one namespace don't delay others.
+// Schemas without pool or with unknown pool use common workers
+func (p *Whisper) SetPools(pools map[string]int) {
+	p.pools = pools
+}
+
+// poolRange is part of shuffler out channels used by pool
+type poolRange struct {
+	offset int
+	count  int
+}
+
+// poolWorkers returns total count of workers in pools
+func (p *Whisper) poolWorkers() int {
+	var n int
+	for _, count := range p.pools {
+		if count > 0 {
+			n += count
+		}
+	}
+	return n
+}
+
+// poolRanges returns ranges of pools in out channels: common workers first, then pools sorted by name
+func (p *Whisper) poolRanges(channels int) map[string]poolRange {
+	if len(p.pools) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(p.pools))
+	for name, count := range p.pools {
+		if count > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ranges := make(map[string]poolRange, len(names))
+	offset := channels - p.poolWorkers()
+	for _, name := range names {
+		ranges[name] = poolRange{offset: offset, count: p.pools[name]}
+		offset += p.pools[name]
+	}
+	return ranges
+}
+
+// route returns range of out channels for metric
+func (p *Whisper) route(metric string, common poolRange, ranges map[string]poolRange) poolRange {
+	if len(ranges) == 0 {
+		return common
+	}
+	schema, ok := p.loadStorageConfig().schemas.Match(metric)
+	if !ok || schema.Pool == "" {
+		return common
+	}
+	if r, ok := ranges[schema.Pool]; ok {
+		return r
+	}
+	return common
+}
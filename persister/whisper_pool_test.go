@@ -0,0 +1,123 @@
+package persister
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchemasPool(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas := assertSchemas(t, `
+[noisy]
+pattern = ^noisy\.
+retentions = 60s:1d
+pool = noisy
+
+[default]
+pattern = .*
+retentions = 60s:30d
+	`,
+		[]testcase{
+			testcase{"noisy", "^noisy\\.", "60s:1d"},
+			testcase{"default", ".*", "60s:30d"},
+		},
+	)
+
+	if assert.Len(schemas, 2) {
+		assert.Equal("noisy", schemas[0].Pool)
+		assert.Equal("", schemas[1].Pool)
+	}
+}
+
+func TestPools(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas, err := parseSchemas(t, `
+[noisy]
+pattern = ^noisy\.
+retentions = 60s:1d
+pool = noisy
+
+[critical]
+pattern = ^critical\.
+retentions = 10s:1d
+pool = critical
+
+[unknown]
+pattern = ^unknown\.
+retentions = 60s:1d
+pool = unknown
+
+[default]
+pattern = .*
+retentions = 60s:30d
+	`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	p := NewWhisper("", schemas, NewWhisperAggregation(), nil, nil)
+	p.SetWorkers(2)
+	p.SetPools(map[string]int{"noisy": 2, "critical": 1})
+
+	// 2 common workers, then pools sorted by name: critical, noisy
+	in := make(chan *points.Points)
+	out := make([]chan *points.Points, 5)
+	received := make([]map[string]bool, len(out))
+
+	var wg sync.WaitGroup
+	for i := range out {
+		out[i] = make(chan *points.Points)
+		received[i] = make(map[string]bool)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for values := range out[i] {
+				received[i][values.Metric] = true
+			}
+		}(i)
+	}
+
+	go p.shuffler(in, out, nil, nil)
+
+	for _, prefix := range []string{"noisy", "critical", "unknown", "other"} {
+		for i := 0; i < 100; i++ {
+			in <- points.OnePoint(fmt.Sprintf("%s.metric%d", prefix, i), 1, 10)
+		}
+	}
+	close(in)
+	wg.Wait()
+
+	count := func(i int, prefix string) int {
+		var n int
+		for metric := range received[i] {
+			if strings.HasPrefix(metric, prefix+".") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// common workers
+	for i := 0; i < 2; i++ {
+		assert.Equal(0, count(i, "noisy"), i)
+		assert.Equal(0, count(i, "critical"), i)
+	}
+	assert.Equal(100, count(0, "unknown")+count(1, "unknown"))
+	assert.Equal(100, count(0, "other")+count(1, "other"))
+
+	// critical pool
+	assert.Equal(100, count(2, "critical"))
+	assert.Len(received[2], 100)
+
+	// noisy pool
+	assert.Equal(100, count(3, "noisy")+count(4, "noisy"))
+	assert.Len(received[3], count(3, "noisy"))
+	assert.Len(received[4], count(4, "noisy"))
+}
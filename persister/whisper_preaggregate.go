@@ -0,0 +1,107 @@
+package persister
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-whisper"
+)
+
+// aggregateFunc computes value of archive point from samples received for its interval
+type aggregateFunc func(values []float64) float64
+
+type pointsByTimestamp []points.Point
+
+func (v pointsByTimestamp) Len() int           { return len(v) }
+func (v pointsByTimestamp) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v pointsByTimestamp) Less(i, j int) bool { return v[i].Timestamp < v[j].Timestamp }
+
+// preAggregation is aggregation method not supported by whisper. Value of each point of the first archive
+// is computed by persister from samples of one update, lower archives are aggregated by whisper with native method
+type preAggregation struct {
+	aggregate aggregateFunc
+	native    whisper.AggregationMethod
+}
+
+// parsePreAggregation parses "count", "median" and percentiles "p0" ... "p100" (e.g. "p95", "p99.9").
+// Count is rolled up by whisper with sum, percentiles with max
+func parsePreAggregation(method string) (*preAggregation, bool) {
+	switch method {
+	case "count":
+		return &preAggregation{aggregate: countValues, native: whisper.Sum}, true
+	case "median":
+		return &preAggregation{aggregate: percentile(50), native: whisper.Max}, true
+	}
+
+	if !strings.HasPrefix(method, "p") {
+		return nil, false
+	}
+	rank, err := strconv.ParseFloat(method[1:], 64)
+	if err != nil || rank < 0 || rank > 100 {
+		return nil, false
+	}
+	return &preAggregation{aggregate: percentile(rank), native: whisper.Max}, true
+}
+
+func countValues(values []float64) float64 {
+	return float64(len(values))
+}
+
+// percentile returns nearest-rank percentile of values. Values are sorted inplace
+func percentile(rank float64) aggregateFunc {
+	return func(values []float64) float64 {
+		sort.Float64s(values)
+		i := int(math.Ceil(rank/100*float64(len(values)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i]
+	}
+}
+
+// apply groups data by intervals of step seconds and returns one point per interval with aggregated
+// value of its samples. Timestamp of point is start of interval. Source slice is not modified
+// because it is still visible for carbonlink until confirmed
+func (a *preAggregation) apply(data []points.Point, step int) []points.Point {
+	if step <= 0 || len(data) == 0 {
+		return data
+	}
+
+	sorted := make([]points.Point, len(data))
+	copy(sorted, data)
+	sort.Sort(pointsByTimestamp(sorted))
+
+	result := make([]points.Point, 0, len(sorted))
+	values := make([]float64, 0, len(sorted))
+
+	interval := func(timestamp int64) int64 {
+		return timestamp - timestamp%int64(step)
+	}
+
+	start := interval(sorted[0].Timestamp)
+	for _, d := range sorted {
+		if t := interval(d.Timestamp); t != start {
+			result = append(result, points.Point{Value: a.aggregate(values), Timestamp: start})
+			values = values[:0]
+			start = t
+		}
+		values = append(values, d.Value)
+	}
+	result = append(result, points.Point{Value: a.aggregate(values), Timestamp: start})
+
+	return result
+}
+
+// preAggregation returns pre-aggregation of metric or nil if whisper aggregates it natively
+func (a *WhisperAggregation) preAggregation(metric string) *preAggregation {
+	if a == nil || !a.preAggregated {
+		return nil
+	}
+	if item := a.match(metric); item != nil {
+		return item.preAggregation
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package persister
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePreAggregation(t *testing.T) {
+	assert := assert.New(t)
+
+	values := func() []float64 {
+		return []float64{5, 1, 4, 2, 3, 6, 10, 8, 9, 7}
+	}
+
+	table := []struct {
+		method string
+		native whisper.AggregationMethod
+		value  float64
+	}{
+		{"count", whisper.Sum, 10},
+		{"median", whisper.Max, 5},
+		{"p50", whisper.Max, 5},
+		{"p95", whisper.Max, 10},
+		{"p90", whisper.Max, 9},
+		{"p0", whisper.Max, 1},
+		{"p100", whisper.Max, 10},
+		{"p99.9", whisper.Max, 10},
+	}
+
+	for _, test := range table {
+		pre, ok := parsePreAggregation(test.method)
+		if assert.True(ok, test.method) {
+			assert.Equal(test.native, pre.native, test.method)
+			assert.Equal(test.value, pre.aggregate(values()), test.method)
+		}
+	}
+
+	for _, method := range []string{"", "p", "p101", "p-1", "pfoo", "average", "sum"} {
+		_, ok := parsePreAggregation(method)
+		assert.False(ok, method)
+	}
+}
+
+func TestPreAggregationApply(t *testing.T) {
+	assert := assert.New(t)
+
+	pre, _ := parsePreAggregation("count")
+
+	data := []points.Point{
+		{Value: 1, Timestamp: 125},
+		{Value: 1, Timestamp: 60},
+		{Value: 1, Timestamp: 61},
+		{Value: 1, Timestamp: 61},
+		{Value: 1, Timestamp: 119},
+		{Value: 1, Timestamp: 120},
+	}
+
+	assert.Equal([]points.Point{
+		{Value: 4, Timestamp: 60},
+		{Value: 2, Timestamp: 120},
+	}, pre.apply(data, 60))
+
+	// source is not modified
+	assert.Equal(int64(125), data[0].Timestamp)
+
+	assert.Equal(data, pre.apply(data, 0))
+}
+
+func TestReadWhisperAggregationPre(t *testing.T) {
+	assert := assert.New(t)
+
+	aggr, err := parseAggregation(t, `
+[latency]
+pattern = \.latency$
+aggregationMethod = p95
+
+[default]
+pattern = .*
+aggregationMethod = avg
+`)
+
+	if assert.NoError(err) && assert.Len(aggr.Data, 2) {
+		assert.True(aggr.preAggregated)
+		assert.Equal(whisper.Max, aggr.match("foo.latency").aggregationMethod)
+		assert.NotNil(aggr.preAggregation("foo.latency"))
+		assert.Nil(aggr.preAggregation("foo.bar"))
+	}
+
+	assert.Nil(NewWhisperAggregation().preAggregation("foo.bar"))
+}
+
+func TestStorePreAggregated(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("10s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "10s:1h", Retentions: retentions},
+		}
+
+		aggr := NewWhisperAggregation()
+		aggr.Default.preAggregation, _ = parsePreAggregation("p50")
+		aggr.preAggregated = true
+
+		p := NewWhisper(root, schemas, aggr, nil, nil)
+
+		now := time.Now().Unix()
+		now -= now % 10
+		values := points.OnePoint("latency", 3, now)
+		values.Add(1, now+1)
+		values.Add(2, now+2)
+		values.Add(100, now-10)
+		store(p, values)
+
+		w, err := whisper.Open(filepath.Join(root, "latency.wsp"))
+		if !assert.NoError(err) {
+			return
+		}
+		defer w.Close()
+
+		series, err := w.Fetch(int(now-20), int(now+10))
+		if assert.NoError(err) {
+			assert.Equal([]float64{100, 2}, series.Values()[len(series.Values())-2:])
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package persister
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// precreateRetryInterval is wait of Precreate after throttled create
+	precreateRetryInterval = 10 * time.Millisecond
+	// precreateThrottleTimeout limits wait of Precreate for limiter per metric
+	precreateThrottleTimeout = 10 * time.Second
+)
+
+// Precreate creates whisper files of metrics without points, e.g. for tree of new node before switch of traffic.
+// Names are normalized and validated, schema and aggregation are matched like for received points. Existing files and
+// dropped metrics are skipped. Creates are limited by max creates per second, Precreate waits for limiter up to 10s
+// per metric, wait of started persister is interrupted by Stop. Returns error with count of failed metrics, each of
+// them is logged, so it serves as smoke test of schemas over inventory. Files are not created while persister is
+// frozen or after Stop, such metrics are failed without log
+func (p *Whisper) Precreate(metrics []string) error {
+	var created, skipped, failed int
+	var first error
+
+	p.RLock()
+	exit := p.exit
+	p.RUnlock()
+
+	for _, metric := range metrics {
+		ok, err := p.precreate(metric, exit)
+		switch {
+		case err == errFrozen || err == errNotStarted:
+			failed++
+			if first == nil {
+				first = err
+			}
+		case err != nil:
+			failed++
+			if first == nil {
+				first = err
+			}
+			p.log.Errorf("[persister] Failed to precreate whisper file of %s: %s", metric, err.Error())
+		case ok:
+			created++
+		default:
+			skipped++
+		}
+	}
+
+	logrus.Infof("[persister] Precreated %d whisper files, %d skipped, %d failed", created, skipped, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d metrics not precreated, first error: %s", failed, len(metrics), first.Error())
+	}
+	return nil
+}
+
+// precreate returns true if file of metric is created, false if it is skipped. Throttled create is retried
+// up to precreateThrottleTimeout, until Stop of started persister
+func (p *Whisper) precreate(metric string, exit chan bool) (bool, error) {
+	if p.nameNormalizer != nil {
+		metric = p.nameNormalizer(metric)
+	}
+
+	if err := p.validateName(metric); err != nil {
+		return false, err
+	}
+
+	if d, _ := p.dropList.Load().(*DropList); d.Match(metric) {
+		return false, nil
+	}
+
+	path, _, err := p.metricPath(metric)
+	if err != nil {
+		return false, fmt.Errorf("Bad metric name %#v: %s", metric, err.Error())
+	}
+
+	w, err := p.createOpener.Open(path)
+	if err == nil {
+		w.Close()
+		return false, nil
+	}
+	if !p.fileNotExists(path) {
+		return false, fmt.Errorf("Failed to open whisper file %s: %s", path, err.Error())
+	}
+
+	schema, aggr, err := p.matchStorage(metric, path)
+	if err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(precreateThrottleTimeout)
+	for {
+		created, err := p.precreateFile(metric, path, schema, aggr)
+		if err != errCreateThrottled || time.Now().After(deadline) {
+			return created, err
+		}
+
+		select {
+		case <-exit:
+			return false, errNotStarted
+		case <-time.After(precreateRetryInterval):
+		}
+	}
+}
+
+// precreateFile creates file if it is not created meanwhile
+func (p *Whisper) precreateFile(metric string, path string, schema Schema, aggr *whisperAggregationItem) (bool, error) {
+	if !p.beginSideWrite() {
+		return false, errFrozen
+	}
+	defer p.endSideWrite()
+
+	w, created, err := p.createExclusive(metric, path, schema, aggr)
+	if err != nil {
+		return false, err
+	}
+	w.Close()
+	return created, nil
+}
@@ -0,0 +1,142 @@
+package persister
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	whisper "github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecreate(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h,1h:1d")
+		schemas := WhisperSchemas{
+			Schema{Name: "hourly", Pattern: regexp.MustCompile(`^a\.`), RetentionStr: "60s:1h,1h:1d", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		assert.NoError(p.Precreate([]string{"a.b", "a.c.d", "a.b"}))
+
+		w, err := whisper.Open(filepath.Join(root, "a", "c", "d.wsp"))
+		if assert.NoError(err) {
+			assert.Len(w.Retentions(), 2)
+			assert.Equal(60, w.Retentions()[0].SecondsPerPoint())
+			series, err := w.Fetch(int(time.Now().Unix())-600, int(time.Now().Unix()))
+			assert.NoError(err)
+			for _, v := range series.Values() {
+				assert.True(v != v, "point in precreated file")
+			}
+			w.Close()
+		}
+		assert.Equal(uint32(2), p.created)
+
+		// existing file is not created again
+		assert.NoError(p.Precreate([]string{"a.b"}))
+		assert.Equal(uint32(2), p.created)
+
+		// no schema and bad name
+		err = p.Precreate([]string{"x.y", "a..b", "a.e"})
+		if assert.Error(err) {
+			assert.Contains(err.Error(), "2 of 3")
+			assert.Contains(err.Error(), "x.y")
+		}
+		_, err = os.Stat(filepath.Join(root, "x", "y.wsp"))
+		assert.True(os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(root, "a", "e.wsp"))
+		assert.NoError(err)
+	})
+}
+
+func TestPrecreateThrottled(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "hourly", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetMaxCreatesPerSecond(2)
+
+		start := time.Now()
+		assert.NoError(p.Precreate([]string{"a.b1", "a.b2", "a.b3", "a.b4", "a.b5"}))
+		assert.True(time.Since(start) > time.Second, "creates are not throttled")
+		assert.Equal(uint32(5), p.created)
+	})
+}
+
+func TestPrecreateStopped(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "hourly", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), make(chan *points.Points), make(chan *points.Points))
+		p.SetMaxCreatesPerSecond(1)
+		assert.NoError(p.Start())
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			p.Stop()
+		}()
+
+		// wait for limiter is interrupted by Stop
+		start := time.Now()
+		err := p.Precreate([]string{"a.b1", "a.b2", "a.b3", "a.b4", "a.b5"})
+		assert.True(time.Since(start) < time.Second, "wait is not interrupted")
+		if assert.Error(err) {
+			assert.Contains(err.Error(), errNotStarted.Error())
+		}
+		assert.Equal(uint32(1), p.created)
+	})
+}
+
+func TestPrecreateExisting(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "hourly", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		schema, aggr, err := p.matchStorage("a.b", filepath.Join(root, "a", "b.wsp"))
+		if !assert.NoError(err) {
+			return
+		}
+
+		// file created by worker after check of Precreate is not replaced
+		path := filepath.Join(root, "a", "b.wsp")
+		assert.NoError(store(p, points.OnePoint("a.b", 42, time.Now().Unix())))
+		created, err := p.precreateFile("a.b", path, schema, aggr)
+		assert.NoError(err)
+		assert.False(created)
+		assert.Equal(uint32(1), p.created)
+
+		w, err := whisper.Open(path)
+		if assert.NoError(err) {
+			series, err := w.Fetch(int(time.Now().Unix())-120, int(time.Now().Unix()))
+			assert.NoError(err)
+			var found bool
+			for _, v := range series.Values() {
+				found = found || v == 42
+			}
+			assert.True(found, "point of worker is lost")
+			w.Close()
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package persister
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// data dir is walked for idle files every punchScanInterval
+const punchScanInterval = time.Hour
+
+// punchBlockSize is size of zero filled block released by punch of hole
+const punchBlockSize = 4096
+
+var errPunchInterrupted = errors.New("interrupted")
+
+// SetHolePunching enables background punch of holes in whisper files not updated during idleAge: zero filled
+// blocks (e.g. empty space of long archives not filled yet) are released to filesystem, so file becomes sparse.
+// File content and format are not changed, archives are not compacted. Up to rate files per second are processed.
+// 0 idleAge - disabled. Supported on Linux only
+func (p *Whisper) SetHolePunching(idleAge time.Duration, rate int) {
+	p.punchIdleAge = idleAge
+	p.punchRate = rate
+}
+
+func (p *Whisper) holePuncher(exit chan bool) {
+	if !punchSupported {
+		logrus.Warn("[persister] Punch of holes in whisper files is not supported on this OS")
+		return
+	}
+
+	ticker := time.NewTicker(punchScanInterval)
+	defer ticker.Stop()
+
+	for {
+		if !p.punchFiles(exit) {
+			return
+		}
+
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// punchFiles walks data dirs and punches holes in idle files. Returns false if interrupted by exit
+func (p *Whisper) punchFiles(exit chan bool) bool {
+	for _, root := range p.dataDirPaths() {
+		if !p.punchDir(root, exit) {
+			return false
+		}
+	}
+	return true
+}
+
+// punchDir walks root and punches holes in idle files. Returns false if interrupted by exit
+func (p *Whisper) punchDir(root string, exit chan bool) bool {
+	var pause time.Duration
+	if p.punchRate > 0 {
+		pause = time.Second / time.Duration(p.punchRate)
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// file removed during walk or permission denied, skip it
+			if path == root {
+				return err
+			}
+			return nil
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".wsp") || !p.punchCandidate(info) {
+			return nil
+		}
+
+		reclaimed, err := p.punchFile(path)
+		if err != nil {
+			logrus.Errorf("[persister] Failed to punch holes in %s: %s", path, err.Error())
+		} else if reclaimed > 0 {
+			atomic.AddUint32(&p.punchedFiles, 1)
+			atomic.AddUint64(&p.punchedBytes, uint64(reclaimed))
+			logrus.Debugf("[persister] Punched holes in %s, %d bytes reclaimed", path, reclaimed)
+		}
+
+		select {
+		case <-exit:
+			return errPunchInterrupted
+		case <-time.After(pause):
+		}
+		return nil
+	})
+
+	if err == errPunchInterrupted {
+		return false
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("[persister] Punch of holes in %s failed: %s", root, err.Error())
+	}
+	return true
+}
+
+// punchCandidate returns true for file not updated during idle age and not sparse yet
+func (p *Whisper) punchCandidate(info os.FileInfo) bool {
+	return time.Since(info.ModTime()) >= p.punchIdleAge && allocatedSize(info) >= info.Size()
+}
+
+// holeRange is range of zero filled blocks of file
+type holeRange struct {
+	offset int64
+	length int64
+}
+
+// zeroRanges reads f from start and returns ranges of zero filled blocks. Partial last block is kept
+func zeroRanges(f *os.File) ([]holeRange, error) {
+	zero := make([]byte, punchBlockSize)
+	block := make([]byte, punchBlockSize)
+	var ranges []holeRange
+
+	for offset := int64(0); ; offset += punchBlockSize {
+		_, err := io.ReadFull(f, block)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ranges, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(block, zero) {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last].offset+ranges[last].length == offset {
+			ranges[last].length += punchBlockSize
+		} else {
+			ranges = append(ranges, holeRange{offset: offset, length: punchBlockSize})
+		}
+	}
+}
+
+// punchFile releases zero filled blocks of file. File is scanned without lock, then it is locked for update
+// by workers during punch only and skipped if updated after scan. Files opened by workers are not affected:
+// inode is not changed. Frozen persister skips file. Returns count of reclaimed bytes
+func (p *Whisper) punchFile(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	before, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !p.punchCandidate(before) {
+		// updated after walk
+		return 0, nil
+	}
+
+	ranges, err := zeroRanges(f)
+	if err != nil || len(ranges) == 0 {
+		return 0, err
+	}
+
+	if !p.beginSideWrite() {
+		return 0, nil
+	}
+	defer p.endSideWrite()
+
+	lock := p.fileLocks.get(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !current.ModTime().Equal(before.ModTime()) || current.Size() != before.Size() {
+		// updated during scan
+		return 0, nil
+	}
+
+	for _, r := range ranges {
+		if err := punchHole(f, r.offset, r.length); err != nil {
+			return 0, err
+		}
+	}
+
+	after, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// keep modification time, so file is still idle for next scans
+	if err := os.Chtimes(path, before.ModTime(), before.ModTime()); err != nil {
+		return 0, err
+	}
+
+	return allocatedSize(before) - allocatedSize(after), nil
+}
@@ -0,0 +1,25 @@
+package persister
+
+import (
+	"os"
+	"syscall"
+)
+
+const punchSupported = true
+
+// fallocate(2) flags
+const fallocKeepSize = 0x01
+const fallocPunchHole = 0x02
+
+// punchHole deallocates range of file, reads of range return zeros
+func punchHole(f *os.File, offset, length int64) error {
+	return syscall.Fallocate(int(f.Fd()), fallocKeepSize|fallocPunchHole, offset, length)
+}
+
+// allocatedSize returns size of disk blocks allocated by file
+func allocatedSize(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Blocks * 512
+	}
+	return info.Size()
+}
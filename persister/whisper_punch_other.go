@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package persister
+
+import (
+	"errors"
+	"os"
+)
+
+const punchSupported = false
+
+func punchHole(f *os.File, offset, length int64) error {
+	return errors.New("not supported")
+}
+
+func allocatedSize(info os.FileInfo) int64 {
+	return info.Size()
+}
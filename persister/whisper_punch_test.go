@@ -0,0 +1,92 @@
+package persister
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPunchHoles(t *testing.T) {
+	if !punchSupported {
+		t.Skip("punch of holes is not supported")
+	}
+
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("1m:30d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1m:30d", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetHolePunching(time.Hour, 0)
+
+		now := time.Now().Unix()
+		store(p, points.OnePoint("idle", 42, now-60))
+		store(p, points.OnePoint("active", 42, now-60))
+
+		idle := filepath.Join(root, "idle.wsp")
+		active := filepath.Join(root, "active.wsp")
+		mtime := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+		assert.NoError(os.Chtimes(idle, mtime, mtime))
+
+		before, err := ioutil.ReadFile(idle)
+		if !assert.NoError(err) {
+			return
+		}
+		activeInfo, _ := os.Stat(active)
+
+		assert.True(p.punchFiles(make(chan bool)))
+
+		info, err := os.Stat(idle)
+		if !assert.NoError(err) {
+			return
+		}
+		if allocatedSize(info) >= info.Size() {
+			t.Skip("filesystem doesn't support punch of holes")
+		}
+
+		// content and modification time is not changed
+		after, err := ioutil.ReadFile(idle)
+		assert.NoError(err)
+		assert.Equal(before, after)
+		assert.Equal(mtime, info.ModTime())
+
+		// active file is not punched
+		info, _ = os.Stat(active)
+		assert.Equal(allocatedSize(activeInfo), allocatedSize(info))
+
+		var punched, reclaimed float64
+		p.Stat(func(metric string, value float64) {
+			switch metric {
+			case "punchedFiles":
+				punched = value
+			case "punchedBytes":
+				reclaimed = value
+			}
+		})
+		assert.Equal(float64(1), punched)
+		assert.True(reclaimed > 0)
+
+		// sparse file is skipped
+		assert.False(p.punchCandidate(info))
+		reclaimedBytes, err := p.punchFile(idle)
+		assert.NoError(err)
+		assert.Equal(int64(0), reclaimedBytes)
+
+		// sparse file is writable
+		store(p, points.OnePoint("idle", 43, now))
+		data, err := p.Query("idle", int(now-120), int(now))
+		if assert.NoError(err) {
+			assert.Len(data, 2)
+		}
+	})
+}
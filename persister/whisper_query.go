@@ -0,0 +1,135 @@
+package persister
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lomik/go-carbon/cache"
+	"github.com/lomik/go-carbon/points"
+)
+
+// fileLocksCount is count of locks shared by whisper files, file is locked by lock of its path hash
+const fileLocksCount = 64
+
+// fileLocks serializes UpdateMany of worker and Fetch of Query for the same file
+type fileLocks [fileLocksCount]sync.RWMutex
+
+func (l *fileLocks) get(path string) *sync.RWMutex {
+	return &l[crc32.ChecksumIEEE([]byte(path))%fileLocksCount]
+}
+
+// SetCacheQuery sets channel of cache queries (cache.Cache.Query()) used by Query for points not stored yet.
+// nil - Query reads whisper files only
+func (p *Whisper) SetCacheQuery(queryChan chan *cache.Query, timeout time.Duration) {
+	p.cacheQuery = queryChan
+	p.cacheQueryTimeout = timeout
+}
+
+// Query returns points of metric with timestamps in [from, until] read from whisper file and merged with points
+// of cache not stored yet. Cache is queried before read of file: points are confirmed to cache after store, so every
+// point is in cache reply or in file. Cached points replace stored ones with the same timestamp.
+// Cached points are used only if interval is read from the first archive, their timestamps are aligned to its step
+func (p *Whisper) Query(metric string, from, until int) ([]points.Point, error) {
+	if from > until {
+		return nil, fmt.Errorf("invalid interval [%d, %d]", from, until)
+	}
+
+	cached, err := p.queryCache(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	// cache keeps received names, file has normalized name
+	name := metric
+	if p.nameNormalizer != nil {
+		name = p.nameNormalizer(metric)
+	}
+	path, _, err := p.metricPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &points.Points{Metric: metric}
+
+	w, err := p.createOpener.Open(path)
+	if os.IsNotExist(err) {
+		// not created yet
+		result.Data = filterPoints(cached, from, until, 1)
+		return result.Dedup(points.DedupLast).Data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	lock := p.fileLocks.get(path)
+	lock.RLock()
+	series, err := w.Fetch(from, until)
+	lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if series != nil {
+		timestamp := series.FromTime()
+		for _, value := range series.Values() {
+			if !math.IsNaN(value) {
+				result.Data = append(result.Data, points.Point{Value: value, Timestamp: int64(timestamp)})
+			}
+			timestamp += series.Step()
+		}
+
+		if retentions := w.Retentions(); len(retentions) > 0 && series.Step() == retentions[0].SecondsPerPoint() {
+			result.Data = append(result.Data, filterPoints(cached, from, until, series.Step())...)
+		}
+	}
+
+	return result.Dedup(points.DedupLast).Data, nil
+}
+
+// queryCache returns points of metric in flight to workers and in cache, older first
+func (p *Whisper) queryCache(metric string) ([]points.Point, error) {
+	if p.cacheQuery == nil {
+		return nil, nil
+	}
+
+	query := cache.NewQuery(metric)
+
+	timeout := time.After(p.cacheQueryTimeout)
+	select {
+	case p.cacheQuery <- query:
+	case <-timeout:
+		return nil, fmt.Errorf("cache query timeout %s", p.cacheQueryTimeout.String())
+	}
+
+	select {
+	case <-query.Wait:
+	case <-timeout:
+		return nil, fmt.Errorf("cache query timeout %s", p.cacheQueryTimeout.String())
+	}
+
+	var data []points.Point
+	for _, values := range query.InFlightData {
+		data = append(data, values.Data...)
+	}
+	if query.CacheData != nil {
+		data = append(data, query.CacheData.Data...)
+	}
+	return data, nil
+}
+
+// filterPoints returns points with timestamps aligned to step in [from, until]
+func filterPoints(data []points.Point, from, until int, step int) []points.Point {
+	var result []points.Point
+	for _, d := range data {
+		d.Timestamp -= d.Timestamp % int64(step)
+		if d.Timestamp >= int64(from) && d.Timestamp <= int64(until) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
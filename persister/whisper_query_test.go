@@ -0,0 +1,110 @@
+package persister
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/cache"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCache replies to queries with data
+func fakeCache(data map[string]*points.Points, inFlight map[string][]*points.Points) (chan *cache.Query, chan bool) {
+	queryChan := make(chan *cache.Query)
+	exit := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-exit:
+				return
+			case query := <-queryChan:
+				query.CacheData = data[query.Metric]
+				query.InFlightData = inFlight[query.Metric]
+				close(query.Wait)
+			}
+		}
+	}()
+	return queryChan, exit
+}
+
+func TestQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("10s:1h,1m:1d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "10s:1h,1m:1d", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+
+		now := int(time.Now().Unix())
+		now -= now % 60
+		ts := func(offset int) int64 {
+			return int64(now + offset)
+		}
+
+		store(p, points.OnePoint("metric", 1, ts(-40)).Add(2, ts(-30)).Add(3, ts(-20)))
+
+		// whisper file only
+		data, err := p.Query("metric", now-50, now)
+		if assert.NoError(err) {
+			assert.Equal([]points.Point{
+				{Value: 1, Timestamp: ts(-40)},
+				{Value: 2, Timestamp: ts(-30)},
+				{Value: 3, Timestamp: ts(-20)},
+			}, data)
+		}
+
+		queryChan, exit := fakeCache(
+			map[string]*points.Points{
+				"metric": points.OnePoint("metric", 5, ts(-9)),
+				"new":    points.OnePoint("new", 6, ts(-5)),
+			},
+			map[string][]*points.Points{
+				"metric": []*points.Points{points.OnePoint("metric", 4, ts(-30))},
+			},
+		)
+		defer close(exit)
+		p.SetCacheQuery(queryChan, time.Second)
+
+		// cached points replace stored, timestamps are aligned to step
+		data, err = p.Query("metric", now-50, now)
+		if assert.NoError(err) {
+			assert.Equal([]points.Point{
+				{Value: 1, Timestamp: ts(-40)},
+				{Value: 4, Timestamp: ts(-30)},
+				{Value: 3, Timestamp: ts(-20)},
+				{Value: 5, Timestamp: ts(-10)},
+			}, data)
+		}
+
+		// file not created yet
+		data, err = p.Query("new", now-50, now)
+		if assert.NoError(err) {
+			assert.Equal([]points.Point{{Value: 6, Timestamp: ts(-5)}}, data)
+		}
+
+		// lower archive, cache is not used: average of 3 stored points of minute
+		data, err = p.Query("metric", now-7200, now)
+		if assert.NoError(err) {
+			assert.Equal([]points.Point{{Value: 2, Timestamp: ts(-60)}}, data)
+		}
+
+		_, err = p.Query("metric", now, now-10)
+		assert.Error(err)
+	})
+}
+
+func TestQueryCacheTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("", nil, nil, nil, nil)
+	p.SetCacheQuery(make(chan *cache.Query), 10*time.Millisecond)
+
+	_, err := p.Query("metric", 0, 10)
+	assert.Error(err)
+}
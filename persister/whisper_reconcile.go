@@ -0,0 +1,279 @@
+package persister
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-whisper"
+)
+
+// rateLimiter allows up to rate events per second. 0 - unlimited
+type rateLimiter struct {
+	sync.Mutex
+	rate   int
+	second int64
+	count  int
+}
+
+func (l *rateLimiter) allow() bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now().Unix()
+	if now != l.second {
+		l.second = now
+		l.count = 0
+	}
+
+	if l.count >= l.rate {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// available returns true if the next event is allowed, it is not counted. True for nil limiter
+func (l *rateLimiter) available() bool {
+	if l == nil || l.rate <= 0 {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	return time.Now().Unix() != l.second || l.count < l.rate
+}
+
+const (
+	// reconcileQueueSize is count of files waiting for rebuild by reconciler
+	reconcileQueueSize = 1024
+	// reconcileRetryInterval is wait of reconciler for rate limiter
+	reconcileRetryInterval = 10 * time.Millisecond
+)
+
+// reconcileRequest is file of metric with retentions different from schema, sent by worker to reconciler
+type reconcileRequest struct {
+	metric string
+	path   string
+}
+
+// RenameCreateOpener is CreateOpener which renames and removes files, required for rebuild of files by schema
+// reconcile. Files of other openers are not rebuilt
+type RenameCreateOpener interface {
+	CreateOpener
+	Rename(oldPath, newPath string) error
+	Remove(path string) error
+}
+
+// SetSchemaReconcile enables rebuilding of existing whisper files with retentions different from
+// storage schema. Files are checked by workers on open and rebuilt in background by reconciler of started
+// persister, rebuilds are limited by rate per second (0 - unlimited)
+func (p *Whisper) SetSchemaReconcile(enabled bool, rate int) {
+	p.schemaReconcile = enabled
+	p.reconcileLimiter = &rateLimiter{rate: rate}
+	p.reconcileQueue = make(chan reconcileRequest, reconcileQueueSize)
+}
+
+func retentionsEqual(a []whisper.Retention, b whisper.Retentions) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i].SecondsPerPoint() != b[i].SecondsPerPoint() || a[i].NumberOfPoints() != b[i].NumberOfPoints() {
+			return false
+		}
+	}
+	return true
+}
+
+// requestReconcile queues rebuild of opened whisper file if its retentions differ from schema. Called by
+// workers, so writes are not blocked by copy of data. Request is dropped if queue is full, file is checked
+// again on next open
+func (p *Whisper) requestReconcile(w WhisperFile, metric string, path string) {
+	schema, ok := p.loadStorageConfig().schemas.Match(metric)
+	if !ok || retentionsEqual(w.Retentions(), schema.Retentions) {
+		return
+	}
+
+	if _, queued := p.reconcileQueued.LoadOrStore(path, true); queued {
+		return
+	}
+
+	select {
+	case p.reconcileQueue <- reconcileRequest{metric: metric, path: path}:
+	default:
+		p.reconcileQueued.Delete(path)
+	}
+}
+
+// reconciler rebuilds files requested by workers until exit
+func (p *Whisper) reconciler(exit chan bool) {
+	for {
+		select {
+		case <-exit:
+			return
+		case req := <-p.reconcileQueue:
+			for !p.reconcileLimiter.allow() {
+				select {
+				case <-exit:
+					return
+				case <-time.After(reconcileRetryInterval):
+				}
+			}
+
+			if err := p.reconcile(req.metric, req.path); err != nil {
+				logrus.Errorf("[persister] Failed to rebuild whisper file %s: %s", req.path, err.Error())
+			}
+			p.reconcileQueued.Delete(req.path)
+		}
+	}
+}
+
+// reconcile rebuilds whisper file if its retentions differ from schema. File is locked for update by workers
+// during rebuild, workers reopen rebuilt file before next update. Frozen persister skips file
+func (p *Whisper) reconcile(metric string, path string) error {
+	co, ok := p.createOpener.(RenameCreateOpener)
+	if !ok {
+		return fmt.Errorf("rebuild is not supported by %T", p.createOpener)
+	}
+
+	storage := p.loadStorageConfig()
+	schema, ok := storage.schemas.Match(metric)
+	if !ok {
+		return nil
+	}
+	aggr := storage.aggregation.match(metric)
+	if aggr == nil {
+		return nil
+	}
+
+	if !p.beginSideWrite() {
+		return nil
+	}
+	defer p.endSideWrite()
+
+	lock := p.fileLocks.get(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	w, err := co.Open(path)
+	if err != nil {
+		if p.fileNotExists(path) {
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+
+	oldRetentions := w.Retentions()
+	if retentionsEqual(oldRetentions, schema.Retentions) {
+		return nil
+	}
+
+	if err := rebuildWhisper(co, w, path, schema.Retentions, aggr.aggregationMethod, float32(aggr.xFilesFactor), p.sparse); err != nil {
+		return err
+	}
+	p.reconciled.Store(path, true)
+
+	if err := p.applyOwnership(path, p.fileMode); err != nil {
+		logrus.Errorf("[persister] Failed to set permissions of rebuilt whisper file %s: %s", path, err.Error())
+	}
+
+	atomic.AddUint32(&p.rebuilt, 1)
+
+	logrus.WithFields(logrus.Fields{
+		"oldArchives": len(oldRetentions),
+		"retention":   schema.RetentionStr,
+		"schema":      schema.Name,
+	}).Infof("[persister] Rebuilt %s", path)
+
+	return nil
+}
+
+// reopenRebuilt returns w or file reopened if it is rebuilt by reconciler after open. Called under lock of path
+func (p *Whisper) reopenRebuilt(w WhisperFile, path string) (WhisperFile, bool, error) {
+	if _, rebuilt := p.reconciled.Load(path); !rebuilt {
+		return w, false, nil
+	}
+
+	nw, err := p.createOpener.Open(path)
+	if err != nil {
+		return w, false, err
+	}
+	p.reconciled.Delete(path)
+	return nw, true, nil
+}
+
+// rebuildWhisper copies data of w to new file with specified retentions and replaces original file.
+// Archives are copied from lowest to highest precision, so best available data wins. w stays opened
+func rebuildWhisper(co RenameCreateOpener, w WhisperFile, path string, retentions whisper.Retentions, method whisper.AggregationMethod, xFilesFactor float32, sparse bool) error {
+	tmpPath := path + ".rebuild"
+	co.Remove(tmpPath)
+
+	nw, err := co.Create(tmpPath, retentions, method, xFilesFactor, sparse)
+	if err != nil {
+		return err
+	}
+
+	oldRetentions := w.Retentions()
+	sort.Sort(sort.Reverse(bySecondsPerPoint(oldRetentions)))
+
+	now := int(time.Now().Unix())
+	for _, r := range oldRetentions {
+		ts, err := w.Fetch(now-r.MaxRetention(), now)
+		if err != nil {
+			nw.Close()
+			co.Remove(tmpPath)
+			return err
+		}
+		if ts == nil {
+			continue
+		}
+
+		var points []*whisper.TimeSeriesPoint
+		for i, v := range ts.Values() {
+			if math.IsNaN(v) {
+				continue
+			}
+			points = append(points, &whisper.TimeSeriesPoint{Time: ts.FromTime() + i*ts.Step(), Value: v})
+		}
+
+		if len(points) > 0 {
+			if err = nw.UpdateMany(points); err != nil {
+				nw.Close()
+				co.Remove(tmpPath)
+				return err
+			}
+		}
+	}
+
+	// data may be not written on failed close
+	if err = nw.Close(); err != nil {
+		co.Remove(tmpPath)
+		return err
+	}
+
+	if err = co.Rename(tmpPath, path); err != nil {
+		co.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+type bySecondsPerPoint []whisper.Retention
+
+func (r bySecondsPerPoint) Len() int      { return len(r) }
+func (r bySecondsPerPoint) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r bySecondsPerPoint) Less(i, j int) bool {
+	return r[i].SecondsPerPoint() < r[j].SecondsPerPoint()
+}
@@ -0,0 +1,183 @@
+package persister
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	l := &rateLimiter{rate: 2}
+	l.second = time.Now().Unix() + 10 // freeze second
+	assert.True(l.allow())
+	assert.True(l.allow())
+	assert.False(l.allow())
+
+	unlimited := &rateLimiter{}
+	for i := 0; i < 100; i++ {
+		assert.True(unlimited.allow())
+	}
+}
+
+func TestSchemaReconcile(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		path := filepath.Join(root, "metric.wsp")
+		now := int(time.Now().Unix())
+		now = now - now%60
+
+		oldRetentions, _ := ParseRetentionDefs("60s:1h")
+		w, err := whisper.Create(path, oldRetentions, whisper.Average, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.UpdateMany([]*whisper.TimeSeriesPoint{
+			&whisper.TimeSeriesPoint{Time: now - 600, Value: 1},
+			&whisper.TimeSeriesPoint{Time: now - 300, Value: 2},
+		})
+		w.Close()
+
+		newRetentions, _ := ParseRetentionDefs("60s:2h,1h:1d")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:2h,1h:1d", Retentions: newRetentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+
+		// disabled
+		store(p, points.OnePoint("metric", 3, int64(now-60)))
+		w, err = whisper.Open(path)
+		if assert.NoError(err) {
+			assert.True(retentionsEqual(w.Retentions(), oldRetentions))
+			w.Close()
+		}
+
+		p.SetSchemaReconcile(true, 0)
+		files := newFileCache(1)
+		defer files.closeAll()
+
+		// rebuild is requested by worker and done by reconciler
+		assert.NoError(storeWithFiles(p, points.OnePoint("metric", 4, int64(now-120)), files))
+		w, err = whisper.Open(path)
+		if assert.NoError(err) {
+			assert.True(retentionsEqual(w.Retentions(), oldRetentions))
+			w.Close()
+		}
+		if !assert.Len(p.reconcileQueue, 1) {
+			return
+		}
+		req := <-p.reconcileQueue
+		assert.NoError(p.reconcile(req.metric, req.path))
+
+		// cached file is reopened after rebuild
+		assert.NoError(storeWithFiles(p, points.OnePoint("metric", 5, int64(now-180)), files))
+
+		w, err = whisper.Open(path)
+		if !assert.NoError(err) {
+			return
+		}
+		defer w.Close()
+
+		assert.True(retentionsEqual(w.Retentions(), newRetentions))
+
+		ts, err := w.Fetch(now-3600, now)
+		if assert.NoError(err) {
+			values := make(map[int]float64)
+			for i, v := range ts.Values() {
+				if !math.IsNaN(v) {
+					values[ts.FromTime()+i*ts.Step()] = v
+				}
+			}
+			assert.Equal(map[int]float64{now - 600: 1, now - 300: 2, now - 180: 5, now - 120: 4, now - 60: 3}, values)
+		}
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(1), stat["rebuilt"])
+
+		// failed reopen of rebuilt file is error of store
+		p.reconciled.Store(path, true)
+		assert.NoError(os.Rename(path, path+".moved"))
+		err = storeWithFiles(p, points.OnePoint("metric", 6, int64(now-60)), files)
+		if e, ok := err.(*StoreError); assert.True(ok, "%#v", err) {
+			assert.Equal(StoreOpOpen, e.Op)
+		}
+	})
+}
+
+func TestSchemaReconcileVirtual(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := ParseRetentionDefs("60s:1h")
+	schemas := WhisperSchemas{
+		Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+	}
+
+	p := NewWhisper("/", schemas, NewWhisperAggregation(), nil, nil)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.SetSchemaReconcile(true, 0)
+
+	// files of opener without rename are not replaced
+	assert.Error(p.reconcile("metric", "/metric.wsp"))
+}
+
+// closeFailingOpener creates files failing on close, e.g. on write error of delayed allocation
+type closeFailingOpener struct {
+	osCreateOpener
+}
+
+type closeFailingFile struct {
+	WhisperFile
+}
+
+func (f closeFailingFile) Close() error {
+	f.WhisperFile.Close()
+	return errors.New("close failed")
+}
+
+func (co closeFailingOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	w, err := co.osCreateOpener.Create(path, retentions, aggregationMethod, xFilesFactor, sparse)
+	if err != nil {
+		return nil, err
+	}
+	return closeFailingFile{w}, nil
+}
+
+func TestRebuildWhisperCloseError(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		path := filepath.Join(root, "metric.wsp")
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		w, err := whisper.Create(path, retentions, whisper.Average, 0.5)
+		if !assert.NoError(err) {
+			return
+		}
+		defer w.Close()
+
+		// incomplete file is not renamed into place
+		newRetentions, _ := ParseRetentionDefs("60s:1d")
+		assert.EqualError(rebuildWhisper(closeFailingOpener{}, whisperFile{w}, path, newRetentions, whisper.Average, 0.5, false), "close failed")
+
+		_, err = os.Stat(path + ".rebuild")
+		assert.True(os.IsNotExist(err))
+		if r, err := whisper.Open(path); assert.NoError(err) {
+			assert.Equal(3600, r.Retentions()[0].MaxRetention())
+			r.Close()
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package persister
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetStorageConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		in := make(chan *points.Points, 10)
+		confirm := make(chan *points.Points, 10)
+
+		oldRetentions, _ := ParseRetentionDefs("1s:1h")
+		p := NewWhisper(root, WhisperSchemas{
+			Schema{Name: "old", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1h", Retentions: oldRetentions},
+		}, NewWhisperAggregation(), in, confirm)
+		p.SetWorkers(2)
+		p.Start()
+		defer p.Stop()
+
+		write := func(metric string, value float64, timestamp int64) {
+			in <- points.OnePoint(metric, value, timestamp)
+			select {
+			case <-confirm:
+			case <-time.After(time.Second):
+				t.Fatal("not confirmed")
+			}
+		}
+
+		now := time.Now().Unix()
+		write("metric.old", 1, now-10)
+
+		newRetentions, _ := ParseRetentionDefs("1s:2h,60s:1d")
+		p.SetStorageConfig(WhisperSchemas{
+			Schema{Name: "new", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:2h,60s:1d", Retentions: newRetentions},
+		}, NewWhisperAggregation())
+
+		write("metric.old", 2, now-5)
+		write("metric.new", 3, now-5)
+
+		check := func(name string, retentions whisper.Retentions, count int) {
+			w, err := whisper.Open(filepath.Join(root, "metric", name+".wsp"))
+			if !assert.NoError(err) {
+				return
+			}
+			defer w.Close()
+
+			assert.True(retentionsEqual(w.Retentions(), retentions), name)
+
+			ts, err := w.Fetch(int(now-60), int(now))
+			if assert.NoError(err) {
+				written := 0
+				for _, v := range ts.Values() {
+					if !math.IsNaN(v) {
+						written++
+					}
+				}
+				assert.Equal(count, written, name)
+			}
+		}
+
+		check("old", oldRetentions, 2)
+		check("new", newRetentions, 1)
+	})
+}
@@ -0,0 +1,231 @@
+package persister
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+var errNotResizable = errors.New("workers of solo worker persister can't be resized without restart")
+
+// resizer changes count of common workers of running shuffler
+type resizer struct {
+	requests chan chan bool        // done channel of request, closed by shuffler after resize
+	queues   []chan *points.Points // queues before workers: input and throttle
+	workers  *sync.WaitGroup       // of current workers, changed by shuffler only
+	handover *workerHandover       // of current workers
+}
+
+// workerState is throttled and retried creates of worker with received values merged into them
+type workerState struct {
+	pending []pendingCreate
+	retries []createRetry
+	merges  map[*points.Points][]*points.Points
+}
+
+// workerHandover passes workerState of workers stopped by resize or freeze to new workers of the same metrics
+type workerHandover struct {
+	sync.Mutex
+	collect bool // set by stopWorkers, exiting workers put their state instead of last attempt
+	state   workerState
+	inherit map[chan *points.Points]workerState
+}
+
+func newWorkerHandover() *workerHandover {
+	return &workerHandover{}
+}
+
+// stopping makes exiting workers put their state
+func (h *workerHandover) stopping() {
+	h.Lock()
+	h.collect = true
+	h.Unlock()
+}
+
+// collecting returns true if exiting worker must put its state. False for nil handover (solo worker)
+func (h *workerHandover) collecting() bool {
+	if h == nil {
+		return false
+	}
+	h.Lock()
+	defer h.Unlock()
+	return h.collect
+}
+
+// put keeps state of exiting worker
+func (h *workerHandover) put(pending []pendingCreate, retries []createRetry, merges map[*points.Points][]*points.Points) {
+	h.Lock()
+	defer h.Unlock()
+	h.state.pending = append(h.state.pending, pending...)
+	h.state.retries = append(h.state.retries, retries...)
+	for merged, queued := range merges {
+		if h.state.merges == nil {
+			h.state.merges = make(map[*points.Points][]*points.Points)
+		}
+		h.state.merges[merged] = queued
+	}
+}
+
+// distribute splits kept state by channels of new workers, route returns index of channel of metric
+func (h *workerHandover) distribute(channels [](chan *points.Points), route func(metric string) int) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.collect = false
+	h.inherit = make(map[chan *points.Points]workerState)
+	for _, item := range h.state.pending {
+		ch := channels[route(item.values.Metric)]
+		st := h.inherited(ch, item.values)
+		st.pending = append(st.pending, item)
+		h.inherit[ch] = st
+	}
+	for _, item := range h.state.retries {
+		ch := channels[route(item.values.Metric)]
+		st := h.inherited(ch, item.values)
+		st.retries = append(st.retries, item)
+		h.inherit[ch] = st
+	}
+	h.state = workerState{}
+}
+
+// inherited returns state of channel ch with merges of values moved to it
+func (h *workerHandover) inherited(ch chan *points.Points, values *points.Points) workerState {
+	st := h.inherit[ch]
+	if queued, ok := h.state.merges[values]; ok {
+		if st.merges == nil {
+			st.merges = make(map[*points.Points][]*points.Points)
+		}
+		st.merges[values] = queued
+	}
+	return st
+}
+
+// take returns state inherited by worker of channel in. Empty for nil handover
+func (h *workerHandover) take(in chan *points.Points) workerState {
+	if h == nil {
+		return workerState{}
+	}
+	h.Lock()
+	defer h.Unlock()
+	st := h.inherit[in]
+	delete(h.inherit, in)
+	return st
+}
+
+// Resize changes count of common workers of started persister without restart: shuffler stops receiving,
+// closes channels of current workers and waits until they write buffered values, then starts new workers.
+// Throttled and retried creates of current workers are passed to new workers of their metrics.
+// Input is buffered by input channel meanwhile. Persister started with solo worker (one worker without pools)
+// can't be resized
+func (p *Whisper) Resize(workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p.Lock()
+	r := p.resizer
+	exit := p.exit
+	if r == nil || exit == nil {
+		p.Unlock()
+		if exit == nil {
+			return errNotStarted
+		}
+		return errNotResizable
+	}
+	if p.workersCount == workers {
+		p.Unlock()
+		return nil
+	}
+	if p.thaw != nil {
+		// shuffler is waiting for thaw
+		p.Unlock()
+		return errFrozen
+	}
+	// read by shuffler after receive of request
+	p.workersCount = workers
+	p.Unlock()
+
+	done := make(chan bool)
+	select {
+	case r.requests <- done:
+	case <-exit:
+		return errNotStarted
+	}
+	<-done
+	return nil
+}
+
+// startWorkers starts common workers and workers of pools with own channels. State of h is passed to new workers.
+// Group is done after exit of all of them
+func (p *Whisper) startWorkers(workers int, h *workerHandover) ([](chan *points.Points), *sync.WaitGroup) {
+	var channels [](chan *points.Points)
+	var stats []*workerStat
+	wg := &sync.WaitGroup{}
+
+	// common workers, then workers of pools
+	for i := 0; i < workers+p.poolWorkers(); i++ {
+		channels = append(channels, make(chan *points.Points, p.workerChannelSize()))
+	}
+	h.distribute(channels, p.workerRoute(len(channels)))
+
+	for _, ch := range channels {
+		ch := ch
+		stat := &workerStat{queue: ch}
+		stats = append(stats, stat)
+		wg.Add(1)
+		p.Go(func(e chan bool) {
+			p.countedWorker(ch, nil, nil, stat, nil, h)
+			wg.Done()
+		})
+	}
+
+	p.workerStats.Store(stats)
+	return channels, wg
+}
+
+// workerRoute returns index of worker channel of metric for count of channels
+func (p *Whisper) workerRoute(channels int) func(metric string) int {
+	ranges := p.poolRanges(channels)
+	common := poolRange{offset: 0, count: channels - p.poolWorkers()}
+
+	shard := p.shardFunc
+	if shard == nil {
+		shard = CRC32Shard
+	}
+	shard = p.namespaceShard(shard)
+
+	return func(metric string) int {
+		r := p.route(metric, common, ranges)
+		return r.offset + shard(metric, r.count)
+	}
+}
+
+// resize replaces workers of out by new count. Values of old workers are written before start of new workers,
+// so points of metric moved to other worker are not written concurrently or out of order
+func (p *Whisper) resize(r *resizer, out [](chan *points.Points)) [](chan *points.Points) {
+	stopWorkers(r, out)
+	channels := p.restartWorkers(r)
+	logrus.Infof("[persister] Workers resized to %d", p.workersCount)
+	return channels
+}
+
+// stopWorkers closes channels of workers and waits until they write buffered values and exit.
+// Throttled and retried creates are kept for restartWorkers
+func stopWorkers(r *resizer, out [](chan *points.Points)) {
+	r.handover.stopping()
+	for _, ch := range out {
+		close(ch)
+	}
+	r.workers.Wait()
+}
+
+// restartWorkers starts workersCount common workers and workers of pools after stopWorkers
+func (p *Whisper) restartWorkers(r *resizer) [](chan *points.Points) {
+	channels, wg := p.startWorkers(p.workersCount, r.handover)
+	r.workers = wg
+	p.queues.Store(append(r.queues[:len(r.queues):len(r.queues)], channels...))
+	return channels
+}
@@ -0,0 +1,111 @@
+package persister
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResize(t *testing.T) {
+	assert := assert.New(t)
+
+	const metrics = 1000
+	in := make(chan *points.Points, metrics)
+	confirm := make(chan *points.Points, 3*metrics)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(slowCreateOpener{})
+	assert.Equal(errNotStarted, p.Resize(4))
+
+	p.SetWorkers(2)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	now := time.Now().Unix()
+	send := func() {
+		for i := 0; i < metrics; i++ {
+			in <- points.OnePoint(fmt.Sprintf("a.b%d", i), 1, now)
+		}
+	}
+
+	workers := func() int {
+		stats, _ := p.workerStats.Load().([]*workerStat)
+		return len(stats)
+	}
+
+	// values sent during resize are not lost
+	done := make(chan bool)
+	go func() {
+		send()
+		close(done)
+	}()
+	assert.NoError(p.Resize(4))
+	assert.Equal(4, workers())
+	assert.NoError(p.Resize(1))
+	assert.Equal(1, workers())
+	<-done
+
+	send()
+	assert.NoError(p.Resize(3))
+	assert.Equal(3, workers())
+	send()
+
+	for i := 0; i < 3*metrics; i++ {
+		select {
+		case <-confirm:
+		case <-time.After(time.Second):
+			t.Fatalf("confirmed %d of %d", i, 3*metrics)
+		}
+	}
+
+	// queues of input and workers
+	queues, _ := p.queues.Load().([]chan *points.Points)
+	assert.Len(queues, 4)
+
+	// unchanged
+	assert.NoError(p.Resize(3))
+
+	p.Stop()
+	solo := NewWhisper("/", nil, NewWhisperAggregation(), in, confirm)
+	solo.SetCreateOpener(slowCreateOpener{})
+	assert.NoError(solo.Start())
+	assert.Equal(errNotResizable, solo.Resize(2))
+	solo.Stop()
+}
+
+func TestResizeThrottled(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	// throttled until stop
+	s := &recordStore{throttled: 1000}
+	p := NewPersister(s, in, confirm)
+	p.SetFlushInterval(time.Hour)
+	p.SetFlushMaxPoints(2)
+	p.SetMaxCreatesPerSecond(1)
+	p.SetWorkers(2)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	in <- points.OnePoint("a", 1, 10)
+	in <- points.OnePoint("a", 2, 20)
+	in <- points.OnePoint("b", 1, 10)
+	in <- points.OnePoint("b", 2, 20)
+	for s.len() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// throttled values are passed to new workers, not retried and confirmed
+	assert.NoError(p.Resize(4))
+	assert.NoError(p.Resize(1))
+	assert.Len(confirm, 0)
+
+	// dropped on stop, merged values are confirmed with received ones
+	p.Stop()
+	assert.Len(confirm, 6)
+}
@@ -0,0 +1,63 @@
+package persister
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ResolveRoot resolves root path to absolute path with evaluated symlinks, files are written to resolved path
+// until next call (e.g. on reload of config), so repointed symlink doesn't move writes in the middle of run.
+// Called by Start. Fails if root path not exists or is not directory. Root path is not resolved with data dirs
+// (not used), symlinks are evaluated only if root is on disk (not for in-memory opener)
+func (p *Whisper) ResolveRoot() error {
+	if len(p.dataDirs) > 0 {
+		return nil
+	}
+
+	root, err := resolveRoot(p.rootPath, p.createOpener)
+	if err != nil {
+		return err
+	}
+
+	if prev, _ := p.resolvedRoot.Load().(string); prev != root {
+		logrus.Infof("[persister] Root path %s resolved to %s", p.rootPath, root)
+	}
+	p.resolvedRoot.Store(root)
+	return nil
+}
+
+func resolveRoot(path string, co CreateOpener) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := co.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("root path %s: %s", path, err.Error())
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("root path %s: not a directory", path)
+	}
+
+	root, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		// not on disk
+		return abs, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("root path %s: %s", path, err.Error())
+	}
+	return root, nil
+}
+
+// root returns root path resolved by ResolveRoot, or configured path if not resolved yet
+func (p *Whisper) root() string {
+	if root, _ := p.resolvedRoot.Load().(string); root != "" {
+		return root
+	}
+	return p.rootPath
+}
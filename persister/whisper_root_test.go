@@ -0,0 +1,73 @@
+package persister
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		a := filepath.Join(root, "a")
+		b := filepath.Join(root, "b")
+		link := filepath.Join(root, "link")
+		assert.NoError(os.Mkdir(a, 0755))
+		assert.NoError(os.Mkdir(b, 0755))
+		assert.NoError(os.Symlink(a, link))
+
+		p := NewWhisper(link, schemas, NewWhisperAggregation(), nil, nil)
+		assert.NoError(p.ResolveRoot())
+
+		now := time.Now().Unix()
+		assert.NoError(store(p, points.OnePoint("m1", 1, now)))
+
+		// repointed symlink is not used until next resolve
+		assert.NoError(os.Remove(link))
+		assert.NoError(os.Symlink(b, link))
+		assert.NoError(store(p, points.OnePoint("m2", 1, now)))
+
+		assert.NoError(p.ResolveRoot())
+		assert.NoError(store(p, points.OnePoint("m3", 1, now)))
+
+		exists := func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}
+		assert.True(exists(filepath.Join(a, "m1.wsp")))
+		assert.True(exists(filepath.Join(a, "m2.wsp")))
+		assert.True(exists(filepath.Join(b, "m3.wsp")))
+		assert.False(exists(filepath.Join(b, "m2.wsp")))
+
+		// missing root or file is rejected by Start
+		p = NewWhisper(filepath.Join(root, "missing"), schemas, NewWhisperAggregation(), make(chan *points.Points), nil)
+		assert.Error(p.Start())
+
+		file := filepath.Join(root, "file")
+		assert.NoError(ioutil.WriteFile(file, []byte{}, 0644))
+		p = NewWhisper(file, schemas, NewWhisperAggregation(), make(chan *points.Points), nil)
+		assert.Error(p.Start())
+
+		// relative path
+		wd, _ := os.Getwd()
+		defer os.Chdir(wd)
+		assert.NoError(os.Chdir(root))
+		p = NewWhisper("a", schemas, NewWhisperAggregation(), nil, nil)
+		assert.NoError(p.ResolveRoot())
+		resolved, _ := filepath.EvalSymlinks(a)
+		assert.Equal(resolved, p.root())
+	})
+}
@@ -22,6 +22,8 @@ type Schema struct {
 	RetentionStr string
 	Retentions   whisper.Retentions
 	Priority     int64
+	Pool         string  // worker pool, "" - common workers
+	Mirror       *Mirror // additional file of metric, nil - disabled
 }
 
 // WhisperSchemas contains schema settings
@@ -122,6 +124,10 @@ func ReadWhisperSchemas(file string) (WhisperSchemas, error) {
 			}
 		}
 		schema.Priority = int64(p)<<32 - int64(i) // to sort records with same priority by position in file
+		schema.Pool = strings.TrimSpace(sec.ValueOf("pool"))
+		if schema.Mirror, err = parseMirror(sec); err != nil {
+			return nil, fmt.Errorf("[persister] %s for [%s]", err.Error(), schema.Name)
+		}
 
 		schemas = append(schemas, schema)
 	}
@@ -0,0 +1,177 @@
+package persister
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// carbonRingReplicas is replica count of nodes in ConsistentHashRing of carbon-relay
+const carbonRingReplicas = 100
+
+// ShardFunc returns index of worker in [0, workers) for metric
+type ShardFunc func(metric string, workers int) int
+
+// SetShardFunc sets distribution of metrics by workers. nil - CRC32Shard
+func (p *Whisper) SetShardFunc(fn ShardFunc) {
+	p.shardFunc = fn
+}
+
+// SetShardHash sets distribution of metrics by workers by name of hash, see NewShardFunc
+func (p *Whisper) SetShardHash(name string) error {
+	fn, err := NewShardFunc(name, 0)
+	if err != nil {
+		return err
+	}
+	p.shardFunc = fn
+	return nil
+}
+
+// CRC32Shard is crc32(metric) % workers. Changing of workers count remaps almost all metrics
+func CRC32Shard(metric string, workers int) int {
+	return int(crc32.ChecksumIEEE([]byte(metric)) % uint32(workers))
+}
+
+// FNVShard is fnv1a32(metric) % workers, as fnv1a hash of carbon-c-relay without ring
+func FNVShard(metric string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(metric))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// JumpShard is jump consistent hash of fnv64a(metric). Changing of workers count from n to n+1 remaps ~1/(n+1) of metrics
+func JumpShard(metric string, workers int) int {
+	h := fnv.New64a()
+	h.Write([]byte(metric))
+	return jumpHash(h.Sum64(), workers)
+}
+
+// jumpHash from "A Fast, Minimal Memory, Consistent Hash Algorithm" (Lamping, Veach)
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// CarbonShard returns ShardFunc with ConsistentHashRing of carbon-relay (carbon_ch of carbon-c-relay): worker N
+// is node "N" with 100 replicas on ring of 16 bit md5 positions, so bucketing is the same as of carbon ring with
+// nodes "0" ... "N-1". Ring is built on first call and on change of workers count
+func CarbonShard() ShardFunc {
+	var current atomic.Value // *carbonRing
+	return func(metric string, workers int) int {
+		ring, _ := current.Load().(*carbonRing)
+		if ring == nil || ring.nodes != workers {
+			ring = newCarbonRing(workers)
+			current.Store(ring)
+		}
+		return ring.get(metric)
+	}
+}
+
+type carbonRingEntry struct {
+	position int
+	node     int
+}
+
+// carbonRing is ConsistentHashRing of carbon with nodes "0" ... "N-1"
+type carbonRing struct {
+	nodes   int
+	entries []carbonRingEntry // sorted by unique position
+}
+
+// carbonRingPosition is carbon ConsistentHashRing.compute_ring_position: first 2 bytes of md5
+func carbonRingPosition(key string) int {
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2]))
+}
+
+func newCarbonRing(nodes int) *carbonRing {
+	r := &carbonRing{nodes: nodes}
+	used := make(map[int]bool)
+	for node := 0; node < nodes; node++ {
+		name := strconv.Itoa(node)
+		for i := 0; i < carbonRingReplicas; i++ {
+			position := carbonRingPosition(name + ":" + strconv.Itoa(i))
+			// occupied position is shifted by carbon to next free
+			for used[position] {
+				position++
+			}
+			used[position] = true
+			r.entries = append(r.entries, carbonRingEntry{position: position, node: node})
+		}
+	}
+	sort.Sort(byCarbonRingPosition(r.entries))
+	return r
+}
+
+// get returns node of first entry with position not less than position of metric, as bisect_left of carbon
+func (r *carbonRing) get(metric string) int {
+	position := carbonRingPosition(metric)
+	i := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].position >= position })
+	if i == len(r.entries) {
+		i = 0
+	}
+	return r.entries[i].node
+}
+
+type byCarbonRingPosition []carbonRingEntry
+
+func (v byCarbonRingPosition) Len() int           { return len(v) }
+func (v byCarbonRingPosition) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byCarbonRingPosition) Less(i, j int) bool { return v[i].position < v[j].position }
+
+// PrefixShard shards metrics by first segments of name with fn, so metrics of one directory are written by one worker
+func PrefixShard(segments int, fn ShardFunc) ShardFunc {
+	return func(metric string, workers int) int {
+		return fn(metricPrefix(metric, segments), workers)
+	}
+}
+
+// metricPrefix returns first segments of metric name
+func metricPrefix(metric string, segments int) string {
+	if segments <= 0 {
+		return metric
+	}
+	offset := 0
+	for i := 0; i < segments; i++ {
+		n := strings.IndexByte(metric[offset:], '.')
+		if n < 0 {
+			return metric
+		}
+		offset += n + 1
+	}
+	return metric[:offset-1]
+}
+
+// NewShardFunc returns ShardFunc by name ("crc32", "fnv", "jump" or "carbon"). If segments > 0 metrics are sharded
+// by first segments of name
+func NewShardFunc(name string, segments int) (ShardFunc, error) {
+	var fn ShardFunc
+	switch name {
+	case "crc32", "":
+		fn = CRC32Shard
+	case "fnv":
+		fn = FNVShard
+	case "jump":
+		fn = JumpShard
+	case "carbon":
+		fn = CarbonShard()
+	default:
+		return nil, fmt.Errorf("unknown sharding %#v, use \"crc32\", \"fnv\", \"jump\" or \"carbon\"", name)
+	}
+
+	if segments > 0 {
+		fn = PrefixShard(segments, fn)
+	}
+	return fn, nil
+}
@@ -0,0 +1,82 @@
+package persister
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+func benchmarkShard(b *testing.B, fn ShardFunc) {
+	const workers = 16
+	const metrics = 1000000
+
+	names := make([]string, metrics)
+	for i := 0; i < metrics; i++ {
+		names[i] = fmt.Sprintf("carbon.agents.host%d.metric%d", i%1000, i)
+	}
+
+	b.ResetTimer()
+	var counts [workers]int
+	for n := 0; n < b.N; n++ {
+		counts = [workers]int{}
+		for _, metric := range names {
+			counts[fn(metric, workers)]++
+		}
+	}
+	b.StopTimer()
+
+	min, max := counts[0], counts[0]
+	for _, c := range counts {
+		if c < min {
+			min = c
+		}
+		if c > max {
+			max = c
+		}
+	}
+	b.Logf("%d metrics by %d workers: min %d, max %d, max/min %.3f", metrics, workers, min, max, float64(max)/float64(min))
+}
+
+func BenchmarkShardCRC32(b *testing.B)  { benchmarkShard(b, CRC32Shard) }
+func BenchmarkShardFNV(b *testing.B)    { benchmarkShard(b, FNVShard) }
+func BenchmarkShardJump(b *testing.B)   { benchmarkShard(b, JumpShard) }
+func BenchmarkShardCarbon(b *testing.B) { benchmarkShard(b, CarbonShard()) }
+func BenchmarkShardPrefix(b *testing.B) {
+	benchmarkShard(b, PrefixShard(3, JumpShard))
+}
+
+func benchmarkInternalChannelSize(b *testing.B, size int) {
+	const workers = 8
+	const metrics = 1000
+
+	names := make([]string, metrics)
+	for i := 0; i < metrics; i++ {
+		names[i] = fmt.Sprintf("carbon.agents.host%d.metric%d", i%10, i)
+	}
+
+	in := make(chan *points.Points, 1024)
+	confirm := make(chan *points.Points, 1024)
+
+	p := NewPersister(&memoryStore{data: make(map[string][]points.Point)}, in, confirm)
+	p.SetWorkers(workers)
+	p.SetInternalChannelSize(size)
+	p.Start()
+	defer p.Stop()
+
+	go func() {
+		for n := 0; n < b.N; n++ {
+			in <- points.OnePoint(names[n%metrics], float64(n), int64(n))
+		}
+	}()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		<-confirm
+	}
+}
+
+func BenchmarkInternalChannelSize1(b *testing.B)    { benchmarkInternalChannelSize(b, 1) }
+func BenchmarkInternalChannelSize32(b *testing.B)   { benchmarkInternalChannelSize(b, 32) }
+func BenchmarkInternalChannelSize256(b *testing.B)  { benchmarkInternalChannelSize(b, 256) }
+func BenchmarkInternalChannelSize4096(b *testing.B) { benchmarkInternalChannelSize(b, 4096) }
@@ -0,0 +1,114 @@
+package persister
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("a.b", metricPrefix("a.b.c.d", 2))
+	assert.Equal("a.b.c.d", metricPrefix("a.b.c.d", 4))
+	assert.Equal("a.b.c.d", metricPrefix("a.b.c.d", 10))
+	assert.Equal("a", metricPrefix("a", 1))
+	assert.Equal("a.b.c.d", metricPrefix("a.b.c.d", 0))
+}
+
+func TestJumpShard(t *testing.T) {
+	assert := assert.New(t)
+
+	const metrics = 10000
+	moved := 0
+	for i := 0; i < metrics; i++ {
+		metric := fmt.Sprintf("carbon.agents.host%d.cpu", i)
+		a := JumpShard(metric, 10)
+		b := JumpShard(metric, 11)
+		assert.True(a >= 0 && a < 10)
+		if a != b {
+			assert.Equal(10, b, "metric can be moved to new worker only")
+			moved++
+		}
+	}
+
+	// ~1/11 of metrics
+	assert.InDelta(metrics/11, moved, metrics/50)
+}
+
+func TestCompatibleShard(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := []string{
+		"carbon.agents.host1.cpu",
+		"a.b.c",
+		"servers.web01.load.1min",
+		"test",
+		"stats.counters.requests.count",
+		"zz.top",
+	}
+
+	bucketing := func(fn ShardFunc, workers int) []int {
+		var result []int
+		for _, m := range metrics {
+			result = append(result, fn(m, workers))
+		}
+		return result
+	}
+
+	// ConsistentHashRing of carbon/lib/carbon/hashing.py with nodes "0", "1", ...
+	carbon := CarbonShard()
+	assert.Equal([]int{0, 0, 2, 2, 0, 1}, bucketing(carbon, 3))
+	assert.Equal([]int{0, 0, 6, 6, 0, 7}, bucketing(carbon, 8))
+	assert.Equal([]int{0, 0, 2, 2, 0, 1}, bucketing(carbon, 3))
+
+	// fnv1a_32 % workers
+	assert.Equal([]int{7, 7, 5, 5, 0, 0}, bucketing(FNVShard, 8))
+}
+
+func TestNewShardFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	fn, err := NewShardFunc("jump", 2)
+	if assert.NoError(err) {
+		assert.Equal(fn("a.b.c", 8), fn("a.b.d", 8))
+		assert.Equal(JumpShard("a.b", 8), fn("a.b.c", 8))
+	}
+
+	fn, err = NewShardFunc("crc32", 0)
+	if assert.NoError(err) {
+		assert.Equal(CRC32Shard("a.b.c", 8), fn("a.b.c", 8))
+	}
+
+	fn, err = NewShardFunc("fnv", 0)
+	if assert.NoError(err) {
+		assert.Equal(FNVShard("a.b.c", 8), fn("a.b.c", 8))
+	}
+
+	fn, err = NewShardFunc("carbon", 0)
+	if assert.NoError(err) {
+		assert.Equal(CarbonShard()("a.b.c", 8), fn("a.b.c", 8))
+	}
+
+	_, err = NewShardFunc("md5", 0)
+	assert.Error(err)
+
+	p := NewWhisper("/", nil, nil, nil, nil)
+	assert.NoError(p.SetShardHash("fnv"))
+	assert.Equal(FNVShard("a.b.c", 8), p.shardFunc("a.b.c", 8))
+	assert.Error(p.SetShardHash("md5"))
+}
+
+func TestWorkerChannelSize(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewPersister(nil, nil, nil)
+	for workers, size := range map[int]int{1: 256, 4: 64, 32: 32, 100: 32} {
+		p.SetWorkers(workers)
+		assert.Equal(size, p.workerChannelSize(), workers)
+	}
+
+	p.SetInternalChannelSize(10)
+	assert.Equal(10, p.workerChannelSize())
+}
@@ -0,0 +1,27 @@
+package persister
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// SetSlowWriteThreshold enables logging of whisper updates longer than threshold with metric name and path
+// (persister.slowWrites metric). 0 - disabled
+func (p *Whisper) SetSlowWriteThreshold(threshold time.Duration) {
+	p.slowWriteThreshold = threshold
+}
+
+// checkSlowWrite counts and logs update of file which took longer than threshold
+func (p *Whisper) checkSlowWrite(metric string, path string, duration time.Duration) {
+	if p.slowWriteThreshold <= 0 || duration < p.slowWriteThreshold {
+		return
+	}
+
+	atomic.AddUint32(&p.slowWrites, 1)
+	p.log.WithFields(logrus.Fields{
+		"metric": metric,
+		"path":   path,
+	}).Warnf("[persister] Slow write of %s: %s", metric, duration.String())
+}
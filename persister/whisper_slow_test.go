@@ -0,0 +1,64 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowFile sleeps in UpdateMany of metric "slow"
+type slowFile struct {
+	nopFile
+	delay time.Duration
+}
+
+func (f slowFile) UpdateMany(points []*whisper.TimeSeriesPoint) error {
+	time.Sleep(f.delay)
+	return nil
+}
+
+type slowCreateOpener struct {
+	osCreateOpener
+}
+
+func (slowCreateOpener) Open(path string) (WhisperFile, error) {
+	if path == "/slow.wsp" {
+		return slowFile{delay: 20 * time.Millisecond}, nil
+	}
+	return nopFile{}, nil
+}
+
+func (co slowCreateOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	return co.Open(path)
+}
+
+func TestSlowWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), nil, nil)
+	p.SetCreateOpener(slowCreateOpener{})
+
+	stat := func() map[string]float64 {
+		result := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			result[metric] = value
+		})
+		return result
+	}
+
+	now := time.Now().Unix()
+
+	// disabled
+	store(p, points.OnePoint("slow", 1, now))
+	_, exists := stat()["slowWrites"]
+	assert.False(exists)
+
+	p.SetSlowWriteThreshold(10 * time.Millisecond)
+	store(p, points.OnePoint("slow", 1, now))
+	store(p, points.OnePoint("fast", 1, now))
+	store(p, points.OnePoint("slow", 1, now))
+	assert.Equal(float64(2), stat()["slowWrites"])
+}
@@ -1,6 +1,7 @@
 package persister
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"sync/atomic"
@@ -100,3 +101,145 @@ func TestStopEmptyThrottledPersister(t *testing.T) {
 		}
 	}
 }
+
+func TestStopWithTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, workers := range []int{1, 4} {
+		qa.Root(t, func(root string) {
+			// more than buffers between shuffler and workers
+			ch := make(chan *points.Points, 1000)
+			p := NewWhisper(root, nil, nil, ch, nil)
+			p.SetWorkers(workers)
+
+			storeWait := make(chan bool)
+			var storeCount uint32
+
+			p.mockStore = func() (StoreFunc, func()) {
+				return func(p *Whisper, values *points.Points) {
+					<-storeWait
+					atomic.AddUint32(&storeCount, 1)
+				}, nil
+			}
+
+			p.Start()
+
+			for i := 0; i < cap(ch); i++ {
+				ch <- points.NowPoint(fmt.Sprintf("%d", i), float64(i))
+			}
+
+			// release writes after timeout
+			time.AfterFunc(50*time.Millisecond, func() { close(storeWait) })
+
+			err := p.StopWithTimeout(10 * time.Millisecond)
+			assert.Error(err, "workers: %d", workers)
+			assert.Equal(cap(ch), int(storeCount)+len(ch), "workers: %d", workers)
+			assert.NotEqual(0, len(ch), "workers: %d", workers)
+
+			// stop of stopped persister
+			assert.NoError(p.StopWithTimeout(10 * time.Millisecond))
+
+			// restart and drain all
+			p.Start()
+			assert.NoError(p.StopWithTimeout(time.Second), "workers: %d", workers)
+			assert.Equal(0, len(ch), "workers: %d", workers)
+			assert.Equal(cap(ch), int(storeCount), "workers: %d", workers)
+		})
+	}
+}
+
+func TestStopWorkersExit(t *testing.T) {
+	for _, maxUpdatesPerSecond := range []int{0, 4000} {
+		for _, workers := range []int{1, 4} {
+			ch := make(chan *points.Points, 10)
+			p := NewWhisper("", nil, nil, ch, nil)
+			p.SetMaxUpdatesPerSecond(maxUpdatesPerSecond)
+			p.SetWorkers(workers)
+
+			p.mockStore = func() (StoreFunc, func()) {
+				return func(p *Whisper, values *points.Points) {}, nil
+			}
+
+			startGoroutineNum := runtime.NumGoroutine()
+			p.Start()
+			ch <- points.NowPoint("metric", 1)
+
+			done := make(chan bool)
+			go func() {
+				p.Stop()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("Stop not finished, maxUpdatesPerSecond: %d, workers: %d", maxUpdatesPerSecond, workers)
+			}
+
+			// all workers should exit. Wait for "done" goroutine
+			deadline := time.Now().Add(time.Second)
+			for runtime.NumGoroutine() > startGoroutineNum && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+
+			if n := runtime.NumGoroutine(); n > startGoroutineNum {
+				t.Fatalf("%d goroutines leaked, maxUpdatesPerSecond: %d, workers: %d", n-startGoroutineNum, maxUpdatesPerSecond, workers)
+			}
+		}
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 16)
+	confirm := make(chan *points.Points, 16)
+	s := &memoryStore{data: make(map[string][]points.Point)}
+
+	p := NewPersister(s, in, confirm)
+	p.SetWorkers(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(p.StartContext(ctx))
+
+	in <- points.OnePoint("metric", 1, 10)
+	select {
+	case <-confirm:
+	case <-time.After(time.Second):
+		t.Fatal("not confirmed")
+	}
+
+	cancel()
+	select {
+	case <-p.exit:
+	case <-time.After(time.Second):
+		t.Fatal("not stopped")
+	}
+
+	// Stop after cancel is noop
+	p.Stop()
+
+	// stopped by Stop, later cancel is noop
+	ctx, cancel = context.WithCancel(context.Background())
+	assert.NoError(p.StartContext(ctx))
+	p.Stop()
+	p.Stop()
+	cancel()
+}
+
+func TestStopTwice(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 16)
+	p := NewPersister(&memoryStore{data: make(map[string][]points.Point)}, in, nil)
+
+	assert.Equal(errNotStarted, p.StopWithTimeout(0))
+
+	assert.NoError(p.Start())
+	assert.NotPanics(func() {
+		p.Stop()
+		p.Stop()
+		p.Stop()
+	})
+	assert.NoError(p.StopWithTimeout(0))
+}
@@ -0,0 +1,72 @@
+package persister
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+type batch []*points.Points
+
+type byLength batch
+type byTimestamp batch
+
+func (v byLength) Len() int           { return len(v) }
+func (v byLength) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byLength) Less(i, j int) bool { return len(v[i].Data) > len(v[j].Data) }
+
+func (v byTimestamp) Len() int      { return len(v) }
+func (v byTimestamp) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byTimestamp) Less(i, j int) bool {
+	// values without points first
+	if len(v[i].Data) == 0 || len(v[j].Data) == 0 {
+		return len(v[i].Data) < len(v[j].Data)
+	}
+	return v[i].Data[0].Timestamp < v[j].Data[0].Timestamp
+}
+
+// WriteStrategy defines order of writing points received by worker in one batch
+type WriteStrategy int
+
+const (
+	// Noop writes points in order of receiving
+	Noop WriteStrategy = iota
+	// MaximumLength writes metrics with most points first
+	MaximumLength
+	// TimestampOrder writes metrics with oldest first point first, values without points before others
+	TimestampOrder
+)
+
+// SetWriteStrategy sets order of writing. Values: "max", "sorted", "noop"
+func (p *Whisper) SetWriteStrategy(s string) (err error) {
+	switch s {
+	case "max":
+		p.writeStrategy = MaximumLength
+	case "sorted":
+		p.writeStrategy = TimestampOrder
+	case "noop":
+		p.writeStrategy = Noop
+	default:
+		return fmt.Errorf("Unknown write strategy '%s', should be one of: max, sorted, noop", s)
+	}
+	return nil
+}
+
+// readBatch appends to b first received values and all values already buffered in channel (up to max).
+// Returns false if channel closed
+func readBatch(b batch, first *points.Points, in chan *points.Points, max int) (batch, bool) {
+	b = append(b, first)
+	drained, ok := points.Channel(in).Drain(b, max-len(b))
+	return batch(drained), ok
+}
+
+func (p *Whisper) sortBatch(b batch) {
+	switch p.writeStrategy {
+	case MaximumLength:
+		sort.Stable(byLength(b))
+	case TimestampOrder:
+		sort.Stable(byTimestamp(b))
+	case Noop:
+	}
+}
@@ -0,0 +1,129 @@
+package persister
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetWriteStrategy(t *testing.T) {
+	assert := assert.New(t)
+	p := Whisper{}
+
+	assert.NoError(p.SetWriteStrategy("max"))
+	assert.Equal(MaximumLength, p.writeStrategy)
+	assert.NoError(p.SetWriteStrategy("sorted"))
+	assert.Equal(TimestampOrder, p.writeStrategy)
+	assert.NoError(p.SetWriteStrategy("noop"))
+	assert.Equal(Noop, p.writeStrategy)
+	assert.Error(p.SetWriteStrategy("unknown"))
+}
+
+func TestSortBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	newBatch := func() batch {
+		return batch{
+			points.OnePoint("a", 1, 30).Add(1, 40),
+			// empty values
+			&points.Points{Metric: "e"},
+			points.OnePoint("b", 1, 10),
+			points.OnePoint("c", 1, 20).Add(1, 30).Add(1, 40),
+		}
+	}
+
+	names := func(b batch) []string {
+		res := make([]string, len(b))
+		for i, v := range b {
+			res[i] = v.Metric
+		}
+		return res
+	}
+
+	table := []struct {
+		strategy string
+		expected []string
+	}{
+		{"noop", []string{"a", "e", "b", "c"}},
+		{"max", []string{"c", "a", "b", "e"}},
+		{"sorted", []string{"e", "b", "c", "a"}},
+	}
+
+	for _, c := range table {
+		p := Whisper{}
+		p.SetWriteStrategy(c.strategy)
+		b := newBatch()
+		p.sortBatch(b)
+		assert.Equal(c.expected, names(b), c.strategy)
+
+		empty := batch{}
+		p.sortBatch(empty)
+		assert.Len(empty, 0)
+	}
+}
+
+func TestWorkerWriteStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 16)
+	in <- points.OnePoint("a", 1, 30)
+	in <- points.OnePoint("b", 1, 10)
+	in <- points.OnePoint("c", 1, 20)
+	close(in)
+
+	p := &Whisper{}
+	p.SetWriteStrategy("sorted")
+
+	var stored []string
+	p.mockStore = func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {
+			stored = append(stored, values.Metric)
+		}, nil
+	}
+
+	p.worker(in, nil, nil)
+	assert.Equal([]string{"b", "c", "a"}, stored)
+}
+
+func benchmarkWriteStrategy(b *testing.B, strategy string) {
+	metricsCount := 1000 * 1000
+
+	values := make(batch, metricsCount)
+	for i := 0; i < metricsCount; i++ {
+		p := points.OnePoint(fmt.Sprintf("metric.name.for.bench.test.%d", i), 0, rand.Int63n(int64(metricsCount)))
+		for j := 0; j < rand.Intn(100); j++ {
+			p.Add(0, int64(j))
+		}
+		values[i] = p
+	}
+
+	p := &Whisper{}
+	if err := p.SetWriteStrategy(strategy); err != nil {
+		b.Fatal(err)
+	}
+
+	p.mockStore = func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {}, nil
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		in := make(chan *points.Points, metricsCount)
+		for _, v := range values {
+			in <- v
+		}
+		close(in)
+		b.StartTimer()
+
+		p.worker(in, nil, nil)
+	}
+}
+
+func BenchmarkWriteStrategyMax(b *testing.B)    { benchmarkWriteStrategy(b, "max") }
+func BenchmarkWriteStrategySorted(b *testing.B) { benchmarkWriteStrategy(b, "sorted") }
+func BenchmarkWriteStrategyNoop(b *testing.B)   { benchmarkWriteStrategy(b, "noop") }
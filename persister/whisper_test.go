@@ -1,9 +1,15 @@
 package persister
 
 import (
+	"os"
+	"path/filepath"
+	"regexp"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
 
 	"github.com/stretchr/testify/assert"
 
@@ -19,12 +25,15 @@ func TestNewWhisper(t *testing.T) {
 	aggrs := WhisperAggregation{}
 	output := NewWhisper("foo", schemas, &aggrs, inchan, nil)
 	expected := Whisper{
-		in:           inchan,
-		schemas:      schemas,
-		aggregation:  &aggrs,
-		workersCount: 1,
-		rootPath:     "foo",
+		in:               inchan,
+		workersCount:     1,
+		rootPath:         "foo",
+		stopTimeout:      10 * time.Second,
+		degradedRetryMin: time.Second,
+		pathEncoder:      SafePathEncoder{},
+		createOpener:     osCreateOpener{},
 	}
+	expected.SetStorageConfig(schemas, &aggrs)
 	assert.Equal(t, *output, expected)
 }
 
@@ -58,7 +67,7 @@ func TestShuffler(t *testing.T) {
 	out3 := make(chan *points.Points)
 	out4 := make(chan *points.Points)
 	out := [](chan *points.Points){out1, out2, out3, out4}
-	go fixture.shuffler(in, out, nil)
+	go fixture.shuffler(in, out, nil, nil)
 	buckets := [4]int{0, 0, 0, 0}
 	runlength := 10000
 
@@ -93,3 +102,107 @@ func TestShuffler(t *testing.T) {
 	assert.Equal(t, runlength, total, "total output of shuffle is not equal to input")
 
 }
+
+func TestStoreOutdatedPoints(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, err := ParseRetentionDefs("60s:1h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		now := time.Now().Unix()
+
+		store(p, points.OnePoint("outdated", 42, now-7200))
+		_, err = os.Stat(filepath.Join(root, "outdated.wsp"))
+		assert.True(os.IsNotExist(err))
+
+		store(p, points.OnePoint("mixed", 42, now-7200).Add(43, now-60))
+		_, err = os.Stat(filepath.Join(root, "mixed.wsp"))
+		assert.NoError(err)
+
+		// custom max age
+		p.SetMaxRetentionAge(time.Minute)
+		store(p, points.OnePoint("custom", 42, now-600))
+		_, err = os.Stat(filepath.Join(root, "custom.wsp"))
+		assert.True(os.IsNotExist(err))
+
+		var outdated float64
+		p.Stat(func(metric string, value float64) {
+			if metric == "outdatedPoints" {
+				outdated = value
+			}
+		})
+		assert.Equal(float64(3), outdated)
+
+		// age is relative to clock of persister
+		p.SetMaxRetentionAge(0)
+		past := time.Now().Add(-2 * time.Hour)
+		p.nowFunc = func() time.Time { return past }
+		store(p, points.OnePoint("past", 42, past.Unix()-60))
+		_, err = os.Stat(filepath.Join(root, "past.wsp"))
+		assert.NoError(err)
+	})
+}
+
+// syncCountingOpener counts syncs of opened whisper files
+type syncCountingOpener struct {
+	osCreateOpener
+	syncs *int32
+}
+
+type syncCountingFile struct {
+	WhisperFile
+	syncs *int32
+}
+
+func (f syncCountingFile) Sync() error {
+	atomic.AddInt32(f.syncs, 1)
+	return f.WhisperFile.(syncer).Sync()
+}
+
+func (co syncCountingOpener) Open(path string) (WhisperFile, error) {
+	w, err := co.osCreateOpener.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return syncCountingFile{WhisperFile: w, syncs: co.syncs}, nil
+}
+
+func (co syncCountingOpener) Create(path string, retentions whisper.Retentions, aggregationMethod whisper.AggregationMethod, xFilesFactor float32, sparse bool) (WhisperFile, error) {
+	w, err := co.osCreateOpener.Create(path, retentions, aggregationMethod, xFilesFactor, sparse)
+	if err != nil {
+		return nil, err
+	}
+	return syncCountingFile{WhisperFile: w, syncs: co.syncs}, nil
+}
+
+func TestFsync(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		var syncs int32
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetCreateOpener(syncCountingOpener{syncs: &syncs})
+
+		now := time.Now().Unix()
+		assert.NoError(store(p, points.OnePoint("metric", 1, now-60)))
+		assert.Equal(int32(0), atomic.LoadInt32(&syncs))
+
+		// opened file is synced after update
+		p.SetFsync(true)
+		assert.NoError(store(p, points.OnePoint("metric", 2, now-60)))
+		assert.NoError(store(p, points.OnePoint("new", 3, now-60)))
+		assert.Equal(int32(2), atomic.LoadInt32(&syncs))
+	})
+}
@@ -0,0 +1,150 @@
+package persister
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/lomik/go-whisper"
+)
+
+// ValidationResult describes settings which persister would apply to metric
+type ValidationResult struct {
+	Metric            string
+	Path              string
+	Schema            string
+	Retentions        string
+	Aggregation       string
+	AggregationMethod string
+	XFilesFactor      float64
+	Size              int64 // estimated size of whisper file in bytes, sparse file takes less until filled
+	Err               error
+}
+
+func (r ValidationResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s\terror: %s", r.Metric, r.Err.Error())
+	}
+	return fmt.Sprintf("%s\tschema=%s retentions=%s aggregation=%s method=%s xFilesFactor=%g size=%d path=%s",
+		r.Metric, r.Schema, r.Retentions, r.Aggregation, r.AggregationMethod, r.XFilesFactor, r.Size, r.Path)
+}
+
+// validationFields are names of columns of WriteValidationCSV and keys of WriteValidationJSON
+var validationFields = []string{"metric", "path", "schema", "retentions", "aggregation", "method", "xFilesFactor", "size", "error"}
+
+func (r ValidationResult) fields() []string {
+	var errStr string
+	if r.Err != nil {
+		errStr = r.Err.Error()
+	}
+	return []string{
+		r.Metric, r.Path, r.Schema, r.Retentions, r.Aggregation, r.AggregationMethod,
+		strconv.FormatFloat(r.XFilesFactor, 'g', -1, 64), strconv.FormatInt(r.Size, 10), errStr,
+	}
+}
+
+// WriteValidationCSV writes results of Validate as CSV with header
+func WriteValidationCSV(w io.Writer, res []ValidationResult) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(validationFields); err != nil {
+		return err
+	}
+	for _, r := range res {
+		if err := cw.Write(r.fields()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type validationJSON struct {
+	Metric            string  `json:"metric"`
+	Path              string  `json:"path,omitempty"`
+	Schema            string  `json:"schema,omitempty"`
+	Retentions        string  `json:"retentions,omitempty"`
+	Aggregation       string  `json:"aggregation,omitempty"`
+	AggregationMethod string  `json:"method,omitempty"`
+	XFilesFactor      float64 `json:"xFilesFactor"`
+	Size              int64   `json:"size"`
+	Err               string  `json:"error,omitempty"`
+}
+
+// WriteValidationJSON writes results of Validate as JSON array of objects
+func WriteValidationJSON(w io.Writer, res []ValidationResult) error {
+	out := make([]validationJSON, len(res))
+	for i, r := range res {
+		out[i] = validationJSON{
+			Metric:            r.Metric,
+			Path:              r.Path,
+			Schema:            r.Schema,
+			Retentions:        r.Retentions,
+			Aggregation:       r.Aggregation,
+			AggregationMethod: r.AggregationMethod,
+			XFilesFactor:      r.XFilesFactor,
+			Size:              r.Size,
+		}
+		if r.Err != nil {
+			out[i].Err = r.Err.Error()
+		}
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// whisperFileSize returns size of whisper file with retentions: header, archive infos and points of all archives
+func whisperFileSize(retentions whisper.Retentions) int64 {
+	size := int64(whisper.MetadataSize + whisper.ArchiveInfoSize*len(retentions))
+	for _, r := range retentions {
+		size += int64(r.NumberOfPoints()) * whisper.PointSize
+	}
+	return size
+}
+
+var errNoSchema = errors.New("no storage schema matched")
+var errNoAggregation = errors.New("no storage aggregation matched")
+
+// Validate reports schema and aggregation which would be selected for each metric.
+// Whisper files are not opened or created
+func (p *Whisper) Validate(metrics []string) []ValidationResult {
+	res := make([]ValidationResult, 0, len(metrics))
+	storage := p.loadStorageConfig()
+
+	for _, metric := range metrics {
+		r := ValidationResult{Metric: metric}
+
+		path, _, err := p.metricPath(metric)
+		if err != nil {
+			r.Err = err
+			res = append(res, r)
+			continue
+		}
+		r.Path = path
+
+		schema, ok := storage.schemas.Match(metric)
+		if !ok {
+			r.Err = errNoSchema
+			res = append(res, r)
+			continue
+		}
+		r.Schema = schema.Name
+		r.Retentions = schema.RetentionStr
+		r.Size = whisperFileSize(schema.Retentions)
+
+		aggr := storage.aggregation.match(metric)
+		if aggr == nil {
+			r.Err = errNoAggregation
+			res = append(res, r)
+			continue
+		}
+		r.Aggregation = aggr.name
+		r.AggregationMethod = aggr.aggregationMethodStr
+		r.XFilesFactor = aggr.xFilesFactor
+
+		res = append(res, r)
+	}
+
+	return res
+}
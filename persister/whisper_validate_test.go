@@ -0,0 +1,69 @@
+package persister
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := ParseRetentionDefs("60s:1d")
+	schemas := WhisperSchemas{
+		Schema{Name: "carbon", Pattern: regexp.MustCompile("^carbon\\."), RetentionStr: "60s:1d", Retentions: retentions},
+	}
+
+	p := NewWhisper("/nonexistent", schemas, NewWhisperAggregation(), nil, nil)
+
+	res := p.Validate([]string{"carbon.agents.host.cpu", "collectd.host.cpu", "carbon..cpu"})
+	if !assert.Len(res, 3) {
+		return
+	}
+
+	assert.NoError(res[0].Err)
+	assert.Equal("carbon", res[0].Schema)
+	assert.Equal("60s:1d", res[0].Retentions)
+	assert.Equal("default", res[0].Aggregation)
+	assert.Equal("average", res[0].AggregationMethod)
+	assert.Equal(0.5, res[0].XFilesFactor)
+	assert.Equal(filepath.Join("/nonexistent", "carbon/agents/host/cpu.wsp"), res[0].Path)
+
+	assert.Equal(errNoSchema, res[1].Err)
+	assert.Error(res[2].Err)
+}
+
+func TestValidateOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	retentions, _ := ParseRetentionDefs("60s:1d,1h:7d")
+	schemas := WhisperSchemas{
+		Schema{Name: "carbon", Pattern: regexp.MustCompile("^carbon\\."), RetentionStr: "60s:1d,1h:7d", Retentions: retentions},
+	}
+
+	p := NewWhisper("/nonexistent", schemas, NewWhisperAggregation(), nil, nil)
+	res := p.Validate([]string{"carbon.cpu", "collectd.cpu"})
+
+	// header, 2 archives, 1440 + 168 points
+	assert.Equal(int64(16+2*12+(1440+168)*12), res[0].Size)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(WriteValidationCSV(buf, res))
+	assert.Equal("metric,path,schema,retentions,aggregation,method,xFilesFactor,size,error\n"+
+		"carbon.cpu,"+filepath.Join("/nonexistent", "carbon/cpu.wsp")+",carbon,\"60s:1d,1h:7d\",default,average,0.5,19336,\n"+
+		"collectd.cpu,"+filepath.Join("/nonexistent", "collectd/cpu.wsp")+",,,,,0,0,no storage schema matched\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(WriteValidationJSON(buf, res))
+	var out []map[string]interface{}
+	if assert.NoError(json.Unmarshal(buf.Bytes(), &out)) && assert.Len(out, 2) {
+		assert.Equal("carbon", out[0]["schema"])
+		assert.Equal(float64(19336), out[0]["size"])
+		assert.Nil(out[0]["error"])
+		assert.Equal("no storage schema matched", out[1]["error"])
+	}
+}
@@ -0,0 +1,136 @@
+package persister
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/lomik/go-whisper"
+)
+
+const (
+	// maxVerifyArchives is limit of archive count in header, larger count is surely garbage
+	maxVerifyArchives = 1024
+	// compressedMagic starts header of compressed whisper file
+	compressedMagic = "whisper_compressed"
+)
+
+// SetVerifyOnOpen enables check of header of existing whisper file on open: aggregation method, archive offsets
+// and file size. Corrupt file is quarantined and created again if quarantine-corrupt is enabled, else update
+// fails with open error. Check reads header of every opened file, so keep it disabled unless files are
+// damaged (e.g. after crash of filesystem) and updates of them fail with panics
+func (p *Whisper) SetVerifyOnOpen(enabled bool) {
+	p.verifyOnOpen = enabled
+}
+
+// verifyWhisperFile checks header of classic whisper file at path. Compressed files are not checked
+func verifyWhisperFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+
+	header := make([]byte, whisper.MetadataSize)
+	if size < int64(len(header)) {
+		return fmt.Errorf("file size %d is less than header size %d", size, len(header))
+	}
+	if _, err = f.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if size >= int64(len(compressedMagic)) {
+		magic := make([]byte, len(compressedMagic))
+		if _, err = f.ReadAt(magic, 0); err != nil {
+			return err
+		}
+		if bytes.Equal(magic, []byte(compressedMagic)) {
+			return nil
+		}
+	}
+
+	// values above 1024 are lastUpdate of very old format with average aggregation
+	method := binary.BigEndian.Uint32(header[0:4])
+	if method < uint32(whisper.Average) || (method > uint32(whisper.First) && method <= 1024) {
+		return fmt.Errorf("unknown aggregation method %d", method)
+	}
+	maxRetention := binary.BigEndian.Uint32(header[4:8])
+	xFilesFactor := math.Float32frombits(binary.BigEndian.Uint32(header[8:12]))
+	if !(xFilesFactor >= 0 && xFilesFactor <= 1) {
+		return fmt.Errorf("xFilesFactor %v is out of [0, 1]", xFilesFactor)
+	}
+	archiveCount := binary.BigEndian.Uint32(header[12:16])
+	if archiveCount == 0 || archiveCount > maxVerifyArchives {
+		return fmt.Errorf("bad archive count %d", archiveCount)
+	}
+
+	offset := int64(whisper.MetadataSize) + int64(archiveCount)*whisper.ArchiveInfoSize
+	if size < offset {
+		return fmt.Errorf("file size %d is less than header size %d of %d archives", size, offset, archiveCount)
+	}
+	info := make([]byte, int(archiveCount)*whisper.ArchiveInfoSize)
+	if _, err = f.ReadAt(info, whisper.MetadataSize); err != nil {
+		return err
+	}
+
+	var secondsPerPoint, points uint32
+	for i := 0; i < int(archiveCount); i++ {
+		b := info[i*whisper.ArchiveInfoSize:]
+		archiveOffset := binary.BigEndian.Uint32(b[0:4])
+		spp := binary.BigEndian.Uint32(b[4:8])
+		points = binary.BigEndian.Uint32(b[8:12])
+
+		if int64(archiveOffset) != offset {
+			return fmt.Errorf("archive %d offset %d, expected %d", i, archiveOffset, offset)
+		}
+		if spp == 0 || points == 0 {
+			return fmt.Errorf("archive %d is empty: %d seconds per point, %d points", i, spp, points)
+		}
+		if spp <= secondsPerPoint {
+			return fmt.Errorf("archive %d is not less precise than archive %d", i, i-1)
+		}
+		secondsPerPoint = spp
+		offset += int64(points) * whisper.PointSize
+	}
+
+	if uint64(maxRetention) != uint64(secondsPerPoint)*uint64(points) {
+		return fmt.Errorf("max retention %d is not retention %d of the last archive", maxRetention, uint64(secondsPerPoint)*uint64(points))
+	}
+	if size != offset {
+		return fmt.Errorf("file size %d, expected %d", size, offset)
+	}
+	return nil
+}
+
+// verifyOpened checks header of opened file w. On failure w is closed and file is quarantined if
+// quarantine-corrupt is enabled. Files without descriptor are not checked
+func (p *Whisper) verifyOpened(w WhisperFile, metric string, path string) error {
+	if _, ok := w.(descriptor); !ok {
+		return nil
+	}
+
+	err := verifyWhisperFile(path)
+	if err == nil {
+		return nil
+	}
+
+	w.Close()
+	atomic.AddUint32(&p.verifyFailed, 1)
+	p.log.WithFields(logrus.Fields{
+		"metric": metric,
+		"path":   path,
+	}).Errorf("[persister] Corrupt whisper file %s: %s", path, err.Error())
+	if p.quarantineCorrupt {
+		quarantine(path)
+	}
+	return fmt.Errorf("corrupt header: %s", err.Error())
+}
@@ -0,0 +1,109 @@
+package persister
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyWhisperFile(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := whisper.ParseRetentionDefs("60s:1h,1h:1d")
+
+		create := func(name string, sparse bool) string {
+			path := filepath.Join(root, name)
+			w, err := whisper.CreateWithOptions(path, retentions, whisper.Sum, 0.5, &whisper.Options{Sparse: sparse})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			return path
+		}
+
+		assert.NoError(verifyWhisperFile(create("ok.wsp", false)))
+		assert.NoError(verifyWhisperFile(create("sparse.wsp", true)))
+
+		// corrupt copies of valid file
+		valid, err := ioutil.ReadFile(create("valid.wsp", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt := map[string]func(b []byte) []byte{
+			"short":       func(b []byte) []byte { return b[:10] },
+			"truncated":   func(b []byte) []byte { return b[:len(b)-1] },
+			"extended":    func(b []byte) []byte { return append(b, 0) },
+			"aggregation": func(b []byte) []byte { binary.BigEndian.PutUint32(b[0:], 42); return b },
+			"retention":   func(b []byte) []byte { binary.BigEndian.PutUint32(b[4:], 1); return b },
+			"archives":    func(b []byte) []byte { binary.BigEndian.PutUint32(b[12:], 0); return b },
+			"offset":      func(b []byte) []byte { binary.BigEndian.PutUint32(b[16+12:], 100); return b },
+			"precision":   func(b []byte) []byte { binary.BigEndian.PutUint32(b[16+12+4:], 60); return b },
+		}
+		for name, fn := range corrupt {
+			b := fn(append([]byte(nil), valid...))
+			path := filepath.Join(root, name+".wsp")
+			if err := ioutil.WriteFile(path, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			assert.Error(verifyWhisperFile(path), name)
+		}
+	})
+}
+
+func TestVerifyOnOpen(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		retentions, _ := ParseRetentionDefs("60s:1h")
+		schemas := WhisperSchemas{
+			Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "60s:1h", Retentions: retentions},
+		}
+
+		p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+		p.SetVerifyOnOpen(true)
+
+		now := time.Now().Unix()
+		assert.NoError(store(p, points.OnePoint("metric", 1, now)))
+		assert.NoError(store(p, points.OnePoint("metric", 2, now)))
+
+		// archive offset points into header
+		path := filepath.Join(root, "metric.wsp")
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.BigEndian.PutUint32(b[16:], 4)
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err = store(p, points.OnePoint("metric", 3, now))
+		if assert.IsType(&StoreError{}, err) {
+			assert.Equal(StoreOpOpen, err.(*StoreError).Op)
+		}
+
+		// quarantined and created again
+		p.SetQuarantineCorrupt(true)
+		assert.NoError(store(p, points.OnePoint("metric", 4, now)))
+		_, err = os.Stat(path + ".corrupt")
+		assert.NoError(err)
+		assert.NoError(verifyWhisperFile(path))
+
+		stat := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		assert.Equal(float64(2), stat["verifyFailed"])
+		assert.Equal(float64(2), stat["created"])
+	})
+}
@@ -0,0 +1,147 @@
+package persister
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// minWatchdogInterval limits check rate of watchdog with small store timeout
+const minWatchdogInterval = 10 * time.Millisecond
+
+// SetStoreTimeout enables watchdog of workers: store of values longer than timeout is logged with metric name,
+// counted by persister.storeTimeouts and its worker is reported stalled (Stalled, persister.stalledWorkers metric
+// and 503 of /health). Blocking file I/O can't be canceled, worker stays blocked until OS returns. 0 - disabled
+func (p *Whisper) SetStoreTimeout(timeout time.Duration) {
+	p.storeTimeout = timeout
+}
+
+// storeWatchdog keeps store in progress of each worker
+type storeWatchdog struct {
+	sync.Mutex
+	slots []*watchSlot
+}
+
+// watchSlot is store in progress of one worker
+type watchSlot struct {
+	sync.Mutex
+	metric  string
+	started time.Time // zero - worker waits for input
+	stalled bool      // timeout of current store is reported
+}
+
+// register returns slot of new worker
+func (d *storeWatchdog) register() *watchSlot {
+	d.Lock()
+	defer d.Unlock()
+
+	slot := &watchSlot{}
+	d.slots = append(d.slots, slot)
+	return slot
+}
+
+// unregister removes slot of stopped worker
+func (d *storeWatchdog) unregister(slot *watchSlot) {
+	d.Lock()
+	defer d.Unlock()
+
+	for i, s := range d.slots {
+		if s == slot {
+			d.slots = append(d.slots[:i], d.slots[i+1:]...)
+			return
+		}
+	}
+}
+
+// check marks stores started before now-timeout as stalled and calls timedOut once for each of them.
+// Returns count of stalled workers
+func (d *storeWatchdog) check(now time.Time, timeout time.Duration, timedOut func(metric string, duration time.Duration)) int {
+	d.Lock()
+	defer d.Unlock()
+
+	var stalled int
+	for _, s := range d.slots {
+		s.Lock()
+		if !s.started.IsZero() && now.Sub(s.started) >= timeout {
+			stalled++
+			if !s.stalled {
+				s.stalled = true
+				timedOut(s.metric, now.Sub(s.started))
+			}
+		}
+		s.Unlock()
+	}
+	return stalled
+}
+
+// watched returns store func which keeps values in progress in slot
+func (s *watchSlot) watched(store StoreFunc) StoreFunc {
+	return func(p *Whisper, values *points.Points) {
+		s.Lock()
+		s.metric = values.Metric
+		s.started = time.Now()
+		s.Unlock()
+
+		store(p, values)
+
+		s.Lock()
+		stalled, duration := s.stalled, time.Since(s.started)
+		s.started = time.Time{}
+		s.stalled = false
+		s.Unlock()
+
+		if stalled {
+			logrus.Warnf("[persister] Stalled store of %s returned after %s", values.Metric, duration.String())
+		}
+	}
+}
+
+// watchdog reports stores longer than store timeout until exit
+func (p *Whisper) watchdog(exit chan bool) {
+	interval := p.storeTimeout / 2
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+			p.checkStalled(time.Now())
+		}
+	}
+}
+
+// checkStalled logs and counts newly stalled stores
+func (p *Whisper) checkStalled(now time.Time) int {
+	return p.storeWatchdog.check(now, p.storeTimeout, func(metric string, duration time.Duration) {
+		atomic.AddUint32(&p.storeTimeouts, 1)
+		p.log.WithFields(logrus.Fields{
+			"metric": metric,
+		}).Errorf("[persister] Store of %s is running for %s, worker is stalled", metric, duration.String())
+	})
+}
+
+// Stalled returns true if store of any worker runs longer than store timeout
+func (p *Whisper) Stalled() bool {
+	if p.storeTimeout <= 0 {
+		return false
+	}
+	return p.checkStalled(time.Now()) > 0
+}
+
+func (p *Whisper) watchdogStat(send helper.StatCallback) {
+	if p.storeTimeout <= 0 {
+		return
+	}
+	send("stalledWorkers", float64(p.checkStalled(time.Now())))
+	helper.SendAndSubstractUint32("storeTimeouts", &p.storeTimeouts, send)
+}
@@ -0,0 +1,82 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreWatchdog(t *testing.T) {
+	assert := assert.New(t)
+
+	var d storeWatchdog
+	slot := d.register()
+	now := time.Now()
+
+	var timedOut []string
+	check := func(at time.Time) int {
+		return d.check(at, time.Second, func(metric string, duration time.Duration) {
+			timedOut = append(timedOut, metric)
+		})
+	}
+
+	slot.metric = "a.b"
+	slot.started = now
+	assert.Equal(0, check(now.Add(500*time.Millisecond)))
+	assert.Equal(1, check(now.Add(time.Second)))
+	// reported once
+	assert.Equal(1, check(now.Add(2*time.Second)))
+	assert.Equal([]string{"a.b"}, timedOut)
+
+	d.unregister(slot)
+	assert.Equal(0, check(now.Add(3*time.Second)))
+}
+
+func TestStoreTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	release := make(chan bool)
+	stored := make(chan string, 10)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, nil)
+	p.SetMockStore(func() (StoreFunc, func()) {
+		return func(p *Whisper, values *points.Points) {
+			if values.Metric == "hung" {
+				<-release
+			}
+			stored <- values.Metric
+		}, nil
+	})
+	p.SetStoreTimeout(20 * time.Millisecond)
+	assert.NoError(p.Start())
+	defer p.Stop()
+
+	stat := func() map[string]float64 {
+		result := make(map[string]float64)
+		p.Stat(func(metric string, value float64) {
+			result[metric] = value
+		})
+		return result
+	}
+
+	in <- points.OnePoint("fast", 1, 1)
+	assert.Equal("fast", <-stored)
+	assert.False(p.Stalled())
+
+	in <- points.OnePoint("hung", 1, 1)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(p.Stalled())
+	s := stat()
+	assert.Equal(float64(1), s["stalledWorkers"])
+	assert.Equal(float64(1), s["storeTimeouts"])
+
+	close(release)
+	assert.Equal("hung", <-stored)
+	assert.False(p.Stalled())
+	s = stat()
+	assert.Equal(float64(0), s["stalledWorkers"])
+	assert.Equal(float64(0), s["storeTimeouts"])
+}
@@ -0,0 +1,20 @@
+package persister
+
+import "runtime"
+
+// DefaultWorkers returns workers count for "perCPU * GOMAXPROCS", not less than 1 and not more than max
+// (0 - no cap). Writes are bound by disk rather than CPU: on HDD more workers than disk spindles only add seeks,
+// on SSD and NVMe concurrent writes scale up to queue depth of device. perCPU <= 0 - 1 per CPU
+func DefaultWorkers(perCPU float64, max int) int {
+	if perCPU <= 0 {
+		perCPU = 1
+	}
+	count := int(perCPU * float64(runtime.GOMAXPROCS(0)))
+	if max > 0 && count > max {
+		count = max
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
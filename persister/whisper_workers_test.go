@@ -0,0 +1,77 @@
+package persister
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	procs := runtime.GOMAXPROCS(0)
+	assert.Equal(procs, DefaultWorkers(1, 0))
+	assert.Equal(procs, DefaultWorkers(0, 0))
+	assert.Equal(2*procs, DefaultWorkers(2, 0))
+	assert.Equal(1, DefaultWorkers(0.01, 0))
+	assert.Equal(1, DefaultWorkers(4, 1))
+	if procs > 1 {
+		assert.Equal(procs-1, DefaultWorkers(1, procs-1))
+	}
+}
+
+// BenchmarkWorkers writes points of 10000 metrics by different workers count. Files are created in
+// GO_CARBON_BENCH_DIR (e.g. mount of SSD or HDD) or in temporary directory
+func BenchmarkWorkers(b *testing.B) {
+	const metrics = 10000
+
+	retentions, _ := ParseRetentionDefs("1s:1d")
+	schemas := WhisperSchemas{
+		Schema{Name: "default", Pattern: regexp.MustCompile(".*"), RetentionStr: "1s:1d", Retentions: retentions},
+	}
+
+	for _, workers := range []int{1, 2, 4, 8, 16, 32} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			root, err := ioutil.TempDir(os.Getenv("GO_CARBON_BENCH_DIR"), "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			in := make(chan *points.Points, 1024)
+			confirm := make(chan *points.Points, 1024)
+
+			p := NewWhisper(root, schemas, NewWhisperAggregation(), in, confirm)
+			p.SetWorkers(workers)
+			p.SetMaxOpenFiles(metrics)
+			if err = p.Start(); err != nil {
+				b.Fatal(err)
+			}
+			defer p.Stop()
+
+			// files are created before measure
+			now := time.Now().Unix()
+			for i := 0; i < metrics; i++ {
+				in <- points.OnePoint(fmt.Sprintf("bench.m%d", i), 1, now)
+				<-confirm
+			}
+
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					in <- points.OnePoint(fmt.Sprintf("bench.m%d", i%metrics), float64(i), now-int64(i/metrics))
+				}
+			}()
+			for i := 0; i < b.N; i++ {
+				<-confirm
+			}
+		})
+	}
+}
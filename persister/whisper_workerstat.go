@@ -0,0 +1,37 @@
+package persister
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// workerStat is counters of one worker of shuffler
+type workerStat struct {
+	queue            chan *points.Points
+	updateOperations uint32 // counter
+	committedPoints  uint32 // counter
+}
+
+// stored counts successfully stored values. Safe for nil stat
+func (s *workerStat) stored(values *points.Points) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint32(&s.updateOperations, 1)
+	atomic.AddUint32(&s.committedPoints, uint32(len(values.Data)))
+}
+
+// workersStat sends worker.<N>.updateOperations, worker.<N>.committedPoints and worker.<N>.queueDepth
+// of each worker of shuffler (common workers first, then workers of pools), so unbalanced sharding is visible
+func (p *Whisper) workersStat(send helper.StatCallback) {
+	stats, _ := p.workerStats.Load().([]*workerStat)
+	for i, s := range stats {
+		prefix := fmt.Sprintf("worker.%d.", i)
+		helper.SendAndSubstractUint32(prefix+"updateOperations", &s.updateOperations, send)
+		helper.SendAndSubstractUint32(prefix+"committedPoints", &s.committedPoints, send)
+		send(prefix+"queueDepth", float64(len(s.queue)))
+	}
+}
@@ -0,0 +1,63 @@
+package persister
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkersStat(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 10)
+	confirm := make(chan *points.Points, 10)
+
+	p := NewWhisper("/", nil, NewWhisperAggregation(), in, confirm)
+	p.SetCreateOpener(slowCreateOpener{})
+	p.SetWorkers(3)
+	// all metrics except "b.*" go to worker 0
+	p.SetShardFunc(func(metric string, workers int) int {
+		if strings.HasPrefix(metric, "b.") {
+			return 1
+		}
+		return 0
+	})
+
+	now := time.Now().Unix()
+	in <- &points.Points{Metric: "a.x", Data: []points.Point{{Value: 1, Timestamp: now - 1}, {Value: 2, Timestamp: now}}}
+	in <- points.OnePoint("a.y", 1, now)
+	in <- points.OnePoint("b.x", 1, now)
+
+	p.Start()
+	defer p.Stop()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-confirm:
+		case <-time.After(time.Second):
+			t.Fatal("not confirmed")
+		}
+	}
+
+	stat := make(map[string]float64)
+	p.Stat(func(metric string, value float64) {
+		if strings.HasPrefix(metric, "worker.") {
+			stat[metric] = value
+		}
+	})
+
+	assert.Equal(map[string]float64{
+		"worker.0.updateOperations": 2,
+		"worker.0.committedPoints":  3,
+		"worker.0.queueDepth":       0,
+		"worker.1.updateOperations": 1,
+		"worker.1.committedPoints":  1,
+		"worker.1.queueDepth":       0,
+		"worker.2.updateOperations": 0,
+		"worker.2.committedPoints":  0,
+		"worker.2.queueDepth":       0,
+	}, stat)
+}
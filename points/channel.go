@@ -0,0 +1,21 @@
+package points
+
+// Channel is queue of values, e.g. input of persister worker. Plain "chan *Points" is converted by Channel(ch)
+type Channel chan *Points
+
+// Drain appends to dst up to max values already queued in channel without blocking, so consumer can merge values
+// of the same metric and sort them before write. Returns false if channel is closed. max <= 0 - nothing is read
+func (ch Channel) Drain(dst []*Points, max int) ([]*Points, bool) {
+	for i := 0; i < max; i++ {
+		select {
+		case values, ok := <-ch:
+			if !ok {
+				return dst, false
+			}
+			dst = append(dst, values)
+		default:
+			return dst, true
+		}
+	}
+	return dst, true
+}
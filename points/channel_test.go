@@ -0,0 +1,51 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	ch := make(Channel, 10)
+
+	// empty channel doesn't block
+	b, ok := ch.Drain(nil, 5)
+	assert.True(ok)
+	assert.Len(b, 0)
+
+	for i := 0; i < 5; i++ {
+		ch <- OnePoint("a", float64(i), 1)
+	}
+
+	// partial drain keeps the rest queued
+	first := OnePoint("first", 1, 1)
+	b, ok = ch.Drain([]*Points{first}, 3)
+	assert.True(ok)
+	if assert.Len(b, 4) {
+		assert.True(b[0] == first)
+		assert.Equal(float64(0), b[1].Data[0].Value)
+		assert.Equal(float64(2), b[3].Data[0].Value)
+	}
+	assert.Len(ch, 2)
+
+	b, ok = ch.Drain(b[:0], 0)
+	assert.True(ok)
+	assert.Len(b, 0)
+	assert.Len(ch, 2)
+
+	// queued values are read before close is reported
+	close(ch)
+	b, ok = ch.Drain(b[:0], 5)
+	assert.False(ok)
+	assert.Len(b, 2)
+
+	// conversion of plain channel
+	in := make(chan *Points, 1)
+	in <- OnePoint("b", 1, 1)
+	b, ok = Channel(in).Drain(nil, 5)
+	assert.True(ok)
+	assert.Len(b, 1)
+}
@@ -0,0 +1,79 @@
+package points
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DedupPolicy defines value of points with the same timestamp
+type DedupPolicy int
+
+const (
+	// DedupLast keeps last received value
+	DedupLast DedupPolicy = iota
+	// DedupFirst keeps first received value
+	DedupFirst
+	// DedupSum sums values
+	DedupSum
+)
+
+// ParseDedupPolicy returns policy by name: "last", "first" or "sum"
+func ParseDedupPolicy(s string) (DedupPolicy, error) {
+	switch s {
+	case "last":
+		return DedupLast, nil
+	case "first":
+		return DedupFirst, nil
+	case "sum":
+		return DedupSum, nil
+	}
+	return DedupLast, fmt.Errorf("unknown dedup policy %#v, use \"last\", \"first\" or \"sum\"", s)
+}
+
+type byTimestamp []Point
+
+func (v byTimestamp) Len() int           { return len(v) }
+func (v byTimestamp) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byTimestamp) Less(i, j int) bool { return v[i].Timestamp < v[j].Timestamp }
+
+// Dedup returns points sorted by timestamp with one value per timestamp. Returns p itself if data
+// is already sorted without duplicates, otherwise new object. p is never modified
+func (p *Points) Dedup(policy DedupPolicy) *Points {
+	sorted := true
+	for i := 1; i < len(p.Data); i++ {
+		if p.Data[i].Timestamp <= p.Data[i-1].Timestamp {
+			sorted = false
+			break
+		}
+	}
+	if sorted {
+		return p
+	}
+
+	data := make([]Point, len(p.Data))
+	copy(data, p.Data)
+	// stable, so order of receiving is kept for equal timestamps
+	sort.Stable(byTimestamp(data))
+
+	n := 0
+	for i := 1; i < len(data); i++ {
+		if data[i].Timestamp != data[n].Timestamp {
+			n++
+			data[n] = data[i]
+			continue
+		}
+
+		switch policy {
+		case DedupLast:
+			data[n] = data[i]
+		case DedupSum:
+			data[n].Value += data[i].Value
+		case DedupFirst:
+		}
+	}
+
+	return &Points{
+		Metric: p.Metric,
+		Data:   data[:n+1],
+	}
+}
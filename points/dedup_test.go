@@ -0,0 +1,44 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	p := OnePoint("metric", 1, 30).Add(2, 10).Add(3, 30).Add(4, 20).Add(5, 10)
+	source := OnePoint("metric", 1, 30).Add(2, 10).Add(3, 30).Add(4, 20).Add(5, 10)
+
+	assert.Equal(OnePoint("metric", 5, 10).Add(4, 20).Add(3, 30), p.Dedup(DedupLast))
+	assert.Equal(OnePoint("metric", 2, 10).Add(4, 20).Add(1, 30), p.Dedup(DedupFirst))
+	assert.Equal(OnePoint("metric", 7, 10).Add(4, 20).Add(4, 30), p.Dedup(DedupSum))
+
+	// source is not modified
+	assert.Equal(source, p)
+
+	// unsorted without duplicates
+	assert.Equal(OnePoint("metric", 2, 10).Add(1, 20), OnePoint("metric", 1, 20).Add(2, 10).Dedup(DedupSum))
+
+	// sorted
+	sorted := OnePoint("metric", 1, 10).Add(2, 20)
+	assert.True(sorted == sorted.Dedup(DedupLast))
+
+	empty := &Points{Metric: "metric"}
+	assert.True(empty == empty.Dedup(DedupLast))
+}
+
+func TestParseDedupPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, expected := range map[string]DedupPolicy{"last": DedupLast, "first": DedupFirst, "sum": DedupSum} {
+		policy, err := ParseDedupPolicy(s)
+		assert.NoError(err)
+		assert.Equal(expected, policy)
+	}
+
+	_, err := ParseDedupPolicy("max")
+	assert.Error(err)
+}
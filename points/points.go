@@ -58,10 +58,25 @@ func (p *Points) Copy() *Points {
 // ParseText parse text protocol Point
 //  host.Point.value 42 1422641531\n
 func ParseText(line string) (*Points, error) {
+	p, _, err := ParseTextTimestamp(line, nil)
+	return p, err
+}
+
+// ParseTextTimestamp parses text protocol Point like ParseText. If now is not nil line without timestamp
+//  host.Point.value 42\n
+// gets timestamp now() and stamped is true, like in carbon-cache
+func ParseTextTimestamp(line string, now func() int64) (p *Points, stamped bool, err error) {
 
 	row := strings.Split(strings.Trim(line, "\n \t\r"), " ")
+	if len(row) == 2 && now != nil {
+		value, err := strconv.ParseFloat(row[1], 64)
+		if err != nil || math.IsNaN(value) {
+			return nil, false, fmt.Errorf("bad message: %#v", line)
+		}
+		return OnePoint(row[0], value, now()), true, nil
+	}
 	if len(row) != 3 {
-		return nil, fmt.Errorf("bad message: %#v", line)
+		return nil, false, fmt.Errorf("bad message: %#v", line)
 	}
 
 	// 0x2e == ".". Or use split? @TODO: benchmark
@@ -72,13 +87,13 @@ func ParseText(line string) (*Points, error) {
 	value, err := strconv.ParseFloat(row[1], 64)
 
 	if err != nil || math.IsNaN(value) {
-		return nil, fmt.Errorf("bad message: %#v", line)
+		return nil, false, fmt.Errorf("bad message: %#v", line)
 	}
 
 	tsf, err := strconv.ParseFloat(row[2], 64)
 
 	if err != nil || math.IsNaN(tsf) {
-		return nil, fmt.Errorf("bad message: %#v", line)
+		return nil, false, fmt.Errorf("bad message: %#v", line)
 	}
 
 	// 315522000 == "1980-01-01 00:00:00"
@@ -92,7 +107,7 @@ func ParseText(line string) (*Points, error) {
 	// 	return nil, fmt.Errorf("bad message: %#v", line)
 	// }
 
-	return OnePoint(row[0], value, int64(tsf)), nil
+	return OnePoint(row[0], value, int64(tsf)), false, nil
 }
 
 // ParsePickle ...
@@ -62,6 +62,33 @@ func TestParseText(t *testing.T) {
 
 }
 
+func TestParseTextTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	now := func() int64 { return 1422642189 }
+
+	p, stamped, err := ParseTextTimestamp("metric.name 42.15\n", now)
+	assert.NoError(err)
+	assert.True(stamped)
+	assert.Equal(OnePoint("metric.name", 42.15, 1422642189), p)
+
+	p, stamped, err = ParseTextTimestamp("metric.name 42.15 1422640000\n", now)
+	assert.NoError(err)
+	assert.False(stamped)
+	assert.Equal(OnePoint("metric.name", 42.15, 1422640000), p)
+
+	// strict
+	_, _, err = ParseTextTimestamp("metric.name 42.15\n", nil)
+	assert.Error(err)
+
+	for _, line := range []string{"metric.name NaN\n", "metric.name 42a\n", "metric.name\n", "42\n"} {
+		p, stamped, err = ParseTextTimestamp(line, now)
+		assert.Error(err, line)
+		assert.False(stamped, line)
+		assert.Nil(p, line)
+	}
+}
+
 func TestCopyAndEq(t *testing.T) {
 	assert := assert.New(t)
 
@@ -160,6 +187,18 @@ var (
 				OnePoint("param2", -15, 1423931224),
 			},
 		},
+		// python3: pickle.dumps([("carbon.relay.a", (1452200952, 42.5), (1452200960, 43)),
+		//   ("carbon.relay.b", (1452200952, -1)), ("carbon.relay.c", (1452200952.7, 2**40))], protocol=2)
+		testcase{"Batch of protocol 2 from carbon-relay",
+			[]byte("\x80\x02]q\x00(X\x0e\x00\x00\x00carbon.relay.aq\x01J\xf8\xd3\x8eVG@E@\x00\x00\x00\x00\x00\x86q\x02J\x00\xd4" +
+				"\x8eVK+\x86q\x03\x87q\x04X\x0e\x00\x00\x00carbon.relay.bq\x05J\xf8\xd3\x8eVJ\xff\xff\xff\xff\x86q\x06\x86q\x07X\x0e" +
+				"\x00\x00\x00carbon.relay.cq\x08GA\xd5\xa3\xb4\xfe,\xcc\xcd\x8a\x06\x00\x00\x00\x00\x00\x01\x86q\x09\x86q\x0ae."),
+			[]*Points{
+				OnePoint("carbon.relay.a", 42.5, 1452200952).Add(43, 1452200960),
+				OnePoint("carbon.relay.b", -1, 1452200952),
+				OnePoint("carbon.relay.c", 1099511627776, 1452200952),
+			},
+		},
 	}
 
 	badPickles = [][]byte{
@@ -176,6 +215,12 @@ var (
 		[]byte("(lp0\n(S'param1'\np1\n(I-1423931224\nI60\ntp2\ntp3\na."),
 		// #5 timestamp too big for uint32
 		[]byte("(lp0\n(S'param1'\np1\n(I4294967296\nF60.2\ntp2\ntp3\na."),
+		// #6 dict instead of list: pickle.dumps({"a": 1}, protocol=2)
+		[]byte("\x80\x02}q\x00X\x01\x00\x00\x00aq\x01K\x01s."),
+		// #7 string value: pickle.dumps([("a", (1452200952, "x"))], protocol=2)
+		[]byte("\x80\x02]q\x00X\x01\x00\x00\x00aq\x01J\xf8\xd3\x8eVX\x01\x00\x00\x00xq\x02\x86q\x03\x86q\x04a."),
+		// #8 truncated
+		[]byte("\x80\x02]q\x00X\x0c\x00\x00\x00second.ba"),
 	}
 )
 
@@ -0,0 +1,22 @@
+package points
+
+// Split returns len(boundaries)+1 sub-batches of points by windows of timestamp between ascending boundaries
+// (unix time, e.g. now - max retention of every archive of whisper file): [-inf, b0), [b0, b1), ..., [bN, +inf).
+// Order of points is kept, windows without points have empty Data. Source is not modified
+func (p *Points) Split(boundaries []int) []*Points {
+	result := make([]*Points, len(boundaries)+1)
+	for i := range result {
+		result[i] = &Points{Metric: p.Metric}
+	}
+
+	for _, d := range p.Data {
+		// boundaries are few (archives of whisper file)
+		window := 0
+		for window < len(boundaries) && d.Timestamp >= int64(boundaries[window]) {
+			window++
+		}
+		result[window].Data = append(result[window].Data, d)
+	}
+
+	return result
+}
@@ -0,0 +1,37 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	assert := assert.New(t)
+
+	p := OnePoint("metric", 1, 100).Add(2, 200).Add(3, 99).Add(4, 300).Add(5, 250).Add(6, 400)
+
+	// samples straddling boundaries
+	parts := p.Split([]int{100, 250, 400})
+	assert.Equal([]*Points{
+		OnePoint("metric", 3, 99),
+		OnePoint("metric", 1, 100).Add(2, 200),
+		OnePoint("metric", 4, 300).Add(5, 250),
+		OnePoint("metric", 6, 400),
+	}, parts)
+
+	// source is not modified
+	assert.Equal(OnePoint("metric", 1, 100).Add(2, 200).Add(3, 99).Add(4, 300).Add(5, 250).Add(6, 400), p)
+
+	// empty windows
+	parts = p.Split([]int{0, 1000})
+	if assert.Len(parts, 3) {
+		assert.Empty(parts[0].Data)
+		assert.Equal(p.Data, parts[1].Data)
+		assert.Empty(parts[2].Data)
+		assert.Equal("metric", parts[2].Metric)
+	}
+
+	// no boundaries
+	assert.Equal([]*Points{p}, p.Split(nil))
+}
@@ -1,6 +1,7 @@
 package receiver
 
 import (
+	"net"
 	"testing"
 	"time"
 
@@ -59,3 +60,58 @@ func TestPickleMemoryError(t *testing.T) {
 		assert.Contains(log.String(), "W [pickle] Bad message")
 	})
 }
+
+func TestPickleBatches(t *testing.T) {
+	assert := assert.New(t)
+	test := newTCPTestCase(t, true)
+	defer test.Finish()
+
+	// >>> python3
+	// >>> batch = [("carbon.relay.a", (1452200952, 42.5), (1452200960, 43)),
+	// ...          ("carbon.relay.b", (1452200952, -1)), ("carbon.relay.c", (1452200952.7, 2**40))]
+	// >>> payload = pickle.dumps(batch, protocol=2)
+	// >>> message = struct.pack("!L", len(payload)) + payload
+	test.Send("\x00\x00\x00\x8c\x80\x02]q\x00(X\x0e\x00\x00\x00carbon.relay.aq\x01J\xf8\xd3\x8eVG@E@\x00\x00\x00\x00\x00\x86q\x02" +
+		"J\x00\xd4\x8eVK+\x86q\x03\x87q\x04X\x0e\x00\x00\x00carbon.relay.bq\x05J\xf8\xd3\x8eVJ\xff\xff\xff\xff\x86q\x06\x86q\x07" +
+		"X\x0e\x00\x00\x00carbon.relay.cq\x08GA\xd5\xa3\xb4\xfe,\xcc\xcd\x8a\x06\x00\x00\x00\x00\x00\x01\x86q\x09\x86q\x0ae.")
+	// second message of connection: [("second.batch", (1452200970, 1))]
+	test.Send("\x00\x00\x00'\x80\x02]q\x00X\x0c\x00\x00\x00second.batchq\x01J\x0a\xd4\x8eVK\x01\x86q\x02\x86q\x03a.")
+
+	expected := []*points.Points{
+		points.OnePoint("carbon.relay.a", 42.5, 1452200952).Add(43, 1452200960),
+		points.OnePoint("carbon.relay.b", -1, 1452200952),
+		points.OnePoint("carbon.relay.c", 1099511627776, 1452200952),
+		points.OnePoint("second.batch", 1, 1452200970),
+	}
+	for i, e := range expected {
+		select {
+		case msg := <-test.rcvChan:
+			test.Eq(msg, e)
+		case <-time.After(time.Second):
+			t.Fatalf("Message #%d not received", i)
+		}
+	}
+
+	// malformed message closes connection: {"a": 1}
+	logging.Test(func(log logging.TestOut) {
+		test.Send("\x00\x00\x00\x0f\x80\x02}q\x00X\x01\x00\x00\x00aq\x01K\x01s.")
+		time.Sleep(10 * time.Millisecond)
+		assert.Contains(log.String(), "I [pickle] Can't unpickle message")
+	})
+
+	test.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := test.conn.Read(make([]byte, 1))
+	// closed by receiver: EOF or reset, not timeout
+	if assert.Error(err) {
+		if e, ok := err.(net.Error); ok {
+			assert.False(e.Timeout())
+		}
+	}
+
+	stat := make(map[string]float64)
+	test.receiver.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(5), stat["metricsReceived"])
+	assert.Equal(float64(1), stat["errors"])
+}
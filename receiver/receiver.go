@@ -27,6 +27,16 @@ func PickleMaxMessageSize(size uint32) Option {
 	}
 }
 
+// TCPMaxLineSize creates option for New contructor. Longer lines of tcp receiver are skipped. 0 - unlimited
+func TCPMaxLineSize(size int) Option {
+	return func(r Receiver) error {
+		if t, ok := r.(*TCP); ok {
+			t.maxLineSize = size
+		}
+		return nil
+	}
+}
+
 // UDPLogIncomplete creates option for New contructor
 func UDPLogIncomplete(enable bool) Option {
 	return func(r Receiver) error {
@@ -93,6 +103,8 @@ func New(dsn string, opts ...Option) (Receiver, error) {
 		if u.Scheme == "pickle" {
 			r.isPickle = true
 			r.maxPickleMessageSize = 67108864 // 64Mb
+		} else {
+			r.maxLineSize = 65536
 		}
 
 		for _, optApply := range opts {
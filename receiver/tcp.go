@@ -3,6 +3,7 @@ package receiver
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"io"
 	"net"
 	"strings"
@@ -22,11 +23,13 @@ type TCP struct {
 	out                  func(*points.Points)
 	name                 string // name for store metrics
 	maxPickleMessageSize uint32
+	maxLineSize          int // 0 - unlimited
 	metricsReceived      uint32
 	errors               uint32
 	active               int32 // counter
 	listener             *net.TCPListener
 	isPickle             bool
+	textParser
 }
 
 // Name returns receiver name (for store internal metrics)
@@ -47,7 +50,7 @@ func (rcv *TCP) HandleConnection(conn net.Conn) {
 	defer atomic.AddInt32(&rcv.active, -1)
 
 	defer conn.Close()
-	reader := bufio.NewReader(conn)
+	reader := newLineReader(conn, rcv.maxLineSize)
 
 	finished := make(chan bool)
 	defer close(finished)
@@ -65,8 +68,13 @@ func (rcv *TCP) HandleConnection(conn net.Conn) {
 	for {
 		conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
 
-		line, err := reader.ReadBytes('\n')
+		line, err := reader.readLine()
 
+		if err == errLineTooLong {
+			atomic.AddUint32(&rcv.errors, 1)
+			logrus.Infof("[tcp] Line longer than %d bytes skipped", rcv.maxLineSize)
+			continue
+		}
 		if err != nil {
 			if err == io.EOF {
 				if len(line) > 0 {
@@ -79,7 +87,7 @@ func (rcv *TCP) HandleConnection(conn net.Conn) {
 			break
 		}
 		if len(line) > 0 { // skip empty lines
-			if msg, err := points.ParseText(string(line)); err != nil {
+			if msg, err := rcv.parse(string(line)); err != nil {
 				atomic.AddUint32(&rcv.errors, 1)
 				logrus.Info(err)
 			} else {
@@ -90,10 +98,50 @@ func (rcv *TCP) HandleConnection(conn net.Conn) {
 	}
 }
 
+var errLineTooLong = errors.New("line too long")
+
+// lineReader reads lines from connection with limit of length
+type lineReader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+func newLineReader(r io.Reader, maxSize int) *lineReader {
+	if maxSize <= 0 {
+		return &lineReader{reader: bufio.NewReader(r)}
+	}
+	// place for newline
+	return &lineReader{reader: bufio.NewReaderSize(r, maxSize+1), maxSize: maxSize}
+}
+
+// readLine returns line with trailing newline, it is valid until next call. Line split by reads of
+// connection is joined. Lines longer than maxSize are read to newline and skipped with errLineTooLong.
+// Unfinished line is returned with io.EOF
+func (r *lineReader) readLine() ([]byte, error) {
+	if r.maxSize <= 0 {
+		return r.reader.ReadBytes('\n')
+	}
+
+	line, err := r.reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+
+	// skip rest of long line
+	for err == bufio.ErrBufferFull {
+		_, err = r.reader.ReadSlice('\n')
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, errLineTooLong
+}
+
 func (rcv *TCP) handlePickle(conn net.Conn) {
 	framedConn, _ := framing.NewConn(conn, byte(4), binary.BigEndian)
 	defer func() {
 		if r := recover(); r != nil {
+			atomic.AddUint32(&rcv.errors, 1)
 			logrus.Errorf("[pickle] Unknown error recovered: %s", r)
 		}
 	}()
@@ -158,6 +206,8 @@ func (rcv *TCP) Stat(send helper.StatCallback) {
 	errors := atomic.LoadUint32(&rcv.errors)
 	atomic.AddUint32(&rcv.errors, -errors)
 	send("errors", float64(errors))
+
+	rcv.stat(send)
 }
 
 // Listen bind port. Receive messages and send to out channel
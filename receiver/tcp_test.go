@@ -1,11 +1,16 @@
 package receiver
 
 import (
+	"fmt"
+	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/lomik/go-carbon/logging"
 	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
 )
 
 type tcpTestCase struct {
@@ -108,3 +113,135 @@ func TestTCP2(t *testing.T) {
 		t.Fatalf("Message #1 not received")
 	}
 }
+
+func TestLineReader(t *testing.T) {
+	assert := assert.New(t)
+
+	// lines split by reads
+	r, w := io.Pipe()
+	go func() {
+		for _, chunk := range []string{"hello.wo", "rld 1 1422698155\nshort 2 ", "1422698155\n", strings.Repeat("x", 40), "\n", "unfinished"} {
+			w.Write([]byte(chunk))
+		}
+		w.Close()
+	}()
+
+	reader := newLineReader(r, 30)
+
+	line, err := reader.readLine()
+	assert.NoError(err)
+	assert.Equal("hello.world 1 1422698155\n", string(line))
+
+	line, err = reader.readLine()
+	assert.NoError(err)
+	assert.Equal("short 2 1422698155\n", string(line))
+
+	_, err = reader.readLine()
+	assert.Equal(errLineTooLong, err)
+
+	line, err = reader.readLine()
+	assert.Equal(io.EOF, err)
+	assert.Equal("unfinished", string(line))
+
+	// unlimited
+	reader = newLineReader(strings.NewReader(strings.Repeat("x", 100000)+"\n"), 0)
+	line, err = reader.readLine()
+	assert.NoError(err)
+	assert.Len(line, 100001)
+}
+
+func TestTCPMaxLineSize(t *testing.T) {
+	assert := assert.New(t)
+
+	rcvChan := make(chan *points.Points, 128)
+	r, err := New("tcp://localhost:0", OutChan(rcvChan), TCPMaxLineSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	conn, err := net.Dial("tcp", r.(*TCP).Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	logging.Test(func(log logging.TestOut) {
+		conn.Write([]byte("hello.world 42.15 1422698155\n" + strings.Repeat("long.metric.", 10)))
+		time.Sleep(10 * time.Millisecond)
+		// rest of long line and next line in other packet
+		conn.Write([]byte("name 1 1422698155\nmetric.name -72.11 1422698155\n"))
+
+		for _, expected := range []*points.Points{
+			points.OnePoint("hello.world", 42.15, 1422698155),
+			points.OnePoint("metric.name", -72.11, 1422698155),
+		} {
+			select {
+			case msg := <-rcvChan:
+				assert.True(msg.Eq(expected), fmt.Sprintf("%#v != %#v", msg, expected))
+			case <-time.After(time.Second):
+				t.Fatalf("%s not received", expected.Metric)
+			}
+		}
+		assert.Contains(log.String(), "[tcp] Line longer than 40 bytes skipped")
+	})
+
+	stat := make(map[string]float64)
+	r.Stat(func(metric string, value float64) {
+		stat[metric] = value
+	})
+	assert.Equal(float64(2), stat["metricsReceived"])
+	assert.Equal(float64(1), stat["errors"])
+	assert.Equal(float64(1), stat["active"])
+}
+
+func TestTCPMissingTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, lenient := range []bool{false, true} {
+		rcvChan := make(chan *points.Points, 128)
+		r, err := New("tcp://localhost:0", OutChan(rcvChan), MissingTimestamp(lenient))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.(*TCP).nowFunc = func() time.Time { return time.Unix(1422698000, 0) }
+
+		conn, err := net.Dial("tcp", r.(*TCP).Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logging.Test(func(log logging.TestOut) {
+			conn.Write([]byte("no.timestamp 42\nhello.world 42.15 1422698155\n"))
+
+			expected := []*points.Points{points.OnePoint("hello.world", 42.15, 1422698155)}
+			if lenient {
+				expected = append([]*points.Points{points.OnePoint("no.timestamp", 42, 1422698000)}, expected...)
+			}
+			for _, e := range expected {
+				select {
+				case msg := <-rcvChan:
+					assert.True(msg.Eq(e), fmt.Sprintf("%#v != %#v", msg, e))
+				case <-time.After(time.Second):
+					t.Fatalf("%s not received", e.Metric)
+				}
+			}
+		})
+		conn.Close()
+
+		stat := make(map[string]float64)
+		r.Stat(func(metric string, value float64) {
+			stat[metric] = value
+		})
+		r.Stop()
+
+		if lenient {
+			assert.Equal(float64(1), stat["stampedPoints"])
+			assert.Equal(float64(0), stat["errors"])
+		} else {
+			_, ok := stat["stampedPoints"]
+			assert.False(ok)
+			assert.Equal(float64(1), stat["errors"])
+		}
+	}
+}
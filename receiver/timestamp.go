@@ -0,0 +1,57 @@
+package receiver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// textParser parses lines of text protocol of tcp and udp receivers
+type textParser struct {
+	lenientTimestamp bool
+	stamped          uint32 // counter
+	nowFunc          func() time.Time
+}
+
+// MissingTimestamp creates option for New contructor. lenient - lines of tcp and udp receivers without timestamp
+// get time of receive (like in carbon-cache) instead of rejection
+func MissingTimestamp(lenient bool) Option {
+	return func(r Receiver) error {
+		if t, ok := r.(*TCP); ok {
+			t.lenientTimestamp = lenient
+		}
+		if t, ok := r.(*UDP); ok {
+			t.lenientTimestamp = lenient
+		}
+		return nil
+	}
+}
+
+// now returns current unix time, overridden by tests
+func (tp *textParser) now() int64 {
+	if tp.nowFunc != nil {
+		return tp.nowFunc().Unix()
+	}
+	return time.Now().Unix()
+}
+
+func (tp *textParser) parse(line string) (*points.Points, error) {
+	if !tp.lenientTimestamp {
+		return points.ParseText(line)
+	}
+
+	p, stamped, err := points.ParseTextTimestamp(line, tp.now)
+	if stamped {
+		atomic.AddUint32(&tp.stamped, 1)
+	}
+	return p, err
+}
+
+// stat sends count of points stamped with time of receive
+func (tp *textParser) stat(send helper.StatCallback) {
+	if tp.lenientTimestamp {
+		helper.SendAndSubstractUint32("stampedPoints", &tp.stamped, send)
+	}
+}
@@ -24,6 +24,7 @@ type UDP struct {
 	errors             uint32
 	logIncomplete      bool
 	conn               *net.UDPConn
+	textParser
 }
 
 // Name returns receiver name (for store internal metrics)
@@ -132,6 +133,8 @@ func (rcv *UDP) Stat(send helper.StatCallback) {
 	errors := atomic.LoadUint32(&rcv.errors)
 	atomic.AddUint32(&rcv.errors, -errors)
 	send("errors", float64(errors))
+
+	rcv.stat(send)
 }
 
 func (rcv *UDP) receiveWorker(exit chan bool) {
@@ -184,7 +187,7 @@ func (rcv *UDP) receiveWorker(exit chan bool) {
 				break
 			}
 			if len(line) > 0 { // skip empty lines
-				if msg, err := points.ParseText(string(line)); err != nil {
+				if msg, err := rcv.parse(string(line)); err != nil {
 					atomic.AddUint32(&rcv.errors, 1)
 					logrus.Info(err)
 				} else {